@@ -14,56 +14,976 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Staffbase/spark-submit/pkg/audit"
+	"github.com/Staffbase/spark-submit/pkg/auth"
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/events"
 	"github.com/Staffbase/spark-submit/pkg/handlers"
+	"github.com/Staffbase/spark-submit/pkg/httputil"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/Staffbase/spark-submit/pkg/pipelines"
+	"github.com/Staffbase/spark-submit/pkg/scheduler"
 	"github.com/Staffbase/spark-submit/pkg/spark"
+	"github.com/Staffbase/spark-submit/pkg/statsd"
+	"github.com/Staffbase/spark-submit/pkg/tracing"
+	"github.com/Staffbase/spark-submit/pkg/vault"
+	"github.com/Staffbase/spark-submit/pkg/yarn"
 	"github.com/alecthomas/kong"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-type mainCmd struct {
+// sparkFlags are shared by all commands that submit presets
+type sparkFlags struct {
 	SparkHome      string `required:"" default:"/opt/spark" help:"spark home directory" env:"SPARK_HOME"`
 	SparkPresetDir string `required:"" help:"directory with spark configuration presets" env:"SPARK_PRESET_DIR"`
+	DefaultsFile   string `help:"file whose sparkConf is merged under every preset, the _defaults.yaml of the preset directory if empty" env:"DEFAULTS_FILE"`
 	Master         string `required:"" help:"spark master address" env:"SPARK_MASTER"`
 	DebugSubmit    bool   `help:"write spark-submit output to logger" env:"DEBUG_SPARK_SUBMIT"`
 	DevMode        bool   `help:"sets the logger output to development config"`
 	Debug          bool   `help:"enables debug logs" env:"DEBUG"`
+
+	CommandTimeout time.Duration `default:"0" help:"maximum duration of a single spark-submit run, 0 means no limit" env:"COMMAND_TIMEOUT"`
+
+	BackoffStrategy     string        `enum:"exponential,linear,constant" default:"exponential" help:"how the delay between retries grows (exponential, linear, constant)" env:"BACKOFF_STRATEGY"`
+	BackoffRetries      int           `default:"10" help:"number of spark-submit tries before a submission fails" env:"BACKOFF_RETRIES"`
+	BackoffInitialDelay time.Duration `default:"1s" help:"delay after the first failed try" env:"BACKOFF_INITIAL_DELAY"`
+	BackoffMaxDelay     time.Duration `default:"3m" help:"upper limit of the delay between retries" env:"BACKOFF_MAX_DELAY"`
+	BackoffMultiplier   float64       `default:"2" help:"delay multiplier of the exponential strategy" env:"BACKOFF_MULTIPLIER"`
+	BackoffJitter       float64       `default:"0" help:"randomly shortens every delay by up to this fraction (0-1)" env:"BACKOFF_JITTER"`
+
+	VaultAddr            string        `help:"vault address, enables ${vault:path#field} placeholders in presets" env:"VAULT_ADDR"`
+	VaultToken           string        `help:"vault token" env:"VAULT_TOKEN"`
+	VaultKubernetesRole  string        `help:"vault role for kubernetes auth with the service account, used if no token is set" env:"VAULT_KUBERNETES_ROLE"`
+	VaultKubernetesMount string        `default:"kubernetes" help:"path of the kubernetes auth method" env:"VAULT_KUBERNETES_MOUNT"`
+	VaultCacheTTL        time.Duration `default:"5m" help:"how long vault secrets without a lease are cached" env:"VAULT_CACHE_TTL"`
+
+	KeytabDir      string `default:"/etc/security/keytabs" help:"directory with the kerberos keytabs presets refer to" env:"KEYTAB_DIR"`
+	PodTemplateDir string `help:"directory with the pod templates submit requests can select by name" env:"POD_TEMPLATE_DIR"`
+	ArtifactStore  string `help:"s3:// or gs:// prefix of uploaded artifacts, presets reference them as artifact://<name>" env:"ARTIFACT_STORE"`
+}
+
+type mainCmd struct {
+	sparkFlags `embed:""`
+
+	JobStore      string        `enum:"memory,bolt,redis" default:"memory" help:"where job records are stored (memory, bolt, redis), redis shares them between replicas" env:"JOB_STORE"`
+	JobStorePath  string        `default:"jobs.db" help:"path of the job database file when using the bolt job store" env:"JOB_STORE_PATH"`
+	JobRetention  time.Duration `default:"168h" help:"how long finished jobs are kept with their events and output, 0 keeps them forever" env:"JOB_RETENTION"`
+	MaxJobs       int           `default:"10000" help:"maximum number of kept jobs, the oldest finished jobs are deleted beyond it, 0 is unlimited" env:"MAX_JOBS"`
+	Backend       string        `enum:"spark-submit,kubernetes,yarn,mock" default:"spark-submit" help:"how status and kill requests are executed (spark-submit, kubernetes, yarn), mock simulates submissions and applications for integration tests" env:"BACKEND"`
+	KubeAPIServer string        `help:"kubernetes API server for the kubernetes backend and preset ConfigMaps, defaults to the spark master or the in-cluster API server" env:"KUBE_API_SERVER"`
+	WatchDrivers  bool          `default:"true" negatable:"" help:"watches the driver pods of the kubernetes backend and keeps the app phase of the jobs up to date" env:"WATCH_DRIVERS"`
+	NoScheduler   bool          `help:"don't submit presets with a schedule, e.g. on all but one replica" env:"NO_SCHEDULER"`
+	DryRun        bool          `help:"process submissions without running spark-submit, e.g. for staging environments and load tests" env:"DRY_RUN"`
+	PipelineDir   string        `help:"directory with pipeline definitions, pipelines saved through the API are written to it" env:"PIPELINE_DIR"`
+	SparkUIPort   int           `default:"4040" help:"port of the Spark UI of drivers, proxied by /jobs/{id}/ui on kubernetes" env:"SPARK_UI_PORT"`
+
+	JobStoreURL    string        `name:"job-store-url" help:"address of the redis server of the redis job store, e.g. redis://:password@redis:6379/0" env:"JOB_STORE_URL"`
+	JobStorePrefix string        `default:"spark-submit:" help:"prefix of the keys of the redis job store" env:"JOB_STORE_PREFIX"`
+	ReplicaTTL     time.Duration `default:"30s" help:"how long the jobs of a replica are left alone after its last heartbeat to the redis job store, before other replicas take them over" env:"REPLICA_TTL"`
+
+	IdempotencyTTL    time.Duration `default:"24h" help:"how long Idempotency-Key headers of submit requests are remembered" env:"IDEMPOTENCY_TTL"`
+	SubmitOutputLimit int           `default:"65536" help:"bytes of spark-submit output kept per job for /jobs/{id}/submit-output" env:"SUBMIT_OUTPUT_LIMIT"`
+	StderrTailLimit   int           `default:"8192" help:"bytes of stderr of the last attempt stored on failed jobs" env:"STDERR_TAIL_LIMIT"`
+	AllowedSparkConf  []string      `help:"glob patterns of sparkConf keys that aren't reported as unknown, e.g. spark.myplugin.*" env:"ALLOWED_SPARK_CONF"`
+	AllowedMasters    []string      `help:"masters submit requests may select with ?master=" env:"ALLOWED_MASTERS"`
+	AllowedNamespaces []string      `help:"glob patterns of the kubernetes namespaces the server may submit to, kill in or query, all if empty" env:"ALLOWED_NAMESPACES"`
+	PricePerCoreHour  float64       `help:"price of one requested core for an hour, for the cost estimate of jobs" env:"PRICE_PER_CORE_HOUR"`
+	PricePerGBHour    float64       `name:"price-per-gb-hour" help:"price of one requested GB of memory for an hour, for the cost estimate of jobs" env:"PRICE_PER_GB_HOUR"`
+
+	MockLatency        time.Duration `default:"1s" help:"how long spark-submit runs of the mock backend take" env:"MOCK_LATENCY"`
+	MockFailureRate    float64       `default:"0" help:"fraction of spark-submit runs of the mock backend that fail (0-1)" env:"MOCK_FAILURE_RATE"`
+	MockPendingTime    time.Duration `default:"5s" help:"how long applications of the mock backend are pending" env:"MOCK_PENDING_TIME"`
+	MockRunTime        time.Duration `default:"30s" help:"how long applications of the mock backend run before they finish" env:"MOCK_RUN_TIME"`
+	MockAppFailureRate float64       `default:"0" help:"fraction of applications of the mock backend that fail (0-1)" env:"MOCK_APP_FAILURE_RATE"`
+
+	YarnResourceManager string `name:"yarn-resource-manager" help:"web address of the YARN ResourceManager for the yarn backend, e.g. http://resourcemanager:8088" env:"YARN_RESOURCE_MANAGER"`
+	YarnUser            string `name:"yarn-user" help:"user sent to ResourceManagers with simple authentication" env:"YARN_USER"`
+
+	PresetConfigMapSelector  string        `name:"preset-configmap-selector" help:"label selector of ConfigMaps whose data is loaded as presets" env:"PRESET_CONFIGMAP_SELECTOR"`
+	PresetConfigMapNamespace string        `name:"preset-configmap-namespace" help:"namespace of the preset ConfigMaps, all namespaces if empty" env:"PRESET_CONFIGMAP_NAMESPACE"`
+	PresetStoreURL           string        `name:"preset-store-url" help:"s3:// or gs:// prefix whose objects are loaded as presets" env:"PRESET_STORE_URL"`
+	PresetGitURL             string        `name:"preset-git-url" help:"git repository whose preset files are loaded as presets" env:"PRESET_GIT_URL"`
+	PresetGitBranch          string        `name:"preset-git-branch" help:"branch of the preset repository, the default branch if empty" env:"PRESET_GIT_BRANCH"`
+	PresetGitPath            string        `name:"preset-git-path" help:"directory of the presets within the repository" env:"PRESET_GIT_PATH"`
+	PresetRefreshInterval    time.Duration `default:"30s" help:"how often presets of ConfigMaps, object stores and git are reloaded" env:"PRESET_REFRESH_INTERVAL"`
+
+	MaxConcurrentSubmits int `default:"32" help:"number of workers running submissions, i.e. the maximum number of submissions running at the same time" env:"MAX_CONCURRENT_SUBMITS"`
+	MaxSparkProcesses    int `default:"0" help:"maximum number of spark-submit processes for submits, status and kill requests at the same time, 0 means unlimited" env:"MAX_SPARK_PROCESSES"`
+	MaxQueuedSubmits     int `default:"1000" help:"maximum number of submissions waiting for a free worker" env:"MAX_QUEUED_SUBMITS"`
+
+	MaxRequestRetries    int           `default:"10" help:"maximum retries after the first try a submit request may ask for in its retry policy, unlike --backoff-retries the first try isn't counted" env:"MAX_REQUEST_RETRIES"`
+	MaxRequestRetryDelay time.Duration `default:"3m" help:"maximum delays a submit request may ask for in its retry policy" env:"MAX_REQUEST_RETRY_DELAY"`
+
+	NamespaceQuota     map[string]int `help:"maximum number of queued and running submissions per kubernetes namespace, e.g. etl=5, * applies to all other namespaces" env:"NAMESPACE_QUOTA"`
+	NamespaceQuotaMode string         `enum:"queue,reject" default:"queue" help:"whether submissions beyond the namespace quota wait or are rejected with 429 (queue, reject)" env:"NAMESPACE_QUOTA_MODE"`
+	CapacityCheck      string         `enum:"off,queue,reject" default:"off" help:"whether kubernetes submissions that don't fit on the nodes wait or are rejected with 503, needs the kubernetes backend (off, queue, reject)" env:"CAPACITY_CHECK"`
+
+	ShutdownTimeout time.Duration `default:"30s" help:"how long to wait for running submissions on shutdown before aborting them" env:"SHUTDOWN_TIMEOUT"`
+	DrainDelay      time.Duration `default:"0s" help:"how long /readyz fails before the server stops accepting requests on shutdown" env:"DRAIN_DELAY"`
+
+	ReadHeaderTimeout time.Duration `default:"10s" help:"how long clients may take to send the request headers" env:"READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `default:"1m" help:"how long clients may take to send the whole request" env:"READ_TIMEOUT"`
+	WriteTimeout      time.Duration `default:"0" help:"how long writing a response may take, 0 means no limit since status requests wait for spark-submit" env:"WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `default:"2m" help:"how long idle keep-alive connections are kept open" env:"IDLE_TIMEOUT"`
+	MaxBodySize       int64         `default:"1048576" help:"maximum size of request bodies in bytes" env:"MAX_BODY_SIZE"`
+	MaxArtifactSize   int64         `default:"536870912" help:"maximum size of artifact uploads in bytes" env:"MAX_ARTIFACT_SIZE"`
+
+	EnablePprof bool   `name:"enable-pprof" help:"serves the pprof profiles at /debug/pprof on the admin address" env:"ENABLE_PPROF"`
+	AdminAddr   string `default:"localhost:7071" help:"address of the admin server, which isn't authenticated" env:"ADMIN_ADDR"`
+
+	StatsDAddress  string        `name:"statsd-address" help:"host:port of a StatsD agent, the metrics are exported to it when set" env:"STATSD_ADDRESS"`
+	StatsDFlavor   string        `name:"statsd-flavor" enum:"statsd,dogstatsd" default:"dogstatsd" help:"send labels as DogStatsD tags or append them to the metric names (statsd, dogstatsd)" env:"STATSD_FLAVOR"`
+	StatsDPrefix   string        `name:"statsd-prefix" help:"prefix of the exported metric names, e.g. spark_submit." env:"STATSD_PREFIX"`
+	StatsDInterval time.Duration `name:"statsd-interval" default:"10s" help:"how often the metrics are exported to StatsD" env:"STATSD_INTERVAL"`
+
+	KafkaRestProxy string `help:"Kafka REST proxy address, job lifecycle events are published when set" env:"KAFKA_REST_PROXY"`
+	KafkaTopic     string `default:"spark-submit-events" help:"Kafka topic for job lifecycle events" env:"KAFKA_TOPIC"`
+	NATSURL        string `name:"nats-url" help:"NATS server address, job lifecycle events are published when set" env:"NATS_URL"`
+	NATSSubject    string `name:"nats-subject" default:"spark-submit.events" help:"NATS subject for job lifecycle events" env:"NATS_SUBJECT"`
+	WebhookURL     string `name:"webhook-url" help:"URL job lifecycle events are posted to when set" env:"WEBHOOK_URL"`
+	WebhookSecret  string `name:"webhook-secret" help:"shared secret the webhook deliveries and callbacks are signed with (HMAC-SHA256)" env:"WEBHOOK_SECRET"`
+
+	AuthToken     string `help:"bearer token required for all requests except /health and /metrics" env:"AUTH_TOKEN"`
+	AuthTokenFile string `help:"file containing the bearer token, e.g. a mounted secret" env:"AUTH_TOKEN_FILE"`
+	APIKeysFile   string `name:"api-keys-file" help:"YAML file with API keys restricted to presets and namespaces" env:"API_KEYS_FILE"`
+	TenantsFile   string `help:"YAML file with the tenants that scope presets, namespaces and jobs of requests" env:"TENANTS_FILE"`
+
+	LegacyAPI bool `default:"true" negatable:"" help:"serves the unversioned API next to /api/v1" env:"LEGACY_API"`
+	SwaggerUI bool `name:"swagger-ui" help:"serves Swagger UI for the OpenAPI document at /docs" env:"SWAGGER_UI"`
+
+	AuditLog string `help:"file the audit log of submits, kills and preset changes is appended to, - writes it to stdout" env:"AUDIT_LOG"`
+
+	TLSCert           string        `name:"tls-cert" help:"certificate file, serves HTTPS instead of HTTP when set" env:"TLS_CERT"`
+	TLSKey            string        `name:"tls-key" help:"private key file of the certificate" env:"TLS_KEY"`
+	TLSReloadInterval time.Duration `name:"tls-reload-interval" default:"0" help:"how often the certificate files are checked for changes, 0 disables reloading" env:"TLS_RELOAD_INTERVAL"`
 }
 
+// jobRetentionInterval is how often finished jobs are pruned
+const jobRetentionInterval = time.Minute
+
+// replica names this process in a shared job store, the suffix keeps it
+// apart from earlier processes of the same pod whose jobs are taken over
+var replica = replicaName()
+
 var CLI struct {
-	Main mainCmd `cmd:"" default:"withargs" help:"start the web-server"`
+	Main   mainCmd   `cmd:"" default:"withargs" help:"start the web-server"`
+	Submit submitCmd `cmd:"" help:"submit a preset and wait for the result, without starting the web-server"`
 }
 
 func main() {
-	kong.Parse(&CLI)
-	CLI.Main.Run()
+	ctx := kong.Parse(&CLI)
+	ctx.FatalIfErrorf(ctx.Run())
 }
 
-func (cmd *mainCmd) Run() {
-	cmd.setupLogger()
-	s, err := spark.New(cmd.SparkHome, cmd.SparkPresetDir, cmd.Master, cmd.DebugSubmit)
+func (cmd *mainCmd) Run() error {
+	logLevel := cmd.setupLogger()
+	retries, err := cmd.backoff()
+	if err != nil {
+		zap.L().Fatal("invalid backoff configuration", zap.Error(err))
+	}
+	jobStore, err := cmd.setupJobStore()
+	if err != nil {
+		zap.L().Fatal("couldn't initialize job store", zap.Error(err))
+	}
+	tracer, err := tracing.FromEnv()
+	if err != nil {
+		zap.L().Fatal("couldn't initialize tracing", zap.Error(err))
+	}
+	if tracer != nil {
+		zap.L().Info("exporting traces")
+		tracing.SetTracer(tracer)
+	}
+	var metricsExporter *statsd.Exporter
+	if cmd.StatsDAddress != "" {
+		metricsExporter, err = statsd.NewExporter(statsd.Config{
+			Address:      cmd.StatsDAddress,
+			Flavor:       statsd.Flavor(cmd.StatsDFlavor),
+			Prefix:       cmd.StatsDPrefix,
+			Interval:     cmd.StatsDInterval,
+			MetricPrefix: "spark_",
+		}, prometheus.DefaultGatherer)
+		if err != nil {
+			zap.L().Fatal("couldn't initialize StatsD exporter", zap.Error(err))
+		}
+		metricsExporter.Start()
+		zap.L().Info("exporting metrics to StatsD", zap.String("address", cmd.StatsDAddress))
+	}
+	publisher, err := cmd.setupEvents()
+	if err != nil {
+		zap.L().Fatal("couldn't initialize event publishing", zap.Error(err))
+	}
+	sources, err := cmd.presetSources()
+	if err != nil {
+		zap.L().Fatal("couldn't initialize preset sources", zap.Error(err))
+	}
+	secrets, err := cmd.secretStore()
+	if err != nil {
+		zap.L().Fatal("couldn't initialize vault", zap.Error(err))
+	}
+	tenants, err := cmd.tenants()
+	if err != nil {
+		zap.L().Fatal("couldn't load tenants", zap.Error(err))
+	}
+	artifacts, err := cmd.artifactStore()
+	if err != nil {
+		zap.L().Fatal("couldn't initialize the artifact store", zap.Error(err))
+	}
+	mockCluster, err := cmd.mockCluster()
+	if err != nil {
+		zap.L().Fatal("invalid mock backend configuration", zap.Error(err))
+	}
+	var submitRunner spark.SubmitRunner
+	if mockCluster != nil {
+		submitRunner = mockCluster
+	}
+	// the pipelines advance on the events of finished jobs
+	pipes, err := pipelines.New(cmd.PipelineDir)
+	if err != nil {
+		zap.L().Fatal("couldn't load pipelines", zap.Error(err))
+	}
+	s, err := spark.New(spark.Config{
+		SparkHome:            cmd.SparkHome,
+		PresetDir:            cmd.SparkPresetDir,
+		DefaultsFile:         cmd.DefaultsFile,
+		Master:               cmd.Master,
+		Debug:                cmd.DebugSubmit,
+		SubmitOutputLimit:    cmd.SubmitOutputLimit,
+		StderrTailLimit:      cmd.StderrTailLimit,
+		MaxConcurrentSubmits: cmd.MaxConcurrentSubmits,
+		MaxProcesses:         cmd.MaxSparkProcesses,
+		MaxQueuedSubmits:     cmd.MaxQueuedSubmits,
+		NamespaceQuotas:      cmd.NamespaceQuota,
+		RejectOverQuota:      cmd.NamespaceQuotaMode == "reject",
+		CapacityCheck:        cmd.capacityMode(),
+		CommandTimeout:       cmd.CommandTimeout,
+		Backoff:              retries,
+		RetryLimits:          spark.RetryLimits{Retries: cmd.MaxRequestRetries, Delay: cmd.MaxRequestRetryDelay},
+		Events:               append(publisher, pipes),
+		Callbacks:            events.NewWebhookPublisher("", cmd.WebhookSecret),
+		PresetSources:        sources,
+		Secrets:              secrets,
+		KeytabDir:            cmd.KeytabDir,
+		PodTemplateDir:       cmd.PodTemplateDir,
+		Artifacts:            artifacts,
+		IdempotencyTTL:       cmd.IdempotencyTTL,
+		Tenant: func(presetName string) string {
+			return auth.PresetTenant(tenants, presetName)
+		},
+		AllowedSparkConf:  cmd.AllowedSparkConf,
+		AllowedMasters:    cmd.AllowedMasters,
+		AllowedNamespaces: cmd.AllowedNamespaces,
+		Prices:            spark.Prices{CoreHour: cmd.PricePerCoreHour, GBHour: cmd.PricePerGBHour},
+		DryRun:            cmd.DryRun,
+		SubmitRunner:      submitRunner,
+		Queue:             queueStore(jobStore),
+		Replica:           replicaOf(jobStore),
+	}, jobStore)
 	if err != nil {
 		zap.L().Fatal("couldn't initialize spark dependency", zap.Error(err))
 	}
+	backend, err := cmd.setupBackend(s, mockCluster)
+	if err != nil {
+		zap.L().Fatal("couldn't initialize backend", zap.Error(err))
+	}
+	s.SetAppController(backend)
+	pipes.SetSubmitter(s)
+	if cmd.DryRun {
+		zap.L().Warn("dry-run mode, submissions don't run spark-submit")
+	}
+	if mockCluster != nil {
+		zap.L().Warn("mock backend, submissions and applications are simulated")
+	}
+	if cmd.CapacityCheck != "off" {
+		checker, ok := backend.(spark.CapacityChecker)
+		if !ok {
+			zap.L().Fatal("the capacity check needs the kubernetes backend")
+		}
+		s.SetCapacityChecker(checker)
+	}
+	if replicas, ok := jobStore.(jobs.ReplicaStore); ok {
+		if err := replicas.Heartbeat(replica, cmd.ReplicaTTL); err != nil {
+			zap.L().Fatal("couldn't register replica", zap.Error(err))
+		}
+		zap.L().Info("sharing jobs with other replicas", zap.String("replica", replica))
+	}
+	if err := takeOverJobs(context.Background(), backend, jobStore, s); err != nil {
+		zap.L().Fatal("couldn't take over unfinished jobs", zap.Error(err))
+	}
+	var sched *scheduler.Scheduler
+	if !cmd.NoScheduler {
+		sched = scheduler.New(s)
+		sched.Sync(s.Schedules())
+		s.OnPresetChange(func() { sched.Sync(s.Schedules()) })
+		sched.Start()
+	}
+	auditLog, err := cmd.setupAuditLog()
+	if err != nil {
+		zap.L().Fatal("couldn't set up audit log", zap.Error(err))
+	}
+
+	jobUI, err := cmd.jobUI(s, backend)
+	if err != nil {
+		zap.L().Fatal("couldn't initialize the spark UI proxy", zap.Error(err))
+	}
+
 	r := chi.NewRouter()
+	// the body size limit is set per route group, artifact uploads have their own
+	r.Use(middleware.RequestID, httputil.AccessLog, tracing.Middleware)
+	keys, err := cmd.apiKeys()
+	if err != nil {
+		zap.L().Fatal("couldn't load API keys", zap.Error(err))
+	}
+	if len(keys) > 0 {
+		r.Use(auth.Middleware(keys))
+	} else {
+		zap.L().Warn("no auth token or API keys configured, the API is open to everyone who can reach it")
+	}
 	r.Get("/health", handlers.HandleHealth)
+	r.Get("/healthz", handlers.HandleHealth)
+	r.Get("/readyz", handlers.HandleReady(s))
 	r.Handle("/metrics", promhttp.Handler())
-	r.Post("/", handlers.HandleSubmit(s))
-	r.Get("/", handlers.HandleStatus(s))
-	r.Delete("/", handlers.HandleKill(s))
-	zap.L().Info("start http server on port 7070")
-	if err := http.ListenAndServe(":7070", r); err != nil {
-		zap.L().Fatal("couldn't start webserver", zap.Error(err))
+	r.Get("/openapi.json", handlers.HandleOpenAPI)
+	if cmd.SwaggerUI {
+		r.Get("/docs", handlers.HandleSwaggerUI)
+	}
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(auth.TenantMiddleware(tenants))
+		r.Group(func(r chi.Router) {
+			r.Use(httputil.MaxBodySize(cmd.MaxBodySize))
+			apiRoutes(r, s, backend, jobUI, auditLog)
+			adminRoutes(r, s, logLevel, auditLog)
+			pipelineRoutes(r, pipes, auditLog)
+			if sched != nil {
+				scheduleRoutes(r, sched, auditLog)
+			}
+		})
+		if cmd.ArtifactStore != "" {
+			artifactRoutes(r, s, cmd.MaxArtifactSize, auditLog)
+		}
+	})
+	if len(tenants) > 0 {
+		r.Route("/tenants/{tenant}/api/v1", func(r chi.Router) {
+			r.Use(auth.TenantMiddleware(tenants), httputil.MaxBodySize(cmd.MaxBodySize))
+			apiRoutes(r, s, backend, jobUI, auditLog)
+		})
+	}
+	if cmd.LegacyAPI {
+		r.Group(func(r chi.Router) {
+			r.Use(auth.TenantMiddleware(tenants), httputil.MaxBodySize(cmd.MaxBodySize))
+			legacyRoutes(r, s, backend, auditLog)
+		})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if len(sources) > 0 {
+		go s.WatchPresetSources(ctx, cmd.PresetRefreshInterval)
+	}
+	if cmd.JobRetention > 0 || cmd.MaxJobs > 0 {
+		go s.WatchJobRetention(ctx, jobRetentionInterval, cmd.JobRetention, cmd.MaxJobs)
+	}
+	if watcher, ok := backend.(spark.DriverWatcher); ok && cmd.WatchDrivers {
+		go watcher.WatchDrivers(ctx)
+	}
+	if replicas, ok := jobStore.(jobs.ReplicaStore); ok {
+		go watchReplicas(ctx, replicas, cmd.ReplicaTTL, func() error {
+			return takeOverJobs(ctx, backend, jobStore, s)
+		})
+	}
+
+	server := &http.Server{
+		Addr:              ":7070",
+		Handler:           r,
+		ReadHeaderTimeout: cmd.ReadHeaderTimeout,
+		ReadTimeout:       cmd.ReadTimeout,
+		WriteTimeout:      cmd.WriteTimeout,
+		IdleTimeout:       cmd.IdleTimeout,
+	}
+	if err := cmd.setupTLS(ctx, server); err != nil {
+		zap.L().Fatal("couldn't set up TLS", zap.Error(err))
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			zap.L().Info("start https server on port 7070")
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			zap.L().Info("start http server on port 7070")
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Fatal("couldn't start webserver", zap.Error(err))
+		}
+	}()
+
+	var adminServer *http.Server
+	if cmd.EnablePprof {
+		adminServer = cmd.startAdminServer()
+	}
+
+	<-ctx.Done()
+	stop()
+	if cmd.DrainDelay > 0 {
+		// give load balancers time to notice the failing readiness check
+		s.Drain()
+		zap.L().Info("draining before shutdown", zap.Duration("delay", cmd.DrainDelay))
+		time.Sleep(cmd.DrainDelay)
+	}
+	zap.L().Info("shutting down", zap.Duration("timeout", cmd.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cmd.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		zap.L().Warn("couldn't shut down webserver", zap.Error(err))
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			zap.L().Warn("couldn't shut down admin server", zap.Error(err))
+		}
+	}
+	if sched != nil {
+		<-sched.Stop().Done()
+	}
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		zap.L().Warn("aborted running submissions", zap.Error(err))
+	}
+	if tracer != nil {
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			zap.L().Warn("couldn't export remaining spans", zap.Error(err))
+		}
+	}
+	if err := publisher.Close(); err != nil {
+		zap.L().Warn("couldn't close event publishers", zap.Error(err))
+	}
+	if metricsExporter != nil {
+		if err := metricsExporter.Close(); err != nil {
+			zap.L().Warn("couldn't export the final metrics to StatsD", zap.Error(err))
+		}
+	}
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			zap.L().Warn("couldn't close audit log", zap.Error(err))
+		}
+	}
+	if closer, ok := jobStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			zap.L().Warn("couldn't close job store", zap.Error(err))
+		}
+	}
+	zap.L().Info("shutdown complete")
+	return nil
+}
+
+// apiRoutes registers the resources of the versioned API, jobUI is nil
+// without kubernetes
+func apiRoutes(r chi.Router, s *spark.Spark, backend handlers.Spark, jobUI http.HandlerFunc, auditLog *audit.Log) {
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Viewer))
+		r.Get("/presets", handlers.HandleListPresets(s))
+		r.Get("/presets/{name}", handlers.HandleGetPreset(s))
+		r.Get("/preset-sources", handlers.HandleListPresetSources(s))
+		r.Get("/presets/errors", handlers.HandlePresetLoadErrors(s))
+		r.Post("/presets/lint", handlers.HandleLintPreset(s))
+		r.Post("/presets/{name}/lint", handlers.HandleLintPreset(s))
+		r.Get("/jobs", handlers.HandleListJobs(s))
+		r.Get("/jobs/export", handlers.HandleExportJobs(s))
+		r.Get("/jobs/{id}", handlers.HandleGetJob(s))
+		r.Get("/jobs/{id}/submit-output", handlers.HandleSubmitOutput(s))
+		r.Get("/jobs/{id}/events", handlers.HandleJobEvents(s))
+		r.Get("/jobs/{id}/app", handlers.HandleJobAppStatus(s, backend))
+		if jobUI != nil {
+			r.Get("/jobs/{id}/ui/*", jobUI)
+		}
+		r.Get("/namespaces/{namespace}/apps", handlers.HandleAppStatus(backend))
+		r.Get("/namespaces/{namespace}/apps/{name}", handlers.HandleAppStatus(backend))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Submitter))
+		r.With(audit.Middleware(auditLog, audit.Submit)).Post("/presets/{name}/jobs", handlers.HandleSubmitPreset(backend))
+		r.With(audit.Middleware(auditLog, audit.Cancel)).Delete("/jobs/{id}", handlers.HandleCancelJob(s))
+		r.With(audit.Middleware(auditLog, audit.Kill)).Delete("/jobs/{id}/app", handlers.HandleKillJobApp(s, backend))
+		r.With(audit.Middleware(auditLog, audit.Kill)).Delete("/namespaces/{namespace}/apps", handlers.HandleKillApps(backend))
+		r.With(audit.Middleware(auditLog, audit.Kill)).Delete("/namespaces/{namespace}/apps/{name}", handlers.HandleKillApp(backend))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Admin))
+		r.With(audit.Middleware(auditLog, audit.CreatePreset)).Post("/presets/{name}", handlers.HandleCreatePreset(s))
+		r.With(audit.Middleware(auditLog, audit.PutPreset)).Put("/presets/{name}", handlers.HandlePutPreset(s))
+		r.With(audit.Middleware(auditLog, audit.DeletePreset)).Delete("/presets/{name}", handlers.HandleDeletePreset(s))
+		r.With(audit.Middleware(auditLog, audit.Drain)).Post("/drain", handlers.HandleDrain(s))
+		r.With(audit.Middleware(auditLog, audit.Resume)).Delete("/drain", handlers.HandleResume(s))
+		if auditLog != nil {
+			r.Get("/audit", handlers.HandleListAudit(auditLog))
+		}
+	})
+}
+
+// adminRoutes registers the runtime settings, they aren't scoped to tenants
+func adminRoutes(r chi.Router, s *spark.Spark, logLevel zap.AtomicLevel, auditLog *audit.Log) {
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Admin))
+		r.Get("/admin/settings", handlers.HandleGetSettings(s, logLevel))
+		r.With(audit.Middleware(auditLog, audit.UpdateSettings)).Patch("/admin/settings", handlers.HandleUpdateSettings(s, logLevel))
+	})
+}
+
+// artifactRoutes registers the artifact store, uploads aren't subject to the
+// body size limit of the other requests
+func artifactRoutes(r chi.Router, s *spark.Spark, maxSize int64, auditLog *audit.Log) {
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Viewer))
+		r.Get("/artifacts", handlers.HandleListArtifacts(s))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Submitter), httputil.MaxBodySize(maxSize))
+		r.With(audit.Middleware(auditLog, audit.UploadArtifact)).Put("/artifacts/*", handlers.HandleUploadArtifact(s))
+	})
+}
+
+// scheduleRoutes registers the pauses of scheduled presets and the whole scheduler
+func scheduleRoutes(r chi.Router, sched *scheduler.Scheduler, auditLog *audit.Log) {
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Viewer))
+		r.Get("/schedules", handlers.HandleListSchedules(sched))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Submitter))
+		r.With(audit.Middleware(auditLog, audit.PauseSchedule)).Post("/schedules/{name}/pause", handlers.HandlePauseSchedule(sched, true))
+		r.With(audit.Middleware(auditLog, audit.ResumeSchedule)).Delete("/schedules/{name}/pause", handlers.HandlePauseSchedule(sched, false))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Admin))
+		r.With(audit.Middleware(auditLog, audit.PauseScheduler)).Post("/scheduler/pause", handlers.HandlePauseScheduler(sched, true))
+		r.With(audit.Middleware(auditLog, audit.ResumeScheduler)).Delete("/scheduler/pause", handlers.HandlePauseScheduler(sched, false))
+	})
+}
+
+// pipelineRoutes registers the pipelines, they aren't scoped to tenants
+func pipelineRoutes(r chi.Router, pipes *pipelines.Manager, auditLog *audit.Log) {
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Viewer))
+		r.Get("/pipelines", handlers.HandleListPipelines(pipes))
+		r.Get("/pipelines/{name}", handlers.HandleGetPipeline(pipes))
+		r.Get("/pipelines/{name}/runs", handlers.HandleListPipelineRuns(pipes))
+		r.Get("/pipelines/{name}/runs/{id}", handlers.HandleGetPipelineRun(pipes))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Submitter))
+		r.With(audit.Middleware(auditLog, audit.StartPipeline)).Post("/pipelines/{name}/runs", handlers.HandleStartPipeline(pipes))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Admin))
+		r.With(audit.Middleware(auditLog, audit.PutPipeline)).Put("/pipelines/{name}", handlers.HandlePutPipeline(pipes))
+		r.With(audit.Middleware(auditLog, audit.DeletePipeline)).Delete("/pipelines/{name}", handlers.HandleDeletePipeline(pipes))
+	})
+}
+
+// legacyRoutes registers the unversioned API, which addresses applications
+// and presets with query parameters
+func legacyRoutes(r chi.Router, s *spark.Spark, backend handlers.Spark, auditLog *audit.Log) {
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Viewer))
+		r.Get("/", handlers.HandleStatus(backend))
+		r.Get("/jobs", handlers.HandleListJobs(s))
+		r.Get("/jobs/{id}", handlers.HandleGetJob(s))
+		r.Get("/presets/{name}", handlers.HandleGetPreset(s))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Submitter))
+		r.With(audit.Middleware(auditLog, audit.Submit)).Post("/", handlers.HandleSubmit(backend))
+		r.With(audit.Middleware(auditLog, audit.Kill)).Delete("/", handlers.HandleKill(backend))
+		r.With(audit.Middleware(auditLog, audit.Cancel)).Delete("/jobs/{id}", handlers.HandleCancelJob(s))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(auth.Admin))
+		r.With(audit.Middleware(auditLog, audit.CreatePreset)).Post("/presets/{name}", handlers.HandleCreatePreset(s))
+		r.With(audit.Middleware(auditLog, audit.PutPreset)).Put("/presets/{name}", handlers.HandlePutPreset(s))
+		r.With(audit.Middleware(auditLog, audit.DeletePreset)).Delete("/presets/{name}", handlers.HandleDeletePreset(s))
+		if auditLog != nil {
+			r.Get("/audit", handlers.HandleListAudit(auditLog))
+		}
+	})
+}
+
+// startAdminServer serves the pprof profiles on a separate address, so they
+// are neither reachable through the API port nor need an API key
+func (cmd mainCmd) startAdminServer() *http.Server {
+	r := chi.NewRouter()
+	r.Mount("/debug", middleware.Profiler())
+	server := &http.Server{Addr: cmd.AdminAddr, Handler: r, ReadHeaderTimeout: cmd.ReadHeaderTimeout}
+	go func() {
+		zap.L().Info("start admin server", zap.String("addr", cmd.AdminAddr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Fatal("couldn't start admin server", zap.Error(err))
+		}
+	}()
+	return server
+}
+
+// setupBackend returns the backend of status and kill requests, mockCluster
+// is only set for the mock backend
+func (cmd mainCmd) setupBackend(s *spark.Spark, mockCluster *spark.MockCluster) (handlers.Spark, error) {
+	switch cmd.Backend {
+	case "mock":
+		return spark.NewMockBackend(s, mockCluster), nil
+	case "kubernetes":
+		client, err := cmd.kubeClient()
+		if err != nil {
+			return nil, err
+		}
+		return spark.NewKubernetesBackend(s, client), nil
+	case "yarn":
+		client, err := yarn.NewClient(cmd.YarnResourceManager, cmd.YarnUser)
+		if err != nil {
+			return nil, err
+		}
+		return spark.NewYarnBackend(s, client), nil
+	default:
+		return s, nil
+	}
+}
+
+// mockCluster returns the simulated cluster of the mock backend, nil for all
+// other backends
+func (cmd mainCmd) mockCluster() (*spark.MockCluster, error) {
+	if cmd.Backend != "mock" {
+		return nil, nil
+	}
+	config := spark.MockConfig{
+		Latency:        cmd.MockLatency,
+		FailureRate:    cmd.MockFailureRate,
+		PendingTime:    cmd.MockPendingTime,
+		RunTime:        cmd.MockRunTime,
+		AppFailureRate: cmd.MockAppFailureRate,
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return spark.NewMockCluster(config), nil
+}
+
+func (cmd mainCmd) capacityMode() spark.CapacityMode {
+	if cmd.CapacityCheck == "off" {
+		return spark.CapacityCheckOff
+	}
+	return spark.CapacityMode(cmd.CapacityCheck)
+}
+
+// jobUI returns the proxy to the Spark UI of running jobs, if the drivers run
+// on kubernetes
+func (cmd mainCmd) jobUI(s *spark.Spark, backend handlers.Spark) (http.HandlerFunc, error) {
+	if cmd.Backend == "mock" || (cmd.Backend != "kubernetes" && !strings.HasPrefix(cmd.Master, "k8s://")) {
+		return nil, nil
+	}
+	client, err := cmd.kubeClient()
+	if err != nil {
+		return nil, err
+	}
+	return handlers.HandleJobUI(s, backend, client, cmd.SparkUIPort), nil
+}
+
+func (cmd mainCmd) kubeClient() (*kube.Client, error) {
+	apiServer := cmd.KubeAPIServer
+	if apiServer == "" && strings.HasPrefix(cmd.Master, "k8s://") {
+		apiServer = cmd.Master
+	}
+	return kube.NewClient(apiServer)
+}
+
+func (cmd mainCmd) presetSources() ([]spark.PresetSource, error) {
+	var sources []spark.PresetSource
+	if cmd.PresetConfigMapSelector != "" {
+		client, err := cmd.kubeClient()
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, spark.NewConfigMapSource(client, cmd.PresetConfigMapNamespace, cmd.PresetConfigMapSelector))
+	}
+	if cmd.PresetStoreURL != "" {
+		source, err := spark.NewObjectStoreSource(cmd.PresetStoreURL)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	if cmd.PresetGitURL != "" {
+		source, err := spark.NewGitSource(cmd.PresetGitURL, cmd.PresetGitBranch, cmd.PresetGitPath, "")
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+func (cmd mainCmd) authToken() (string, error) {
+	if cmd.AuthTokenFile == "" {
+		return cmd.AuthToken, nil
+	}
+	raw, err := os.ReadFile(cmd.AuthTokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf(`auth token file "%s" is empty`, cmd.AuthTokenFile)
+	}
+	return token, nil
+}
+
+// setupTLS configures the server for HTTPS if a certificate is set and
+// reloads it until ctx is done
+func (cmd mainCmd) setupTLS(ctx context.Context, server *http.Server) error {
+	if cmd.TLSCert == "" && cmd.TLSKey == "" {
+		return nil
+	}
+	if cmd.TLSCert == "" || cmd.TLSKey == "" {
+		return errors.New("--tls-cert and --tls-key must be set together")
+	}
+
+	certs, err := httputil.NewCertReloader(cmd.TLSCert, cmd.TLSKey)
+	if err != nil {
+		return err
+	}
+	if cmd.TLSReloadInterval > 0 {
+		go certs.Watch(ctx, cmd.TLSReloadInterval)
+	}
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	return nil
+}
+
+func (cmd mainCmd) setupAuditLog() (*audit.Log, error) {
+	switch cmd.AuditLog {
+	case "":
+		return nil, nil
+	case "-":
+		return audit.NewWriterLog(os.Stdout), nil
+	default:
+		return audit.NewFileLog(cmd.AuditLog)
+	}
+}
+
+// tenants returns the tenants of the tenants file, none if it isn't set
+func (cmd mainCmd) tenants() ([]auth.Tenant, error) {
+	if cmd.TenantsFile == "" {
+		return nil, nil
+	}
+	return auth.LoadTenants(cmd.TenantsFile)
+}
+
+// apiKeys returns the keys of the API keys file and the auth token, which
+// grants unrestricted access
+func (cmd mainCmd) apiKeys() ([]auth.Key, error) {
+	var keys []auth.Key
+	if cmd.APIKeysFile != "" {
+		loaded, err := auth.LoadKeys(cmd.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, loaded...)
+	}
+
+	token, err := cmd.authToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		keys = append(keys, auth.Unrestricted("default", token))
+	}
+	return keys, nil
+}
+
+func (cmd mainCmd) setupEvents() (events.Multi, error) {
+	var publishers events.Multi
+	if cmd.KafkaRestProxy != "" {
+		zap.L().Info("publishing events to kafka", zap.String("topic", cmd.KafkaTopic))
+		publishers = append(publishers, events.NewKafkaPublisher(cmd.KafkaRestProxy, cmd.KafkaTopic))
+	}
+	if cmd.NATSURL != "" {
+		zap.L().Info("publishing events to nats", zap.String("subject", cmd.NATSSubject))
+		publisher, err := events.NewNATSPublisher(cmd.NATSURL, cmd.NATSSubject)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, publisher)
+	}
+	if cmd.WebhookURL != "" {
+		zap.L().Info("posting events to webhook", zap.Bool("signed", cmd.WebhookSecret != ""))
+		publishers = append(publishers, events.NewWebhookPublisher(cmd.WebhookURL, cmd.WebhookSecret))
+	}
+	return publishers, nil
+}
+
+func (cmd mainCmd) setupJobStore() (jobs.Store, error) {
+	switch cmd.JobStore {
+	case "bolt":
+		zap.L().Info("persisting jobs", zap.String("path", cmd.JobStorePath))
+		store, err := jobs.NewBoltStore(cmd.JobStorePath)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "redis":
+		if cmd.JobStoreURL == "" {
+			return nil, errors.New("the redis job store needs --job-store-url")
+		}
+		if cmd.ReplicaTTL <= 0 {
+			return nil, errors.New("--replica-ttl must be positive")
+		}
+		store, err := jobs.NewRedisStore(cmd.JobStoreURL, cmd.JobStorePrefix)
+		if err != nil {
+			return nil, err
+		}
+		zap.L().Info("persisting jobs in redis", zap.String("prefix", cmd.JobStorePrefix))
+		return store, nil
+	}
+	return jobs.NewMemoryStore(), nil
+}
+
+// replicaOf returns the name of the replica if the job store is shared
+func replicaOf(store jobs.Store) string {
+	if _, ok := store.(jobs.ReplicaStore); ok {
+		return replica
+	}
+	return ""
+}
+
+func replicaName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "spark-submit"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// takeOverJobs reconciles the unfinished jobs that no live replica runs, all
+// of them after a restart and those of the replicas whose lease expired with
+// a shared job store. The jobs whose applications outlived their replica are
+// recovered before the remaining ones are interrupted and the queued
+// submissions are restored.
+func takeOverJobs(ctx context.Context, backend handlers.Spark, store jobs.Store, s *spark.Spark) error {
+	if recoverer, ok := backend.(spark.JobRecoverer); ok {
+		recovered, err := recoverer.RecoverJobs(ctx)
+		if err != nil {
+			zap.L().Error("couldn't recover jobs from the cluster", zap.Error(err))
+		}
+		if len(recovered) > 0 {
+			zap.L().Info("recovered jobs from the cluster", zap.Int("count", len(recovered)))
+		}
+	}
+	interrupted, err := jobs.Interrupt(store)
+	if err != nil {
+		return fmt.Errorf("couldn't interrupt unfinished jobs, %w", err)
+	}
+	for _, job := range interrupted {
+		zap.L().Warn("failed job interrupted by restart", zap.String("jobID", job.ID), zap.String("preset", job.Preset))
+	}
+	restored, err := s.RestoreQueue(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't restore queued submissions, %w", err)
+	}
+	if len(restored) > 0 {
+		zap.L().Info("restored queued submissions", zap.Int("count", len(restored)))
+	}
+	return nil
+}
+
+// watchReplicas renews the lease of the replica three times per ttl and
+// takes over the jobs of expired replicas once per ttl until ctx is done
+func watchReplicas(ctx context.Context, replicas jobs.ReplicaStore, ttl time.Duration, takeOver func() error) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for beat := 1; ; beat++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := replicas.Heartbeat(replica, ttl); err != nil {
+			zap.L().Warn("couldn't renew the lease of the replica", zap.Error(err))
+		}
+		if beat%3 != 0 {
+			continue
+		}
+		if err := takeOver(); err != nil {
+			zap.L().Warn("couldn't take over the jobs of expired replicas", zap.Error(err))
+		}
+	}
+}
+
+// queueStore returns the job store if it keeps queued submissions across
+// restarts
+func queueStore(store jobs.Store) jobs.QueueStore {
+	if queue, ok := store.(jobs.QueueStore); ok {
+		return queue
+	}
+	return nil
+}
+
+// backoff returns the validated default backoff of all presets
+func (cmd sparkFlags) backoff() (backoff.Config, error) {
+	retries := backoff.Config{
+		Strategy:     backoff.Strategy(cmd.BackoffStrategy),
+		Retries:      cmd.BackoffRetries,
+		InitialDelay: cmd.BackoffInitialDelay,
+		MaxDelay:     cmd.BackoffMaxDelay,
+		Multiplier:   cmd.BackoffMultiplier,
+		Jitter:       cmd.BackoffJitter,
+	}
+	return retries, retries.Validate()
+}
+
+// artifactStore returns nil without an artifact store URL
+func (cmd sparkFlags) artifactStore() (*spark.ArtifactStore, error) {
+	if cmd.ArtifactStore == "" {
+		return nil, nil
+	}
+	return spark.NewArtifactStore(cmd.ArtifactStore)
+}
+
+// secretStore returns the vault client if a vault address is set
+func (cmd sparkFlags) secretStore() (spark.SecretStore, error) {
+	if cmd.VaultAddr == "" {
+		return nil, nil
 	}
+	return vault.NewClient(vault.Config{
+		Address:         cmd.VaultAddr,
+		Token:           cmd.VaultToken,
+		KubernetesRole:  cmd.VaultKubernetesRole,
+		KubernetesMount: cmd.VaultKubernetesMount,
+		CacheTTL:        cmd.VaultCacheTTL,
+	})
 }
 
-func (cmd mainCmd) setupLogger() {
+// setupLogger installs the global logger and returns its level, which can
+// change at runtime
+func (cmd sparkFlags) setupLogger() zap.AtomicLevel {
 	config := zap.NewProductionConfig()
 	if cmd.DevMode {
 		config = zap.NewDevelopmentConfig()
@@ -79,4 +999,5 @@ func (cmd mainCmd) setupLogger() {
 	} else {
 		zap.ReplaceGlobals(logger)
 	}
+	return config.Level
 }
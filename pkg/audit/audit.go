@@ -0,0 +1,176 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records who changed what on the server to an append-only log,
+// separate from the application logs.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	Submit          = "submit"
+	Kill            = "kill"
+	Cancel          = "cancel"
+	CreatePreset    = "create-preset"
+	PutPreset       = "put-preset"
+	DeletePreset    = "delete-preset"
+	Drain           = "drain"
+	Resume          = "resume"
+	UpdateSettings  = "update-settings"
+	UploadArtifact  = "upload-artifact"
+	PutPipeline     = "put-pipeline"
+	DeletePipeline  = "delete-pipeline"
+	StartPipeline   = "start-pipeline"
+	PauseSchedule   = "pause-schedule"
+	ResumeSchedule  = "resume-schedule"
+	PauseScheduler  = "pause-scheduler"
+	ResumeScheduler = "resume-scheduler"
+)
+
+// Entry is a single audited request
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"requestId,omitempty"`
+	Caller    string            `json:"caller"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Action    string            `json:"action"`
+	Params    map[string]string `json:"params,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Status    int               `json:"status"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Filter selects entries, zero values match everything
+type Filter struct {
+	Caller string
+	Action string
+	Since  time.Time
+	Limit  int
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.Caller == "" || f.Caller == e.Caller) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		!e.Time.Before(f.Since)
+}
+
+// maxRecent is the number of entries kept in memory for queries if the log
+// isn't written to a file
+const maxRecent = 1000
+
+// Log writes entries as JSON lines
+type Log struct {
+	mu     sync.Mutex
+	w      io.Writer
+	file   *os.File
+	recent []Entry
+}
+
+// NewFileLog appends entries to the file, queries read the whole file
+func NewFileLog(file string) (*Log, error) {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open audit log, %w", err)
+	}
+	return &Log{w: f, file: f}, nil
+}
+
+// NewWriterLog writes entries to w, e.g. stdout, queries only see the most
+// recent entries
+func NewWriterLog(w io.Writer) *Log {
+	return &Log{w: w}
+}
+
+func (l *Log) Record(e Entry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("couldn't encode audit entry, %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(raw, '\n')); err != nil {
+		return fmt.Errorf("couldn't write audit entry, %w", err)
+	}
+	if l.file == nil {
+		l.recent = append(l.recent, e)
+		if len(l.recent) > maxRecent {
+			l.recent = l.recent[len(l.recent)-maxRecent:]
+		}
+	}
+	return nil
+}
+
+// Query returns the newest matching entries first
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := l.recent
+	if l.file != nil {
+		var err error
+		if entries, err = l.readFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	matched := make([]Entry, 0)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(matched) >= f.Limit {
+			break
+		}
+		if f.matches(entries[i]) {
+			matched = append(matched, entries[i])
+		}
+	}
+	return matched, nil
+}
+
+func (l *Log) readFile() ([]Entry, error) {
+	f, err := os.Open(l.file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read audit log, %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxBody)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("couldn't parse audit log, %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read audit log, %w", err)
+	}
+	return entries, nil
+}
+
+// Close closes the file of the log, writers passed to NewWriterLog are left open
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	entries := []Entry{
+		{Time: now.Add(-time.Hour), Caller: "ci", Action: Submit, Params: map[string]string{"preset": "pi"}, Status: 200},
+		{Time: now, Caller: "ci", Action: Kill, Status: 200},
+		{Time: now, Caller: "admin", Action: DeletePreset, Status: 404, Error: "preset not found"},
+	}
+
+	t.Run("given a file log, appends lines and queries across reopens", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "audit.log")
+		l, err := NewFileLog(file)
+		require.NoError(t, err)
+		require.NoError(t, l.Record(entries[0]))
+		require.NoError(t, l.Close())
+
+		l, err = NewFileLog(file)
+		require.NoError(t, err)
+		defer l.Close()
+		require.NoError(t, l.Record(entries[1]))
+		require.NoError(t, l.Record(entries[2]))
+
+		got, err := l.Query(Filter{Caller: "ci"})
+		require.NoError(t, err)
+		require.Equal(t, []Entry{entries[1], entries[0]}, got)
+	})
+
+	t.Run("given a writer log, writes JSON lines and queries recent entries", func(t *testing.T) {
+		var out bytes.Buffer
+		l := NewWriterLog(&out)
+		for _, e := range entries {
+			require.NoError(t, l.Record(e))
+		}
+		require.Equal(t, 3, bytes.Count(out.Bytes(), []byte("\n")))
+
+		got, err := l.Query(Filter{Since: now})
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+
+		got, err = l.Query(Filter{Action: DeletePreset})
+		require.NoError(t, err)
+		require.Equal(t, []Entry{entries[2]}, got)
+
+		got, err = l.Query(Filter{Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, []Entry{entries[2]}, got)
+	})
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/auth"
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// maxBody limits the recorded request body, larger bodies are truncated
+const maxBody = 64 * 1024
+
+// Middleware records the action of every request with its parameters and
+// outcome. Requests pass unrecorded if log is nil.
+func Middleware(log *Log, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if log == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry := Entry{
+				Time:      time.Now().UTC(),
+				RequestID: middleware.GetReqID(r.Context()),
+				Caller:    "anonymous",
+				Action:    action,
+				Params:    params(r),
+			}
+			if key, ok := auth.FromContext(r.Context()); ok {
+				entry.Caller = key.Name
+			}
+			if tenant, ok := auth.TenantFromContext(r.Context()); ok {
+				entry.Tenant = tenant.Name
+			}
+			if r.Body != nil {
+				body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+				if err == nil {
+					entry.Body = string(body)
+					// the handler still reads the untruncated body
+					r.Body = struct {
+						io.Reader
+						io.Closer
+					}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				}
+			}
+
+			var response bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&response)
+			next.ServeHTTP(ww, r)
+
+			entry.Status = ww.Status()
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			if entry.Status >= 400 {
+				var errorResponse struct {
+					Error string `json:"error"`
+				}
+				_ = json.Unmarshal(response.Bytes(), &errorResponse)
+				entry.Error = errorResponse.Error
+			}
+			if err := log.Record(entry); err != nil {
+				logging.FromContext(r.Context()).Error("couldn't record audit entry", zap.Error(err))
+			}
+		})
+	}
+}
+
+// params collects the query and URL parameters of the request
+func params(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[key] = values[0]
+		}
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		for i, key := range rctx.URLParams.Keys {
+			if key != "*" {
+				params[key] = rctx.URLParams.Values[i]
+			}
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
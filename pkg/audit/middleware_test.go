@@ -0,0 +1,71 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/auth"
+	"github.com/Staffbase/spark-submit/pkg/httputil"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	l := NewWriterLog(io.Discard)
+	r := chi.NewRouter()
+	r.With(Middleware(l, PutPreset)).Put("/presets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if string(body) == "invalid" {
+			httputil.RenderError(w, r, httputil.BadRequestError("main is required"))
+		}
+	})
+
+	t.Run("records the caller, parameters and body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/presets/etl?dry=true", strings.NewReader("main: etl.py"))
+		ctx := auth.WithTenant(auth.WithKey(req.Context(), auth.Key{Name: "pipeline"}), auth.Tenant{Name: "data"})
+		r.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+		got, err := l.Query(Filter{Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, "pipeline", got[0].Caller)
+		require.Equal(t, "data", got[0].Tenant)
+		require.Equal(t, PutPreset, got[0].Action)
+		require.Equal(t, map[string]string{"name": "etl", "dry": "true"}, got[0].Params)
+		require.Equal(t, "main: etl.py", got[0].Body)
+		require.Equal(t, http.StatusOK, got[0].Status)
+	})
+
+	t.Run("records failures with the error message", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/presets/etl", strings.NewReader("invalid")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		got, err := l.Query(Filter{Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, "anonymous", got[0].Caller)
+		require.Equal(t, http.StatusBadRequest, got[0].Status)
+		require.Equal(t, "main is required", got[0].Error)
+	})
+
+	t.Run("given no log, passes requests through", func(t *testing.T) {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		require.NotNil(t, Middleware(nil, Submit)(next))
+	})
+}
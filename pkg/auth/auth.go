@@ -0,0 +1,213 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth authenticates API requests with bearer tokens and restricts
+// each key to the presets and namespaces it may use.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Staffbase/spark-submit/pkg/httputil"
+	"gopkg.in/yaml.v2"
+)
+
+// Role is what a key may do with the presets and namespaces it may access
+type Role string
+
+const (
+	// Viewer may query the status of applications and jobs
+	Viewer Role = "viewer"
+	// Submitter may also submit, kill and cancel
+	Submitter Role = "submitter"
+	// Admin may also manage presets
+	Admin Role = "admin"
+)
+
+var roleRanks = map[Role]int{Viewer: 1, Submitter: 2, Admin: 3}
+
+// Includes reports whether the role has all permissions of the other role
+func (r Role) Includes(other Role) bool {
+	return roleRanks[r] >= roleRanks[other]
+}
+
+// Key is an API key and what it may access. Presets, namespaces and tenants
+// are glob patterns, "*" allows everything. Keys without a role are viewers,
+// so a forgotten role doesn't grant more than intended. Keys without tenants
+// may act for any tenant or none.
+type Key struct {
+	Name       string   `yaml:"name"`
+	Key        string   `yaml:"key"`
+	Role       Role     `yaml:"role"`
+	Presets    []string `yaml:"presets"`
+	Namespaces []string `yaml:"namespaces"`
+	Tenants    []string `yaml:"tenants"`
+}
+
+// Unrestricted creates an admin key that may access all presets and namespaces
+func Unrestricted(name, key string) Key {
+	return Key{Name: name, Key: key, Role: Admin, Presets: []string{"*"}, Namespaces: []string{"*"}}
+}
+
+func (k Key) AllowsPreset(preset string) bool {
+	return matchAny(k.Presets, preset)
+}
+
+func (k Key) AllowsNamespace(namespace string) bool {
+	return matchAny(k.Namespaces, namespace)
+}
+
+// matchAny matches value against glob patterns, "*" on its own also matches
+// values containing slashes like presets in subdirectories
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+type keysFile struct {
+	Keys []Key `yaml:"keys"`
+}
+
+// LoadKeys reads API keys from a YAML file with a list of keys
+func LoadKeys(file string) ([]Key, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var parsed keysFile
+	if err := yaml.UnmarshalStrict(raw, &parsed); err != nil {
+		return nil, fmt.Errorf(`invalid API keys file "%s", %w`, file, err)
+	}
+	for i, key := range parsed.Keys {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf(`invalid API keys file "%s", key %d needs a name and a key`, file, i)
+		}
+		if key.Role == "" {
+			parsed.Keys[i].Role = Viewer
+		} else if _, ok := roleRanks[key.Role]; !ok {
+			return nil, fmt.Errorf(`invalid API keys file "%s", role "%s" of key "%s" is unknown`, file, key.Role, key.Name)
+		}
+		for _, pattern := range append(append(key.Presets, key.Namespaces...), key.Tenants...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf(`invalid API keys file "%s", pattern "%s" of key "%s" is invalid`, file, pattern, key.Name)
+			}
+		}
+	}
+	return parsed.Keys, nil
+}
+
+// publicPaths stay reachable without credentials for probes, scrapers and
+// the API documentation
+var publicPaths = map[string]bool{"/health": true, "/healthz": true, "/readyz": true, "/metrics": true, "/openapi.json": true, "/docs": true}
+
+// Middleware rejects requests without an "Authorization: Bearer <key>"
+// header matching one of the keys, and passes the matched key downstream
+func Middleware(keys []Key) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if publicPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key, ok := lookup(keys, bearerToken(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				httputil.RenderError(w, r, httputil.WithStatusError(http.StatusUnauthorized, "missing or invalid token"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithKey(r.Context(), key)))
+		})
+	}
+}
+
+// Require rejects requests of keys without the role. Requests are
+// unrestricted if authentication is disabled.
+func Require(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := FromContext(r.Context()); ok && !key.Role.Includes(role) {
+				httputil.RenderError(w, r, httputil.WithStatusError(http.StatusForbidden, fmt.Sprintf("the API key needs the %s role", role)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// lookup compares the token with all keys in constant time
+func lookup(keys []Key, token string) (Key, bool) {
+	var found Key
+	ok := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 && token != "" {
+			found, ok = key, true
+		}
+	}
+	return found, ok
+}
+
+// bearerToken returns the token of the Authorization header, if any
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type keyContextKey struct{}
+
+// WithKey returns a context carrying the key that authenticated the request
+func WithKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the key that authenticated the request
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(Key)
+	return key, ok
+}
+
+// PresetAllowed reports whether the request may use the preset. Requests
+// are unrestricted if authentication is disabled and no tenant is selected.
+func PresetAllowed(ctx context.Context, preset string) bool {
+	if tenant, ok := TenantFromContext(ctx); ok && !tenant.AllowsPreset(preset) {
+		return false
+	}
+	key, ok := FromContext(ctx)
+	return !ok || key.AllowsPreset(preset)
+}
+
+// NamespaceAllowed reports whether the request may access the namespace.
+// Requests are unrestricted if authentication is disabled and no tenant is
+// selected.
+func NamespaceAllowed(ctx context.Context, namespace string) bool {
+	if !TenantAllowsNamespace(ctx, namespace) {
+		return false
+	}
+	key, ok := FromContext(ctx)
+	return !ok || key.AllowsNamespace(namespace)
+}
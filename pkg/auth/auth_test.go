@@ -0,0 +1,164 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	keys := []Key{
+		Unrestricted("default", "secret"),
+		{Name: "team", Key: "team-secret", Presets: []string{"etl-*"}, Namespaces: []string{"team"}},
+	}
+	var got Key
+	handler := Middleware(keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+	request := func(path, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("accepts matching keys and passes them downstream", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request("/jobs", "Bearer secret").Code)
+		require.Equal(t, "default", got.Name)
+		require.Equal(t, http.StatusOK, request("/jobs", "bearer team-secret").Code)
+		require.Equal(t, "team", got.Name)
+	})
+
+	t.Run("rejects missing and invalid tokens", func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer", "Bearer other", "Basic secret"} {
+			w := request("/jobs", authorization)
+			require.Equal(t, http.StatusUnauthorized, w.Code, authorization)
+			require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("keeps health, metrics and the API documentation public", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request("/health", "").Code)
+		require.Equal(t, http.StatusOK, request("/readyz", "").Code)
+		require.Equal(t, http.StatusOK, request("/metrics", "").Code)
+		require.Equal(t, http.StatusOK, request("/openapi.json", "").Code)
+	})
+}
+
+func TestLoadKeys(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		file := filepath.Join(t.TempDir(), "keys.yaml")
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		return file
+	}
+
+	t.Run("given a valid file, returns the keys", func(t *testing.T) {
+		keys, err := LoadKeys(write(t, `
+keys:
+  - name: team
+    key: team-secret
+    presets: [etl-*]
+    namespaces: [team]
+`))
+		require.NoError(t, err)
+		require.Equal(t, []Key{{Name: "team", Key: "team-secret", Role: Viewer, Presets: []string{"etl-*"}, Namespaces: []string{"team"}}}, keys)
+	})
+
+	t.Run("given a role, returns keys with the role", func(t *testing.T) {
+		keys, err := LoadKeys(write(t, "keys:\n  - name: monitoring\n    key: s\n    role: viewer\n"))
+		require.NoError(t, err)
+		require.Equal(t, Viewer, keys[0].Role)
+	})
+
+	t.Run("given an unknown role, returns an error", func(t *testing.T) {
+		_, err := LoadKeys(write(t, "keys:\n  - name: team\n    key: s\n    role: owner\n"))
+		require.ErrorContains(t, err, `role "owner" of key "team" is unknown`)
+	})
+
+	t.Run("given a key without a secret, returns an error", func(t *testing.T) {
+		_, err := LoadKeys(write(t, "keys:\n  - name: team\n"))
+		require.ErrorContains(t, err, "needs a name and a key")
+	})
+
+	t.Run("given an invalid pattern, returns an error", func(t *testing.T) {
+		_, err := LoadKeys(write(t, "keys:\n  - name: team\n    key: s\n    presets: ['[']\n"))
+		require.ErrorContains(t, err, `pattern "[" of key "team" is invalid`)
+	})
+
+	t.Run("given an unknown field, returns an error", func(t *testing.T) {
+		_, err := LoadKeys(write(t, "keys:\n  - name: team\n    key: s\n    preset: [etl]\n"))
+		require.Error(t, err)
+	})
+}
+
+func TestAllowed(t *testing.T) {
+	t.Run("given no key, allows everything", func(t *testing.T) {
+		require.True(t, PresetAllowed(context.Background(), "etl"))
+		require.True(t, NamespaceAllowed(context.Background(), "team"))
+	})
+
+	t.Run("given a restricted key, allows only matching presets and namespaces", func(t *testing.T) {
+		ctx := WithKey(context.Background(), Key{Presets: []string{"etl-*"}, Namespaces: []string{"team"}})
+		require.True(t, PresetAllowed(ctx, "etl-daily"))
+		require.False(t, PresetAllowed(ctx, "pi"))
+		require.True(t, NamespaceAllowed(ctx, "team"))
+		require.False(t, NamespaceAllowed(ctx, "other"))
+	})
+
+	t.Run("given an unrestricted key, allows everything", func(t *testing.T) {
+		ctx := WithKey(context.Background(), Unrestricted("default", "secret"))
+		require.True(t, PresetAllowed(ctx, "pi"))
+		require.True(t, PresetAllowed(ctx, "team/etl"))
+		require.True(t, NamespaceAllowed(ctx, "any"))
+	})
+
+	t.Run("given a directory pattern, allows the presets of the directory", func(t *testing.T) {
+		ctx := WithKey(context.Background(), Key{Presets: []string{"team/*"}})
+		require.True(t, PresetAllowed(ctx, "team/etl"))
+		require.False(t, PresetAllowed(ctx, "team/nightly/etl"))
+		require.False(t, PresetAllowed(ctx, "other/etl"))
+	})
+}
+
+func TestRequire(t *testing.T) {
+	handler := Require(Submitter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(ctx context.Context) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx))
+		return w.Code
+	}
+
+	t.Run("given no key, allows the request", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request(context.Background()))
+	})
+
+	t.Run("given a key with the role or a higher one, allows the request", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request(WithKey(context.Background(), Key{Role: Submitter})))
+		require.Equal(t, http.StatusOK, request(WithKey(context.Background(), Key{Role: Admin})))
+	})
+
+	t.Run("given a key with a lower role, responds 403", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, request(WithKey(context.Background(), Key{Role: Viewer})))
+	})
+}
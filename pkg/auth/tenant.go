@@ -0,0 +1,158 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/Staffbase/spark-submit/pkg/httputil"
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+)
+
+// TenantHeader selects the tenant of requests outside /tenants/{tenant}
+const TenantHeader = "X-Tenant"
+
+// Tenant is a team sharing the deployment. Requests of a tenant only see the
+// presets and namespaces of the tenant, in addition to the restrictions of
+// their key.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// Presets are glob patterns, "<name>/*" if empty
+	Presets []string `yaml:"presets"`
+	// Namespaces are glob patterns, the namespace named after the tenant if empty
+	Namespaces []string `yaml:"namespaces"`
+}
+
+func (t Tenant) AllowsPreset(preset string) bool {
+	return matchAny(t.Presets, preset)
+}
+
+func (t Tenant) AllowsNamespace(namespace string) bool {
+	return matchAny(t.Namespaces, namespace)
+}
+
+var tenantNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_.-]*[a-zA-Z0-9])?$`)
+
+type tenantsFile struct {
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// LoadTenants reads the tenants from a YAML file with a list of tenants
+func LoadTenants(file string) ([]Tenant, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var parsed tenantsFile
+	if err := yaml.UnmarshalStrict(raw, &parsed); err != nil {
+		return nil, fmt.Errorf(`invalid tenants file "%s", %w`, file, err)
+	}
+	names := make(map[string]bool)
+	for i, tenant := range parsed.Tenants {
+		if !tenantNamePattern.MatchString(tenant.Name) {
+			return nil, fmt.Errorf(`invalid tenants file "%s", name "%s" of tenant %d may only contain letters, digits, ".", "_" and "-"`, file, tenant.Name, i)
+		}
+		if names[tenant.Name] {
+			return nil, fmt.Errorf(`invalid tenants file "%s", tenant "%s" is defined twice`, file, tenant.Name)
+		}
+		names[tenant.Name] = true
+		if len(tenant.Presets) == 0 {
+			parsed.Tenants[i].Presets = []string{tenant.Name + "/*"}
+		}
+		if len(tenant.Namespaces) == 0 {
+			parsed.Tenants[i].Namespaces = []string{tenant.Name}
+		}
+		for _, pattern := range append(tenant.Presets, tenant.Namespaces...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf(`invalid tenants file "%s", pattern "%s" of tenant "%s" is invalid`, file, pattern, tenant.Name)
+			}
+		}
+	}
+	return parsed.Tenants, nil
+}
+
+// PresetTenant returns the name of the first tenant the preset belongs to, or
+// an empty string
+func PresetTenant(tenants []Tenant, preset string) string {
+	for _, tenant := range tenants {
+		if tenant.AllowsPreset(preset) {
+			return tenant.Name
+		}
+	}
+	return ""
+}
+
+// TenantMiddleware scopes requests to the tenant of the "tenant" URL
+// parameter or the X-Tenant header. Keys bound to tenants must select one of
+// them, other requests without a tenant stay unscoped.
+func TenantMiddleware(tenants []Tenant) func(http.Handler) http.Handler {
+	byName := make(map[string]Tenant, len(tenants))
+	for _, tenant := range tenants {
+		byName[tenant.Name] = tenant
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := chi.URLParam(r, "tenant")
+			if name == "" {
+				name = r.Header.Get(TenantHeader)
+			}
+			key, authenticated := FromContext(r.Context())
+			if name == "" {
+				if authenticated && len(key.Tenants) > 0 {
+					httputil.RenderError(w, r, httputil.WithStatusError(http.StatusForbidden, "the API key must select a tenant"))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tenant, ok := byName[name]
+			if !ok {
+				httputil.RenderError(w, r, httputil.NotFoundError("tenant not found"))
+				return
+			}
+			if authenticated && len(key.Tenants) > 0 && !matchAny(key.Tenants, name) {
+				httputil.RenderError(w, r, httputil.WithStatusError(http.StatusForbidden, "the API key may not access this tenant"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+type tenantContextKey struct{}
+
+// WithTenant returns a context scoped to the tenant
+func WithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant the request is scoped to
+func TenantFromContext(ctx context.Context) (Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(Tenant)
+	return tenant, ok
+}
+
+// TenantAllowsNamespace reports whether the tenant of the request, if any,
+// owns the namespace. Unlike NamespaceAllowed it ignores the key.
+func TenantAllowsNamespace(ctx context.Context, namespace string) bool {
+	tenant, ok := TenantFromContext(ctx)
+	return !ok || tenant.AllowsNamespace(namespace)
+}
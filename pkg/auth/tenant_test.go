@@ -0,0 +1,149 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTenants(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		file := filepath.Join(t.TempDir(), "tenants.yaml")
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		return file
+	}
+
+	t.Run("given tenants without patterns, defaults to their directory and namespace", func(t *testing.T) {
+		tenants, err := LoadTenants(write(t, `
+tenants:
+  - name: data
+  - name: ml
+    presets: [ml/*, shared/*]
+    namespaces: [ml-*]
+`))
+		require.NoError(t, err)
+		require.Equal(t, []Tenant{
+			{Name: "data", Presets: []string{"data/*"}, Namespaces: []string{"data"}},
+			{Name: "ml", Presets: []string{"ml/*", "shared/*"}, Namespaces: []string{"ml-*"}},
+		}, tenants)
+	})
+
+	t.Run("given an invalid name, returns an error", func(t *testing.T) {
+		_, err := LoadTenants(write(t, "tenants:\n  - name: a/b\n"))
+		require.ErrorContains(t, err, `name "a/b" of tenant 0 may only contain`)
+	})
+
+	t.Run("given a duplicate tenant, returns an error", func(t *testing.T) {
+		_, err := LoadTenants(write(t, "tenants:\n  - name: data\n  - name: data\n"))
+		require.ErrorContains(t, err, `tenant "data" is defined twice`)
+	})
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	tenants := []Tenant{
+		{Name: "data", Presets: []string{"data/*"}, Namespaces: []string{"data"}},
+		{Name: "ml", Presets: []string{"ml/*"}, Namespaces: []string{"ml"}},
+	}
+	var got Tenant
+	var scoped bool
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name := r.Header.Get("X-Key"); name != "" {
+				r = r.WithContext(WithKey(r.Context(), Key{Name: name, Tenants: []string{name}}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		got, scoped = TenantFromContext(r.Context())
+	}
+	r.With(TenantMiddleware(tenants)).Get("/api/v1/jobs", handler)
+	r.Route("/tenants/{tenant}/api/v1", func(r chi.Router) {
+		r.Use(TenantMiddleware(tenants))
+		r.Get("/jobs", handler)
+	})
+	request := func(path string, headers map[string]string) int {
+		got, scoped = Tenant{}, false
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("given the tenant header, scopes the request", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request("/api/v1/jobs", map[string]string{TenantHeader: "data"}))
+		require.True(t, scoped)
+		require.Equal(t, "data", got.Name)
+	})
+
+	t.Run("given the tenant path prefix, scopes the request", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request("/tenants/ml/api/v1/jobs", nil))
+		require.Equal(t, "ml", got.Name)
+	})
+
+	t.Run("given no tenant, leaves the request unscoped", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request("/api/v1/jobs", nil))
+		require.False(t, scoped)
+	})
+
+	t.Run("given an unknown tenant, responds 404", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, request("/tenants/nope/api/v1/jobs", nil))
+	})
+
+	t.Run("given a key bound to another tenant, responds 403", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, request("/tenants/ml/api/v1/jobs", map[string]string{"X-Key": "data"}))
+		require.Equal(t, http.StatusOK, request("/tenants/data/api/v1/jobs", map[string]string{"X-Key": "data"}))
+	})
+
+	t.Run("given a key bound to tenants without a tenant, responds 403", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, request("/api/v1/jobs", map[string]string{"X-Key": "data"}))
+	})
+}
+
+func TestTenantScope(t *testing.T) {
+	ctx := WithTenant(context.Background(), Tenant{Name: "data", Presets: []string{"data/*"}, Namespaces: []string{"data"}})
+
+	t.Run("restricts presets and namespaces to the tenant", func(t *testing.T) {
+		require.True(t, PresetAllowed(ctx, "data/etl"))
+		require.False(t, PresetAllowed(ctx, "ml/train"))
+		require.True(t, NamespaceAllowed(ctx, "data"))
+		require.False(t, NamespaceAllowed(ctx, "ml"))
+		require.False(t, TenantAllowsNamespace(ctx, "ml"))
+	})
+
+	t.Run("combines the tenant with the restrictions of the key", func(t *testing.T) {
+		ctx := WithKey(ctx, Key{Presets: []string{"data/etl"}, Namespaces: []string{"*"}})
+		require.True(t, PresetAllowed(ctx, "data/etl"))
+		require.False(t, PresetAllowed(ctx, "data/report"))
+		require.False(t, NamespaceAllowed(ctx, "ml"))
+	})
+
+	t.Run("returns the tenant of a preset", func(t *testing.T) {
+		tenants := []Tenant{{Name: "data", Presets: []string{"data/*"}}}
+		require.Equal(t, "data", PresetTenant(tenants, "data/etl"))
+		require.Equal(t, "", PresetTenant(tenants, "pi"))
+	})
+}
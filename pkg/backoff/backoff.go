@@ -0,0 +1,204 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backoff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Strategy string
+
+const (
+	// Exponential multiplies the delay by the multiplier after every retry
+	Exponential Strategy = "exponential"
+	// Linear adds the initial delay after every retry
+	Linear Strategy = "linear"
+	// Constant always waits the initial delay
+	Constant Strategy = "constant"
+)
+
+var RetriesExceededError error = fmt.Errorf("retries exceeded")
+
+// Config describes how often and how long to wait between retries
+type Config struct {
+	Strategy     Strategy      `yaml:"strategy,omitempty"`
+	Retries      int           `yaml:"retries,omitempty"`
+	InitialDelay time.Duration `yaml:"initialDelay,omitempty"`
+	MaxDelay     time.Duration `yaml:"maxDelay,omitempty"`
+	Multiplier   float64       `yaml:"multiplier,omitempty"`
+	// Jitter randomly shortens every delay by up to the given fraction (0-1),
+	// so that retries of many submissions don't hit the cluster at once
+	Jitter float64 `yaml:"jitter,omitempty"`
+}
+
+// MarshalJSON encodes the delays as duration strings like "1m30s" instead
+// of nanoseconds
+func (c Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Strategy     Strategy `json:"strategy,omitempty"`
+		Retries      int      `json:"retries,omitempty"`
+		InitialDelay string   `json:"initialDelay,omitempty"`
+		MaxDelay     string   `json:"maxDelay,omitempty"`
+		Multiplier   float64  `json:"multiplier,omitempty"`
+		Jitter       float64  `json:"jitter,omitempty"`
+	}{c.Strategy, c.Retries, durationString(c.InitialDelay), durationString(c.MaxDelay), c.Multiplier, c.Jitter})
+}
+
+// UnmarshalJSON decodes the delays from duration strings like "1m30s"
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Strategy     Strategy `json:"strategy"`
+		Retries      int      `json:"retries"`
+		InitialDelay string   `json:"initialDelay"`
+		MaxDelay     string   `json:"maxDelay"`
+		Multiplier   float64  `json:"multiplier"`
+		Jitter       float64  `json:"jitter"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	decoded := Config{Strategy: raw.Strategy, Retries: raw.Retries, Multiplier: raw.Multiplier, Jitter: raw.Jitter}
+	for _, delay := range []struct {
+		value  string
+		target *time.Duration
+	}{{raw.InitialDelay, &decoded.InitialDelay}, {raw.MaxDelay, &decoded.MaxDelay}} {
+		if delay.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(delay.value)
+		if err != nil {
+			return fmt.Errorf("invalid backoff delay, %w", err)
+		}
+		*delay.target = d
+	}
+	*c = decoded
+	return nil
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// Default is the backoff used when nothing else is configured
+var Default = Config{
+	Strategy:     Exponential,
+	Retries:      10,
+	InitialDelay: time.Second,
+	MaxDelay:     3 * time.Minute,
+	Multiplier:   2,
+}
+
+func (c Config) Validate() error {
+	switch c.Strategy {
+	case Exponential, Linear, Constant, "":
+	default:
+		return fmt.Errorf(`unknown backoff strategy "%s"`, c.Strategy)
+	}
+	if c.Retries < 0 || c.InitialDelay < 0 || c.MaxDelay < 0 || c.Multiplier < 0 {
+		return fmt.Errorf("backoff values must not be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("backoff jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Override returns c with all non-zero fields of o applied
+func (c Config) Override(o Config) Config {
+	if o.Strategy != "" {
+		c.Strategy = o.Strategy
+	}
+	if o.Retries != 0 {
+		c.Retries = o.Retries
+	}
+	if o.InitialDelay != 0 {
+		c.InitialDelay = o.InitialDelay
+	}
+	if o.MaxDelay != 0 {
+		c.MaxDelay = o.MaxDelay
+	}
+	if o.Multiplier != 0 {
+		c.Multiplier = o.Multiplier
+	}
+	if o.Jitter != 0 {
+		c.Jitter = o.Jitter
+	}
+	return c
+}
+
+// Delay returns the wait duration after the given (zero based) try, without jitter
+func (c Config) Delay(try int) time.Duration {
+	var delay float64
+	switch c.Strategy {
+	case Constant:
+		delay = float64(c.InitialDelay)
+	case Linear:
+		delay = float64(c.InitialDelay) * float64(try+1)
+	default:
+		delay = float64(c.InitialDelay) * math.Pow(c.Multiplier, float64(try))
+	}
+	if c.MaxDelay > 0 && delay >= float64(c.MaxDelay) {
+		return c.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (c Config) jittered(delay time.Duration) time.Duration {
+	if c.Jitter <= 0 {
+		return delay
+	}
+	return delay - time.Duration(rand.Float64()*c.Jitter*float64(delay))
+}
+
+// Retry calls fn until it succeeds, the retries are exhausted or ctx is done.
+// Once the retries are exhausted, the error wraps the one of the last try.
+func Retry(ctx context.Context, c Config, fn func() error) error {
+	var lastErr error
+	for try := 0; try < c.Retries; try++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if lastErr = fn(); lastErr == nil {
+			return nil
+		}
+		if try == c.Retries-1 {
+			break
+		}
+		delay := c.jittered(c.Delay(try))
+		zap.L().Warn(
+			"retry failed",
+			zap.Int("try", try),
+			zap.String("waitDuration", delay.String()),
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w, %w", RetriesExceededError, lastErr)
+	}
+	return RetriesExceededError
+}
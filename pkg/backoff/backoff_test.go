@@ -0,0 +1,125 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backoff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelay(t *testing.T) {
+	t.Run("exponential multiplies the delay", func(t *testing.T) {
+		c := Config{Strategy: Exponential, InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+		require.Equal(t, time.Second, c.Delay(0))
+		require.Equal(t, 4*time.Second, c.Delay(2))
+		require.Equal(t, 5*time.Second, c.Delay(3))
+	})
+
+	t.Run("linear adds the initial delay", func(t *testing.T) {
+		c := Config{Strategy: Linear, InitialDelay: time.Second}
+		require.Equal(t, time.Second, c.Delay(0))
+		require.Equal(t, 3*time.Second, c.Delay(2))
+	})
+
+	t.Run("constant always waits the initial delay", func(t *testing.T) {
+		c := Config{Strategy: Constant, InitialDelay: time.Second}
+		require.Equal(t, time.Second, c.Delay(0))
+		require.Equal(t, time.Second, c.Delay(5))
+	})
+
+	t.Run("jitter shortens the delay by up to the fraction", func(t *testing.T) {
+		c := Config{Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			delay := c.jittered(time.Second)
+			require.GreaterOrEqual(t, delay, 500*time.Millisecond)
+			require.LessOrEqual(t, delay, time.Second)
+		}
+	})
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("Override applies non-zero fields", func(t *testing.T) {
+		c := Default.Override(Config{Strategy: Linear, Jitter: 0.2})
+		require.Equal(t, Linear, c.Strategy)
+		require.Equal(t, 0.2, c.Jitter)
+		require.Equal(t, Default.Retries, c.Retries)
+	})
+
+	t.Run("Validate rejects invalid values", func(t *testing.T) {
+		require.NoError(t, Default.Validate())
+		require.Error(t, Config{Strategy: "random"}.Validate())
+		require.Error(t, Config{Jitter: 2}.Validate())
+		require.Error(t, Config{Retries: -1}.Validate())
+	})
+
+	t.Run("encodes delays as duration strings", func(t *testing.T) {
+		raw, err := json.Marshal(Default)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"strategy": "exponential", "retries": 10, "initialDelay": "1s", "maxDelay": "3m0s", "multiplier": 2}`, string(raw))
+	})
+
+	t.Run("decodes delays from duration strings", func(t *testing.T) {
+		var c Config
+		require.NoError(t, json.Unmarshal([]byte(`{"retries": 3, "initialDelay": "5s", "maxDelay": "1m"}`), &c))
+		require.Equal(t, Config{Retries: 3, InitialDelay: 5 * time.Second, MaxDelay: time.Minute}, c)
+		require.Error(t, json.Unmarshal([]byte(`{"initialDelay": "soon"}`), &c))
+	})
+}
+
+func TestRetry(t *testing.T) {
+	c := Config{Strategy: Constant, Retries: 3, InitialDelay: time.Nanosecond}
+
+	t.Run("retries until fn succeeds", func(t *testing.T) {
+		try := 0
+		fn := func() error {
+			if try >= 2 {
+				return nil
+			}
+			try++
+			return fmt.Errorf("error in fn")
+		}
+		require.NoError(t, Retry(context.Background(), c, fn))
+		require.Equal(t, 2, try)
+	})
+
+	t.Run("gives up after the configured retries", func(t *testing.T) {
+		calls := 0
+		fn := func() error {
+			calls++
+			return fmt.Errorf("error in fn")
+		}
+		err := Retry(context.Background(), c, fn)
+		require.ErrorIs(t, err, RetriesExceededError)
+		require.EqualError(t, err, "retries exceeded, error in fn")
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		fn := func() error {
+			calls++
+			cancel()
+			return fmt.Errorf("error in fn")
+		}
+		c := Config{Retries: 3, InitialDelay: time.Hour}
+		require.ErrorIs(t, Retry(ctx, c, fn), context.Canceled)
+		require.Equal(t, 1, calls)
+	})
+}
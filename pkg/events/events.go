@@ -0,0 +1,79 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+type Type string
+
+const (
+	Submitted Type = "submitted"
+	Retrying  Type = "retrying"
+	Succeeded Type = "succeeded"
+	Failed    Type = "failed"
+	Cancelled Type = "cancelled"
+	// Killed is emitted for kill requests, which address spark applications
+	// by namespace and name instead of a job
+	Killed Type = "killed"
+	// MaxRuntimeExceeded is emitted when a job is killed because it ran
+	// longer than the max runtime of its preset
+	MaxRuntimeExceeded Type = "max_runtime_exceeded"
+)
+
+// Event is a lifecycle change of a job
+type Event struct {
+	Type      Type      `json:"type"`
+	Time      time.Time `json:"time"`
+	JobID     string    `json:"jobId,omitempty"`
+	Preset    string    `json:"preset,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Multi publishes every event to all publishers
+type Multi []Publisher
+
+func (m Multi) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, p := range m {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all publishers that hold connections
+func (m Multi) Close() error {
+	var errs []error
+	for _, p := range m {
+		if closer, ok := p.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type publisherFunc func(ctx context.Context, event Event) error
+
+func (f publisherFunc) Publish(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+func TestMulti(t *testing.T) {
+	t.Run("publishes to all publishers even if one fails", func(t *testing.T) {
+		failure := errors.New("unavailable")
+		calls := 0
+		multi := Multi{
+			publisherFunc(func(ctx context.Context, event Event) error { calls++; return failure }),
+			publisherFunc(func(ctx context.Context, event Event) error { calls++; return nil }),
+		}
+		require.ErrorIs(t, multi.Publish(context.Background(), Event{Type: Submitted}), failure)
+		require.Equal(t, 2, calls)
+	})
+}
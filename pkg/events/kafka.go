@@ -0,0 +1,105 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaPublisher produces events to a topic through the Kafka REST Proxy
+// (v2 API), which keeps the heavy native client out of the server. Events
+// are keyed by job id, so all events of a job end up in the same partition.
+type KafkaPublisher struct {
+	topicURL string
+	http     *http.Client
+}
+
+func NewKafkaPublisher(restProxy, topic string) *KafkaPublisher {
+	return &KafkaPublisher{
+		topicURL: strings.TrimSuffix(restProxy, "/") + "/topics/" + url.PathEscape(topic),
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key,omitempty"`
+	Value Event  `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+type kafkaError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (k *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(kafkaProduceRequest{Records: []kafkaRecord{{Key: event.JobID, Value: event}}})
+	if err != nil {
+		return fmt.Errorf("couldn't encode event, %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.topicURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't create request, %w", err)
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to kafka REST proxy failed, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var kafkaErr kafkaError
+		if err := json.NewDecoder(resp.Body).Decode(&kafkaErr); err != nil || kafkaErr.Message == "" {
+			return fmt.Errorf("kafka REST proxy responded with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("kafka REST proxy responded with status %d: %s", resp.StatusCode, kafkaErr.Message)
+	}
+
+	// the proxy responds with 200 even if single records couldn't be produced
+	var produced kafkaProduceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&produced); err != nil {
+		return fmt.Errorf("couldn't decode kafka REST proxy response, %w", err)
+	}
+	for _, offset := range produced.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("couldn't produce event, %s", offset.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafkaPublisher(t *testing.T) {
+	t.Run("produces the event keyed by job id", func(t *testing.T) {
+		var received kafkaProduceRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/topics/spark-events", r.URL.Path)
+			require.Equal(t, kafkaContentType, r.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.Write([]byte(`{"offsets": [{"partition": 0, "offset": 1}]}`))
+		}))
+		defer server.Close()
+
+		publisher := NewKafkaPublisher(server.URL+"/", "spark-events")
+		require.NoError(t, publisher.Publish(context.Background(), Event{Type: Submitted, JobID: "1", Preset: "pi"}))
+		require.Len(t, received.Records, 1)
+		require.Equal(t, "1", received.Records[0].Key)
+		require.Equal(t, Submitted, received.Records[0].Value.Type)
+	})
+
+	t.Run("returns errors of single records", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"offsets": [{"partition": null, "offset": null, "error_code": 50003, "error": "broker unavailable"}]}`))
+		}))
+		defer server.Close()
+
+		err := NewKafkaPublisher(server.URL, "spark-events").Publish(context.Background(), Event{Type: Submitted})
+		require.ErrorContains(t, err, "broker unavailable")
+	})
+
+	t.Run("returns the proxy error message", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code": 40401, "message": "Topic not found."}`))
+		}))
+		defer server.Close()
+
+		err := NewKafkaPublisher(server.URL, "spark-events").Publish(context.Background(), Event{Type: Submitted})
+		require.ErrorContains(t, err, "Topic not found.")
+	})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNATS speaks just enough of the NATS protocol to test the publisher,
+// with an optional JetStream stream capturing all subjects
+type fakeNATS struct {
+	listener  net.Listener
+	jetStream bool
+	stream    bool
+
+	mu        sync.Mutex
+	connects  []string
+	published []Event
+}
+
+func newFakeNATS(t *testing.T, jetStream, stream bool) *fakeNATS {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeNATS{listener: listener, jetStream: jetStream, stream: stream}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"headers\":true,\"jetstream\":%t}\r\n", f.jetStream)
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "CONNECT":
+			f.mu.Lock()
+			f.connects = append(f.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT ")))
+			f.mu.Unlock()
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			reply := ""
+			if len(fields) == 4 {
+				reply = fields[2]
+			}
+			f.handlePublish(conn, fields[1], reply, payload[:size])
+		}
+	}
+}
+
+func (f *fakeNATS) handlePublish(conn net.Conn, subject, reply string, payload []byte) {
+	respond := func(data string) {
+		fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", reply, len(data), data)
+	}
+	noResponders := func() {
+		header := "NATS/1.0 503\r\n\r\n"
+		fmt.Fprintf(conn, "HMSG %s 1 %d %d\r\n%s\r\n", reply, len(header), len(header), header)
+	}
+
+	if subject == "$JS.API.STREAM.NAMES" {
+		if !f.jetStream {
+			noResponders()
+		} else if f.stream {
+			respond(`{"streams":["EVENTS"]}`)
+		} else {
+			respond(`{"streams":null}`)
+		}
+		return
+	}
+
+	var event Event
+	json.Unmarshal(payload, &event)
+	f.mu.Lock()
+	f.published = append(f.published, event)
+	f.mu.Unlock()
+	if reply != "" && f.stream {
+		respond(`{"stream":"EVENTS","seq":1}`)
+	}
+}
+
+func (f *fakeNATS) events() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event(nil), f.published...)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	publish := func(t *testing.T, p *NATSPublisher) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, p.Publish(ctx, Event{Type: Submitted, JobID: "1"}))
+		require.NoError(t, p.Publish(ctx, Event{Type: Succeeded, JobID: "1"}))
+	}
+
+	t.Run("publishes to core NATS without JetStream", func(t *testing.T) {
+		server := newFakeNATS(t, false, false)
+		p, err := NewNATSPublisher(server.listener.Addr().String(), "spark.events")
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		require.False(t, p.jetStream)
+		require.Len(t, server.events(), 2)
+	})
+
+	t.Run("waits for JetStream acks when a stream captures the subject", func(t *testing.T) {
+		server := newFakeNATS(t, true, true)
+		p, err := NewNATSPublisher("nats://"+server.listener.Addr().String(), "spark.events")
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		require.True(t, p.jetStream)
+		require.Equal(t, Succeeded, server.events()[1].Type)
+	})
+
+	t.Run("falls back to core NATS without a stream", func(t *testing.T) {
+		server := newFakeNATS(t, true, false)
+		p, err := NewNATSPublisher("nats://"+server.listener.Addr().String(), "spark.events")
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		require.False(t, p.jetStream)
+		require.Len(t, server.events(), 2)
+	})
+
+	t.Run("sends credentials from the address", func(t *testing.T) {
+		server := newFakeNATS(t, false, false)
+		p, err := NewNATSPublisher("nats://spark:secret@"+server.listener.Addr().String(), "spark.events")
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		require.Contains(t, server.connects[0], `"user":"spark","pass":"secret"`)
+	})
+
+	t.Run("reconnects after the connection was lost", func(t *testing.T) {
+		server := newFakeNATS(t, false, false)
+		p, err := NewNATSPublisher(server.listener.Addr().String(), "spark.events")
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		p.conn.Close()
+		require.Error(t, p.Publish(context.Background(), Event{Type: Failed}))
+		publish(t, p)
+		require.Len(t, server.events(), 4)
+	})
+
+	t.Run("given an unsupported scheme, returns an error", func(t *testing.T) {
+		_, err := NewNATSPublisher("tls://localhost:4222", "spark.events")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// DeliveryHeader carries the id of a delivery, it stays the same when
+	// the delivery is retried so receivers can drop duplicates
+	DeliveryHeader = "X-Spark-Submit-Delivery"
+	// EventHeader carries the type of the event
+	EventHeader = "X-Spark-Submit-Event"
+	// SignatureHeader carries the HMAC-SHA256 of the body with the shared
+	// secret as "sha256=<hex>"
+	SignatureHeader = "X-Spark-Submit-Signature"
+)
+
+// webhookRetries is the number of retries of deliveries that failed with a
+// 5xx status or a network error, the delay doubles after every retry
+const webhookRetries = 3
+
+// WebhookPublisher posts events as JSON to a URL. Deliveries are signed if
+// a secret is set and retried on server errors, 4xx responses aren't retried.
+type WebhookPublisher struct {
+	url        string
+	secret     []byte
+	http       *http.Client
+	retryDelay time.Duration
+}
+
+func NewWebhookPublisher(url, secret string) *WebhookPublisher {
+	return &WebhookPublisher{
+		url:        url,
+		secret:     []byte(secret),
+		http:       &http.Client{Timeout: 30 * time.Second},
+		retryDelay: 500 * time.Millisecond,
+	}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event Event) error {
+	return p.Deliver(ctx, p.url, event.Type, event)
+}
+
+// Deliver posts the payload of an event of the type to the URL, retrying
+// server errors until the retries are exhausted or ctx is done
+func (p *WebhookPublisher) Deliver(ctx context.Context, url string, eventType Type, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("couldn't encode event, %w", err)
+	}
+	id, err := newDeliveryID()
+	if err != nil {
+		return err
+	}
+
+	delay := p.retryDelay
+	for try := 0; ; try++ {
+		retry, err := p.post(ctx, url, id, eventType, body)
+		if err == nil || !retry || try == webhookRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, %w", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends a single try and reports whether a failure may be retried
+func (p *WebhookPublisher) post(ctx context.Context, url, id string, eventType Type, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("couldn't create request, %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, id)
+	req.Header.Set(EventHeader, string(eventType))
+	if len(p.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(p.secret, body))
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("webhook request failed, %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// Sign returns the value of the signature header of the body, receivers
+// compute it the same way and compare it in constant time
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newDeliveryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate delivery id, %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
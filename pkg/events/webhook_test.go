@@ -0,0 +1,97 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPublisher(t *testing.T) {
+	t.Run("posts the signed event", func(t *testing.T) {
+		var received Event
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, Sign([]byte("secret"), body), r.Header.Get(SignatureHeader))
+			require.Len(t, r.Header.Get(DeliveryHeader), 32)
+			require.Equal(t, "succeeded", r.Header.Get(EventHeader))
+			require.NoError(t, json.Unmarshal(body, &received))
+		}))
+		defer server.Close()
+
+		publisher := NewWebhookPublisher(server.URL, "secret")
+		require.NoError(t, publisher.Publish(context.Background(), Event{Type: Succeeded, JobID: "1", Preset: "pi"}))
+		require.Equal(t, "1", received.JobID)
+	})
+
+	t.Run("without a secret, doesn't sign", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Empty(t, r.Header.Get(SignatureHeader))
+		}))
+		defer server.Close()
+
+		require.NoError(t, NewWebhookPublisher(server.URL, "").Publish(context.Background(), Event{Type: Submitted}))
+	})
+
+	t.Run("retries server errors with the same delivery id", func(t *testing.T) {
+		var deliveries []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deliveries = append(deliveries, r.Header.Get(DeliveryHeader))
+			if len(deliveries) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+		defer server.Close()
+
+		publisher := NewWebhookPublisher(server.URL, "secret")
+		publisher.retryDelay = time.Millisecond
+		require.NoError(t, publisher.Publish(context.Background(), Event{Type: Failed}))
+		require.Len(t, deliveries, 3)
+		require.Equal(t, deliveries[0], deliveries[2])
+	})
+
+	t.Run("gives up after the retries", func(t *testing.T) {
+		tries := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		publisher := NewWebhookPublisher(server.URL, "secret")
+		publisher.retryDelay = time.Millisecond
+		require.ErrorContains(t, publisher.Publish(context.Background(), Event{Type: Failed}), "status 503")
+		require.Equal(t, webhookRetries+1, tries)
+	})
+
+	t.Run("doesn't retry client errors", func(t *testing.T) {
+		tries := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		require.ErrorContains(t, NewWebhookPublisher(server.URL, "secret").Publish(context.Background(), Event{Type: Failed}), "status 401")
+		require.Equal(t, 1, tries)
+	})
+}
@@ -14,13 +14,31 @@ limitations under the License.
 package handlers
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Staffbase/spark-submit/pkg/audit"
+	"github.com/Staffbase/spark-submit/pkg/auth"
 	"github.com/Staffbase/spark-submit/pkg/httputil"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/Staffbase/spark-submit/pkg/pipelines"
+	"github.com/Staffbase/spark-submit/pkg/scheduler"
 	"github.com/Staffbase/spark-submit/pkg/spark"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var HandleHealth http.HandlerFunc = httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
@@ -30,29 +48,435 @@ var HandleHealth http.HandlerFunc = httputil.Wrap(func(w http.ResponseWriter, r
 	return nil
 })
 
+type Readiness interface {
+	Ready() error
+}
+
+// HandleReady responds 503 while the server shouldn't get new submissions,
+// e.g. during a drain, in contrast to HandleHealth which only reports that
+// the process is alive
+var HandleReady = func(s Readiness) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := s.Ready(); err != nil {
+			return httputil.WithStatusError(http.StatusServiceUnavailable, "not ready, "+err.Error())
+		}
+
+		render.JSON(w, r, struct {
+			Ready bool `json:"ready"`
+		}{true})
+		return nil
+	})
+}
+
+type Drainer interface {
+	Drain()
+	Resume()
+}
+
+// HandleDrain starts draining, see spark.Spark.Drain
+var HandleDrain = func(s Drainer) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		s.Drain()
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+// HandleResume ends draining
+var HandleResume = func(s Drainer) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		s.Resume()
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+type Scheduler interface {
+	Schedules() ([]scheduler.Schedule, bool)
+	SetPaused(preset string, paused bool) error
+	SetPausedAll(paused bool)
+}
+
+// HandleListSchedules responds with the scheduled presets the request may
+// access and whether the whole scheduler is paused
+var HandleListSchedules = func(s Scheduler) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		all, paused := s.Schedules()
+		schedules := make([]scheduler.Schedule, 0, len(all))
+		for _, schedule := range all {
+			if auth.PresetAllowed(r.Context(), schedule.Preset) {
+				schedules = append(schedules, schedule)
+			}
+		}
+		render.JSON(w, r, struct {
+			Paused    bool                 `json:"paused"`
+			Schedules []scheduler.Schedule `json:"schedules"`
+		}{paused, schedules})
+		return nil
+	})
+}
+
+// HandlePauseSchedule pauses or resumes the scheduled runs of a preset
+var HandlePauseSchedule = func(s Scheduler, paused bool) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		name := presetName(r)
+		if !auth.PresetAllowed(r.Context(), name) {
+			return forbidden("preset")
+		}
+		if err := s.SetPaused(name, paused); err != nil {
+			if errors.Is(err, scheduler.NotScheduledError) {
+				return httputil.NotFoundError(err.Error())
+			}
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+// HandlePauseScheduler pauses or resumes the scheduled runs of all presets,
+// tenants can't pause the presets of others
+var HandlePauseScheduler = func(s Scheduler, paused bool) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if _, ok := auth.TenantFromContext(r.Context()); ok {
+			return forbidden("scheduler")
+		}
+		s.SetPausedAll(paused)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+type RuntimeSettings interface {
+	Settings() spark.Settings
+	UpdateSettings(update spark.SettingsUpdate) (spark.Settings, error)
+}
+
+// settingsResponse adds the log level of the server to the settings
+type settingsResponse struct {
+	spark.Settings
+	LogLevel string `json:"logLevel"`
+}
+
+type settingsUpdate struct {
+	spark.SettingsUpdate
+	LogLevel *string `json:"logLevel"`
+}
+
+// HandleGetSettings responds with the settings that can change at runtime
+var HandleGetSettings = func(s RuntimeSettings, level zap.AtomicLevel) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if _, ok := auth.TenantFromContext(r.Context()); ok {
+			return forbidden("server settings")
+		}
+		render.JSON(w, r, settingsResponse{s.Settings(), level.Level().String()})
+		return nil
+	})
+}
+
+// HandleUpdateSettings applies the settings of the request body, omitted
+// settings stay unchanged
+var HandleUpdateSettings = func(s RuntimeSettings, level zap.AtomicLevel) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if _, ok := auth.TenantFromContext(r.Context()); ok {
+			return forbidden("server settings")
+		}
+		var update settingsUpdate
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&update); err != nil {
+			return httputil.BodyError(err, "invalid request body")
+		}
+		var logLevel zapcore.Level
+		if update.LogLevel != nil {
+			if err := logLevel.UnmarshalText([]byte(*update.LogLevel)); err != nil {
+				return httputil.BadRequestError(fmt.Sprintf(`invalid settings: unknown log level "%s"`, *update.LogLevel))
+			}
+		}
+
+		settings, err := s.UpdateSettings(update.SettingsUpdate)
+		if errors.Is(err, spark.InvalidSettingsError) {
+			return httputil.BadRequestError(err.Error())
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error when updating settings", zap.Error(err))
+			return httputil.InternelServerError("error when updating settings")
+		}
+		if update.LogLevel != nil {
+			level.SetLevel(logLevel)
+		}
+
+		render.JSON(w, r, settingsResponse{settings, level.Level().String()})
+		return nil
+	})
+}
+
 type Spark interface {
-	Submit(preset string) error
-	Kill(namespace, name string)
-	Status(namespace, name string) string
+	Submit(ctx context.Context, preset string, opts spark.SubmitOptions) (string, error)
+	Job(id string) (jobs.Job, error)
+	NamespaceAllowed(namespace string) bool
+	Kill(ctx context.Context, namespace, name string)
+	Status(ctx context.Context, namespace, name string) spark.StatusReport
+	KillApps(ctx context.Context, namespace string, filter spark.AppFilter) ([]string, error)
+}
+
+func forbidden(resource string) error {
+	return httputil.WithStatusError(http.StatusForbidden, "the API key may not access this "+resource)
+}
+
+// checkNamespace rejects namespaces the server or the API key may not access
+func checkNamespace(r *http.Request, s Spark, namespace string) error {
+	if !s.NamespaceAllowed(namespace) {
+		return httputil.WithStatusError(http.StatusForbidden, fmt.Sprintf(`the server may not access namespace "%s"`, namespace))
+	}
+	if !auth.NamespaceAllowed(r.Context(), namespace) {
+		return forbidden("namespace")
+	}
+	return nil
+}
+
+// checkJobNamespace rejects jobs whose application runs in a namespace the
+// server or the API key may not access, applications outside of kubernetes
+// have no namespace
+func checkJobNamespace(r *http.Request, s Spark, job jobs.Job) error {
+	if job.Namespace == "" {
+		return nil
+	}
+	return checkNamespace(r, s, job.Namespace)
+}
+
+type submitResponse struct {
+	ID string `json:"id"`
+}
+
+const maxIdempotencyKeyLength = 255
+
+const namespaceConfKey = "spark.kubernetes.namespace"
+
+// submit submits the preset with the options of the request body
+func submit(r *http.Request, s Spark, preset string) (string, error) {
+	if !auth.PresetAllowed(r.Context(), preset) {
+		return "", forbidden("preset")
+	}
+
+	var opts spark.SubmitOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		return "", httputil.BodyError(err, "invalid request body")
+	}
+	// API keys and tenants may not move their submissions into namespaces of
+	// others, Submit checks the namespace of the preset
+	if namespace, ok := opts.SparkConf[namespaceConfKey]; ok {
+		if err := checkNamespace(r, s, namespace); err != nil {
+			return "", err
+		}
+	}
+	opts.NamespaceAllowed = func(namespace string) bool {
+		return auth.NamespaceAllowed(r.Context(), namespace)
+	}
+
+	if runAt := r.URL.Query().Get("run_at"); runAt != "" {
+		t, err := time.Parse(time.RFC3339, runAt)
+		if err != nil {
+			return "", httputil.BadRequestError("invalid parameter run_at, expected an RFC 3339 timestamp")
+		}
+		opts.RunAt = &t
+	}
+	opts.Master = r.URL.Query().Get("master")
+	opts.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	if len(opts.IdempotencyKey) > maxIdempotencyKeyLength {
+		return "", httputil.BadRequestError(fmt.Sprintf("Idempotency-Key may not be longer than %d characters", maxIdempotencyKeyLength))
+	}
+
+	id, err := s.Submit(r.Context(), preset, opts)
+	if err != nil {
+		if errors.Is(err, spark.PresetNotFoundError) {
+			return "", httputil.NotFoundError("preset not found")
+		}
+		if errors.Is(err, spark.MasterNotAllowedError) || errors.Is(err, spark.NamespaceNotAllowedError) {
+			return "", httputil.WithStatusError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, spark.IdempotencyKeyReusedError) {
+			return "", httputil.WithStatusError(http.StatusUnprocessableEntity, "Idempotency-Key was used for another preset")
+		}
+		if errors.Is(err, spark.PresetRunningError) {
+			return "", httputil.WithStatusError(http.StatusConflict, "preset is already running")
+		}
+		if errors.Is(err, spark.NamespaceQuotaExceededError) {
+			return "", httputil.WithStatusError(http.StatusTooManyRequests, err.Error())
+		}
+		if errors.Is(err, spark.MaintenanceError) {
+			return "", httputil.WithStatusError(http.StatusServiceUnavailable, "server is in maintenance")
+		}
+		if errors.Is(err, spark.CapacityExceededError) {
+			return "", httputil.WithStatusError(http.StatusServiceUnavailable, err.Error())
+		}
+		if errors.Is(err, spark.QueueFullError) {
+			return "", httputil.WithStatusError(http.StatusServiceUnavailable, "submission queue is full")
+		}
+		if errors.Is(err, spark.ShuttingDownError) {
+			return "", httputil.WithStatusError(http.StatusServiceUnavailable, "server is shutting down")
+		}
+		if errors.Is(err, spark.InvalidParamsError) || errors.Is(err, spark.InvalidPresetError) || errors.Is(err, spark.InvalidPodTemplateError) || errors.Is(err, spark.InvalidLabelError) || errors.Is(err, spark.InvalidRetryPolicyError) || errors.Is(err, spark.InvalidPriorityClassError) || errors.Is(err, spark.InvalidCallbackURLError) || errors.Is(err, spark.InvalidSparkConfError) {
+			return "", httputil.BadRequestError(err.Error())
+		}
+		if errors.Is(err, spark.ArtifactNotFoundError) || errors.Is(err, spark.InvalidArtifactError) {
+			return "", httputil.BadRequestError(err.Error())
+		}
+		if errors.Is(err, spark.ArtifactChangedError) {
+			return "", httputil.WithStatusError(http.StatusConflict, err.Error())
+		}
+		if errors.Is(err, spark.UnresolvedVariableError) {
+			// the preset references a variable that isn't set on the server
+			return "", httputil.InternelServerError(err.Error())
+		}
+
+		logging.FromContext(r.Context()).Error("error when submitting spark app", zap.Error(err))
+		return "", httputil.InternelServerError("error when submitting spark app")
+	}
+	return id, nil
+}
+
+const (
+	// defaultSubmitWait is the timeout of submissions with wait=true and
+	// without a timeout parameter
+	defaultSubmitWait = 30 * time.Minute
+	maxSubmitWait     = 24 * time.Hour
+)
+
+// submitWaitInterval is how often the job and its application are polled
+// while a submit request waits for the result
+var submitWaitInterval = 5 * time.Second
+
+// submitWait returns the timeout of the wait and timeout parameters, 0 if
+// the request doesn't wait
+func submitWait(r *http.Request) (time.Duration, error) {
+	if r.URL.Query().Get("wait") == "" {
+		return 0, nil
+	}
+	wait, err := strconv.ParseBool(r.URL.Query().Get("wait"))
+	if err != nil {
+		return 0, httputil.BadRequestError("invalid parameter wait, expected true or false")
+	}
+	if !wait {
+		return 0, nil
+	}
+	timeout := r.URL.Query().Get("timeout")
+	if timeout == "" {
+		return defaultSubmitWait, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 || d > maxSubmitWait {
+		return 0, httputil.BadRequestError(fmt.Sprintf("invalid parameter timeout, expected a duration like 30m up to %s", maxSubmitWait))
+	}
+	return d, nil
+}
+
+type waitResponse struct {
+	ID    string     `json:"id"`
+	State jobs.State `json:"state"`
+	// Done is false if the timeout ended the wait first
+	Done bool     `json:"done"`
+	Job  jobs.Job `json:"job"`
+	// App is the last status of the spark application
+	App *spark.AppStatus `json:"app,omitempty"`
+}
+
+// waitForResult polls the job until it ended and the spark application it
+// submitted isn't pending or running anymore, or the timeout passed
+func waitForResult(r *http.Request, s Spark, id string, timeout time.Duration) (waitResponse, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	ticker := time.NewTicker(submitWaitInterval)
+	defer ticker.Stop()
+	for {
+		job, err := s.Job(id)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error when waiting for job", zap.String("jobID", id), zap.Error(err))
+			return waitResponse{}, httputil.InternelServerError("error when waiting for job")
+		}
+		result := waitResponse{ID: id, State: job.State, Job: job, Done: job.State.Terminal()}
+		// spark-submit may return before the application ends, e.g. with
+		// spark.kubernetes.submission.waitAppCompletion=false
+		if job.State == jobs.StateSucceeded && job.AppName() != "" {
+			if report := s.Status(spark.WithMaster(ctx, job.Master), job.Namespace, job.AppName()); len(report.Apps) > 0 {
+				result.App = &report.Apps[0]
+				result.Done = !result.App.Live()
+			}
+		}
+		if result.Done {
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// respondSubmit responds with the id of the job, or with its result if the
+// request waits for it. Waits that time out are responded with 202.
+func respondSubmit(w http.ResponseWriter, r *http.Request, s Spark, id string, timeout time.Duration, status int) error {
+	if timeout == 0 {
+		render.Status(r, status)
+		render.JSON(w, r, submitResponse{id})
+		return nil
+	}
+
+	// the wait may take longer than the write timeout of the server
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + time.Minute))
+	result, err := waitForResult(r, s, id, timeout)
+	if err != nil {
+		return err
+	}
+	status = http.StatusOK
+	if !result.Done {
+		status = http.StatusAccepted
+	}
+	render.Status(r, status)
+	render.JSON(w, r, result)
+	return nil
 }
 
+// HandleSubmit submits the preset of the preset parameter, with wait=true
+// it responds with the result of the job once it ended
 var HandleSubmit = func(s Spark) http.HandlerFunc {
 	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 		preset := r.URL.Query().Get("preset")
 		if preset == "" {
 			return httputil.BadRequestError("missing parameter preset")
 		}
+		timeout, err := submitWait(r)
+		if err != nil {
+			return err
+		}
 
-		if err := s.Submit(preset); err != nil {
-			if errors.Is(err, spark.PresetNotFoundError) {
-				return httputil.NotFoundError("preset not found")
-			}
+		id, err := submit(r, s, preset)
+		if err != nil {
+			return err
+		}
 
-			zap.L().Error("error when submitting spark app", zap.Error(err))
-			return httputil.InternelServerError("error when submitting spark app")
+		return respondSubmit(w, r, s, id, timeout, http.StatusOK)
+	})
+}
+
+// HandleSubmitPreset submits the preset of the name URL parameter and
+// responds with the location of the new job, with wait=true it responds
+// with the result of the job once it ended
+var HandleSubmitPreset = func(s Spark) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		timeout, err := submitWait(r)
+		if err != nil {
+			return err
+		}
+		id, err := submit(r, s, presetName(r))
+		if err != nil {
+			return err
 		}
 
-		return nil
+		w.Header().Set("Location", "/api/v1/jobs/"+id)
+		return respondSubmit(w, r, s, id, timeout, http.StatusAccepted)
 	})
 }
 
@@ -67,8 +491,64 @@ var HandleKill = func(s Spark) http.HandlerFunc {
 		if name == "" {
 			return httputil.BadRequestError("missing parameter name")
 		}
+		if err := checkNamespace(r, s, namespace); err != nil {
+			return err
+		}
+
+		s.Kill(r.Context(), namespace, name)
+		return nil
+	})
+}
+
+// HandleKillApp kills the applications of the namespace and name URL parameters
+var HandleKillApp = func(s Spark) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		namespace := chi.URLParam(r, "namespace")
+		if err := checkNamespace(r, s, namespace); err != nil {
+			return err
+		}
+
+		s.Kill(r.Context(), namespace, chi.URLParam(r, "name"))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+// HandleKillApps kills all running applications of a namespace that belong
+// to a preset or match a label selector
+var HandleKillApps = func(s Spark) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		namespace := chi.URLParam(r, "namespace")
+		if err := checkNamespace(r, s, namespace); err != nil {
+			return err
+		}
+		filter := spark.AppFilter{
+			Preset:        r.URL.Query().Get("preset"),
+			LabelSelector: r.URL.Query().Get("labelSelector"),
+		}
+		// killing every application of the namespace is too easy to do by accident
+		if filter.Preset == "" && filter.LabelSelector == "" {
+			return httputil.BadRequestError("missing parameter preset or labelSelector")
+		}
+		if filter.Preset != "" && !auth.PresetAllowed(r.Context(), filter.Preset) {
+			return forbidden("preset")
+		}
+
+		killed, err := s.KillApps(r.Context(), namespace, filter)
+		if err != nil {
+			if errors.Is(err, spark.UnsupportedError) {
+				return httputil.WithStatusError(http.StatusNotImplemented, err.Error())
+			}
+
+			logging.FromContext(r.Context()).Error("error when killing spark apps", zap.Error(err))
+			return httputil.InternelServerError("error when killing spark apps")
+		}
 
-		s.Kill(namespace, name)
+		render.JSON(w, r, struct {
+			Killed []string `json:"killed"`
+		}{
+			Killed: killed,
+		})
 		return nil
 	})
 }
@@ -84,12 +564,891 @@ var HandleStatus = func(s Spark) http.HandlerFunc {
 		if name == "" {
 			name = "*"
 		}
+		if err := checkNamespace(r, s, namespace); err != nil {
+			return err
+		}
+
+		render.JSON(w, r, s.Status(r.Context(), namespace, name))
+		return nil
+	})
+}
+
+// HandleAppStatus reports the status of the applications of the namespace
+// and name URL parameters, all applications of the namespace without a name
+var HandleAppStatus = func(s Spark) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		namespace := chi.URLParam(r, "namespace")
+		if err := checkNamespace(r, s, namespace); err != nil {
+			return err
+		}
+
+		name := chi.URLParam(r, "name")
+		if name == "" {
+			name = "*"
+		}
+		report := s.Status(r.Context(), namespace, name)
+		if r.URL.Query().Get("live") == "true" {
+			live := make([]spark.AppStatus, 0, len(report.Apps))
+			for _, app := range report.Apps {
+				if app.Live() {
+					live = append(live, app)
+				}
+			}
+			report.Apps = live
+		}
+		render.JSON(w, r, report)
+		return nil
+	})
+}
+
+type Jobs interface {
+	Jobs() ([]jobs.Job, error)
+	Job(id string) (jobs.Job, error)
+}
+
+// jobFilter reads the filter and the cursor of job lists from the query
+func jobFilter(r *http.Request) (jobs.Filter, error) {
+	query := r.URL.Query()
+	filter := jobs.Filter{Preset: query.Get("preset"), Cursor: query.Get("cursor")}
+	if status := query.Get("status"); status != "" {
+		for _, state := range strings.Split(status, ",") {
+			switch s := jobs.State(state); s {
+			case jobs.StateScheduled, jobs.StatePending, jobs.StateRunning, jobs.StateFailed, jobs.StateSucceeded, jobs.StateCancelled:
+				filter.States = append(filter.States, s)
+			default:
+				return filter, httputil.BadRequestError(fmt.Sprintf(`invalid parameter status, unknown state "%s"`, state))
+			}
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, httputil.BadRequestError("invalid parameter since, expected an RFC 3339 timestamp")
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return filter, httputil.BadRequestError("invalid parameter limit, expected a positive number")
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
+
+// HandleListJobs responds with the jobs matching the status, preset and
+// since parameters ordered by their creation, limit splits them into pages
+// which are continued with the next cursor
+var HandleListJobs = func(s Jobs) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		filter, err := jobFilter(r)
+		if err != nil {
+			return err
+		}
+		list, err := s.Jobs()
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error when listing jobs", zap.Error(err))
+			return httputil.InternelServerError("error when listing jobs")
+		}
+
+		// jobs of other presets are hidden from restricted API keys
+		allowed := make([]jobs.Job, 0, len(list))
+		for _, job := range list {
+			if auth.PresetAllowed(r.Context(), job.Preset) {
+				allowed = append(allowed, job)
+			}
+		}
+
+		page, next, err := filter.Page(allowed)
+		if err != nil {
+			return httputil.BadRequestError("invalid parameter cursor, expected the id of a listed job")
+		}
 
 		render.JSON(w, r, struct {
-			Status string `json:"status"`
+			Jobs []jobs.Job `json:"jobs"`
+			Next string     `json:"next,omitempty"`
 		}{
-			Status: s.Status(namespace, name),
+			Jobs: page,
+			Next: next,
 		})
 		return nil
 	})
 }
+
+// exportColumns are the columns of CSV exports of jobs
+var exportColumns = []string{"id", "preset", "state", "createdAt", "runAt", "startedAt", "finishedAt", "attempts",
+	"exitCode", "error", "namespace", "driverPod", "applicationId", "estimatedCost", "priority", "dryRun"}
+
+type JobExporter interface {
+	// ForEachJob calls fn with every job ordered by their creation
+	ForEachJob(fn func(job jobs.Job) error) error
+}
+
+// HandleExportJobs streams all jobs the API key may access as a CSV file or
+// a JSON array, ordered by their creation
+var HandleExportJobs = func(s JobExporter) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "csv" {
+			return httputil.BadRequestError("invalid parameter format, expected csv or json")
+		}
+
+		export := &jobExport{w: w, format: format}
+		err := s.ForEachJob(func(job jobs.Job) error {
+			if !auth.PresetAllowed(r.Context(), job.Preset) {
+				return nil
+			}
+			if err := r.Context().Err(); err != nil {
+				return err
+			}
+			return export.write(job)
+		})
+		if err == nil {
+			err = export.finish()
+		}
+		if err != nil {
+			if !export.started {
+				logging.FromContext(r.Context()).Error("error when exporting jobs", zap.Error(err))
+				return httputil.InternelServerError("error when exporting jobs")
+			}
+			// the response is written job by job, errors after the header
+			// can only be logged
+			logging.FromContext(r.Context()).Warn("export of jobs aborted", zap.Error(err))
+		}
+		return nil
+	})
+}
+
+// jobExport writes the jobs of an export one by one, the header is only
+// written with the first job so that failing job stores still respond 500
+type jobExport struct {
+	w       http.ResponseWriter
+	format  string
+	csv     *csv.Writer
+	encoder *json.Encoder
+	started bool
+	written int
+}
+
+func (e *jobExport) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="jobs.%s"`, e.format))
+	if e.format == "csv" {
+		e.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		e.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	e.w.WriteHeader(http.StatusOK)
+
+	if e.format == "csv" {
+		e.csv = csv.NewWriter(e.w)
+		return e.csv.Write(exportColumns)
+	}
+	e.encoder = json.NewEncoder(e.w)
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jobExport) write(job jobs.Job) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	var err error
+	if e.format == "csv" {
+		err = e.csv.Write(exportRow(job))
+	} else {
+		if e.written > 0 {
+			_, err = io.WriteString(e.w, ",")
+		}
+		if err == nil {
+			err = e.encoder.Encode(job)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	e.written++
+	if e.written%100 == 0 {
+		if e.csv != nil {
+			e.csv.Flush()
+		}
+		if flusher, ok := e.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+func (e *jobExport) finish() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	if e.format == "csv" {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+func exportRow(job jobs.Job) []string {
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	exitCode, cost := "", ""
+	if job.ExitCode != nil {
+		exitCode = strconv.Itoa(*job.ExitCode)
+	}
+	if job.EstimatedCost != nil {
+		cost = strconv.FormatFloat(*job.EstimatedCost, 'f', -1, 64)
+	}
+	return []string{job.ID, job.Preset, string(job.State), timestamp(&job.CreatedAt), timestamp(job.RunAt),
+		timestamp(job.StartedAt), timestamp(job.FinishedAt), strconv.Itoa(len(job.Attempts)), exitCode, job.Error,
+		job.Namespace, job.DriverPod, job.ApplicationID, cost, strconv.Itoa(job.Priority), strconv.FormatBool(job.DryRun)}
+}
+
+var HandleGetJob = func(s Jobs) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJob(r, s)
+		if err != nil {
+			return err
+		}
+
+		render.JSON(w, r, job)
+		return nil
+	})
+}
+
+// loadJob loads the job of the id URL parameter, jobs of presets the API key
+// may not access are reported as not found
+func loadJob(r *http.Request, s Jobs) (jobs.Job, error) {
+	job, err := s.Job(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, jobs.JobNotFoundError) {
+			return job, httputil.NotFoundError("job not found")
+		}
+
+		logging.FromContext(r.Context()).Error("error when loading job", zap.Error(err))
+		return job, httputil.InternelServerError("error when loading job")
+	}
+	if !auth.PresetAllowed(r.Context(), job.Preset) {
+		return job, httputil.NotFoundError("job not found")
+	}
+	return job, nil
+}
+
+// loadJobApp loads the job of the id URL parameter and makes sure
+// spark-submit reported its application
+func loadJobApp(r *http.Request, s Jobs) (jobs.Job, error) {
+	job, err := loadJob(r, s)
+	if err != nil {
+		return job, err
+	}
+	if job.AppName() == "" {
+		return job, httputil.NotFoundError("the job has no spark application yet")
+	}
+	return job, nil
+}
+
+// HandleJobAppStatus responds with the status of the spark application a job
+// submitted, the caller doesn't need to know the generated pod name
+var HandleJobAppStatus = func(s Jobs, backend Spark) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJobApp(r, s)
+		if err != nil {
+			return err
+		}
+		if err := checkJobNamespace(r, backend, job); err != nil {
+			return err
+		}
+
+		render.JSON(w, r, backend.Status(spark.WithMaster(r.Context(), job.Master), job.Namespace, job.AppName()))
+		return nil
+	})
+}
+
+// HandleKillJobApp kills the spark application a job submitted
+var HandleKillJobApp = func(s Jobs, backend Spark) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJobApp(r, s)
+		if err != nil {
+			return err
+		}
+		if err := checkJobNamespace(r, backend, job); err != nil {
+			return err
+		}
+
+		backend.Kill(spark.WithMaster(r.Context(), job.Master), job.Namespace, job.AppName())
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+type PodProxy interface {
+	PodProxy(namespace, name string, port int) http.Handler
+}
+
+// HandleJobUI proxies to the Spark UI of the driver of a running job. The UI
+// builds its links from the X-Forwarded-Context header, so they point back
+// at the proxy.
+var HandleJobUI = func(s Jobs, backend Spark, proxy PodProxy, port int) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJob(r, s)
+		if err != nil {
+			return err
+		}
+		if job.DriverPod == "" {
+			return httputil.NotFoundError("the job has no driver pod")
+		}
+		if err := checkJobNamespace(r, backend, job); err != nil {
+			return err
+		}
+		if job.State != jobs.StateRunning {
+			return httputil.WithStatusError(http.StatusConflict, "the spark UI is only available while the job is running")
+		}
+
+		uiPath := chi.URLParam(r, "*")
+		// the path is appended to the proxy subresource of the pod, it must not
+		// climb out of it to other paths of the API server
+		for _, segment := range strings.Split(uiPath, "/") {
+			if segment == ".." {
+				return httputil.BadRequestError("the path must not contain .. segments")
+			}
+		}
+		prefix := strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, uiPath), "/")
+		cleaned := path.Clean("/" + uiPath)
+		if strings.HasSuffix(uiPath, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		proxied := r.Clone(r.Context())
+		proxied.URL.Path = cleaned
+		proxied.URL.RawPath = ""
+		proxied.Header.Set("X-Forwarded-Context", prefix)
+		proxy.PodProxy(job.Namespace, job.DriverPod, port).ServeHTTP(w, proxied)
+		return nil
+	})
+}
+
+type SubmitOutputs interface {
+	Jobs
+	SubmitOutput(id string) (string, error)
+}
+
+// HandleSubmitOutput responds with the captured spark-submit output of a job
+// as plain text
+var HandleSubmitOutput = func(s SubmitOutputs) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJob(r, s)
+		if err != nil {
+			return err
+		}
+		output, err := s.SubmitOutput(job.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error when loading submit output", zap.Error(err))
+			return httputil.InternelServerError("error when loading submit output")
+		}
+
+		render.PlainText(w, r, output)
+		return nil
+	})
+}
+
+type JobEvents interface {
+	Jobs
+	JobEvents(id string) ([]jobs.Event, error)
+}
+
+// HandleJobEvents responds with the state transitions and attempts of a job,
+// oldest first
+var HandleJobEvents = func(s JobEvents) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJob(r, s)
+		if err != nil {
+			return err
+		}
+		events, err := s.JobEvents(job.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error when loading job events", zap.Error(err))
+			return httputil.InternelServerError("error when loading job events")
+		}
+
+		render.JSON(w, r, map[string][]jobs.Event{"events": events})
+		return nil
+	})
+}
+
+type Canceller interface {
+	Jobs
+	Cancel(id string) error
+}
+
+var HandleCancelJob = func(s Canceller) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		job, err := loadJob(r, s)
+		if err != nil {
+			return err
+		}
+		if err := s.Cancel(job.ID); err != nil {
+			if errors.Is(err, jobs.JobNotFoundError) {
+				return httputil.NotFoundError("job not found")
+			}
+			if errors.Is(err, spark.JobNotCancellableError) {
+				return httputil.WithStatusError(http.StatusConflict, "job can't be cancelled")
+			}
+
+			logging.FromContext(r.Context()).Error("error when cancelling job", zap.Error(err))
+			return httputil.InternelServerError("error when cancelling job")
+		}
+
+		return nil
+	})
+}
+
+type Presets interface {
+	CreatePreset(name string, raw []byte) error
+	PutPreset(name string, raw []byte) error
+	DeletePreset(name string) error
+}
+
+type PresetLister interface {
+	PresetNames() []string
+}
+
+type PresetSourceLister interface {
+	PresetSources() []spark.PresetSourceStatus
+}
+
+type PresetErrorLister interface {
+	PresetLoadErrors() []spark.PresetLoadError
+}
+
+type PresetDetailer interface {
+	PresetDetail(name string, params map[string]string) (spark.PresetDetail, error)
+}
+
+// presetName returns the preset of the URL, presets in subdirectories are
+// requested with an escaped slash, e.g. /presets/team%2Fetl
+func presetName(r *http.Request) string {
+	name := chi.URLParam(r, "name")
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// HandleGetPreset responds with the resolved configuration of a preset, the
+// query parameters are the params of the preset templates
+var HandleGetPreset = func(s PresetDetailer) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		name := presetName(r)
+		if !auth.PresetAllowed(r.Context(), name) {
+			return forbidden("preset")
+		}
+
+		params := make(map[string]string)
+		for key, values := range r.URL.Query() {
+			params[key] = values[0]
+		}
+
+		detail, err := s.PresetDetail(name, params)
+		if err != nil {
+			return presetError(r, err, "loading")
+		}
+
+		render.JSON(w, r, detail)
+		return nil
+	})
+}
+
+// HandleListPresets responds with the names of the presets the API key may access
+var HandleListPresets = func(s PresetLister) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		names := make([]string, 0)
+		for _, name := range s.PresetNames() {
+			if auth.PresetAllowed(r.Context(), name) {
+				names = append(names, name)
+			}
+		}
+
+		render.JSON(w, r, struct {
+			Presets []string `json:"presets"`
+		}{names})
+		return nil
+	})
+}
+
+// HandlePresetLoadErrors responds with the preset files that were skipped on
+// the last load of their source, e.g. after a broken ConfigMap rollout
+var HandlePresetLoadErrors = func(s PresetErrorLister) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		errs := make([]spark.PresetLoadError, 0)
+		for _, loadError := range s.PresetLoadErrors() {
+			if auth.PresetAllowed(r.Context(), loadError.Preset) {
+				errs = append(errs, loadError)
+			}
+		}
+
+		render.JSON(w, r, struct {
+			Errors []spark.PresetLoadError `json:"errors"`
+		}{errs})
+		return nil
+	})
+}
+
+// HandleListPresetSources responds with the state of the preset sources,
+// including the revision of the presets that are live
+var HandleListPresetSources = func(s PresetSourceLister) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		render.JSON(w, r, struct {
+			Sources []spark.PresetSourceStatus `json:"sources"`
+		}{s.PresetSources()})
+		return nil
+	})
+}
+
+func presetError(r *http.Request, err error, action string) error {
+	switch {
+	case errors.Is(err, spark.InvalidPresetError), errors.Is(err, spark.InvalidParamsError):
+		return httputil.BadRequestError(err.Error())
+	case errors.Is(err, spark.PresetExistsError):
+		return httputil.WithStatusError(http.StatusConflict, "preset already exists")
+	case errors.Is(err, spark.ReadOnlyPresetError):
+		return httputil.WithStatusError(http.StatusConflict, err.Error())
+	case errors.Is(err, spark.PresetNotFoundError):
+		return httputil.NotFoundError("preset not found")
+	case errors.Is(err, spark.UnresolvedVariableError):
+		return httputil.InternelServerError(err.Error())
+	}
+
+	logging.FromContext(r.Context()).Error("error when "+action+" preset", zap.Error(err))
+	return httputil.InternelServerError("error when " + action + " preset")
+}
+
+var HandleCreatePreset = func(s Presets) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			return httputil.BodyError(err, "couldn't read request body")
+		}
+
+		name := presetName(r)
+		if !auth.PresetAllowed(r.Context(), name) {
+			return forbidden("preset")
+		}
+		if err := s.CreatePreset(name, raw); err != nil {
+			return presetError(r, err, "creating")
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	})
+}
+
+var HandlePutPreset = func(s Presets) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			return httputil.BodyError(err, "couldn't read request body")
+		}
+
+		name := presetName(r)
+		if !auth.PresetAllowed(r.Context(), name) {
+			return forbidden("preset")
+		}
+		if err := s.PutPreset(name, raw); err != nil {
+			return presetError(r, err, "saving")
+		}
+
+		return nil
+	})
+}
+
+type PresetLinter interface {
+	LintPreset(name string, raw []byte) spark.PresetLint
+}
+
+// lintPresetName is the name presets are checked with by POST /presets/lint
+// without a name parameter
+const lintPresetName = "lint"
+
+// HandleLintPreset responds with the errors and warnings of the preset in the
+// request body without saving it. The preset is named by the URL or the name
+// parameter, unnamed presets can be checked by every API key.
+var HandleLintPreset = func(s PresetLinter) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			return httputil.BodyError(err, "couldn't read request body")
+		}
+
+		name := presetName(r)
+		if name == "" {
+			name = r.URL.Query().Get("name")
+		}
+		if name == "" {
+			name = lintPresetName
+		} else if !auth.PresetAllowed(r.Context(), name) {
+			return forbidden("preset")
+		}
+
+		render.JSON(w, r, s.LintPreset(name, raw))
+		return nil
+	})
+}
+
+var HandleDeletePreset = func(s Presets) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		name := presetName(r)
+		if !auth.PresetAllowed(r.Context(), name) {
+			return forbidden("preset")
+		}
+		if err := s.DeletePreset(name); err != nil {
+			return presetError(r, err, "deleting")
+		}
+
+		return nil
+	})
+}
+
+type AuditLog interface {
+	Query(filter audit.Filter) ([]audit.Entry, error)
+}
+
+var HandleListAudit = func(l AuditLog) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		filter := audit.Filter{
+			Caller: r.URL.Query().Get("caller"),
+			Action: r.URL.Query().Get("action"),
+			Limit:  100,
+		}
+		if since := r.URL.Query().Get("since"); since != "" {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				return httputil.BadRequestError("invalid parameter since, expected an RFC 3339 timestamp")
+			}
+			filter.Since = t
+		}
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				return httputil.BadRequestError("invalid parameter limit, expected a positive number")
+			}
+			filter.Limit = n
+		}
+
+		entries, err := l.Query(filter)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error when querying audit log", zap.Error(err))
+			return httputil.InternelServerError("error when querying audit log")
+		}
+
+		render.JSON(w, r, struct {
+			Entries []audit.Entry `json:"entries"`
+		}{entries})
+		return nil
+	})
+}
+
+type Artifacts interface {
+	UploadArtifact(ctx context.Context, name string, content []byte) (spark.Artifact, error)
+	Artifacts(ctx context.Context) ([]spark.Artifact, error)
+}
+
+func artifactError(r *http.Request, err error, action string) error {
+	if errors.Is(err, spark.InvalidArtifactError) {
+		return httputil.BadRequestError(err.Error())
+	}
+	logging.FromContext(r.Context()).Error("error when "+action+" artifacts", zap.Error(err))
+	return httputil.InternelServerError("error when " + action + " artifacts")
+}
+
+// HandleUploadArtifact stores the request body as the artifact named by the
+// rest of the path. The artifact store is shared, so tenants can't upload.
+var HandleUploadArtifact = func(s Artifacts) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if _, ok := auth.TenantFromContext(r.Context()); ok {
+			return forbidden("artifact store")
+		}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return httputil.BodyError(err, "couldn't read request body")
+		}
+
+		artifact, err := s.UploadArtifact(r.Context(), chi.URLParam(r, "*"), content)
+		if err != nil {
+			return artifactError(r, err, "uploading")
+		}
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, artifact)
+		return nil
+	})
+}
+
+var HandleListArtifacts = func(s Artifacts) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		artifacts, err := s.Artifacts(r.Context())
+		if err != nil {
+			return artifactError(r, err, "listing")
+		}
+		render.JSON(w, r, struct {
+			Artifacts []spark.Artifact `json:"artifacts"`
+		}{artifacts})
+		return nil
+	})
+}
+
+type Pipelines interface {
+	Pipelines() []pipelines.Pipeline
+	Pipeline(name string) (pipelines.Pipeline, error)
+	PutPipeline(name string, raw []byte) (pipelines.Pipeline, error)
+	DeletePipeline(name string) error
+	Start(ctx context.Context, name string) (pipelines.Run, error)
+	Runs(pipeline string) []pipelines.Run
+	Run(id string) (pipelines.Run, error)
+}
+
+func pipelineError(r *http.Request, err error, action string) error {
+	switch {
+	case errors.Is(err, pipelines.InvalidPipelineError):
+		return httputil.BadRequestError(err.Error())
+	case errors.Is(err, pipelines.NotFoundError):
+		return httputil.NotFoundError("pipeline not found")
+	case errors.Is(err, pipelines.RunNotFoundError):
+		return httputil.NotFoundError("pipeline run not found")
+	}
+	logging.FromContext(r.Context()).Error("error when "+action+" pipeline", zap.Error(err))
+	return httputil.InternelServerError("error when " + action + " pipeline")
+}
+
+// noTenant rejects tenant API keys, pipelines may chain presets of several tenants
+func noTenant(r *http.Request) error {
+	if _, ok := auth.TenantFromContext(r.Context()); ok {
+		return forbidden("pipeline")
+	}
+	return nil
+}
+
+var HandleListPipelines = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		render.JSON(w, r, struct {
+			Pipelines []pipelines.Pipeline `json:"pipelines"`
+		}{p.Pipelines()})
+		return nil
+	})
+}
+
+var HandleGetPipeline = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		pipeline, err := p.Pipeline(chi.URLParam(r, "name"))
+		if err != nil {
+			return pipelineError(r, err, "reading")
+		}
+		render.JSON(w, r, pipeline)
+		return nil
+	})
+}
+
+// HandlePutPipeline creates or replaces the pipeline with the YAML or JSON definition of the body
+var HandlePutPipeline = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			return httputil.BodyError(err, "couldn't read request body")
+		}
+
+		pipeline, err := p.PutPipeline(chi.URLParam(r, "name"), raw)
+		if err != nil {
+			return pipelineError(r, err, "saving")
+		}
+		render.JSON(w, r, pipeline)
+		return nil
+	})
+}
+
+var HandleDeletePipeline = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		if err := p.DeletePipeline(chi.URLParam(r, "name")); err != nil {
+			return pipelineError(r, err, "deleting")
+		}
+		return nil
+	})
+}
+
+// HandleStartPipeline starts a run of the pipeline, the response contains
+// the jobs of the steps without dependencies. The API key must be allowed to
+// submit the presets of all steps.
+var HandleStartPipeline = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		pipeline, err := p.Pipeline(chi.URLParam(r, "name"))
+		if err != nil {
+			return pipelineError(r, err, "starting")
+		}
+		for _, step := range pipeline.Steps {
+			if !auth.PresetAllowed(r.Context(), step.Preset) {
+				return forbidden("preset " + step.Preset)
+			}
+		}
+		run, err := p.Start(r.Context(), pipeline.Name)
+		if err != nil {
+			return pipelineError(r, err, "starting")
+		}
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, run)
+		return nil
+	})
+}
+
+var HandleListPipelineRuns = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		name := chi.URLParam(r, "name")
+		if _, err := p.Pipeline(name); err != nil {
+			return pipelineError(r, err, "reading")
+		}
+		render.JSON(w, r, struct {
+			Runs []pipelines.Run `json:"runs"`
+		}{p.Runs(name)})
+		return nil
+	})
+}
+
+var HandleGetPipelineRun = func(p Pipelines) http.HandlerFunc {
+	return httputil.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if err := noTenant(r); err != nil {
+			return err
+		}
+		run, err := p.Run(chi.URLParam(r, "id"))
+		if err != nil || run.Pipeline != chi.URLParam(r, "name") {
+			return httputil.NotFoundError("pipeline run not found")
+		}
+		render.JSON(w, r, run)
+		return nil
+	})
+}
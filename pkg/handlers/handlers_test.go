@@ -16,14 +16,27 @@ limitations under the License.
 package handlers
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Staffbase/spark-submit/pkg/audit"
+	"github.com/Staffbase/spark-submit/pkg/auth"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/pipelines"
+	"github.com/Staffbase/spark-submit/pkg/scheduler"
 	"github.com/Staffbase/spark-submit/pkg/spark"
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 type recorder struct {
@@ -65,34 +78,179 @@ func TestHandleHealth(t *testing.T) {
 	})
 }
 
+type readinessMock struct {
+	draining bool
+}
+
+func (m *readinessMock) Ready() error {
+	if m.draining {
+		return errors.New("draining")
+	}
+	return nil
+}
+func (m *readinessMock) Drain()  { m.draining = true }
+func (m *readinessMock) Resume() { m.draining = false }
+
+func TestHandleReady(t *testing.T) {
+	s := &readinessMock{}
+	t.Run("responds with 200 when ready", func(t *testing.T) {
+		w, r := newRequest("", "/readyz")
+		HandleReady(s)(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+	})
+
+	t.Run("responds with 503 while draining", func(t *testing.T) {
+		w, r := newRequest(http.MethodPost, "/api/v1/drain")
+		HandleDrain(s)(w, r)
+		w.assertHTTPStatus(t, http.StatusNoContent)
+
+		w, r = newRequest("", "/readyz")
+		HandleReady(s)(w, r)
+		w.assertHTTPStatus(t, http.StatusServiceUnavailable)
+		w.assertError(t, "draining")
+	})
+
+	t.Run("responds with 200 after resuming", func(t *testing.T) {
+		w, r := newRequest(http.MethodDelete, "/api/v1/drain")
+		HandleResume(s)(w, r)
+		w.assertHTTPStatus(t, http.StatusNoContent)
+
+		w, r = newRequest("", "/readyz")
+		HandleReady(s)(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+	})
+}
+
+type settingsMock struct {
+	settings spark.Settings
+	update   spark.SettingsUpdate
+}
+
+func (m *settingsMock) Settings() spark.Settings { return m.settings }
+func (m *settingsMock) UpdateSettings(update spark.SettingsUpdate) (spark.Settings, error) {
+	if update.MaxConcurrentSubmits != nil && *update.MaxConcurrentSubmits <= 0 {
+		return m.settings, fmt.Errorf("%w: maxConcurrentSubmits must be positive", spark.InvalidSettingsError)
+	}
+	m.update = update
+	if update.Maintenance != nil {
+		m.settings.Maintenance = *update.Maintenance
+	}
+	return m.settings, nil
+}
+
+func TestHandleSettings(t *testing.T) {
+	t.Run("responds with the settings and the log level", func(t *testing.T) {
+		level := zap.NewAtomicLevelAt(zap.WarnLevel)
+		w, r := newRequest("", "/api/v1/admin/settings")
+		HandleGetSettings(&settingsMock{settings: spark.Settings{MaxConcurrentSubmits: 4}}, level)(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&body))
+		require.Equal(t, float64(4), body["maxConcurrentSubmits"])
+		require.Equal(t, "warn", body["logLevel"])
+	})
+
+	t.Run("applies the settings and the log level", func(t *testing.T) {
+		s := &settingsMock{}
+		level := zap.NewAtomicLevelAt(zap.InfoLevel)
+		w, r := newRequest(http.MethodPatch, "/api/v1/admin/settings")
+		r.Body = io.NopCloser(strings.NewReader(`{"maintenance": true, "logLevel": "debug"}`))
+		HandleUpdateSettings(s, level)(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.True(t, *s.update.Maintenance)
+		require.Nil(t, s.update.MaxConcurrentSubmits)
+		require.Equal(t, zap.DebugLevel, level.Level())
+	})
+
+	t.Run("given invalid settings, responds with 400 and keeps the log level", func(t *testing.T) {
+		level := zap.NewAtomicLevelAt(zap.InfoLevel)
+		w, r := newRequest(http.MethodPatch, "/api/v1/admin/settings")
+		r.Body = io.NopCloser(strings.NewReader(`{"maxConcurrentSubmits": 0, "logLevel": "debug"}`))
+		HandleUpdateSettings(&settingsMock{}, level)(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "maxConcurrentSubmits must be positive")
+		require.Equal(t, zap.InfoLevel, level.Level())
+
+		w, r = newRequest(http.MethodPatch, "/api/v1/admin/settings")
+		r.Body = io.NopCloser(strings.NewReader(`{"logLevel": "loud"}`))
+		HandleUpdateSettings(&settingsMock{}, level)(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, `unknown log level "loud"`)
+	})
+
+	t.Run("given an unknown setting, responds with 400", func(t *testing.T) {
+		w, r := newRequest(http.MethodPatch, "/api/v1/admin/settings")
+		r.Body = io.NopCloser(strings.NewReader(`{"maxWorkers": 2}`))
+		HandleUpdateSettings(&settingsMock{}, zap.NewAtomicLevel())(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+	})
+
+	t.Run("given a tenant, responds with 403", func(t *testing.T) {
+		w, r := newRequest("", "/tenants/team-a/api/v1/admin/settings")
+		r = r.WithContext(auth.WithTenant(r.Context(), auth.Tenant{Name: "team-a"}))
+		HandleGetSettings(&settingsMock{}, zap.NewAtomicLevel())(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+}
+
 // mock implementation of spark dependency
 type sparkMock struct {
-	submit func(preset string) error
-	kill   func(namespace, name string)
-	status func(namespace, name string) string
+	submit   func(preset string, opts spark.SubmitOptions) (string, error)
+	kill     func(namespace, name string)
+	status   func(namespace, name string) spark.StatusReport
+	killApps func(namespace string, filter spark.AppFilter) ([]string, error)
+	job      func(id string) (jobs.Job, error)
+	// namespaces are the allowed namespaces, all if nil
+	namespaces []string
 }
 
-func (sm *sparkMock) Submit(preset string) error {
+func (sm *sparkMock) Submit(_ context.Context, preset string, opts spark.SubmitOptions) (string, error) {
 	if sm.submit == nil {
 		// relaxed fallback
-		return nil
+		return "", nil
 	}
-	return sm.submit(preset)
+	return sm.submit(preset, opts)
 }
-func (sm *sparkMock) Kill(namespace, name string) {
+func (sm *sparkMock) Kill(_ context.Context, namespace, name string) {
 	if sm.kill == nil {
 		return
 	}
 
 	sm.kill(namespace, name)
 }
-func (sm *sparkMock) Status(namespace, name string) string {
+func (sm *sparkMock) Status(_ context.Context, namespace, name string) spark.StatusReport {
 	if sm.status == nil {
-		return ""
+		return spark.StatusReport{}
 	}
 
 	return sm.status(namespace, name)
 }
+func (sm *sparkMock) Job(id string) (jobs.Job, error) {
+	if sm.job == nil {
+		return jobs.Job{ID: id, State: jobs.StateSucceeded}, nil
+	}
+
+	return sm.job(id)
+}
+func (sm *sparkMock) NamespaceAllowed(namespace string) bool {
+	if sm.namespaces == nil {
+		return true
+	}
+	for _, allowed := range sm.namespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+func (sm *sparkMock) KillApps(_ context.Context, namespace string, filter spark.AppFilter) ([]string, error) {
+	if sm.killApps == nil {
+		return []string{}, nil
+	}
+
+	return sm.killApps(namespace, filter)
+}
 
 func TestHandleSubmit(t *testing.T) {
 	t.Run("given a valid preset, responds 200", func(t *testing.T) {
@@ -102,6 +260,190 @@ func TestHandleSubmit(t *testing.T) {
 		w.assertHTTPStatus(t, http.StatusOK)
 	})
 
+	t.Run("given a preset the API key may not access, responds 403", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "the API key may not access this preset")
+	})
+
+	t.Run("given a preset with an unset environment variable, responds 500 with the variable", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf(`%w "BUCKET"`, spark.UnresolvedVariableError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusInternalServerError)
+		w.assertError(t, `unresolved environment variable "BUCKET"`)
+	})
+
+	t.Run("given params the preset template can't render, responds 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, map[string]string{"day": "1"}, opts.Params)
+				return "", fmt.Errorf(`%w: map has no entry for key "date"`, spark.InvalidParamsError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		r.Body = io.NopCloser(strings.NewReader(`{"params": {"day": "1"}}`))
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, `invalid params: map has no entry for key "date"`)
+	})
+
+	t.Run("given a valid preset, responds with the job id", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "my-job-id", nil
+			},
+		})
+		w, r := newRequest("", "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Equal(t, "my-job-id", result.ID)
+	})
+
+	t.Run("given a request body, passes the submit options", func(t *testing.T) {
+		var got spark.SubmitOptions
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				got = opts
+				return "", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		r.Body = io.NopCloser(strings.NewReader(`{"sparkConf": {"spark.executor.instances": "4"}, "args": ["--date=2023-07-10"]}`))
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.NotNil(t, got.NamespaceAllowed)
+		got.NamespaceAllowed = nil
+		require.Equal(t, spark.SubmitOptions{
+			SparkConf: map[string]string{"spark.executor.instances": "4"},
+			Args:      []string{"--date=2023-07-10"},
+		}, got)
+	})
+
+	t.Run("given a pod template and labels, passes them to submit", func(t *testing.T) {
+		var got spark.SubmitOptions
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				got = opts
+				return "", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		r.Body = io.NopCloser(strings.NewReader(`{"podTemplate": {"name": "spot", "driver": {"spec": {"priorityClassName": "high"}}}, "labels": {"ticket": "DATA-123"}}`))
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Equal(t, map[string]string{"ticket": "DATA-123"}, got.Labels)
+		require.Equal(t, "spot", got.PodTemplate.Name)
+		require.JSONEq(t, `{"spec": {"priorityClassName": "high"}}`, string(got.PodTemplate.Driver))
+	})
+
+	t.Run("given an invalid label, responds 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf(`couldn't build submit args, %w: "ticket id" isn't a valid label key`, spark.InvalidLabelError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, `"ticket id" isn't a valid label key`)
+	})
+
+	t.Run("given an invalid pod template, responds 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf(`%w: pod template "nope" not found`, spark.InvalidPodTemplateError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, `pod template "nope" not found`)
+	})
+
+	t.Run("given a retry policy beyond the limits, responds 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, 20, *opts.Retry.Retries)
+				require.Equal(t, time.Second, opts.Retry.InitialDelay)
+				return "", fmt.Errorf("%w: at most 10 retries are allowed", spark.InvalidRetryPolicyError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		r.Body = io.NopCloser(strings.NewReader(`{"retry": {"retries": 20, "initialDelay": "1s"}}`))
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "at most 10 retries are allowed")
+	})
+
+	t.Run("given a missing artifact, responds 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf(`%w: "etl.jar"`, spark.ArtifactNotFoundError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, `artifact not found: "etl.jar"`)
+	})
+
+	t.Run("given a changed artifact, responds 409", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, map[string]string{"etl.jar": "sha256:abc"}, opts.ArtifactDigests)
+				return "", fmt.Errorf(`%w: "etl.jar" has the digest sha256:def, not sha256:abc`, spark.ArtifactChangedError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		r.Body = io.NopCloser(strings.NewReader(`{"artifactDigests": {"etl.jar": "sha256:abc"}}`))
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusConflict)
+		w.assertError(t, `artifact changed: "etl.jar" has the digest sha256:def, not sha256:abc`)
+	})
+
+	t.Run("given run_at, passes the time to submit", func(t *testing.T) {
+		var got spark.SubmitOptions
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				got = opts
+				return "", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi&run_at=2023-07-10T12:00:00Z")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Equal(t, time.Date(2023, 7, 10, 12, 0, 0, 0, time.UTC), *got.RunAt)
+	})
+
+	t.Run("given an invalid run_at, responds with 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{})
+		w, r := newRequest(http.MethodPost, "/?preset=pi&run_at=tomorrow")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "invalid parameter run_at")
+	})
+
+	t.Run("given an invalid request body, responds with 400", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		r.Body = io.NopCloser(strings.NewReader(`{"args": "nope"`))
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "invalid request body")
+	})
+
 	t.Run("given no preset parameter responds with 400", func(t *testing.T) {
 		handler := HandleSubmit(&sparkMock{})
 		w, r := newRequest("", "")
@@ -112,8 +454,8 @@ func TestHandleSubmit(t *testing.T) {
 
 	t.Run("given missing preset, responds with 404", func(t *testing.T) {
 		handler := HandleSubmit(&sparkMock{
-			submit: func(preset string) error {
-				return spark.PresetNotFoundError
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", spark.PresetNotFoundError
 			},
 		})
 		w, r := newRequest("", "/?preset=pi")
@@ -122,10 +464,136 @@ func TestHandleSubmit(t *testing.T) {
 		w.assertError(t, "reset not found")
 	})
 
+	t.Run("given a running preset that disallows concurrent runs, responds with 409", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", spark.PresetRunningError
+			},
+		})
+		w, r := newRequest("", "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusConflict)
+		w.assertError(t, "preset is already running")
+	})
+
+	t.Run("given a namespace of another tenant, responds with 403", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "1", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=data/etl")
+		r.Body = io.NopCloser(strings.NewReader(`{"sparkConf": {"spark.kubernetes.namespace": "ml"}}`))
+		tenant := auth.Tenant{Name: "data", Presets: []string{"data/*"}, Namespaces: []string{"data"}}
+		handler(w, r.WithContext(auth.WithTenant(r.Context(), tenant)))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "the API key may not access this namespace")
+	})
+
+	t.Run("given a namespace the API key may not access, responds with 403", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				t.Fatal("nothing must be submitted")
+				return "", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=etl")
+		r.Body = io.NopCloser(strings.NewReader(`{"sparkConf": {"spark.kubernetes.namespace": "ml"}}`))
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "the API key may not access this namespace")
+	})
+
+	t.Run("restricts the namespace of the preset to the namespaces of the API key", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.True(t, opts.NamespaceAllowed("team"))
+				require.False(t, opts.NamespaceAllowed("ml"))
+				return "1", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=etl")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusOK)
+	})
+
+	t.Run("given maintenance, responds with 503", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", spark.MaintenanceError
+			},
+		})
+		w, r := newRequest("", "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusServiceUnavailable)
+		w.assertError(t, "server is in maintenance")
+	})
+
+	t.Run("given not enough cluster capacity, responds with 503", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf("%w, the driver (1 cores, 1.4GB) and 2 executors (1 cores, 1.4GB each) don't fit on the nodes", spark.CapacityExceededError)
+			},
+		})
+		w, r := newRequest("", "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusServiceUnavailable)
+		w.assertError(t, "not enough cluster capacity, the driver")
+	})
+
+	t.Run("given a full queue, responds with 503", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", spark.QueueFullError
+			},
+		})
+		w, r := newRequest("", "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusServiceUnavailable)
+		w.assertError(t, "submission queue is full")
+	})
+
+	t.Run("passes the master parameter and responds 403 if it isn't allowed", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, "k8s://https://other:443", opts.Master)
+				return "", fmt.Errorf(`%w, "%s" isn't one of the allowed masters`, spark.MasterNotAllowedError, opts.Master)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi&master=k8s://https://other:443")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "master not allowed")
+	})
+
+	t.Run("given a namespace the server may not submit to, responds 403", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf(`%w, the server may not submit to namespace "kube-system"`, spark.NamespaceNotAllowedError)
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "namespace not allowed")
+	})
+
+	t.Run("given a namespace at its quota, responds with 429", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", fmt.Errorf(`%w, namespace "etl" is limited to 5 jobs`, spark.NamespaceQuotaExceededError)
+			},
+		})
+		w, r := newRequest("", "/?preset=pi")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusTooManyRequests)
+		w.assertError(t, `namespace quota exceeded, namespace "etl" is limited to 5 jobs`)
+	})
+
 	t.Run("given submission error, responds with 500", func(t *testing.T) {
 		handler := HandleSubmit(&sparkMock{
-			submit: func(preset string) error {
-				return errors.New("nope")
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", errors.New("nope")
 			},
 		})
 		w, r := newRequest("", "/?preset=pi")
@@ -135,6 +603,88 @@ func TestHandleSubmit(t *testing.T) {
 	})
 }
 
+func TestHandleSubmitWait(t *testing.T) {
+	submitWaitInterval = time.Millisecond
+	t.Cleanup(func() { submitWaitInterval = 5 * time.Second })
+
+	t.Run("given wait=true, polls the job and its application until they ended", func(t *testing.T) {
+		polls := 0
+		handler := HandleSubmit(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) { return "1", nil },
+			job: func(id string) (jobs.Job, error) {
+				polls++
+				if polls < 3 {
+					return jobs.Job{ID: id, State: jobs.StateRunning}, nil
+				}
+				return jobs.Job{ID: id, State: jobs.StateSucceeded, Namespace: "etl", DriverPod: "pi-driver"}, nil
+			},
+			status: func(namespace, name string) spark.StatusReport {
+				phase := "Running"
+				if polls > 3 {
+					phase = "Succeeded"
+				}
+				return spark.StatusReport{Apps: []spark.AppStatus{{PodName: name, Namespace: namespace, Phase: phase}}}
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi&wait=true&timeout=1m")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var body waitResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.True(t, body.Done)
+		require.Equal(t, jobs.StateSucceeded, body.State)
+		require.Equal(t, "Succeeded", body.App.Phase)
+		require.Equal(t, 4, polls)
+	})
+
+	t.Run("given a failed job, responds 200 with its state", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) { return "1", nil },
+			job: func(id string) (jobs.Job, error) {
+				return jobs.Job{ID: id, State: jobs.StateFailed, Error: "exit status 1"}, nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/pi/jobs?wait=true")
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Equal(t, "/api/v1/jobs/1", w.Header().Get("Location"))
+
+		var body waitResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.Equal(t, jobs.StateFailed, body.State)
+		require.Nil(t, body.App)
+	})
+
+	t.Run("given the timeout passes first, responds 202 with the current state", func(t *testing.T) {
+		handler := HandleSubmit(&sparkMock{
+			job: func(id string) (jobs.Job, error) { return jobs.Job{ID: id, State: jobs.StateRunning}, nil },
+		})
+		w, r := newRequest(http.MethodPost, "/?preset=pi&wait=true&timeout=10ms")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusAccepted)
+
+		var body waitResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.False(t, body.Done)
+		require.Equal(t, jobs.StateRunning, body.State)
+	})
+
+	t.Run("given an invalid timeout, responds 400 without submitting", func(t *testing.T) {
+		for _, query := range []string{"wait=yes", "wait=true&timeout=soon", "wait=true&timeout=-1m", "wait=true&timeout=48h"} {
+			handler := HandleSubmit(&sparkMock{
+				submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+					t.Fatal("must not submit")
+					return "", nil
+				},
+			})
+			w, r := newRequest(http.MethodPost, "/?preset=pi&"+query)
+			handler(w, r)
+			w.assertHTTPStatus(t, http.StatusBadRequest)
+		}
+	})
+}
+
 func TestHandleKill(t *testing.T) {
 	t.Run("given a valid preset, responds 200", func(t *testing.T) {
 		handler := HandleKill(&sparkMock{})
@@ -151,6 +701,26 @@ func TestHandleKill(t *testing.T) {
 		w.assertError(t, "missing parameter namespace")
 	})
 
+	t.Run("given a namespace the API key may not access, responds 403", func(t *testing.T) {
+		handler := HandleKill(&sparkMock{})
+		w, r := newRequest("", "/?namespace=foo&name=bar")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+
+	t.Run("given a namespace the server may not access, responds 403 without killing", func(t *testing.T) {
+		handler := HandleKill(&sparkMock{
+			namespaces: []string{"etl"},
+			kill: func(namespace, name string) {
+				t.Fatal("must not kill")
+			},
+		})
+		w, r := newRequest("", "/?namespace=foo&name=bar")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, `the server may not access namespace "foo"`)
+	})
+
 	t.Run("given no namespace, responds 400", func(t *testing.T) {
 		handler := HandleKill(&sparkMock{})
 		w, r := newRequest("", "/?namespace=foo")
@@ -164,8 +734,11 @@ func TestHandleStatus(t *testing.T) {
 	t.Run("given a valid preset, responds 200 and the status message from spark", func(t *testing.T) {
 		sparkStatusMessage := "spark-status=good"
 		handler := HandleStatus(&sparkMock{
-			status: func(namespace, name string) string {
-				return sparkStatusMessage
+			status: func(namespace, name string) spark.StatusReport {
+				return spark.StatusReport{
+					Raw:  sparkStatusMessage,
+					Apps: []spark.AppStatus{{PodName: "bar-driver", Phase: "Running"}},
+				}
 			},
 		})
 		w, r := newRequest("", "/?namespace=foo&name=bar")
@@ -173,10 +746,13 @@ func TestHandleStatus(t *testing.T) {
 		w.assertHTTPStatus(t, http.StatusOK)
 
 		var result struct {
-			Status string `json:"status"`
+			Status string            `json:"status"`
+			Apps   []spark.AppStatus `json:"apps"`
 		}
 		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
 		require.Equal(t, result.Status, sparkStatusMessage)
+		require.Len(t, result.Apps, 1)
+		require.Equal(t, "Running", result.Apps[0].Phase)
 	})
 	t.Run("given no namespace, responds 400", func(t *testing.T) {
 		handler := HandleStatus(&sparkMock{})
@@ -186,13 +762,20 @@ func TestHandleStatus(t *testing.T) {
 		w.assertError(t, "missing parameter namespace")
 	})
 
+	t.Run("given a namespace the API key may not access, responds 403", func(t *testing.T) {
+		handler := HandleStatus(&sparkMock{})
+		w, r := newRequest("", "/?namespace=foo")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+
 	t.Run("given no name, falls back to name=*", func(t *testing.T) {
 		ran := false
 		handler := HandleStatus(&sparkMock{
-			status: func(namespace, name string) string {
+			status: func(namespace, name string) spark.StatusReport {
 				require.Equal(t, "*", name)
 				ran = true
-				return ""
+				return spark.StatusReport{}
 			},
 		})
 		w, r := newRequest("", "/?namespace=foo")
@@ -201,3 +784,1194 @@ func TestHandleStatus(t *testing.T) {
 		require.True(t, ran)
 	})
 }
+
+type jobsMock []jobs.Job
+
+func (m jobsMock) Jobs() ([]jobs.Job, error) {
+	return m, nil
+}
+
+func (m jobsMock) ForEachJob(fn func(job jobs.Job) error) error {
+	for _, job := range m {
+		if err := fn(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m jobsMock) Job(id string) (jobs.Job, error) {
+	for _, job := range m {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+	return jobs.Job{}, jobs.JobNotFoundError
+}
+
+func TestHandleListJobs(t *testing.T) {
+	t.Run("responds 200 with all known jobs", func(t *testing.T) {
+		handler := HandleListJobs(jobsMock{
+			{ID: "first", Preset: "pi", State: jobs.StateRunning},
+			{ID: "second", Preset: "pi", State: jobs.StateFailed},
+		})
+		w, r := newRequest("", "/jobs")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Jobs []jobs.Job `json:"jobs"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Jobs, 2)
+		require.Equal(t, "first", result.Jobs[0].ID)
+		require.Equal(t, jobs.StateFailed, result.Jobs[1].State)
+	})
+
+	t.Run("given a restricted API key, responds only with jobs of allowed presets", func(t *testing.T) {
+		handler := HandleListJobs(jobsMock{
+			{ID: "first", Preset: "pi"},
+			{ID: "second", Preset: "etl"},
+		})
+		w, r := newRequest("", "/jobs")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Jobs []jobs.Job `json:"jobs"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Jobs, 1)
+		require.Equal(t, "second", result.Jobs[0].ID)
+	})
+
+	t.Run("given filters and a limit, responds with pages of the matching jobs", func(t *testing.T) {
+		created := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		handler := HandleListJobs(jobsMock{
+			{ID: "first", Preset: "pi", State: jobs.StateFailed, CreatedAt: created},
+			{ID: "second", Preset: "pi", State: jobs.StateFailed, CreatedAt: created.Add(time.Hour)},
+			{ID: "third", Preset: "etl", State: jobs.StateFailed, CreatedAt: created.Add(2 * time.Hour)},
+			{ID: "fourth", Preset: "pi", State: jobs.StateSucceeded, CreatedAt: created.Add(3 * time.Hour)},
+			{ID: "fifth", Preset: "pi", State: jobs.StateCancelled, CreatedAt: created.Add(4 * time.Hour)},
+		})
+		var result struct {
+			Jobs []jobs.Job `json:"jobs"`
+			Next string     `json:"next"`
+		}
+
+		w, r := newRequest("", "/jobs?status=failed,cancelled&preset=pi&since=2023-06-01T12:30:00Z&limit=1")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Jobs, 1)
+		require.Equal(t, "second", result.Jobs[0].ID)
+		require.Equal(t, "second", result.Next)
+
+		w, r = newRequest("", "/jobs?status=failed,cancelled&preset=pi&since=2023-06-01T12:30:00Z&limit=1&cursor="+result.Next)
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		result.Next = ""
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Jobs, 1)
+		require.Equal(t, "fifth", result.Jobs[0].ID)
+		require.Empty(t, result.Next)
+	})
+
+	t.Run("given invalid parameters, responds 400", func(t *testing.T) {
+		handler := HandleListJobs(jobsMock{{ID: "first", Preset: "pi"}})
+		for _, query := range []string{"status=done", "since=yesterday", "limit=0", "cursor=unknown"} {
+			w, r := newRequest("", "/jobs?"+query)
+			handler(w, r)
+			w.assertHTTPStatus(t, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestHandleExportJobs(t *testing.T) {
+	created := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	exitCode := 1
+	mock := jobsMock{
+		{ID: "first", Preset: "pi", State: jobs.StateFailed, CreatedAt: created, ExitCode: &exitCode, Error: "exit status 1", Attempts: []jobs.Attempt{{}, {}}},
+		{ID: "second", Preset: "etl", State: jobs.StateSucceeded, CreatedAt: created.Add(time.Hour)},
+	}
+
+	t.Run("given format=csv, responds with a row per job", func(t *testing.T) {
+		w, r := newRequest("", "/jobs/export?format=csv")
+		HandleExportJobs(mock)(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+		require.Contains(t, w.Header().Get("Content-Disposition"), `filename="jobs.csv"`)
+
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		require.Equal(t, "id", rows[0][0])
+		require.Equal(t, []string{"first", "pi", "failed", "2023-06-01T12:00:00Z", "", "", "", "2", "1", "exit status 1", "", "", "", "", "0", "false"}, rows[1])
+		require.Equal(t, "second", rows[2][0])
+	})
+
+	t.Run("responds with a JSON array of the jobs the API key may access", func(t *testing.T) {
+		w, r := newRequest("", "/jobs/export")
+		HandleExportJobs(mock)(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result []jobs.Job
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result, 1)
+		require.Equal(t, "second", result[0].ID)
+	})
+
+	t.Run("given no jobs, responds with an empty JSON array", func(t *testing.T) {
+		w, r := newRequest("", "/jobs/export?format=json")
+		HandleExportJobs(jobsMock{})(w, r)
+		require.Equal(t, "[]\n", w.Body.String())
+	})
+
+	t.Run("given an unknown format, responds 400", func(t *testing.T) {
+		w, r := newRequest("", "/jobs/export?format=xml")
+		HandleExportJobs(mock)(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+	})
+
+	t.Run("given a failing job store, responds 500", func(t *testing.T) {
+		w, r := newRequest("", "/jobs/export")
+		HandleExportJobs(exporterMock(func(fn func(job jobs.Job) error) error {
+			return errors.New("database closed")
+		}))(w, r)
+		w.assertHTTPStatus(t, http.StatusInternalServerError)
+	})
+}
+
+type exporterMock func(fn func(job jobs.Job) error) error
+
+func (m exporterMock) ForEachJob(fn func(job jobs.Job) error) error {
+	return m(fn)
+}
+
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandleGetJob(t *testing.T) {
+	t.Run("given a known id, responds 200 with the job", func(t *testing.T) {
+		handler := HandleGetJob(jobsMock{{ID: "first", Preset: "pi"}})
+		w, r := newRequest("", "/jobs/first")
+		handler(w, withURLParam(r, "id", "first"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result jobs.Job
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Equal(t, "pi", result.Preset)
+	})
+
+	t.Run("given an unknown id, responds 404", func(t *testing.T) {
+		handler := HandleGetJob(jobsMock{})
+		w, r := newRequest("", "/jobs/nope")
+		handler(w, withURLParam(r, "id", "nope"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+		w.assertError(t, "job not found")
+	})
+
+	t.Run("given a job of a preset the API key may not access, responds 404", func(t *testing.T) {
+		handler := HandleGetJob(jobsMock{{ID: "first", Preset: "pi"}})
+		w, r := newRequest("", "/jobs/first")
+		handler(w, withURLParam(withKey(r), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+}
+
+type presetsMock struct {
+	save   func(name string, raw []byte) error
+	delete func(name string) error
+}
+
+func (pm *presetsMock) CreatePreset(name string, raw []byte) error {
+	return pm.save(name, raw)
+}
+func (pm *presetsMock) PutPreset(name string, raw []byte) error {
+	return pm.save(name, raw)
+}
+func (pm *presetsMock) DeletePreset(name string) error {
+	return pm.delete(name)
+}
+
+func TestHandlePresets(t *testing.T) {
+	t.Run("given a valid preset, create responds 201", func(t *testing.T) {
+		var gotName, gotBody string
+		handler := HandleCreatePreset(&presetsMock{
+			save: func(name string, raw []byte) error {
+				gotName, gotBody = name, string(raw)
+				return nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/presets/etl")
+		r.Body = io.NopCloser(strings.NewReader("main: etl.py"))
+		handler(w, withURLParam(r, "name", "etl"))
+		w.assertHTTPStatus(t, http.StatusCreated)
+		require.Equal(t, "etl", gotName)
+		require.Equal(t, "main: etl.py", gotBody)
+	})
+
+	t.Run("given an existing preset, create responds 409", func(t *testing.T) {
+		handler := HandleCreatePreset(&presetsMock{
+			save: func(name string, raw []byte) error {
+				return spark.PresetExistsError
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/presets/etl")
+		handler(w, withURLParam(r, "name", "etl"))
+		w.assertHTTPStatus(t, http.StatusConflict)
+		w.assertError(t, "preset already exists")
+	})
+
+	t.Run("given a preset of a preset source, delete responds 409", func(t *testing.T) {
+		handler := HandleDeletePreset(&presetsMock{
+			delete: func(name string) error {
+				return fmt.Errorf("%w, it is managed by ConfigMaps", spark.ReadOnlyPresetError)
+			},
+		})
+		w, r := newRequest(http.MethodDelete, "/presets/etl")
+		handler(w, withURLParam(r, "name", "etl"))
+		w.assertHTTPStatus(t, http.StatusConflict)
+		w.assertError(t, "preset is read-only, it is managed by ConfigMaps")
+	})
+
+	t.Run("given an invalid preset, put responds 400", func(t *testing.T) {
+		handler := HandlePutPreset(&presetsMock{
+			save: func(name string, raw []byte) error {
+				return fmt.Errorf("%w: main is required", spark.InvalidPresetError)
+			},
+		})
+		w, r := newRequest(http.MethodPut, "/presets/etl")
+		handler(w, withURLParam(r, "name", "etl"))
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "main is required")
+	})
+
+	t.Run("given a missing preset, delete responds 404", func(t *testing.T) {
+		handler := HandleDeletePreset(&presetsMock{
+			delete: func(name string) error {
+				return spark.PresetNotFoundError
+			},
+		})
+		w, r := newRequest(http.MethodDelete, "/presets/etl")
+		handler(w, withURLParam(r, "name", "etl"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a preset the API key may not access, put responds 403", func(t *testing.T) {
+		handler := HandlePutPreset(&presetsMock{
+			save: func(name string, raw []byte) error {
+				t.Fatal("preset must not be saved")
+				return nil
+			},
+		})
+		w, r := newRequest(http.MethodPut, "/presets/pi")
+		handler(w, withURLParam(withKey(r), "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+}
+
+type cancellerMock struct {
+	jobsMock
+	cancel func(id string) error
+}
+
+func (m cancellerMock) Cancel(id string) error {
+	return m.cancel(id)
+}
+
+func TestHandleCancelJob(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "given a scheduled job", err: nil, wantStatus: http.StatusOK},
+		{name: "given an unknown job", err: jobs.JobNotFoundError, wantStatus: http.StatusNotFound},
+		{name: "given a job that already runs", err: spark.JobNotCancellableError, wantStatus: http.StatusConflict},
+	} {
+		t.Run(fmt.Sprintf("%s, responds %d", tt.name, tt.wantStatus), func(t *testing.T) {
+			handler := HandleCancelJob(cancellerMock{
+				jobsMock: jobsMock{{ID: "first", Preset: "pi"}},
+				cancel: func(id string) error {
+					require.Equal(t, "first", id)
+					return tt.err
+				},
+			})
+			w, r := newRequest(http.MethodDelete, "/jobs/first")
+			handler(w, withURLParam(r, "id", "first"))
+			w.assertHTTPStatus(t, tt.wantStatus)
+		})
+	}
+
+	t.Run("given a job of a preset the API key may not access, responds 404", func(t *testing.T) {
+		handler := HandleCancelJob(cancellerMock{
+			jobsMock: jobsMock{{ID: "first", Preset: "pi"}},
+			cancel: func(id string) error {
+				t.Fatal("job must not be cancelled")
+				return nil
+			},
+		})
+		w, r := newRequest(http.MethodDelete, "/jobs/first")
+		handler(w, withURLParam(withKey(r), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+}
+
+func TestHandleJobApp(t *testing.T) {
+	jobs := jobsMock{
+		{ID: "first", Preset: "pi", Namespace: "spark", DriverPod: "pi-123-driver", ApplicationID: "spark-123"},
+		{ID: "pending", Preset: "pi"},
+	}
+
+	t.Run("status requests the driver pod of the job", func(t *testing.T) {
+		backend := &sparkMock{status: func(namespace, name string) spark.StatusReport {
+			require.Equal(t, "spark", namespace)
+			require.Equal(t, "pi-123-driver", name)
+			return spark.StatusReport{Apps: []spark.AppStatus{{PodName: name, Phase: "Running"}}}
+		}}
+		w, r := newRequest("", "/jobs/first/app")
+		HandleJobAppStatus(jobs, backend)(w, withURLParam(r, "id", "first"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var report spark.StatusReport
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&report))
+		require.Equal(t, "Running", report.Apps[0].Phase)
+	})
+
+	t.Run("kill kills the driver pod of the job", func(t *testing.T) {
+		killed := ""
+		backend := &sparkMock{kill: func(namespace, name string) { killed = namespace + "/" + name }}
+		w, r := newRequest(http.MethodDelete, "/jobs/first/app")
+		HandleKillJobApp(jobs, backend)(w, withURLParam(r, "id", "first"))
+		w.assertHTTPStatus(t, http.StatusNoContent)
+		require.Equal(t, "spark/pi-123-driver", killed)
+	})
+
+	t.Run("given a job without application, responds 404", func(t *testing.T) {
+		backend := &sparkMock{kill: func(namespace, name string) { t.Fatal("nothing must be killed") }}
+		w, r := newRequest(http.MethodDelete, "/jobs/pending/app")
+		HandleKillJobApp(jobs, backend)(w, withURLParam(r, "id", "pending"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+		w.assertError(t, "no spark application")
+	})
+
+	t.Run("given a job of a preset the API key may not access, responds 404", func(t *testing.T) {
+		backend := &sparkMock{kill: func(namespace, name string) { t.Fatal("nothing must be killed") }}
+		w, r := newRequest(http.MethodDelete, "/jobs/first/app")
+		HandleKillJobApp(jobs, backend)(w, withURLParam(withKey(r), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a job in a namespace the API key may not access, responds 403", func(t *testing.T) {
+		backend := &sparkMock{
+			kill: func(namespace, name string) { t.Fatal("nothing must be killed") },
+			status: func(namespace, name string) spark.StatusReport {
+				t.Fatal("no status must be requested")
+				return spark.StatusReport{}
+			},
+		}
+		key := auth.Key{Name: "team", Key: "secret", Presets: []string{"pi"}, Namespaces: []string{"team"}}
+		w, r := newRequest(http.MethodDelete, "/jobs/first/app")
+		HandleKillJobApp(jobs, backend)(w, withURLParam(r.WithContext(auth.WithKey(r.Context(), key)), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "the API key may not access this namespace")
+
+		w, r = newRequest("", "/jobs/first/app")
+		HandleJobAppStatus(jobs, backend)(w, withURLParam(r.WithContext(auth.WithKey(r.Context(), key)), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+
+	t.Run("given a job in a namespace the server may not access, responds 403", func(t *testing.T) {
+		backend := &sparkMock{namespaces: []string{"etl"}, kill: func(namespace, name string) { t.Fatal("nothing must be killed") }}
+		w, r := newRequest(http.MethodDelete, "/jobs/first/app")
+		HandleKillJobApp(jobs, backend)(w, withURLParam(r, "id", "first"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, `the server may not access namespace "spark"`)
+	})
+}
+
+type podProxyMock func(namespace, name string, port int) http.Handler
+
+func (m podProxyMock) PodProxy(namespace, name string, port int) http.Handler {
+	return m(namespace, name, port)
+}
+
+func TestHandleJobUI(t *testing.T) {
+	jobs := jobsMock{
+		{ID: "running", Preset: "pi", State: jobs.StateRunning, Namespace: "spark", DriverPod: "pi-123-driver"},
+		{ID: "done", Preset: "pi", State: jobs.StateSucceeded, Namespace: "spark", DriverPod: "pi-456-driver"},
+		{ID: "pending", Preset: "pi", State: jobs.StatePending},
+	}
+	proxy := podProxyMock(func(namespace, name string, port int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s/%s:%d %s %s", namespace, name, port, r.URL.Path, r.Header.Get("X-Forwarded-Context"))
+		})
+	})
+	serve := func(r *http.Request) recorder {
+		router := chi.NewRouter()
+		router.Get("/api/v1/jobs/{id}/ui/*", HandleJobUI(jobs, &sparkMock{namespaces: []string{"spark"}}, proxy, 4040))
+		w := recorder{httptest.NewRecorder()}
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("proxies to the spark UI of the driver pod", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/running/ui/stages/")
+		w := serve(r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Equal(t, "spark/pi-123-driver:4040 /stages/ /api/v1/jobs/running/ui", w.Body.String())
+	})
+
+	t.Run("cleans the proxied path", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/running/ui/stages//stage/./")
+		w := serve(r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Equal(t, "spark/pi-123-driver:4040 /stages/stage/ /api/v1/jobs/running/ui", w.Body.String())
+	})
+
+	t.Run("given a path climbing out of the pod proxy, responds 400", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/running/ui/../../../../secrets")
+		w := serve(r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "must not contain .. segments")
+	})
+
+	t.Run("given a finished job, responds 409", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/done/ui/")
+		w := serve(r)
+		w.assertHTTPStatus(t, http.StatusConflict)
+		w.assertError(t, "only available while the job is running")
+	})
+
+	t.Run("given a job without driver pod, responds 404", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/pending/ui/")
+		w := serve(r)
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a job of a preset the API key may not access, responds 404", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/running/ui/")
+		w := serve(withKey(r))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a job in a namespace the API key may not access, responds 403", func(t *testing.T) {
+		_, r := newRequest("", "/api/v1/jobs/running/ui/")
+		key := auth.Key{Name: "team", Key: "secret", Presets: []string{"pi"}, Namespaces: []string{"team"}}
+		w := serve(r.WithContext(auth.WithKey(r.Context(), key)))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		w.assertError(t, "the API key may not access this namespace")
+	})
+}
+
+func TestHandleKillApps(t *testing.T) {
+	t.Run("given a preset, responds 200 with the killed apps", func(t *testing.T) {
+		backend := &sparkMock{killApps: func(namespace string, filter spark.AppFilter) ([]string, error) {
+			require.Equal(t, "spark", namespace)
+			require.Equal(t, spark.AppFilter{Preset: "etl"}, filter)
+			return []string{"etl-1-driver", "etl-2-driver"}, nil
+		}}
+		w, r := newRequest(http.MethodDelete, "/namespaces/spark/apps?preset=etl")
+		HandleKillApps(backend)(w, withURLParam(r, "namespace", "spark"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Killed []string `json:"killed"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Equal(t, []string{"etl-1-driver", "etl-2-driver"}, result.Killed)
+	})
+
+	t.Run("given neither preset nor label selector, responds 400", func(t *testing.T) {
+		backend := &sparkMock{killApps: func(string, spark.AppFilter) ([]string, error) {
+			t.Fatal("nothing must be killed")
+			return nil, nil
+		}}
+		w, r := newRequest(http.MethodDelete, "/namespaces/spark/apps")
+		HandleKillApps(backend)(w, withURLParam(r, "namespace", "spark"))
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+	})
+
+	t.Run("given a preset the API key may not access, responds 403", func(t *testing.T) {
+		w, r := newRequest(http.MethodDelete, "/namespaces/team/apps?preset=pi")
+		HandleKillApps(&sparkMock{})(w, withURLParam(withKey(r), "namespace", "team"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+
+	t.Run("given a backend without bulk kills, responds 501", func(t *testing.T) {
+		backend := &sparkMock{killApps: func(string, spark.AppFilter) ([]string, error) {
+			return nil, fmt.Errorf("%w, bulk kills need the kubernetes or yarn backend", spark.UnsupportedError)
+		}}
+		w, r := newRequest(http.MethodDelete, "/namespaces/spark/apps?labelSelector=team%3Ddata")
+		HandleKillApps(backend)(w, withURLParam(r, "namespace", "spark"))
+		w.assertHTTPStatus(t, http.StatusNotImplemented)
+		w.assertError(t, "kubernetes or yarn backend")
+	})
+}
+
+type submitOutputsMock struct {
+	jobsMock
+	output string
+}
+
+func (m submitOutputsMock) SubmitOutput(id string) (string, error) {
+	return m.output, nil
+}
+
+func TestHandleSubmitOutput(t *testing.T) {
+	t.Run("given a known id, responds 200 with the output as text", func(t *testing.T) {
+		handler := HandleSubmitOutput(submitOutputsMock{jobsMock: jobsMock{{ID: "first", Preset: "pi"}}, output: "=== attempt 1 ===\nerror\n"})
+		w, r := newRequest("", "/jobs/first/submit-output")
+		handler(w, withURLParam(r, "id", "first"))
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+		require.Equal(t, "=== attempt 1 ===\nerror\n", w.Body.String())
+	})
+
+	t.Run("given a job of a preset the API key may not access, responds 404", func(t *testing.T) {
+		handler := HandleSubmitOutput(submitOutputsMock{jobsMock: jobsMock{{ID: "first", Preset: "pi"}}, output: "secret"})
+		w, r := newRequest("", "/jobs/first/submit-output")
+		handler(w, withURLParam(withKey(r), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+}
+
+type jobEventsMock struct {
+	jobsMock
+}
+
+func (m jobEventsMock) JobEvents(id string) ([]jobs.Event, error) {
+	return []jobs.Event{{Type: jobs.EventCreated, State: jobs.StatePending}, {Type: jobs.EventStateChanged, State: jobs.StateRunning}}, nil
+}
+
+func TestHandleJobEvents(t *testing.T) {
+	t.Run("given a known id, responds 200 with the events", func(t *testing.T) {
+		handler := HandleJobEvents(jobEventsMock{jobsMock{{ID: "first", Preset: "pi"}}})
+		w, r := newRequest("", "/jobs/first/events")
+		handler(w, withURLParam(r, "id", "first"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Events []jobs.Event `json:"events"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Events, 2)
+		require.Equal(t, jobs.EventStateChanged, result.Events[1].Type)
+	})
+
+	t.Run("given a job of a preset the API key may not access, responds 404", func(t *testing.T) {
+		handler := HandleJobEvents(jobEventsMock{jobsMock{{ID: "first", Preset: "pi"}}})
+		w, r := newRequest("", "/jobs/first/events")
+		handler(w, withURLParam(withKey(r), "id", "first"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+}
+
+// withKey authenticates the request with a key restricted to the etl preset
+// and the team namespace
+func withKey(r *http.Request) *http.Request {
+	key := auth.Key{Name: "team", Key: "secret", Presets: []string{"etl"}, Namespaces: []string{"team"}}
+	return r.WithContext(auth.WithKey(r.Context(), key))
+}
+
+type auditLogMock func(filter audit.Filter) ([]audit.Entry, error)
+
+func (m auditLogMock) Query(filter audit.Filter) ([]audit.Entry, error) {
+	return m(filter)
+}
+
+func TestHandleListAudit(t *testing.T) {
+	t.Run("given filters, responds 200 with the matching entries", func(t *testing.T) {
+		handler := HandleListAudit(auditLogMock(func(filter audit.Filter) ([]audit.Entry, error) {
+			require.Equal(t, "ci", filter.Caller)
+			require.Equal(t, audit.Submit, filter.Action)
+			require.Equal(t, 5, filter.Limit)
+			require.Equal(t, 2023, filter.Since.Year())
+			return []audit.Entry{{Caller: "ci", Action: audit.Submit, Status: http.StatusOK}}, nil
+		}))
+		w, r := newRequest("", "/audit?caller=ci&action=submit&limit=5&since=2023-06-01T00:00:00Z")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Entries []audit.Entry `json:"entries"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Entries, 1)
+	})
+
+	t.Run("given an invalid limit, responds 400", func(t *testing.T) {
+		handler := HandleListAudit(auditLogMock(func(filter audit.Filter) ([]audit.Entry, error) {
+			return nil, nil
+		}))
+		w, r := newRequest("", "/audit?limit=0")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+	})
+}
+
+func TestHandleSubmitPreset(t *testing.T) {
+	t.Run("given a valid preset, responds 202 with the job location", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, "pi", preset)
+				return "my-job-id", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/pi/jobs")
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusAccepted)
+		require.Equal(t, "/api/v1/jobs/my-job-id", w.Header().Get("Location"))
+	})
+
+	t.Run("passes the Idempotency-Key header", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, "retry-1", opts.IdempotencyKey)
+				return "my-job-id", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/pi/jobs")
+		r.Header.Set("Idempotency-Key", "retry-1")
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusAccepted)
+	})
+
+	t.Run("given a too long Idempotency-Key, responds 400", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/pi/jobs")
+		r.Header.Set("Idempotency-Key", strings.Repeat("k", 256))
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+	})
+
+	t.Run("given an Idempotency-Key of another preset, responds 422", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", spark.IdempotencyKeyReusedError
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/pi/jobs")
+		r.Header.Set("Idempotency-Key", "retry-1")
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusUnprocessableEntity)
+		w.assertError(t, "Idempotency-Key was used for another preset")
+	})
+
+	t.Run("given a preset of a subdirectory, unescapes the name", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				require.Equal(t, "team/etl", preset)
+				return "my-job-id", nil
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/team%2Fetl/jobs")
+		handler(w, withURLParam(r, "name", "team%2Fetl"))
+		w.assertHTTPStatus(t, http.StatusAccepted)
+	})
+
+	t.Run("given an unknown preset, responds 404", func(t *testing.T) {
+		handler := HandleSubmitPreset(&sparkMock{
+			submit: func(preset string, opts spark.SubmitOptions) (string, error) {
+				return "", spark.PresetNotFoundError
+			},
+		})
+		w, r := newRequest(http.MethodPost, "/api/v1/presets/nope/jobs")
+		handler(w, withURLParam(r, "name", "nope"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+}
+
+func TestHandleApps(t *testing.T) {
+	t.Run("given no name, reports the status of all apps of the namespace", func(t *testing.T) {
+		handler := HandleAppStatus(&sparkMock{
+			status: func(namespace, name string) spark.StatusReport {
+				require.Equal(t, "foo", namespace)
+				require.Equal(t, "*", name)
+				return spark.StatusReport{}
+			},
+		})
+		w, r := newRequest("", "/api/v1/namespaces/foo/apps")
+		handler(w, withURLParam(r, "namespace", "foo"))
+		w.assertHTTPStatus(t, http.StatusOK)
+	})
+
+	t.Run("given live=true, only reports pending and running apps", func(t *testing.T) {
+		handler := HandleAppStatus(&sparkMock{
+			status: func(namespace, name string) spark.StatusReport {
+				return spark.StatusReport{Apps: []spark.AppStatus{
+					{PodName: "etl-1-driver", Preset: "etl", Phase: "Succeeded"},
+					{PodName: "etl-2-driver", Preset: "etl", Phase: "Running"},
+				}}
+			},
+		})
+		w, r := newRequest("", "/api/v1/namespaces/foo/apps?live=true")
+		handler(w, withURLParam(r, "namespace", "foo"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var report spark.StatusReport
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&report))
+		require.Len(t, report.Apps, 1)
+		require.Equal(t, "etl-2-driver", report.Apps[0].PodName)
+		require.Equal(t, "etl", report.Apps[0].Preset)
+	})
+
+	t.Run("given a name, kills the app and responds 204", func(t *testing.T) {
+		var killed string
+		handler := HandleKillApp(&sparkMock{
+			kill: func(namespace, name string) {
+				killed = namespace + "/" + name
+			},
+		})
+		w, r := newRequest(http.MethodDelete, "/api/v1/namespaces/foo/apps/bar")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("namespace", "foo")
+		rctx.URLParams.Add("name", "bar")
+		handler(w, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx)))
+		w.assertHTTPStatus(t, http.StatusNoContent)
+		require.Equal(t, "foo/bar", killed)
+	})
+
+	t.Run("given a namespace the API key may not access, responds 403", func(t *testing.T) {
+		handler := HandleKillApp(&sparkMock{})
+		w, r := newRequest(http.MethodDelete, "/api/v1/namespaces/foo/apps/bar")
+		handler(w, withURLParam(withKey(r), "namespace", "foo"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+}
+
+type presetDetailerMock map[string]spark.PresetDetail
+
+func (m presetDetailerMock) PresetDetail(name string, params map[string]string) (spark.PresetDetail, error) {
+	if params["date"] == "invalid" {
+		return spark.PresetDetail{}, fmt.Errorf("%w: bad date", spark.InvalidParamsError)
+	}
+	detail, ok := m[name]
+	if !ok {
+		return detail, spark.PresetNotFoundError
+	}
+	return detail, nil
+}
+
+func TestHandleGetPreset(t *testing.T) {
+	handler := HandleGetPreset(presetDetailerMock{"pi": {Name: "pi", Main: "pi.py", SubmitArgs: []string{"pi.py"}}})
+
+	t.Run("given a known preset, responds 200 with the detail", func(t *testing.T) {
+		w, r := newRequest("", "/presets/pi")
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result spark.PresetDetail
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Equal(t, []string{"pi.py"}, result.SubmitArgs)
+	})
+
+	t.Run("given invalid params, responds 400", func(t *testing.T) {
+		w, r := newRequest("", "/presets/pi?date=invalid")
+		handler(w, withURLParam(r, "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "invalid params: bad date")
+	})
+
+	t.Run("given an unknown preset, responds 404", func(t *testing.T) {
+		w, r := newRequest("", "/presets/nope")
+		handler(w, withURLParam(r, "name", "nope"))
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a preset the API key may not access, responds 403", func(t *testing.T) {
+		w, r := newRequest("", "/presets/pi")
+		handler(w, withURLParam(withKey(r), "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+}
+
+type presetLinterFunc func(name string, raw []byte) spark.PresetLint
+
+func (f presetLinterFunc) LintPreset(name string, raw []byte) spark.PresetLint {
+	return f(name, raw)
+}
+
+func TestHandleLintPreset(t *testing.T) {
+	handler := HandleLintPreset(presetLinterFunc(func(name string, raw []byte) spark.PresetLint {
+		return spark.PresetLint{Errors: []string{}, Warnings: []string{name + ": " + string(raw)}}
+	}))
+
+	t.Run("given a preset, responds 200 with the lint result", func(t *testing.T) {
+		w, r := newRequest(http.MethodPost, "/presets/etl/lint")
+		r.Body = io.NopCloser(strings.NewReader("main: etl.py"))
+		handler(w, withURLParam(r, "name", "etl"))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result spark.PresetLint
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Empty(t, result.Errors)
+		require.Equal(t, []string{"etl: main: etl.py"}, result.Warnings)
+	})
+
+	t.Run("given a preset the API key may not access, responds 403", func(t *testing.T) {
+		w, r := newRequest(http.MethodPost, "/presets/pi/lint")
+		handler(w, withURLParam(withKey(r), "name", "pi"))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+
+		w, r = newRequest(http.MethodPost, "/presets/lint?name=pi")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+
+	t.Run("given no name, lints the body with every API key", func(t *testing.T) {
+		w, r := newRequest(http.MethodPost, "/presets/lint")
+		r.Body = io.NopCloser(strings.NewReader("main: etl.py"))
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result spark.PresetLint
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Equal(t, []string{"lint: main: etl.py"}, result.Warnings)
+	})
+}
+
+type presetListerMock []string
+
+func (m presetListerMock) PresetNames() []string {
+	return m
+}
+
+func TestHandleListPresets(t *testing.T) {
+	t.Run("given a restricted API key, responds only with allowed presets", func(t *testing.T) {
+		handler := HandleListPresets(presetListerMock{"etl", "pi"})
+		w, r := newRequest("", "/api/v1/presets")
+		handler(w, withKey(r))
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Presets []string `json:"presets"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Equal(t, []string{"etl"}, result.Presets)
+	})
+}
+
+type presetSourceListerMock []spark.PresetSourceStatus
+
+func (m presetSourceListerMock) PresetSources() []spark.PresetSourceStatus {
+	return m
+}
+
+type presetErrorListerMock []spark.PresetLoadError
+
+func (m presetErrorListerMock) PresetLoadErrors() []spark.PresetLoadError {
+	return m
+}
+
+func TestHandlePresetLoadErrors(t *testing.T) {
+	mock := presetErrorListerMock{
+		{Source: "directory", File: "etl.yaml", Preset: "etl", Error: "yaml: line 1: did not find expected node content"},
+		{Source: "configmaps", File: "pi.yaml", Preset: "pi", Error: "yaml: line 2: mapping values are not allowed"},
+	}
+
+	t.Run("responds 200 with the load errors", func(t *testing.T) {
+		w, r := newRequest("", "/presets/errors")
+		HandlePresetLoadErrors(mock)(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Errors []spark.PresetLoadError `json:"errors"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Equal(t, []spark.PresetLoadError(mock), result.Errors)
+	})
+
+	t.Run("given a restricted API key, responds only with errors of allowed presets", func(t *testing.T) {
+		w, r := newRequest("", "/presets/errors")
+		HandlePresetLoadErrors(mock)(w, withKey(r))
+
+		var result struct {
+			Errors []spark.PresetLoadError `json:"errors"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result.Errors, 1)
+		require.Equal(t, "etl.yaml", result.Errors[0].File)
+	})
+}
+
+func TestHandleListPresetSources(t *testing.T) {
+	t.Run("responds with the sources and their revision", func(t *testing.T) {
+		handler := HandleListPresetSources(presetSourceListerMock{{Name: "https://github.com/org/presets.git", Revision: "0a1b2c", PresetCount: 3}})
+		w, r := newRequest("", "/api/v1/preset-sources")
+		handler(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		var result struct {
+			Sources []spark.PresetSourceStatus `json:"sources"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&result))
+		require.Len(t, result.Sources, 1)
+		require.Equal(t, "0a1b2c", result.Sources[0].Revision)
+	})
+}
+
+type artifactsMock struct {
+	uploaded map[string]string
+}
+
+func (m *artifactsMock) UploadArtifact(ctx context.Context, name string, content []byte) (spark.Artifact, error) {
+	if strings.Contains(name, "..") {
+		return spark.Artifact{}, fmt.Errorf("%w: invalid name", spark.InvalidArtifactError)
+	}
+	m.uploaded[name] = string(content)
+	return spark.Artifact{Name: name, URL: "s3a://artifacts/" + name, Size: int64(len(content))}, nil
+}
+
+func (m *artifactsMock) Artifacts(ctx context.Context) ([]spark.Artifact, error) {
+	artifacts := []spark.Artifact{}
+	for name, content := range m.uploaded {
+		artifacts = append(artifacts, spark.Artifact{Name: name, URL: "s3a://artifacts/" + name, Size: int64(len(content))})
+	}
+	return artifacts, nil
+}
+
+func TestHandleArtifacts(t *testing.T) {
+	newRouter := func(s Artifacts) chi.Router {
+		r := chi.NewRouter()
+		r.Put("/api/v1/artifacts/*", HandleUploadArtifact(s))
+		r.Get("/api/v1/artifacts", HandleListArtifacts(s))
+		return r
+	}
+
+	t.Run("uploads and lists artifacts", func(t *testing.T) {
+		s := &artifactsMock{uploaded: map[string]string{}}
+		w, r := newRequest(http.MethodPut, "/api/v1/artifacts/etl/etl-1.4.jar")
+		r.Body = io.NopCloser(strings.NewReader("jar"))
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusCreated)
+		require.Equal(t, map[string]string{"etl/etl-1.4.jar": "jar"}, s.uploaded)
+
+		w, r = newRequest(http.MethodGet, "/api/v1/artifacts")
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		var body struct {
+			Artifacts []spark.Artifact `json:"artifacts"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&body))
+		require.Equal(t, []spark.Artifact{{Name: "etl/etl-1.4.jar", URL: "s3a://artifacts/etl/etl-1.4.jar", Size: 3}}, body.Artifacts)
+	})
+
+	t.Run("given an invalid name, responds with 400", func(t *testing.T) {
+		w, r := newRequest(http.MethodPut, "/api/v1/artifacts/etl/..%2Fetl.jar")
+		r.Body = io.NopCloser(strings.NewReader("jar"))
+		newRouter(&artifactsMock{uploaded: map[string]string{}}).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+	})
+
+	t.Run("given a tenant, responds with 403", func(t *testing.T) {
+		s := &artifactsMock{uploaded: map[string]string{}}
+		w, r := newRequest(http.MethodPut, "/api/v1/artifacts/etl.jar")
+		r = r.WithContext(auth.WithTenant(r.Context(), auth.Tenant{Name: "team-a"}))
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		require.Empty(t, s.uploaded)
+	})
+}
+
+type pipelineSubmitterMock struct{}
+
+func (pipelineSubmitterMock) Submit(ctx context.Context, preset string, opts spark.SubmitOptions) (string, error) {
+	return preset + "-job", nil
+}
+
+func (pipelineSubmitterMock) Job(id string) (jobs.Job, error) {
+	return jobs.Job{ID: id, State: jobs.StatePending}, nil
+}
+
+func TestHandlePipelines(t *testing.T) {
+	newRouter := func(p Pipelines) chi.Router {
+		r := chi.NewRouter()
+		r.Get("/api/v1/pipelines", HandleListPipelines(p))
+		r.Get("/api/v1/pipelines/{name}", HandleGetPipeline(p))
+		r.Put("/api/v1/pipelines/{name}", HandlePutPipeline(p))
+		r.Delete("/api/v1/pipelines/{name}", HandleDeletePipeline(p))
+		r.Post("/api/v1/pipelines/{name}/runs", HandleStartPipeline(p))
+		r.Get("/api/v1/pipelines/{name}/runs", HandleListPipelineRuns(p))
+		r.Get("/api/v1/pipelines/{name}/runs/{id}", HandleGetPipelineRun(p))
+		return r
+	}
+	newManager := func(t *testing.T) *pipelines.Manager {
+		m, err := pipelines.New("")
+		require.NoError(t, err)
+		m.SetSubmitter(pipelineSubmitterMock{})
+		return m
+	}
+	put := func(t *testing.T, router chi.Router, name, body string) recorder {
+		w, r := newRequest(http.MethodPut, "/api/v1/pipelines/"+name)
+		r.Body = io.NopCloser(strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("saves, starts and reads pipelines", func(t *testing.T) {
+		router := newRouter(newManager(t))
+		w := put(t, router, "etl", `{"steps": [{"name": "extract", "preset": "extract"}, {"name": "load", "preset": "load", "after": ["extract"]}]}`)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		w, r := newRequest(http.MethodGet, "/api/v1/pipelines")
+		router.ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Contains(t, w.Body.String(), `"name":"etl"`)
+
+		w, r = newRequest(http.MethodPost, "/api/v1/pipelines/etl/runs")
+		router.ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusCreated)
+		var run pipelines.Run
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&run))
+		require.Equal(t, pipelines.RunRunning, run.State)
+		require.Equal(t, "extract-job", run.Steps[0].JobID)
+		require.Equal(t, pipelines.StepWaiting, run.Steps[1].State)
+
+		w, r = newRequest(http.MethodGet, "/api/v1/pipelines/etl/runs/"+run.ID)
+		router.ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+
+		w, r = newRequest(http.MethodGet, "/api/v1/pipelines/etl/runs")
+		router.ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		require.Contains(t, w.Body.String(), run.ID)
+	})
+
+	t.Run("given an invalid pipeline, responds with 400", func(t *testing.T) {
+		w := put(t, newRouter(newManager(t)), "etl", `{"steps": [{"name": "a", "preset": "a", "after": ["a"]}]}`)
+		w.assertHTTPStatus(t, http.StatusBadRequest)
+		w.assertError(t, "depends on itself")
+	})
+
+	t.Run("given an unknown pipeline or run, responds with 404", func(t *testing.T) {
+		router := newRouter(newManager(t))
+		for _, target := range []string{"/api/v1/pipelines/etl", "/api/v1/pipelines/etl/runs", "/api/v1/pipelines/etl/runs/123"} {
+			w, r := newRequest(http.MethodGet, target)
+			router.ServeHTTP(w, r)
+			w.assertHTTPStatus(t, http.StatusNotFound)
+		}
+		w, r := newRequest(http.MethodPost, "/api/v1/pipelines/etl/runs")
+		router.ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusNotFound)
+		w, r = newRequest(http.MethodDelete, "/api/v1/pipelines/etl")
+		router.ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a key without access to a step preset, responds with 403", func(t *testing.T) {
+		m := newManager(t)
+		_, err := m.PutPipeline("etl", []byte(`steps: [{name: extract, preset: etl-extract}, {name: report, preset: report, after: [extract]}]`))
+		require.NoError(t, err)
+		w, r := newRequest(http.MethodPost, "/api/v1/pipelines/etl/runs")
+		r = r.WithContext(auth.WithKey(r.Context(), auth.Key{Role: auth.Submitter, Presets: []string{"etl-*"}}))
+		newRouter(m).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		require.Empty(t, m.Runs("etl"))
+	})
+
+	t.Run("given a tenant, responds with 403", func(t *testing.T) {
+		w, r := newRequest(http.MethodGet, "/api/v1/pipelines")
+		r = r.WithContext(auth.WithTenant(r.Context(), auth.Tenant{Name: "team-a"}))
+		newRouter(newManager(t)).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+}
+
+type schedulerMock struct {
+	schedules []scheduler.Schedule
+	paused    map[string]bool
+	pausedAll bool
+}
+
+func (m *schedulerMock) Schedules() ([]scheduler.Schedule, bool) {
+	return m.schedules, m.pausedAll
+}
+
+func (m *schedulerMock) SetPaused(preset string, paused bool) error {
+	for _, schedule := range m.schedules {
+		if schedule.Preset == preset {
+			m.paused[preset] = paused
+			return nil
+		}
+	}
+	return scheduler.NotScheduledError
+}
+
+func (m *schedulerMock) SetPausedAll(paused bool) { m.pausedAll = paused }
+
+func TestHandleSchedules(t *testing.T) {
+	newScheduler := func() *schedulerMock {
+		return &schedulerMock{
+			schedules: []scheduler.Schedule{{Preset: "etl/nightly", Schedule: "0 3 * * *"}, {Preset: "pi", Schedule: "0 * * * *"}},
+			paused:    map[string]bool{},
+		}
+	}
+	newRouter := func(s Scheduler) chi.Router {
+		r := chi.NewRouter()
+		r.Get("/api/v1/schedules", HandleListSchedules(s))
+		r.Post("/api/v1/schedules/{name}/pause", HandlePauseSchedule(s, true))
+		r.Delete("/api/v1/schedules/{name}/pause", HandlePauseSchedule(s, false))
+		r.Post("/api/v1/scheduler/pause", HandlePauseScheduler(s, true))
+		r.Delete("/api/v1/scheduler/pause", HandlePauseScheduler(s, false))
+		return r
+	}
+
+	t.Run("lists the schedules the key may access", func(t *testing.T) {
+		w, r := newRequest(http.MethodGet, "/api/v1/schedules")
+		r = r.WithContext(auth.WithKey(r.Context(), auth.Key{Presets: []string{"etl/*"}}))
+		newRouter(newScheduler()).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusOK)
+		var body struct {
+			Paused    bool                 `json:"paused"`
+			Schedules []scheduler.Schedule `json:"schedules"`
+		}
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&body))
+		require.Len(t, body.Schedules, 1)
+		require.Equal(t, "etl/nightly", body.Schedules[0].Preset)
+	})
+
+	t.Run("pauses and resumes a preset", func(t *testing.T) {
+		s := newScheduler()
+		w, r := newRequest(http.MethodPost, "/api/v1/schedules/etl%2Fnightly/pause")
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusNoContent)
+		require.True(t, s.paused["etl/nightly"])
+
+		w, r = newRequest(http.MethodDelete, "/api/v1/schedules/etl%2Fnightly/pause")
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusNoContent)
+		require.False(t, s.paused["etl/nightly"])
+	})
+
+	t.Run("given an unscheduled preset, responds with 404", func(t *testing.T) {
+		w, r := newRequest(http.MethodPost, "/api/v1/schedules/other/pause")
+		newRouter(newScheduler()).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusNotFound)
+	})
+
+	t.Run("given a preset of another key, responds with 403", func(t *testing.T) {
+		w, r := newRequest(http.MethodPost, "/api/v1/schedules/pi/pause")
+		r = r.WithContext(auth.WithKey(r.Context(), auth.Key{Presets: []string{"etl/*"}}))
+		newRouter(newScheduler()).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+	})
+
+	t.Run("pauses and resumes the scheduler", func(t *testing.T) {
+		s := newScheduler()
+		w, r := newRequest(http.MethodPost, "/api/v1/scheduler/pause")
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusNoContent)
+		require.True(t, s.pausedAll)
+
+		w, r = newRequest(http.MethodDelete, "/api/v1/scheduler/pause")
+		r = r.WithContext(auth.WithTenant(r.Context(), auth.Tenant{Name: "team-a"}))
+		newRouter(s).ServeHTTP(w, r)
+		w.assertHTTPStatus(t, http.StatusForbidden)
+		require.True(t, s.pausedAll)
+	})
+}
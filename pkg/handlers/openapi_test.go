@@ -0,0 +1,83 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// refs collects all $ref values of the document
+func refs(node interface{}, found map[string]bool) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				found[ref] = true
+			}
+			refs(value, found)
+		}
+	case []interface{}:
+		for _, value := range n {
+			refs(value, found)
+		}
+	}
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	w, r := newRequest("", "/openapi.json")
+	HandleOpenAPI(w, r)
+	w.assertHTTPStatus(t, http.StatusOK)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var spec map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&spec))
+	require.Equal(t, "3.0.3", spec["openapi"])
+
+	t.Run("all references resolve", func(t *testing.T) {
+		found := make(map[string]bool)
+		refs(spec, found)
+		for ref := range found {
+			var node interface{} = spec
+			for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				object, ok := node.(map[string]interface{})
+				require.True(t, ok, ref)
+				node = object[part]
+			}
+			require.NotNil(t, node, ref)
+		}
+	})
+
+	t.Run("operation ids are unique", func(t *testing.T) {
+		ids := make(map[string]bool)
+		for _, operations := range spec["paths"].(map[string]interface{}) {
+			for _, operation := range operations.(map[string]interface{}) {
+				id := operation.(map[string]interface{})["operationId"].(string)
+				require.False(t, ids[id], id)
+				ids[id] = true
+			}
+		}
+	})
+}
+
+func TestHandleSwaggerUI(t *testing.T) {
+	w, r := newRequest("", "/docs")
+	HandleSwaggerUI(w, r)
+	w.assertHTTPStatus(t, http.StatusOK)
+	require.Contains(t, w.Body.String(), `url: "/openapi.json"`)
+}
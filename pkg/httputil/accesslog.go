@@ -0,0 +1,60 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// quietPaths are polled by probes and scrapers, they're only logged at debug level
+var quietPaths = map[string]bool{"/health": true, "/metrics": true}
+
+// AccessLog logs every request and passes a logger with the request id
+// downstream. It expects the request id of chi's RequestID middleware.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := middleware.GetReqID(r.Context())
+		logger := zap.L()
+		if requestID != "" {
+			w.Header().Set(middleware.RequestIDHeader, requestID)
+			logger = logger.With(zap.String("requestID", requestID))
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(logging.WithLogger(r.Context(), logger)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := zapcore.InfoLevel
+		if quietPaths[r.URL.Path] {
+			level = zapcore.DebugLevel
+		}
+		logger.Log(level, "request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.Int("bytes", ww.BytesWritten()),
+		)
+	})
+}
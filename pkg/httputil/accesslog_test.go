@@ -0,0 +1,73 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLog(t *testing.T) {
+	observe := func(t *testing.T) *observer.ObservedLogs {
+		core, logs := observer.New(zapcore.DebugLevel)
+		t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+		return logs
+	}
+
+	t.Run("logs requests with the request id", func(t *testing.T) {
+		logs := observe(t)
+		handler := middleware.RequestID(AccessLog(Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			logging.FromContext(r.Context()).Info("downstream")
+			return errors.New("nope")
+		})))
+
+		r := httptest.NewRequest(http.MethodPost, "/?preset=pi", nil)
+		r.Header.Set(middleware.RequestIDHeader, "abc")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, "abc", w.Header().Get(middleware.RequestIDHeader))
+		var body errorResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.Equal(t, "abc", body.RequestID)
+
+		entries := logs.All()
+		require.Len(t, entries, 3)
+		for _, entry := range entries {
+			require.Equal(t, "abc", entry.ContextMap()["requestID"])
+		}
+		request := entries[2].ContextMap()
+		require.Equal(t, "request", entries[2].Message)
+		require.Equal(t, "POST", request["method"])
+		require.Equal(t, "/", request["path"])
+		require.Equal(t, int64(http.StatusInternalServerError), request["status"])
+	})
+
+	t.Run("logs probes at debug level", func(t *testing.T) {
+		logs := observe(t)
+		handler := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+		require.Equal(t, zapcore.DebugLevel, logs.All()[0].Level)
+	})
+}
@@ -14,9 +14,12 @@ limitations under the License.
 package httputil
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"go.uber.org/zap"
 )
@@ -59,23 +62,61 @@ func NotFoundError(message string) *HTTPError {
 	return &HTTPError{http.StatusNotFound, message}
 }
 
+// MaxBodySize rejects requests whose body is larger than limit bytes.
+// Bodies without a Content-Length fail while they are read, see BodyError.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				RenderError(w, r, tooLargeError(limit))
+				return
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyError is the response to a request body that couldn't be read or
+// parsed, bodies over the MaxBodySize limit are reported with 413
+func BodyError(err error, message string) *HTTPError {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return tooLargeError(maxBytesError.Limit)
+	}
+	return BadRequestError(message)
+}
+
+func tooLargeError(limit int64) *HTTPError {
+	return WithStatusError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", limit))
+}
+
 type errorResponse struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	RequestID string `json:"requestId,omitempty"`
 }
 
 func Wrap(fn func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := fn(w, r); err != nil {
-			if httpError, ok := err.(*HTTPError); ok {
-				render.Status(r, httpError.Status())
-				render.JSON(w, r, errorResponse{httpError.message})
-				return
-			} else {
-				zap.L().Error("unexpected error returned in handler", zap.Error(err))
-				render.Status(r, http.StatusInternalServerError)
-				render.JSON(w, r, errorResponse{"unexpected error"})
-				return
-			}
+			RenderError(w, r, err)
 		}
 	}
 }
+
+// RenderError responds with the status and message of an HTTPError, other
+// errors are logged and hidden behind a 500
+func RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID := middleware.GetReqID(r.Context())
+	if httpError, ok := err.(*HTTPError); ok {
+		render.Status(r, httpError.Status())
+		render.JSON(w, r, errorResponse{httpError.message, requestID})
+		return
+	}
+
+	logging.FromContext(r.Context()).Error("unexpected error returned in handler", zap.Error(err))
+	render.Status(r, http.StatusInternalServerError)
+	render.JSON(w, r, errorResponse{"unexpected error", requestID})
+}
@@ -17,8 +17,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -104,3 +106,33 @@ func TestHTTPError(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxBodySize(t *testing.T) {
+	handler := MaxBodySize(8)(Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return BodyError(err, "couldn't read request body")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}))
+
+	t.Run("given a small body, calls the handler", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small")))
+		require.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("given a larger Content-Length, responds 413", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("far too large")))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("given a larger body without Content-Length, responds 413", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("far too large")))
+		r.ContentLength = -1
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
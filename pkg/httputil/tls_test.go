@@ -0,0 +1,95 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for the common name
+func writeCert(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0o600))
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, c *CertReloader) string {
+	cert, err := c.GetCertificate(nil)
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Run("given missing files, returns an error", func(t *testing.T) {
+		_, err := NewCertReloader("missing.crt", "missing.key")
+		require.Error(t, err)
+	})
+
+	t.Run("given changed files, serves the new certificate", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := writeCert(t, dir, "first")
+		c, err := NewCertReloader(certFile, keyFile)
+		require.NoError(t, err)
+		require.Equal(t, "first", commonName(t, c))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go c.Watch(ctx, 10*time.Millisecond)
+
+		writeCert(t, dir, "second")
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(certFile, later, later))
+		require.Eventually(t, func() bool { return commonName(t, c) == "second" }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("given an invalid new certificate, keeps the previous one", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := writeCert(t, dir, "first")
+		c, err := NewCertReloader(certFile, keyFile)
+		require.NoError(t, err)
+
+		require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+		require.Error(t, c.Reload())
+		require.Equal(t, "first", commonName(t, c))
+	})
+}
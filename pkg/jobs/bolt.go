@@ -0,0 +1,218 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket  = []byte("jobs")
+	queueBucket = []byte("queue")
+	// createdBucket indexes the jobs by creation time, the keys are the
+	// creation time in createdLayout followed by the job id
+	createdBucket = []byte("created")
+)
+
+// createdLayout has a fixed width, so the keys of the index sort by time
+const createdLayout = "20060102150405.000000000"
+
+// BoltStore persists jobs in an embedded bbolt database so the history
+// survives restarts, the submissions that didn't start yet are kept in a
+// queue bucket
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf(`couldn't open job database ("%s"), %w`, path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		indexed := tx.Bucket(createdBucket) != nil
+		for _, bucket := range [][]byte{jobsBucket, queueBucket, createdBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		// databases of older versions have no index yet
+		if !indexed {
+			return tx.Bucket(jobsBucket).ForEach(func(id, raw []byte) error {
+				var job Job
+				if err := json.Unmarshal(raw, &job); err != nil {
+					return fmt.Errorf("couldn't decode job %s, %w", id, err)
+				}
+				return tx.Bucket(createdBucket).Put(createdKey(job), nil)
+			})
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't create buckets, %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Put(job Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if previous, err := getJob(tx.Bucket(jobsBucket), job.ID); err == nil {
+			if err := unindexJob(tx, previous, job); err != nil {
+				return err
+			}
+		}
+		return putJob(tx, job)
+	})
+}
+
+func (s *BoltStore) Get(id string) (Job, error) {
+	var job Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = getJob(tx.Bucket(jobsBucket), id)
+		return err
+	})
+	return job, err
+}
+
+func (s *BoltStore) List() ([]Job, error) {
+	list := make([]Job, 0)
+	err := s.ForEach(func(job Job) error {
+		list = append(list, job)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ForEach walks the creation index in one read transaction, fn sees the
+// jobs as they were when it started
+func (s *BoltStore) ForEach(fn func(job Job) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		return tx.Bucket(createdBucket).ForEach(func(key, _ []byte) error {
+			job, err := getJob(bucket, string(key[len(createdLayout):]))
+			if err != nil {
+				return err
+			}
+			return fn(job)
+		})
+	})
+}
+
+func (s *BoltStore) Update(id string, fn func(job *Job)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		job, err := getJob(tx.Bucket(jobsBucket), id)
+		if err != nil {
+			return err
+		}
+		previous := job
+		fn(&job)
+		if err := unindexJob(tx, previous, job); err != nil {
+			return err
+		}
+		return putJob(tx, job)
+	})
+}
+
+func (s *BoltStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		job, err := getJob(tx.Bucket(jobsBucket), id)
+		if errors.Is(err, JobNotFoundError) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(createdBucket).Delete(createdKey(job)); err != nil {
+			return err
+		}
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) PutSubmission(jobID string, submission []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Put([]byte(jobID), submission)
+	})
+}
+
+func (s *BoltStore) DeleteSubmission(jobID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Delete([]byte(jobID))
+	})
+}
+
+func (s *BoltStore) Submissions() (map[string][]byte, error) {
+	submissions := make(map[string][]byte)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).ForEach(func(id, raw []byte) error {
+			// the slices are only valid during the transaction
+			submissions[string(id)] = append([]byte(nil), raw...)
+			return nil
+		})
+	})
+	return submissions, err
+}
+
+func getJob(bucket *bolt.Bucket, id string) (Job, error) {
+	raw := bucket.Get([]byte(id))
+	if raw == nil {
+		return Job{}, JobNotFoundError
+	}
+
+	var job Job
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return Job{}, fmt.Errorf("couldn't decode job %s, %w", id, err)
+	}
+	return job, nil
+}
+
+func putJob(tx *bolt.Tx, job Job) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("couldn't encode job %s, %w", job.ID, err)
+	}
+	if err := tx.Bucket(createdBucket).Put(createdKey(job), nil); err != nil {
+		return err
+	}
+	return tx.Bucket(jobsBucket).Put([]byte(job.ID), raw)
+}
+
+// unindexJob removes the index entry of the previous version of a job if
+// its creation time changed
+func unindexJob(tx *bolt.Tx, previous, job Job) error {
+	if key := createdKey(previous); !bytes.Equal(key, createdKey(job)) {
+		return tx.Bucket(createdBucket).Delete(key)
+	}
+	return nil
+}
+
+func createdKey(job Job) []byte {
+	return []byte(job.CreatedAt.UTC().Format(createdLayout) + job.ID)
+}
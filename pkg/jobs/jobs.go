@@ -0,0 +1,462 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type State string
+
+const (
+	StateScheduled State = "scheduled"
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateFailed    State = "failed"
+	StateSucceeded State = "succeeded"
+	StateCancelled State = "cancelled"
+)
+
+func (s State) Terminal() bool {
+	return s == StateFailed || s == StateSucceeded || s == StateCancelled
+}
+
+// transitions are the states a job may change to from a state, terminal
+// states have none
+var transitions = map[State][]State{
+	StateScheduled: {StatePending, StateFailed, StateCancelled},
+	StatePending:   {StateRunning, StateFailed, StateCancelled},
+	StateRunning:   {StateSucceeded, StateFailed, StateCancelled},
+}
+
+// CanTransition reports whether a job may change from s to the state
+func (s State) CanTransition(to State) bool {
+	for _, state := range transitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+var InvalidTransitionError error = fmt.Errorf("invalid state transition")
+
+type Job struct {
+	ID        string     `json:"id"`
+	Preset    string     `json:"preset"`
+	State     State      `json:"state"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RunAt     *time.Time `json:"runAt,omitempty"`
+	Priority  int        `json:"priority,omitempty"`
+	// DryRun jobs were processed by a server in dry-run mode, spark-submit never ran
+	DryRun bool `json:"dryRun,omitempty"`
+	// IdempotencyKey is the Idempotency-Key header of the submit request
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Replica is the server replica that runs the submission, only set with
+	// a store the replicas share
+	Replica string `json:"replica,omitempty"`
+	// CallbackURL receives the job once it finished
+	CallbackURL string     `json:"callbackUrl,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Attempts    []Attempt  `json:"attempts,omitempty"`
+	// Error, ExitCode and Stderr explain the outcome of the last attempt,
+	// stderr is only kept for failed jobs
+	Error    string `json:"error,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	// Master is the master the job was submitted to. Namespace, DriverPod
+	// and ApplicationID identify the spark application of the last attempt,
+	// as far as spark-submit reported them.
+	Master        string `json:"master,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	DriverPod     string `json:"driverPod,omitempty"`
+	ApplicationID string `json:"applicationId,omitempty"`
+	// AppPhase is the last phase of the driver pod the kubernetes backend
+	// saw, or OOMKilled if the driver ran out of memory
+	AppPhase string `json:"appPhase,omitempty"`
+	// EstimatedCost prices the requested resources of a finished job for the
+	// runtime of its attempts
+	EstimatedCost *float64 `json:"estimatedCost,omitempty"`
+	// QueuePosition is the position in the submission queue of a pending job,
+	// it's not persisted
+	QueuePosition int `json:"queuePosition,omitempty"`
+	// Events are the timeline of the job, they're stored with the job but
+	// served separately
+	Events []Event `json:"events,omitempty"`
+}
+
+type EventType string
+
+const (
+	EventCreated         EventType = "created"
+	EventScheduled       EventType = "scheduled"
+	EventStateChanged    EventType = "state_changed"
+	EventAttemptStarted  EventType = "attempt_started"
+	EventAttemptFinished EventType = "attempt_finished"
+	// EventWaiting explains why a pending job doesn't start yet, e.g. a
+	// namespace quota or missing cluster capacity
+	EventWaiting EventType = "waiting"
+	// EventRecovered marks a job that was reconciled with its application
+	// after a restart
+	EventRecovered EventType = "recovered"
+	// EventAppPhaseChanged is a new phase of the driver pod of the job
+	EventAppPhaseChanged EventType = "app_phase_changed"
+)
+
+// Event is a timestamped change in the life of a job
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    EventType `json:"type"`
+	State   State     `json:"state,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Attempt is a single spark-submit run of a job
+type Attempt struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ExitCode   *int       `json:"exitCode,omitempty"`
+}
+
+func New(preset string) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, fmt.Errorf("couldn't generate job id, %w", err)
+	}
+
+	job := Job{
+		ID:        id,
+		Preset:    preset,
+		State:     StatePending,
+		CreatedAt: time.Now().UTC(),
+	}
+	job.Record(Event{Time: job.CreatedAt, Type: EventCreated, State: StatePending})
+	return job, nil
+}
+
+// Record appends the event to the timeline of the job, a zero time is set to
+// now and an empty state to the current state of the job
+func (j *Job) Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.State == "" {
+		event.State = j.State
+	}
+	j.Events = append(j.Events, event)
+}
+
+// Schedule delays the job until runAt
+func (j *Job) Schedule(runAt time.Time) {
+	j.RunAt = &runAt
+	j.State = StateScheduled
+	j.Record(Event{Type: EventScheduled, State: StateScheduled, Message: "runs at " + runAt.Format(time.RFC3339)})
+}
+
+func (j *Job) SetState(state State) {
+	j.State = state
+
+	now := time.Now().UTC()
+	j.Record(Event{Time: now, Type: EventStateChanged, State: state})
+	if state == StateRunning && j.StartedAt == nil {
+		j.StartedAt = &now
+	}
+	if state.Terminal() {
+		j.FinishedAt = &now
+	}
+}
+
+// Transition changes the state if the state machine allows it
+func (j *Job) Transition(state State) error {
+	if !j.State.CanTransition(state) {
+		return fmt.Errorf("%w from %s to %s", InvalidTransitionError, j.State, state)
+	}
+	j.SetState(state)
+	return nil
+}
+
+func (j *Job) StartAttempt() {
+	now := time.Now().UTC()
+	j.Attempts = append(j.Attempts, Attempt{StartedAt: now})
+	j.Record(Event{Time: now, Type: EventAttemptStarted, Attempt: len(j.Attempts)})
+}
+
+func (j *Job) FinishAttempt(err error) {
+	if len(j.Attempts) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	attempt := &j.Attempts[len(j.Attempts)-1]
+	attempt.FinishedAt = &now
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	j.Record(Event{Time: now, Type: EventAttemptFinished, Attempt: len(j.Attempts), Error: attempt.Error})
+}
+
+// AppName returns the name status and kill requests address the application
+// of the job with, the driver pod on kubernetes and the application id on YARN
+func (j *Job) AppName() string {
+	if j.DriverPod != "" {
+		return j.DriverPod
+	}
+	return j.ApplicationID
+}
+
+// InterruptedError is the error of jobs that were unfinished when the server
+// stopped
+var InterruptedError error = fmt.Errorf("interrupted by restart")
+
+// Interrupt fails all jobs of the store that aren't in a terminal state. The
+// timers, queues and processes behind them are gone after a restart, so they
+// would never finish otherwise. Scheduled and pending jobs whose submission
+// is kept by a QueueStore are left alone, the server queues them again, and
+// so are the jobs of the live replicas of a ReplicaStore. It returns the
+// interrupted jobs.
+func Interrupt(store Store) ([]Job, error) {
+	list, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list jobs, %w", err)
+	}
+	live, err := LiveReplicas(store)
+	if err != nil {
+		return nil, err
+	}
+	queue, _ := store.(QueueStore)
+	queued := make(map[string][]byte)
+	if queue != nil {
+		if queued, err = queue.Submissions(); err != nil {
+			return nil, fmt.Errorf("couldn't list queued submissions, %w", err)
+		}
+	}
+	interrupted := make([]Job, 0)
+	for _, job := range list {
+		if job.State.Terminal() || live[job.Replica] {
+			continue
+		}
+		if _, ok := queued[job.ID]; ok {
+			if job.State == StateScheduled || job.State == StatePending {
+				continue
+			}
+			// the submission started, it can't be queued again
+			if err := queue.DeleteSubmission(job.ID); err != nil {
+				return interrupted, fmt.Errorf("couldn't remove queued submission of job %s, %w", job.ID, err)
+			}
+		}
+		var transitionErr error
+		taken := false
+		err := store.Update(job.ID, func(j *Job) {
+			// another replica took the job over in the meantime
+			if taken = j.Replica != job.Replica; taken {
+				return
+			}
+			if len(j.Attempts) > 0 && j.Attempts[len(j.Attempts)-1].FinishedAt == nil {
+				j.FinishAttempt(InterruptedError)
+			}
+			if transitionErr = j.Transition(StateFailed); transitionErr == nil {
+				j.Error = InterruptedError.Error()
+			}
+		})
+		if err == nil {
+			err = transitionErr
+		}
+		if err != nil {
+			return interrupted, fmt.Errorf("couldn't interrupt job %s, %w", job.ID, err)
+		}
+		if taken {
+			continue
+		}
+		job, err = store.Get(job.ID)
+		if err != nil {
+			return interrupted, err
+		}
+		interrupted = append(interrupted, job)
+	}
+	return interrupted, nil
+}
+
+var JobNotFoundError error = fmt.Errorf("job not found")
+
+// Store keeps the job records of all submissions
+type Store interface {
+	Put(job Job) error
+	Get(id string) (Job, error)
+	// List returns all jobs ordered by their creation time
+	List() ([]Job, error)
+	// ForEach calls fn with every job ordered by their creation time until fn
+	// returns an error, without loading all jobs at once
+	ForEach(fn func(job Job) error) error
+	// Update applies fn to the stored job and persists the result
+	Update(id string, fn func(job *Job)) error
+	// Delete removes the job, unknown ids are ignored
+	Delete(id string) error
+}
+
+// QueueStore keeps the submissions that didn't start yet by job id, so that
+// they survive restarts. The submissions are opaque to the store.
+type QueueStore interface {
+	PutSubmission(jobID string, submission []byte) error
+	// DeleteSubmission removes the submission, unknown ids are ignored
+	DeleteSubmission(jobID string) error
+	Submissions() (map[string][]byte, error)
+}
+
+// ReplicaStore is a store that several replicas of the server share. Every
+// replica keeps a lease in the store while it runs, the unfinished jobs of a
+// replica whose lease expired are taken over by the others.
+type ReplicaStore interface {
+	// Heartbeat renews the lease of the replica for ttl
+	Heartbeat(replica string, ttl time.Duration) error
+	// Replicas returns the replicas whose lease didn't expire
+	Replicas() ([]string, error)
+}
+
+// LiveReplicas returns the replicas with a lease if the store is a
+// ReplicaStore, none otherwise
+func LiveReplicas(store Store) (map[string]bool, error) {
+	live := make(map[string]bool)
+	replicas, ok := store.(ReplicaStore)
+	if !ok {
+		return live, nil
+	}
+	names, err := replicas.Replicas()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list replicas, %w", err)
+	}
+	for _, name := range names {
+		live[name] = true
+	}
+	return live, nil
+}
+
+// Prune deletes finished jobs that finished longer than retention before now
+// and the oldest finished jobs beyond maxJobs, a retention or maxJobs of 0
+// keeps all jobs. Unfinished jobs are never deleted. It returns the ids of
+// the deleted jobs.
+func Prune(store Store, retention time.Duration, maxJobs int, now time.Time) ([]string, error) {
+	list, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	excess := 0
+	if maxJobs > 0 && len(list) > maxJobs {
+		excess = len(list) - maxJobs
+	}
+	deleted := make([]string, 0)
+	for _, job := range list {
+		if !job.State.Terminal() {
+			continue
+		}
+		finished := job.CreatedAt
+		if job.FinishedAt != nil {
+			finished = *job.FinishedAt
+		}
+		expired := retention > 0 && now.Sub(finished) > retention
+		if !expired && len(deleted) >= excess {
+			continue
+		}
+		if err := store.Delete(job.ID); err != nil {
+			return deleted, fmt.Errorf("couldn't delete job %s, %w", job.ID, err)
+		}
+		deleted = append(deleted, job.ID)
+	}
+	return deleted, nil
+}
+
+var InvalidCursorError error = fmt.Errorf("invalid cursor")
+
+// Filter selects a page of jobs, empty fields match all jobs
+type Filter struct {
+	States []State
+	Preset string
+	// Since matches jobs created at or after the time
+	Since time.Time
+	// Limit is the maximum number of jobs of a page, 0 is unlimited
+	Limit int
+	// Cursor is the id of the last job of the previous page
+	Cursor string
+}
+
+func (f Filter) matches(job Job) bool {
+	if f.Preset != "" && f.Preset != job.Preset {
+		return false
+	}
+	if job.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if len(f.States) == 0 {
+		return true
+	}
+	for _, state := range f.States {
+		if state == job.State {
+			return true
+		}
+	}
+	return false
+}
+
+// Page returns the matching jobs of the list after the cursor and the cursor
+// of the next page, which is empty on the last page. The list has to be
+// ordered by the creation time like List returns it.
+func (f Filter) Page(list []Job) ([]Job, string, error) {
+	start := 0
+	if f.Cursor != "" {
+		start = -1
+		for i, job := range list {
+			if job.ID == f.Cursor {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, "", InvalidCursorError
+		}
+	}
+
+	page := make([]Job, 0)
+	for _, job := range list[start:] {
+		if !f.matches(job) {
+			continue
+		}
+		if f.Limit > 0 && len(page) == f.Limit {
+			return page, page[len(page)-1].ID, nil
+		}
+		page = append(page, job)
+	}
+	return page, "", nil
+}
+
+func sortByCreation(list []Job) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
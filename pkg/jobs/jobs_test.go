@@ -0,0 +1,375 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobs
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestJob(t *testing.T) {
+	t.Run("creates pending jobs with unique ids", func(t *testing.T) {
+		first, err := New("pi")
+		require.NoError(t, err)
+		second, err := New("pi")
+		require.NoError(t, err)
+
+		require.NotEmpty(t, first.ID)
+		require.NotEqual(t, first.ID, second.ID)
+		require.Equal(t, "pi", first.Preset)
+		require.Equal(t, StatePending, first.State)
+		require.False(t, first.CreatedAt.IsZero())
+	})
+
+	t.Run("sets timestamps on state changes", func(t *testing.T) {
+		job, err := New("pi")
+		require.NoError(t, err)
+
+		job.SetState(StateRunning)
+		require.NotNil(t, job.StartedAt)
+		require.Nil(t, job.FinishedAt)
+
+		job.SetState(StateSucceeded)
+		require.NotNil(t, job.FinishedAt)
+	})
+
+	t.Run("follows the state machine", func(t *testing.T) {
+		job, err := New("pi")
+		require.NoError(t, err)
+
+		require.ErrorIs(t, job.Transition(StateSucceeded), InvalidTransitionError)
+		require.NoError(t, job.Transition(StateRunning))
+		require.NoError(t, job.Transition(StateFailed))
+		require.ErrorIs(t, job.Transition(StateSucceeded), InvalidTransitionError)
+		require.ErrorIs(t, job.Transition(StateCancelled), InvalidTransitionError)
+		require.Equal(t, StateFailed, job.State)
+	})
+
+	t.Run("scheduled jobs become pending or end", func(t *testing.T) {
+		require.True(t, StateScheduled.CanTransition(StatePending))
+		require.True(t, StateScheduled.CanTransition(StateCancelled))
+		require.False(t, StateScheduled.CanTransition(StateRunning))
+		require.False(t, StateSucceeded.CanTransition(StateRunning))
+	})
+
+	t.Run("AppName prefers the driver pod over the application id", func(t *testing.T) {
+		job := Job{ApplicationID: "application_1689000000000_0001"}
+		require.Equal(t, "application_1689000000000_0001", job.AppName())
+		job.DriverPod = "pi-driver"
+		require.Equal(t, "pi-driver", job.AppName())
+	})
+
+	t.Run("records attempts", func(t *testing.T) {
+		job, err := New("pi")
+		require.NoError(t, err)
+
+		job.StartAttempt()
+		job.FinishAttempt(errors.New("exit status 1"))
+		job.StartAttempt()
+		job.FinishAttempt(nil)
+
+		require.Len(t, job.Attempts, 2)
+		require.Equal(t, "exit status 1", job.Attempts[0].Error)
+		require.NotNil(t, job.Attempts[1].FinishedAt)
+		require.Empty(t, job.Attempts[1].Error)
+	})
+
+	t.Run("records a timeline of events", func(t *testing.T) {
+		job, err := New("pi")
+		require.NoError(t, err)
+
+		job.Schedule(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC))
+		require.NoError(t, job.Transition(StatePending))
+		require.NoError(t, job.Transition(StateRunning))
+		job.StartAttempt()
+		job.FinishAttempt(errors.New("exit status 1"))
+		require.NoError(t, job.Transition(StateFailed))
+
+		types := make([]EventType, 0, len(job.Events))
+		for _, event := range job.Events {
+			types = append(types, event.Type)
+			require.False(t, event.Time.IsZero())
+		}
+		require.Equal(t, []EventType{EventCreated, EventScheduled, EventStateChanged, EventStateChanged, EventAttemptStarted, EventAttemptFinished, EventStateChanged}, types)
+		require.Equal(t, "runs at 2023-06-01T12:00:00Z", job.Events[1].Message)
+		require.Equal(t, StateRunning, job.Events[4].State)
+		require.Equal(t, 1, job.Events[5].Attempt)
+		require.Equal(t, "exit status 1", job.Events[5].Error)
+		require.Equal(t, StateFailed, job.Events[6].State)
+	})
+}
+
+func TestStores(t *testing.T) {
+	boltStore, err := NewBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer boltStore.Close()
+	redisStore, err := NewRedisStore(newFakeRedis(t, "").url(), "test:")
+	require.NoError(t, err)
+	defer redisStore.Close()
+
+	for name, store := range map[string]Store{
+		"memory": NewMemoryStore(),
+		"bolt":   boltStore,
+		"redis":  redisStore,
+	} {
+		t.Run(name+" stores and updates jobs", func(t *testing.T) {
+			job, err := New("pi")
+			require.NoError(t, err)
+			require.NoError(t, store.Put(job))
+
+			require.NoError(t, store.Update(job.ID, func(j *Job) {
+				j.SetState(StateRunning)
+				j.StartAttempt()
+			}))
+			got, err := store.Get(job.ID)
+			require.NoError(t, err)
+			require.Equal(t, StateRunning, got.State)
+			require.Len(t, got.Attempts, 1)
+		})
+
+		t.Run(name+" lists all jobs ordered by creation time", func(t *testing.T) {
+			first, err := New("first")
+			require.NoError(t, err)
+			second, err := New("second")
+			require.NoError(t, err)
+			require.NoError(t, store.Put(second))
+			require.NoError(t, store.Put(first))
+
+			list, err := store.List()
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, len(list), 2)
+			require.Equal(t, first.ID, list[len(list)-2].ID)
+			require.Equal(t, second.ID, list[len(list)-1].ID)
+		})
+
+		t.Run(name+" iterates over the jobs ordered by creation time until fn fails", func(t *testing.T) {
+			list, err := store.List()
+			require.NoError(t, err)
+			visited := make([]string, 0)
+			require.NoError(t, store.ForEach(func(job Job) error {
+				visited = append(visited, job.ID)
+				return nil
+			}))
+			require.Len(t, visited, len(list))
+			for i, job := range list {
+				require.Equal(t, job.ID, visited[i])
+			}
+
+			stop := errors.New("stop")
+			calls := 0
+			require.ErrorIs(t, store.ForEach(func(job Job) error {
+				calls++
+				return stop
+			}), stop)
+			require.Equal(t, 1, calls)
+		})
+
+		t.Run(name+" given an unknown id, returns JobNotFoundError", func(t *testing.T) {
+			_, err := store.Get("nope")
+			require.ErrorIs(t, err, JobNotFoundError)
+			require.ErrorIs(t, store.Update("nope", func(j *Job) {}), JobNotFoundError)
+		})
+
+		t.Run(name+" deletes jobs", func(t *testing.T) {
+			job, err := New("pi")
+			require.NoError(t, err)
+			require.NoError(t, store.Put(job))
+
+			require.NoError(t, store.Delete(job.ID))
+			_, err = store.Get(job.ID)
+			require.ErrorIs(t, err, JobNotFoundError)
+			require.NoError(t, store.Delete(job.ID))
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2023, 6, 8, 12, 0, 0, 0, time.UTC)
+	newStore := func(t *testing.T) Store {
+		store := NewMemoryStore()
+		for i, state := range []State{StateSucceeded, StateRunning, StateFailed, StateCancelled, StatePending} {
+			created := now.Add(time.Duration(i-10) * 24 * time.Hour)
+			finished := created.Add(time.Hour)
+			job := Job{ID: string(state), State: state, CreatedAt: created}
+			if state.Terminal() {
+				job.FinishedAt = &finished
+			}
+			require.NoError(t, store.Put(job))
+		}
+		return store
+	}
+
+	t.Run("deletes finished jobs older than the retention", func(t *testing.T) {
+		store := newStore(t)
+		deleted, err := Prune(store, 7*24*time.Hour, 0, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{"succeeded", "failed"}, deleted)
+		_, err = store.Get("running")
+		require.NoError(t, err)
+	})
+
+	t.Run("deletes the oldest finished jobs beyond the maximum", func(t *testing.T) {
+		store := newStore(t)
+		deleted, err := Prune(store, 0, 4, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{"succeeded"}, deleted)
+
+		deleted, err = Prune(store, 0, 1, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{"failed", "cancelled"}, deleted)
+		list, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	t.Run("keeps jobs across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.db")
+		store, err := NewBoltStore(path)
+		require.NoError(t, err)
+		job, err := New("pi")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(job))
+		require.NoError(t, store.Close())
+
+		store, err = NewBoltStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		got, err := store.Get(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, job.ID, got.ID)
+	})
+
+	t.Run("fails unfinished jobs after a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.db")
+		store, err := NewBoltStore(path)
+		require.NoError(t, err)
+		running, err := New("pi")
+		require.NoError(t, err)
+		running.SetState(StateRunning)
+		running.StartAttempt()
+		scheduled, err := New("pi")
+		require.NoError(t, err)
+		scheduled.State = StateScheduled
+		succeeded, err := New("pi")
+		require.NoError(t, err)
+		succeeded.SetState(StateSucceeded)
+		for _, job := range []Job{running, scheduled, succeeded} {
+			require.NoError(t, store.Put(job))
+		}
+		require.NoError(t, store.Close())
+
+		store, err = NewBoltStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		interrupted, err := Interrupt(store)
+		require.NoError(t, err)
+		require.Len(t, interrupted, 2)
+
+		got, err := store.Get(running.ID)
+		require.NoError(t, err)
+		require.Equal(t, StateFailed, got.State)
+		require.Equal(t, "interrupted by restart", got.Error)
+		require.NotNil(t, got.FinishedAt)
+		require.Equal(t, "interrupted by restart", got.Attempts[0].Error)
+		require.NotNil(t, got.Attempts[0].FinishedAt)
+
+		got, err = store.Get(scheduled.ID)
+		require.NoError(t, err)
+		require.Equal(t, StateFailed, got.State)
+
+		got, err = store.Get(succeeded.ID)
+		require.NoError(t, err)
+		require.Equal(t, StateSucceeded, got.State)
+		require.Empty(t, got.Error)
+	})
+	t.Run("keeps the jobs of queued submissions after a restart", func(t *testing.T) {
+		store, err := NewBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+		require.NoError(t, err)
+		defer store.Close()
+		pending, err := New("pi")
+		require.NoError(t, err)
+		running, err := New("pi")
+		require.NoError(t, err)
+		running.SetState(StateRunning)
+		for _, job := range []Job{pending, running} {
+			require.NoError(t, store.Put(job))
+			require.NoError(t, store.PutSubmission(job.ID, []byte(`{"args":[]}`)))
+		}
+
+		interrupted, err := Interrupt(store)
+		require.NoError(t, err)
+		require.Len(t, interrupted, 1)
+		require.Equal(t, running.ID, interrupted[0].ID)
+
+		got, err := store.Get(pending.ID)
+		require.NoError(t, err)
+		require.Equal(t, StatePending, got.State)
+		submissions, err := store.Submissions()
+		require.NoError(t, err)
+		require.Equal(t, map[string][]byte{pending.ID: []byte(`{"args":[]}`)}, submissions)
+
+		require.NoError(t, store.DeleteSubmission(pending.ID))
+		submissions, err = store.Submissions()
+		require.NoError(t, err)
+		require.Empty(t, submissions)
+	})
+
+	t.Run("indexes the jobs of databases without creation index", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.db")
+		store, err := NewBoltStore(path)
+		require.NoError(t, err)
+		job, err := New("pi")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(job))
+		require.NoError(t, store.db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket(createdBucket)
+		}))
+		require.NoError(t, store.Close())
+
+		store, err = NewBoltStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		list, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.Equal(t, job.ID, list[0].ID)
+	})
+
+	t.Run("keeps one index entry per job when the creation time changes", func(t *testing.T) {
+		store, err := NewBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+		require.NoError(t, err)
+		defer store.Close()
+		job, err := New("pi")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(job))
+		require.NoError(t, store.Update(job.ID, func(j *Job) { j.CreatedAt = j.CreatedAt.Add(-time.Hour) }))
+		job.CreatedAt = job.CreatedAt.Add(time.Hour)
+		require.NoError(t, store.Put(job))
+
+		list, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.NoError(t, store.Delete(job.ID))
+		list, err = store.List()
+		require.NoError(t, err)
+		require.Empty(t, list)
+	})
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobs
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps all jobs in memory, they are lost on restart
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]*Job)}
+}
+
+func (s *MemoryStore) Put(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = &job
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, JobNotFoundError
+	}
+	return copyJob(job), nil
+}
+
+func (s *MemoryStore) List() ([]Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, copyJob(job))
+	}
+	sortByCreation(list)
+	return list, nil
+}
+
+// ForEach doesn't hold the lock while fn runs, fn sees the jobs as they are
+// when it's called
+func (s *MemoryStore) ForEach(fn func(job Job) error) error {
+	s.mu.RLock()
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	s.mu.RUnlock()
+
+	for _, job := range list {
+		s.mu.RLock()
+		c := copyJob(job)
+		s.mu.RUnlock()
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Update(id string, fn func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return JobNotFoundError
+	}
+	fn(job)
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+// copyJob makes sure callers can't modify the stored job through shared slices
+func copyJob(job *Job) Job {
+	c := *job
+	c.Attempts = append([]Attempt(nil), job.Attempts...)
+	c.Events = append([]Event(nil), job.Events...)
+	return c
+}
@@ -0,0 +1,516 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransactionConflictError fails writes of the redis store whose job kept
+// changing on other replicas while they were retried
+var TransactionConflictError error = errors.New("job changed concurrently")
+
+const (
+	// redisTimeout bounds every command of the redis store
+	redisTimeout = 5 * time.Second
+	// redisRetries is how often a transaction is retried after a conflict
+	redisRetries = 10
+	// redisPageSize is the number of jobs ForEach loads at once
+	redisPageSize = 100
+	// redisIdleConns is the number of connections kept for reuse
+	redisIdleConns = 8
+)
+
+// redisError is an error reply of the server, the connection stays usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis error, " + string(e)
+}
+
+// RedisStore keeps the jobs and the queued submissions in redis, so several
+// replicas of the server share them. The jobs are JSON strings under
+// <prefix>job:<id>, the sorted set <prefix>created indexes them by creation
+// time like the index of the bolt store, and the hash <prefix>queue keeps the
+// submissions. The replicas keep their lease in the sorted set
+// <prefix>replicas, scored by its expiry. It speaks just enough of the redis
+// protocol for that with a minimal client.
+type RedisStore struct {
+	address  string
+	user     string
+	password string
+	db       int
+	prefix   string
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// NewRedisStore creates a store for a server address like
+// redis://:password@localhost:6379/0, the keys start with prefix
+func NewRedisStore(redisURL, prefix string) (*RedisStore, error) {
+	if !strings.Contains(redisURL, "://") {
+		redisURL = "redis://" + redisURL
+	}
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis address, %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf(`unsupported redis scheme "%s"`, u.Scheme)
+	}
+
+	s := &RedisStore{address: u.Host, prefix: prefix}
+	if u.Port() == "" {
+		s.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf(`invalid redis database "%s"`, db)
+		}
+	}
+
+	if _, err := s.do("PING"); err != nil {
+		return nil, fmt.Errorf(`couldn't connect to redis ("%s"), %w`, s.address, err)
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.idle {
+		conn.conn.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+func (s *RedisStore) Put(job Job) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("couldn't encode job %s, %w", job.ID, err)
+	}
+	return s.transaction(s.jobKey(job.ID), func(conn *redisConn) ([][]string, error) {
+		commands := make([][]string, 0, 3)
+		previous, err := s.getJob(conn, job.ID)
+		if err == nil && createdMember(previous) != createdMember(job) {
+			commands = append(commands, []string{"ZREM", s.prefix + "created", createdMember(previous)})
+		} else if err != nil && !errors.Is(err, JobNotFoundError) {
+			return nil, err
+		}
+		return append(commands,
+			[]string{"ZADD", s.prefix + "created", "0", createdMember(job)},
+			[]string{"SET", s.jobKey(job.ID), string(raw)},
+		), nil
+	})
+}
+
+func (s *RedisStore) Get(id string) (Job, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return Job{}, err
+	}
+	job, err := s.getJob(conn, id)
+	s.release(conn, err)
+	return job, err
+}
+
+func (s *RedisStore) List() ([]Job, error) {
+	list := make([]Job, 0)
+	err := s.ForEach(func(job Job) error {
+		list = append(list, job)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ForEach pages through the creation index, jobs that are deleted while it
+// runs may be left out
+func (s *RedisStore) ForEach(fn func(job Job) error) error {
+	start := "-"
+	for {
+		reply, err := s.do("ZRANGEBYLEX", s.prefix+"created", start, "+", "LIMIT", "0", strconv.Itoa(redisPageSize))
+		if err != nil {
+			return err
+		}
+		members, err := redisStrings(reply)
+		if err != nil || len(members) == 0 {
+			return err
+		}
+
+		keys := make([]string, 0, len(members)+1)
+		keys = append(keys, "MGET")
+		for _, member := range members {
+			keys = append(keys, s.jobKey(member[len(createdLayout):]))
+		}
+		reply, err = s.do(keys...)
+		if err != nil {
+			return err
+		}
+		values, ok := reply.([]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected redis reply %v", reply)
+		}
+		for i, value := range values {
+			raw, ok := value.([]byte)
+			if !ok {
+				continue
+			}
+			var job Job
+			if err := json.Unmarshal(raw, &job); err != nil {
+				return fmt.Errorf("couldn't decode job %s, %w", keys[i+1], err)
+			}
+			if err := fn(job); err != nil {
+				return err
+			}
+		}
+		start = "(" + members[len(members)-1]
+	}
+}
+
+// Update applies fn in an optimistic transaction, fn runs again if another
+// replica changed the job in the meantime
+func (s *RedisStore) Update(id string, fn func(job *Job)) error {
+	return s.transaction(s.jobKey(id), func(conn *redisConn) ([][]string, error) {
+		job, err := s.getJob(conn, id)
+		if err != nil {
+			return nil, err
+		}
+		previous := job
+		fn(&job)
+		raw, err := json.Marshal(job)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't encode job %s, %w", id, err)
+		}
+		commands := make([][]string, 0, 3)
+		if createdMember(previous) != createdMember(job) {
+			commands = append(commands, []string{"ZREM", s.prefix + "created", createdMember(previous)})
+		}
+		return append(commands,
+			[]string{"ZADD", s.prefix + "created", "0", createdMember(job)},
+			[]string{"SET", s.jobKey(id), string(raw)},
+		), nil
+	})
+}
+
+func (s *RedisStore) Delete(id string) error {
+	return s.transaction(s.jobKey(id), func(conn *redisConn) ([][]string, error) {
+		job, err := s.getJob(conn, id)
+		if errors.Is(err, JobNotFoundError) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return [][]string{
+			{"ZREM", s.prefix + "created", createdMember(job)},
+			{"DEL", s.jobKey(id)},
+		}, nil
+	})
+}
+
+func (s *RedisStore) PutSubmission(jobID string, submission []byte) error {
+	_, err := s.do("HSET", s.prefix+"queue", jobID, string(submission))
+	return err
+}
+
+func (s *RedisStore) DeleteSubmission(jobID string) error {
+	_, err := s.do("HDEL", s.prefix+"queue", jobID)
+	return err
+}
+
+func (s *RedisStore) Submissions() (map[string][]byte, error) {
+	reply, err := s.do("HGETALL", s.prefix+"queue")
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	submissions := make(map[string][]byte, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		id, _ := fields[i].([]byte)
+		raw, _ := fields[i+1].([]byte)
+		submissions[string(id)] = raw
+	}
+	return submissions, nil
+}
+
+// Heartbeat renews the lease of the replica and drops the expired leases
+func (s *RedisStore) Heartbeat(replica string, ttl time.Duration) error {
+	now := time.Now()
+	if _, err := s.do("ZADD", s.prefix+"replicas", strconv.FormatInt(now.Add(ttl).UnixMilli(), 10), replica); err != nil {
+		return err
+	}
+	_, err := s.do("ZREMRANGEBYSCORE", s.prefix+"replicas", "-inf", "("+strconv.FormatInt(now.UnixMilli(), 10))
+	return err
+}
+
+func (s *RedisStore) Replicas() ([]string, error) {
+	reply, err := s.do("ZRANGEBYSCORE", s.prefix+"replicas", strconv.FormatInt(time.Now().UnixMilli(), 10), "+inf")
+	if err != nil {
+		return nil, err
+	}
+	return redisStrings(reply)
+}
+
+func (s *RedisStore) jobKey(id string) string {
+	return s.prefix + "job:" + id
+}
+
+func (s *RedisStore) getJob(conn *redisConn, id string) (Job, error) {
+	reply, err := conn.do("GET", s.jobKey(id))
+	if err != nil {
+		return Job{}, err
+	}
+	raw, ok := reply.([]byte)
+	if !ok {
+		return Job{}, JobNotFoundError
+	}
+
+	var job Job
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return Job{}, fmt.Errorf("couldn't decode job %s, %w", id, err)
+	}
+	return job, nil
+}
+
+// transaction watches key while fn reads it and runs the commands fn returns
+// in a MULTI block, starting over if the key changed in the meantime. No
+// commands end the transaction without writes.
+func (s *RedisStore) transaction(key string, fn func(conn *redisConn) ([][]string, error)) (err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return err
+	}
+	defer func() { s.release(conn, err) }()
+
+	for try := 0; try < redisRetries; try++ {
+		if _, err := conn.do("WATCH", key); err != nil {
+			return err
+		}
+		commands, err := fn(conn)
+		if err != nil || len(commands) == 0 {
+			if _, unwatchErr := conn.do("UNWATCH"); unwatchErr != nil {
+				return unwatchErr
+			}
+			return err
+		}
+
+		if _, err := conn.do("MULTI"); err != nil {
+			return err
+		}
+		for _, command := range commands {
+			if _, err := conn.do(command...); err != nil {
+				conn.do("DISCARD")
+				return err
+			}
+		}
+		reply, err := conn.do("EXEC")
+		if err != nil {
+			return err
+		}
+		// a nil reply means the watched key changed
+		if reply == nil {
+			continue
+		}
+		replies, _ := reply.([]interface{})
+		for _, reply := range replies {
+			if replyErr, ok := reply.(redisError); ok {
+				return replyErr
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w, %s", TransactionConflictError, key)
+}
+
+// do runs a single command on a pooled connection
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(args...)
+	s.release(conn, err)
+	return reply, err
+}
+
+// conn returns an idle connection or opens a new one
+func (s *RedisStore) conn() (*redisConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return conn, nil
+	}
+	s.mu.Unlock()
+
+	netConn, err := net.DialTimeout("tcp", s.address, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.user != "" {
+			args = []string{"AUTH", s.user, s.password}
+		}
+		if _, err := conn.do(args...); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// release keeps the connection for reuse unless err left it in an unknown
+// state, error replies of the server don't
+func (s *RedisStore) release(conn *redisConn, err error) {
+	var replyErr redisError
+	if err != nil && !errors.Is(err, JobNotFoundError) && !errors.As(err, &replyErr) {
+		conn.conn.Close()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= redisIdleConns {
+		conn.conn.Close()
+		return
+	}
+	s.idle = append(s.idle, conn)
+}
+
+// createdMember is the member of a job in the creation index, all members
+// have the score 0 so they sort lexicographically
+func createdMember(job Job) string {
+	return string(createdKey(job))
+}
+
+func redisStrings(reply interface{}) ([]string, error) {
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		raw, ok := value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unexpected redis reply %v", reply)
+		}
+		strs = append(strs, string(raw))
+	}
+	return strs, nil
+}
+
+// redisConn sends commands as arrays of bulk strings and reads the replies,
+// simple strings are strings, integers int64, bulk strings []byte, arrays
+// []interface{} and nil bulk strings and arrays nil
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, size)
+		// the replies of EXEC keep the error replies of the commands
+		for i := range values {
+			if values[i], err = c.read(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				values[i] = replyErr
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
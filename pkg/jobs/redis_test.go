@@ -0,0 +1,453 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis speaks just enough of the redis protocol to test the store, the
+// sorted sets only support the score ranges and the lexicographic ranges of
+// members with equal scores
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	strings  map[string]string
+	hashes   map[string]map[string]string
+	zsets    map[string]map[string]float64
+	versions map[string]int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		strings:  make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+		zsets:    make(map[string]map[string]float64),
+		versions: make(map[string]int),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) url() string {
+	if f.password != "" {
+		return "redis://:" + f.password + "@" + f.listener.Addr().String()
+	}
+	return "redis://" + f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	watched := make(map[string]int)
+	var queued [][]string
+	multi := false
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+
+		switch {
+		case name == "AUTH":
+			authenticated = args[len(args)-1] == f.password
+			if !authenticated {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			io.WriteString(conn, "+OK\r\n")
+		case !authenticated:
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+		case name == "WATCH":
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = f.versions[key]
+			}
+			f.mu.Unlock()
+			io.WriteString(conn, "+OK\r\n")
+		case name == "UNWATCH":
+			watched = make(map[string]int)
+			io.WriteString(conn, "+OK\r\n")
+		case name == "MULTI":
+			multi, queued = true, nil
+			io.WriteString(conn, "+OK\r\n")
+		case name == "DISCARD":
+			multi, queued, watched = false, nil, make(map[string]int)
+			io.WriteString(conn, "+OK\r\n")
+		case name == "EXEC":
+			f.mu.Lock()
+			changed := false
+			for key, version := range watched {
+				changed = changed || f.versions[key] != version
+			}
+			reply := "*-1\r\n"
+			if !changed {
+				reply = fmt.Sprintf("*%d\r\n", len(queued))
+				for _, command := range queued {
+					reply += f.exec(command)
+				}
+			}
+			f.mu.Unlock()
+			multi, queued, watched = false, nil, make(map[string]int)
+			io.WriteString(conn, reply)
+		case multi:
+			queued = append(queued, args)
+			io.WriteString(conn, "+QUEUED\r\n")
+		default:
+			f.mu.Lock()
+			reply := f.exec(args)
+			f.mu.Unlock()
+			io.WriteString(conn, reply)
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+// exec runs a command that isn't about connection state, the caller holds mu
+func (f *fakeRedis) exec(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := f.strings[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			if value, ok := f.strings[key]; ok {
+				reply += bulk(value)
+			} else {
+				reply += "$-1\r\n"
+			}
+		}
+		return reply
+	case "SET":
+		f.strings[args[1]] = args[2]
+		f.versions[args[1]]++
+		return "+OK\r\n"
+	case "DEL":
+		delete(f.strings, args[1])
+		f.versions[args[1]]++
+		return ":1\r\n"
+	case "HSET":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		f.hashes[args[1]][args[2]] = args[3]
+		return ":1\r\n"
+	case "HDEL":
+		delete(f.hashes[args[1]], args[2])
+		return ":1\r\n"
+	case "HGETALL":
+		fields := make([]string, 0)
+		for field, value := range f.hashes[args[1]] {
+			fields = append(fields, field, value)
+		}
+		return array(fields)
+	case "ZADD":
+		score, _ := strconv.ParseFloat(args[2], 64)
+		if f.zsets[args[1]] == nil {
+			f.zsets[args[1]] = make(map[string]float64)
+		}
+		f.zsets[args[1]][args[3]] = score
+		return ":1\r\n"
+	case "ZREM":
+		delete(f.zsets[args[1]], args[2])
+		return ":1\r\n"
+	case "ZRANGEBYLEX":
+		members := make([]string, 0)
+		for member := range f.zsets[args[1]] {
+			if lexAbove(member, args[2]) && lexBelow(member, args[3]) {
+				members = append(members, member)
+			}
+		}
+		sort.Strings(members)
+		if len(args) == 7 {
+			offset, _ := strconv.Atoi(args[5])
+			count, _ := strconv.Atoi(args[6])
+			members = members[minInt(offset, len(members)):minInt(offset+count, len(members))]
+		}
+		return array(members)
+	case "ZRANGEBYSCORE":
+		members := make([]string, 0)
+		for member, score := range f.zsets[args[1]] {
+			if scoreAbove(score, args[2]) && scoreBelow(score, args[3]) {
+				members = append(members, member)
+			}
+		}
+		sort.Strings(members)
+		return array(members)
+	case "ZREMRANGEBYSCORE":
+		for member, score := range f.zsets[args[1]] {
+			if scoreAbove(score, args[2]) && scoreBelow(score, args[3]) {
+				delete(f.zsets[args[1]], member)
+			}
+		}
+		return ":1\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func array(values []string) string {
+	reply := fmt.Sprintf("*%d\r\n", len(values))
+	for _, value := range values {
+		reply += bulk(value)
+	}
+	return reply
+}
+
+func lexAbove(member, bound string) bool {
+	switch {
+	case bound == "-":
+		return true
+	case strings.HasPrefix(bound, "("):
+		return member > bound[1:]
+	}
+	return member >= strings.TrimPrefix(bound, "[")
+}
+
+func lexBelow(member, bound string) bool {
+	switch {
+	case bound == "+":
+		return true
+	case strings.HasPrefix(bound, "("):
+		return member < bound[1:]
+	}
+	return member <= strings.TrimPrefix(bound, "[")
+}
+
+func parseScore(bound string) (float64, bool) {
+	exclusive := strings.HasPrefix(bound, "(")
+	switch bound = strings.TrimPrefix(bound, "("); bound {
+	case "-inf":
+		return math.Inf(-1), exclusive
+	case "+inf":
+		return math.Inf(1), exclusive
+	}
+	score, _ := strconv.ParseFloat(bound, 64)
+	return score, exclusive
+}
+
+func scoreAbove(score float64, bound string) bool {
+	lower, exclusive := parseScore(bound)
+	return score > lower || (!exclusive && score == lower)
+}
+
+func scoreBelow(score float64, bound string) bool {
+	upper, exclusive := parseScore(bound)
+	return score < upper || (!exclusive && score == upper)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestRedisStore(t *testing.T) {
+	t.Run("authenticates and fails with a wrong password", func(t *testing.T) {
+		server := newFakeRedis(t, "secret")
+		store, err := NewRedisStore(server.url(), "test:")
+		require.NoError(t, err)
+		defer store.Close()
+
+		_, err = NewRedisStore(strings.Replace(server.url(), "secret", "wrong", 1), "test:")
+		require.ErrorContains(t, err, "WRONGPASS")
+	})
+
+	t.Run("pages through more jobs than fit in a page", func(t *testing.T) {
+		store, err := NewRedisStore(newFakeRedis(t, "").url(), "test:")
+		require.NoError(t, err)
+		defer store.Close()
+		created := time.Now().UTC()
+		ids := make([]string, 0, redisPageSize+5)
+		for i := 0; i < redisPageSize+5; i++ {
+			job, err := New("pi")
+			require.NoError(t, err)
+			job.CreatedAt = created.Add(time.Duration(i) * time.Millisecond)
+			require.NoError(t, store.Put(job))
+			ids = append(ids, job.ID)
+		}
+
+		list, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, list, len(ids))
+		for i, job := range list {
+			require.Equal(t, ids[i], job.ID)
+		}
+	})
+
+	t.Run("keeps one index entry per job when the creation time changes", func(t *testing.T) {
+		store, err := NewRedisStore(newFakeRedis(t, "").url(), "test:")
+		require.NoError(t, err)
+		defer store.Close()
+		job, err := New("pi")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(job))
+		require.NoError(t, store.Update(job.ID, func(j *Job) { j.CreatedAt = j.CreatedAt.Add(-time.Hour) }))
+
+		list, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.NoError(t, store.Delete(job.ID))
+		list, err = store.List()
+		require.NoError(t, err)
+		require.Empty(t, list)
+	})
+
+	t.Run("retries updates when another replica changed the job", func(t *testing.T) {
+		url := newFakeRedis(t, "").url()
+		store, err := NewRedisStore(url, "test:")
+		require.NoError(t, err)
+		defer store.Close()
+		other, err := NewRedisStore(url, "test:")
+		require.NoError(t, err)
+		defer other.Close()
+		job, err := New("pi")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(job))
+
+		calls := 0
+		require.NoError(t, store.Update(job.ID, func(j *Job) {
+			calls++
+			if calls == 1 {
+				require.NoError(t, other.Update(job.ID, func(j *Job) { j.Priority = 5 }))
+			}
+			j.SetState(StateRunning)
+		}))
+		require.Equal(t, 2, calls)
+		got, err := store.Get(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, StateRunning, got.State)
+		require.Equal(t, 5, got.Priority)
+	})
+
+	t.Run("shares the queued submissions", func(t *testing.T) {
+		url := newFakeRedis(t, "").url()
+		store, err := NewRedisStore(url, "test:")
+		require.NoError(t, err)
+		defer store.Close()
+		other, err := NewRedisStore(url, "test:")
+		require.NoError(t, err)
+		defer other.Close()
+
+		require.NoError(t, store.PutSubmission("job-1", []byte(`{"args":[]}`)))
+		submissions, err := other.Submissions()
+		require.NoError(t, err)
+		require.Equal(t, map[string][]byte{"job-1": []byte(`{"args":[]}`)}, submissions)
+
+		require.NoError(t, other.DeleteSubmission("job-1"))
+		submissions, err = store.Submissions()
+		require.NoError(t, err)
+		require.Empty(t, submissions)
+	})
+
+	t.Run("interrupts only the jobs of expired replicas", func(t *testing.T) {
+		store, err := NewRedisStore(newFakeRedis(t, "").url(), "test:")
+		require.NoError(t, err)
+		defer store.Close()
+		require.NoError(t, store.Heartbeat("live", time.Minute))
+		require.NoError(t, store.Heartbeat("expired", -time.Second))
+		replicas, err := store.Replicas()
+		require.NoError(t, err)
+		require.Equal(t, []string{"live"}, replicas)
+
+		ids := make(map[string]string)
+		for _, replica := range []string{"live", "expired", ""} {
+			job, err := New("pi")
+			require.NoError(t, err)
+			job.Replica = replica
+			job.SetState(StateRunning)
+			require.NoError(t, store.Put(job))
+			ids[replica] = job.ID
+		}
+
+		interrupted, err := Interrupt(store)
+		require.NoError(t, err)
+		require.Len(t, interrupted, 2)
+		got, err := store.Get(ids["live"])
+		require.NoError(t, err)
+		require.Equal(t, StateRunning, got.State)
+		got, err = store.Get(ids["expired"])
+		require.NoError(t, err)
+		require.Equal(t, StateFailed, got.State)
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ExpiredError is returned by watches whose resource version is too old to
+// resume from, the resources have to be listed again
+var ExpiredError error = errors.New("resource version expired")
+
+// the event types of a watch
+const (
+	Added    = "ADDED"
+	Modified = "MODIFIED"
+	Deleted  = "DELETED"
+	bookmark = "BOOKMARK"
+	watchErr = "ERROR"
+)
+
+// watchTimeout is how long the API server keeps a watch open, the informer
+// resumes it afterwards
+const watchTimeout = 5 * time.Minute
+
+// PodEvent is a change of a pod, Type is Added, Modified or Deleted
+type PodEvent struct {
+	Type string
+	Pod  Pod
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// WatchPods streams the changes of the pods matching the label selector after
+// resourceVersion to fn until the API server ends the watch or ctx is done.
+// Bookmark events only carry a new resource version. It returns ExpiredError
+// if the resource version is too old.
+func (c *Client) WatchPods(ctx context.Context, namespace, labelSelector, resourceVersion string, fn func(PodEvent)) error {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", strconv.Itoa(int(watchTimeout.Seconds())))
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+	if resourceVersion != "" {
+		query.Set("resourceVersion", resourceVersion)
+	}
+
+	// the client timeout would cut off the stream
+	client := *c.http
+	client.Timeout = 0
+	resp, err := c.send(ctx, &client, http.MethodGet, podsPath(namespace), query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("couldn't decode watch event, %w", err)
+		}
+		if event.Type == watchErr {
+			var status Status
+			if err := json.Unmarshal(event.Object, &status); err == nil && status.Code == http.StatusGone {
+				return ExpiredError
+			}
+			return fmt.Errorf("watch failed: %s", status.Message)
+		}
+		var pod Pod
+		if err := json.Unmarshal(event.Object, &pod); err != nil {
+			return fmt.Errorf("couldn't decode watched pod, %w", err)
+		}
+		fn(PodEvent{Type: event.Type, Pod: pod})
+	}
+}
+
+// PodInformer keeps the handlers up to date with the pods matching a label
+// selector, like a shared informer of client-go. It lists the pods, hands
+// them to the handlers as Added and watches them from then on. Watches that
+// can't resume list the pods again.
+type PodInformer struct {
+	client     *Client
+	namespace  string
+	selector   string
+	retryDelay time.Duration
+	mu         sync.Mutex
+	handlers   []func(PodEvent)
+}
+
+// NewPodInformer creates an informer for the pods of the namespace, an empty
+// namespace means all namespaces
+func NewPodInformer(client *Client, namespace, selector string) *PodInformer {
+	return &PodInformer{client: client, namespace: namespace, selector: selector, retryDelay: 5 * time.Second}
+}
+
+// AddHandler registers a handler for the changes of the pods, it's called
+// from the goroutine of Run
+func (i *PodInformer) AddHandler(fn func(PodEvent)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.handlers = append(i.handlers, fn)
+}
+
+// Run lists and watches the pods until ctx is done
+func (i *PodInformer) Run(ctx context.Context) {
+	for {
+		err := i.listAndWatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ExpiredError) {
+			zap.L().Info("pod watch expired, listing pods again", zap.String("selector", i.selector))
+			continue
+		}
+		zap.L().Warn("pod watch failed", zap.String("selector", i.selector), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(i.retryDelay):
+		}
+	}
+}
+
+func (i *PodInformer) listAndWatch(ctx context.Context) error {
+	list, err := i.client.listPods(ctx, i.namespace, i.selector)
+	if err != nil {
+		return fmt.Errorf("couldn't list pods, %w", err)
+	}
+	for _, pod := range list.Items {
+		i.dispatch(PodEvent{Type: Added, Pod: pod})
+	}
+
+	version := list.Metadata.ResourceVersion
+	for {
+		err := i.client.WatchPods(ctx, i.namespace, i.selector, version, func(event PodEvent) {
+			version = event.Pod.Metadata.ResourceVersion
+			if event.Type != bookmark {
+				i.dispatch(event)
+			}
+		})
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (i *PodInformer) dispatch(event PodEvent) {
+	i.mu.Lock()
+	handlers := i.handlers
+	i.mu.Unlock()
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
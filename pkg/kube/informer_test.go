@@ -0,0 +1,75 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPodInformer(t *testing.T) {
+	t.Run("lists the pods and watches them from the resource version of the list", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		requests := make([]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			require.Equal(t, "/api/v1/pods", r.URL.Path)
+			require.Equal(t, "spark-role=driver", query.Get("labelSelector"))
+			requests = append(requests, query.Get("watch")+"@"+query.Get("resourceVersion"))
+			switch len(requests) {
+			case 1, 4:
+				_, _ = w.Write([]byte(`{"metadata": {"resourceVersion": "10"}, "items": [{"metadata": {"name": "pi-driver", "resourceVersion": "9"}, "status": {"phase": "Pending"}}]}`))
+			case 2:
+				_, _ = w.Write([]byte(`{"type": "MODIFIED", "object": {"metadata": {"name": "pi-driver", "resourceVersion": "11"}, "status": {"phase": "Running"}}}
+{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "12"}}}
+`))
+			case 3:
+				_, _ = w.Write([]byte(`{"type": "ERROR", "object": {"message": "too old resource version", "code": 410}}`))
+			default:
+				cancel()
+			}
+		}))
+		defer server.Close()
+		c, err := NewClient(server.URL)
+		require.NoError(t, err)
+
+		phases := make([]string, 0)
+		informer := NewPodInformer(c, "", "spark-role=driver")
+		informer.AddHandler(func(event PodEvent) {
+			phases = append(phases, event.Type+" "+event.Pod.Status.Phase)
+		})
+		informer.Run(ctx)
+
+		require.Equal(t, []string{"@", "true@10", "true@12", "@", "true@10"}, requests)
+		require.Equal(t, []string{"ADDED Pending", "MODIFIED Running", "ADDED Pending"}, phases)
+	})
+
+	t.Run("given a failed watch, returns the error of the API", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"type": "ERROR", "object": {"message": "internal error", "code": 500}}`))
+		}))
+		defer server.Close()
+		c, err := NewClient(server.URL)
+		require.NoError(t, err)
+
+		err = c.WatchPods(context.Background(), "spark", "", "1", func(PodEvent) {})
+		require.ErrorContains(t, err, "internal error")
+		require.NotErrorIs(t, err, ExpiredError)
+	})
+}
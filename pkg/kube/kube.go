@@ -0,0 +1,259 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kube is a minimal client for the parts of the kubernetes API the
+// server needs. It either talks to the API server the pod is running in,
+// authenticated with the mounted service account, or to a plain http address
+// like the one of `kubectl proxy`.
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+var NotFoundError error = errors.New("resource not found")
+
+type Client struct {
+	baseURL   string
+	tokenFile string
+	http      *http.Client
+}
+
+// NewClient creates a client for the given API server address. The spark
+// master notation (k8s://https://host:port) is accepted as well. If the
+// address is empty, the in-cluster API server is used. The service account
+// token and CA are used whenever they are mounted into the pod.
+func NewClient(apiServer string) (*Client, error) {
+	apiServer = strings.TrimPrefix(apiServer, "k8s://")
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("no kubernetes API server configured and not running inside a cluster")
+		}
+		apiServer = "https://" + host + ":" + port
+	}
+	if !strings.Contains(apiServer, "://") {
+		apiServer = "https://" + apiServer
+	}
+
+	client := &Client{
+		baseURL: strings.TrimSuffix(apiServer, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+
+	tokenFile := serviceAccountDir + "/token"
+	if _, err := os.Stat(tokenFile); err == nil {
+		client.tokenFile = tokenFile
+	}
+
+	if ca, err := os.ReadFile(serviceAccountDir + "/ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		client.http.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	return client, nil
+}
+
+func (c *Client) ListPods(ctx context.Context, namespace, labelSelector string) ([]Pod, error) {
+	list, err := c.listPods(ctx, namespace, labelSelector)
+	return list.Items, err
+}
+
+// listPods returns the list with its resource version, the version a watch
+// of the pods starts from
+func (c *Client) listPods(ctx context.Context, namespace, labelSelector string) (podList, error) {
+	query := url.Values{}
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+
+	var list podList
+	if err := c.do(ctx, http.MethodGet, podsPath(namespace), query, nil, &list); err != nil {
+		return podList{}, err
+	}
+	return list, nil
+}
+
+// ListActivePods lists the pods of all namespaces that aren't finished, the
+// ones that take up resources of their nodes
+func (c *Client) ListActivePods(ctx context.Context) ([]Pod, error) {
+	query := url.Values{}
+	query.Set("fieldSelector", "status.phase!=Succeeded,status.phase!=Failed")
+
+	var list podList
+	if err := c.do(ctx, http.MethodGet, podsPath(""), query, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (c *Client) ListNodes(ctx context.Context) ([]Node, error) {
+	var list nodeList
+	if err := c.do(ctx, http.MethodGet, "/api/v1/nodes", nil, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (c *Client) GetPod(ctx context.Context, namespace, name string) (Pod, error) {
+	var pod Pod
+	err := c.do(ctx, http.MethodGet, podsPath(namespace)+"/"+url.PathEscape(name), nil, nil, &pod)
+	return pod, err
+}
+
+func (c *Client) DeletePod(ctx context.Context, namespace, name string) error {
+	return c.do(ctx, http.MethodDelete, podsPath(namespace)+"/"+url.PathEscape(name), nil, nil, nil)
+}
+
+func (c *Client) ListConfigMaps(ctx context.Context, namespace, labelSelector string) ([]ConfigMap, error) {
+	query := url.Values{}
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+
+	var list configMapList
+	if err := c.do(ctx, http.MethodGet, namespacedPath(namespace, "configmaps"), query, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// PodProxy returns a reverse proxy to a port of a pod through the proxy
+// subresource of the API server, the path of a proxied request is appended
+// to the pod address. The Authorization header of the incoming request is
+// replaced, it never reaches the pod.
+func (c *Client) PodProxy(namespace, name string, port int) http.Handler {
+	target, _ := url.Parse(fmt.Sprintf("%s%s/%s:%d/proxy", c.baseURL, podsPath(namespace), url.PathEscape(name), port))
+	transport := c.http.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.Host = target.Host
+			r.Out.Header.Del("Authorization")
+		},
+		Transport: &tokenTransport{tokenFile: c.tokenFile, next: transport},
+	}
+}
+
+// tokenTransport authenticates requests with the service account token
+type tokenTransport struct {
+	tokenFile string
+	next      http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.tokenFile == "" {
+		return t.next.RoundTrip(req)
+	}
+	token, err := os.ReadFile(t.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read service account token, %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	return t.next.RoundTrip(req)
+}
+
+func podsPath(namespace string) string {
+	return namespacedPath(namespace, "pods")
+}
+
+// namespacedPath returns the path of a core resource, an empty namespace
+// addresses all namespaces
+func namespacedPath(namespace, resource string) string {
+	if namespace == "" {
+		return "/api/v1/" + resource
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(namespace) + "/" + resource
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, result interface{}) error {
+	resp, err := c.send(ctx, c.http, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("couldn't decode kubernetes API response, %w", err)
+	}
+	return nil
+}
+
+// send executes the request with the http client and turns error responses
+// into errors, the caller closes the body of the returned response
+func (c *Client) send(ctx context.Context, client *http.Client, method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create request, %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.tokenFile != "" {
+		// the token is read on every request since it is rotated by the kubelet
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read service account token, %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to kubernetes API failed, %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, NotFoundError
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var status Status
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Message == "" {
+			return nil, fmt.Errorf("kubernetes API responded with status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("kubernetes API responded with status %d: %s", resp.StatusCode, status.Message)
+	}
+	return resp, nil
+}
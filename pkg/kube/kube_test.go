@@ -0,0 +1,95 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Run("accepts the spark master notation", func(t *testing.T) {
+		c, err := NewClient("k8s://http://127.0.0.1:8001/")
+		require.NoError(t, err)
+		require.Equal(t, "http://127.0.0.1:8001", c.baseURL)
+
+		c, err = NewClient("k8s://10.0.0.1:443")
+		require.NoError(t, err)
+		require.Equal(t, "https://10.0.0.1:443", c.baseURL)
+	})
+
+	t.Run("given a missing pod, returns NotFoundError", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL)
+		require.NoError(t, err)
+		_, err = c.GetPod(context.Background(), "spark", "nope")
+		require.ErrorIs(t, err, NotFoundError)
+	})
+
+	t.Run("given an API error, returns its message", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "pods is forbidden", "code": 403}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL)
+		require.NoError(t, err)
+		_, err = c.ListPods(context.Background(), "spark", "")
+		require.ErrorContains(t, err, "pods is forbidden")
+	})
+
+	t.Run("proxies requests to a pod port", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/api/v1/namespaces/spark/pods/pi-driver:4040/proxy/jobs/", r.URL.Path)
+			require.Equal(t, "id=1", r.URL.RawQuery)
+			require.Empty(t, r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte("spark jobs"))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/jobs/?id=1", nil)
+		req.Header.Set("Authorization", "Bearer api-key")
+		w := httptest.NewRecorder()
+		c.PodProxy("spark", "pi-driver", 4040).ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "spark jobs", w.Body.String())
+	})
+
+	t.Run("lists the config maps of all namespaces", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/api/v1/configmaps", r.URL.Path)
+			require.Equal(t, "app=spark", r.URL.Query().Get("labelSelector"))
+			_, _ = w.Write([]byte(`{"items": [{"metadata": {"name": "presets", "namespace": "data"}, "data": {"etl.yaml": "main: etl.py"}}]}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL)
+		require.NoError(t, err)
+		configMaps, err := c.ListConfigMaps(context.Background(), "", "app=spark")
+		require.NoError(t, err)
+		require.Len(t, configMaps, 1)
+		require.Equal(t, "main: etl.py", configMaps[0].Data["etl.yaml"])
+	})
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the suffixes of resource quantities, the binary ones have to be checked
+// before the decimal ones they end with
+var quantitySuffixes = []struct {
+	suffix string
+	factor float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// ParseQuantity parses a resource quantity like 500m cores or 4Gi bytes
+func ParseQuantity(quantity string) (float64, error) {
+	number, factor := strings.TrimSpace(quantity), 1.0
+	for _, unit := range quantitySuffixes {
+		if value, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, factor = value, unit.factor
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid quantity "%s"`, quantity)
+	}
+	return value * factor, nil
+}
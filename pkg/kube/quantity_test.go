@@ -0,0 +1,40 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuantity(t *testing.T) {
+	for quantity, want := range map[string]float64{
+		"2":         2,
+		"500m":      0.5,
+		"1.5":       1.5,
+		"4Gi":       4 << 30,
+		"512Mi":     512 << 20,
+		"8G":        8e9,
+		"1e3":       1000,
+		"128974848": 128974848,
+	} {
+		got, err := ParseQuantity(quantity)
+		require.NoError(t, err, quantity)
+		require.Equal(t, want, got, quantity)
+	}
+
+	_, err := ParseQuantity("lots")
+	require.EqualError(t, err, `invalid quantity "lots"`)
+}
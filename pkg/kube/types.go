@@ -0,0 +1,120 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import "time"
+
+// the types only contain the fields of the kubernetes API objects we need
+
+type ObjectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	CreationTimestamp *time.Time        `json:"creationTimestamp,omitempty"`
+}
+
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     PodSpec    `json:"spec"`
+	Status   PodStatus  `json:"status"`
+}
+
+type PodSpec struct {
+	NodeName           string      `json:"nodeName,omitempty"`
+	ServiceAccountName string      `json:"serviceAccountName,omitempty"`
+	Containers         []Container `json:"containers,omitempty"`
+}
+
+type Container struct {
+	Name      string               `json:"name"`
+	Resources ResourceRequirements `json:"resources,omitempty"`
+}
+
+// ResourceRequirements map resource names like cpu and memory to quantities
+type ResourceRequirements struct {
+	Requests map[string]string `json:"requests,omitempty"`
+}
+
+type PodStatus struct {
+	Phase             string            `json:"phase"`
+	StartTime         *time.Time        `json:"startTime,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+type ContainerStatus struct {
+	Name  string         `json:"name"`
+	Image string         `json:"image"`
+	State ContainerState `json:"state"`
+}
+
+type ContainerState struct {
+	Running *struct {
+		StartedAt *time.Time `json:"startedAt,omitempty"`
+	} `json:"running,omitempty"`
+	Waiting *struct {
+		Reason string `json:"reason,omitempty"`
+	} `json:"waiting,omitempty"`
+	Terminated *struct {
+		ExitCode   int        `json:"exitCode"`
+		Reason     string     `json:"reason,omitempty"`
+		StartedAt  *time.Time `json:"startedAt,omitempty"`
+		FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	} `json:"terminated,omitempty"`
+}
+
+type podList struct {
+	Metadata listMeta `json:"metadata"`
+	Items    []Pod    `json:"items"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type Node struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     NodeSpec   `json:"spec"`
+	Status   NodeStatus `json:"status"`
+}
+
+type NodeSpec struct {
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+type NodeStatus struct {
+	Allocatable map[string]string `json:"allocatable,omitempty"`
+}
+
+type nodeList struct {
+	Items []Node `json:"items"`
+}
+
+type ConfigMap struct {
+	Metadata ObjectMeta        `json:"metadata"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+type configMapList struct {
+	Items []ConfigMap `json:"items"`
+}
+
+// Status is the error object returned by the kubernetes API
+type Status struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Code    int    `json:"code"`
+}
@@ -0,0 +1,33 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFromContext(t *testing.T) {
+	t.Run("falls back to the global logger", func(t *testing.T) {
+		require.Equal(t, zap.L(), FromContext(context.Background()))
+	})
+
+	t.Run("returns the logger of the context", func(t *testing.T) {
+		logger := zap.NewNop()
+		require.Equal(t, logger, FromContext(WithLogger(context.Background(), logger)))
+	})
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsEndpoint      = "https://storage.googleapis.com"
+	gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCSBucket reads and writes objects with the Cloud Storage JSON API, authenticated
+// with the service account of the GCE metadata server, which includes
+// Workload Identity on GKE
+type GCSBucket struct {
+	baseURL   string
+	uploadURL string
+	tokenURL  string
+	http      *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewGCSBucket creates a client for the bucket. Requests are sent without
+// credentials if tokenURL is empty, e.g. to an emulator.
+func NewGCSBucket(bucket, endpoint, tokenURL string) *GCSBucket {
+	return &GCSBucket{
+		baseURL:   strings.TrimSuffix(endpoint, "/") + "/storage/v1/b/" + url.PathEscape(bucket) + "/o",
+		uploadURL: strings.TrimSuffix(endpoint, "/") + "/upload/storage/v1/b/" + url.PathEscape(bucket) + "/o",
+		tokenURL:  tokenURL,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewGCSBucketFromEnv uses the emulator of STORAGE_EMULATOR_HOST if it is
+// set and the production API otherwise
+func NewGCSBucketFromEnv(bucket string) *GCSBucket {
+	if emulator := os.Getenv("STORAGE_EMULATOR_HOST"); emulator != "" {
+		if !strings.Contains(emulator, "://") {
+			emulator = "http://" + emulator
+		}
+		return NewGCSBucket(bucket, emulator, "")
+	}
+	return NewGCSBucket(bucket, gcsEndpoint, gcsMetadataToken)
+}
+
+type gcsList struct {
+	Items []struct {
+		Name string `json:"name"`
+		ETag string `json:"etag"`
+		// the JSON API returns the size as a string
+		Size int64 `json:"size,string"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+type gcsError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type gcsToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (b *GCSBucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"prefix": {prefix}}
+	for {
+		var list gcsList
+		if err := b.do(ctx, http.MethodGet, b.baseURL+"?"+query.Encode(), nil, &list); err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			objects = append(objects, Object{Key: item.Name, ETag: item.ETag, Size: item.Size})
+		}
+		if list.NextPageToken == "" {
+			return objects, nil
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+}
+
+func (b *GCSBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	var content []byte
+	err := b.do(ctx, http.MethodGet, b.baseURL+"/"+url.PathEscape(key)+"?alt=media", nil, &content)
+	return content, err
+}
+
+func (b *GCSBucket) Put(ctx context.Context, key string, content []byte) (Object, error) {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	var result struct {
+		ETag string `json:"etag"`
+	}
+	if err := b.do(ctx, http.MethodPost, b.uploadURL+"?"+query.Encode(), content, &result); err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, ETag: result.ETag, Size: int64(len(content))}, nil
+}
+
+func (b *GCSBucket) do(ctx context.Context, method, target string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't create request, %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if b.tokenURL != "" {
+		token, err := b.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to Cloud Storage failed, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFoundError
+	}
+	if resp.StatusCode >= 300 {
+		var gcsErr gcsError
+		if err := json.NewDecoder(resp.Body).Decode(&gcsErr); err != nil || gcsErr.Error.Message == "" {
+			return fmt.Errorf("Cloud Storage responded with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("Cloud Storage responded with status %d: %s", resp.StatusCode, gcsErr.Error.Message)
+	}
+
+	if content, ok := result.(*[]byte); ok {
+		*content, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("couldn't read object, %w", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("couldn't decode Cloud Storage response, %w", err)
+	}
+	return nil
+}
+
+// accessToken returns the cached token of the metadata server until shortly
+// before it expires
+func (b *GCSBucket) accessToken(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Now().Before(b.expires) {
+		return b.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create token request, %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("couldn't request access token, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server responded with status %d", resp.StatusCode)
+	}
+
+	var token gcsToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("couldn't decode access token, %w", err)
+	}
+	b.token = token.AccessToken
+	b.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return b.token, nil
+}
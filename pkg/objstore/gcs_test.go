@@ -0,0 +1,97 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCSBucket(t *testing.T) {
+	t.Run("lists all pages and reads objects with the token of the metadata server", func(t *testing.T) {
+		tokenRequests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				tokenRequests++
+				require.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+				_, _ = w.Write([]byte(`{"access_token": "secret", "expires_in": 3599}`))
+				return
+			}
+			require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			switch {
+			case r.URL.Path == "/storage/v1/b/presets/o" && r.URL.Query().Get("pageToken") == "":
+				require.Equal(t, "spark/", r.URL.Query().Get("prefix"))
+				_, _ = w.Write([]byte(`{"items": [{"name": "spark/etl.yaml", "etag": "a"}], "nextPageToken": "next"}`))
+			case r.URL.Path == "/storage/v1/b/presets/o":
+				_, _ = w.Write([]byte(`{"items": [{"name": "spark/team/pi.yaml", "etag": "b"}]}`))
+			case r.URL.EscapedPath() == "/storage/v1/b/presets/o/spark%2Fteam%2Fpi.yaml":
+				require.Equal(t, "media", r.URL.Query().Get("alt"))
+				_, _ = w.Write([]byte("main: pi.py"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error": {"message": "No such object"}}`))
+			}
+		}))
+		defer server.Close()
+
+		bucket := NewGCSBucket("presets", server.URL, server.URL+"/token")
+		objects, err := bucket.List(context.Background(), "spark/")
+		require.NoError(t, err)
+		require.Equal(t, []Object{{Key: "spark/etl.yaml", ETag: "a"}, {Key: "spark/team/pi.yaml", ETag: "b"}}, objects)
+
+		content, err := bucket.Get(context.Background(), "spark/team/pi.yaml")
+		require.NoError(t, err)
+		require.Equal(t, "main: pi.py", string(content))
+		require.Equal(t, 1, tokenRequests)
+
+		_, err = bucket.Get(context.Background(), "nope")
+		require.ErrorIs(t, err, NotFoundError)
+	})
+
+	t.Run("uploads objects", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "/upload/storage/v1/b/artifacts/o", r.URL.Path)
+			require.Equal(t, "media", r.URL.Query().Get("uploadType"))
+			require.Equal(t, "jobs/etl.jar", r.URL.Query().Get("name"))
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, "jar", string(body))
+			_, _ = w.Write([]byte(`{"name": "jobs/etl.jar", "size": "3", "etag": "CJ+5"}`))
+		}))
+		defer server.Close()
+
+		object, err := NewGCSBucket("artifacts", server.URL, "").Put(context.Background(), "jobs/etl.jar", []byte("jar"))
+		require.NoError(t, err)
+		require.Equal(t, Object{Key: "jobs/etl.jar", ETag: "CJ+5", Size: 3}, object)
+	})
+
+	t.Run("given an emulator, sends requests without credentials", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Empty(t, r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error": {"message": "forbidden"}}`))
+		}))
+		defer server.Close()
+
+		t.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+		_, err := NewGCSBucketFromEnv("presets").List(context.Background(), "")
+		require.ErrorContains(t, err, "forbidden")
+	})
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package objstore is a minimal client for listing, reading and writing
+// objects of S3 and Google Cloud Storage buckets, addressed as s3://bucket/prefix and
+// gs://bucket/prefix. Credentials are taken from the environment the same way
+// the official tools do.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var NotFoundError error = errors.New("object not found")
+
+// Object is an entry of a bucket listing
+type Object struct {
+	Key string
+	// ETag changes whenever the content of the object changes
+	ETag string
+	Size int64
+}
+
+// Bucket lists and reads the objects of a bucket
+type Bucket interface {
+	// List returns all objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// WritableBucket can also store objects, both S3 and GCS buckets are
+// writable given the credentials
+type WritableBucket interface {
+	Bucket
+	// Put creates or replaces the object and returns it with its new ETag
+	Put(ctx context.Context, key string, content []byte) (Object, error)
+}
+
+// Open returns the bucket and the key prefix of an s3:// or gs:// URL
+func Open(rawURL string) (WritableBucket, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf(`invalid object store URL "%s", %w`, rawURL, err)
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf(`invalid object store URL "%s", the bucket is missing`, rawURL)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return NewS3BucketFromEnv(u.Host), prefix, nil
+	case "gs":
+		return NewGCSBucketFromEnv(u.Host), prefix, nil
+	}
+	return nil, "", fmt.Errorf(`invalid object store URL "%s", the scheme must be s3 or gs`, rawURL)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	t.Run("given an s3 URL, returns an S3 bucket and the prefix", func(t *testing.T) {
+		t.Setenv("AWS_REGION", "eu-central-1")
+		bucket, prefix, err := Open("s3://presets/spark/")
+		require.NoError(t, err)
+		require.IsType(t, &S3Bucket{}, bucket)
+		require.Equal(t, "https://presets.s3.eu-central-1.amazonaws.com", bucket.(*S3Bucket).baseURL)
+		require.Equal(t, "spark/", prefix)
+	})
+
+	t.Run("given a gs URL, returns a GCS bucket", func(t *testing.T) {
+		bucket, prefix, err := Open("gs://presets")
+		require.NoError(t, err)
+		require.IsType(t, &GCSBucket{}, bucket)
+		require.Equal(t, "", prefix)
+	})
+
+	t.Run("given another scheme or no bucket, returns an error", func(t *testing.T) {
+		_, _, err := Open("http://presets/spark")
+		require.ErrorContains(t, err, "the scheme must be s3 or gs")
+		_, _, err = Open("s3:///spark")
+		require.ErrorContains(t, err, "the bucket is missing")
+	})
+}
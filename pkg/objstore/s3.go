@@ -0,0 +1,284 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Bucket reads and writes objects with the S3 REST API, requests are signed with
+// signature version 4
+type S3Bucket struct {
+	baseURL string
+	region  string
+	creds   S3Credentials
+	http    *http.Client
+	now     func() time.Time
+}
+
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// NewS3Bucket creates a client for the bucket. Without an endpoint the AWS
+// endpoint of the region is used, custom endpoints like MinIO are addressed
+// path-style. Requests are sent unsigned if no access key is set.
+func NewS3Bucket(bucket, region, endpoint string, creds S3Credentials) *S3Bucket {
+	baseURL := "https://" + bucket + ".s3." + region + ".amazonaws.com"
+	if endpoint != "" {
+		baseURL = strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	}
+	return &S3Bucket{
+		baseURL: baseURL,
+		region:  region,
+		creds:   creds,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		now:     time.Now,
+	}
+}
+
+// NewS3BucketFromEnv reads the region, endpoint and credentials from the
+// standard AWS_* variables
+func NewS3BucketFromEnv(bucket string) *S3Bucket {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	return NewS3Bucket(bucket, region, endpoint, S3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		ETag string `xml:"ETag"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3PutResult is taken from the response headers, PUT has no response body
+type s3PutResult struct {
+	ETag string
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (b *S3Bucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		var result s3ListResult
+		if err := b.do(ctx, http.MethodGet, "/", query, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: content.Key, ETag: content.ETag, Size: content.Size})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (b *S3Bucket) Get(ctx context.Context, key string) ([]byte, error) {
+	var content []byte
+	err := b.do(ctx, http.MethodGet, "/"+key, nil, nil, &content)
+	return content, err
+}
+
+func (b *S3Bucket) Put(ctx context.Context, key string, content []byte) (Object, error) {
+	var result s3PutResult
+	if err := b.do(ctx, http.MethodPut, "/"+key, nil, content, &result); err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, ETag: result.ETag, Size: int64(len(content))}, nil
+}
+
+func (b *S3Bucket) do(ctx context.Context, method, path string, query url.Values, body []byte, result interface{}) error {
+	target := b.baseURL + escapePath(path)
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't create request, %w", err)
+	}
+	if body != nil {
+		req.Header.Set("X-Amz-Content-Sha256", hexSHA256(string(body)))
+	}
+	if b.creds.AccessKeyID != "" {
+		b.sign(req, b.now())
+	}
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to S3 failed, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFoundError
+	}
+	if resp.StatusCode >= 300 {
+		var s3Err s3Error
+		if err := xml.NewDecoder(resp.Body).Decode(&s3Err); err != nil || s3Err.Message == "" {
+			return fmt.Errorf("S3 responded with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("S3 responded with status %d: %s", resp.StatusCode, s3Err.Message)
+	}
+	if put, ok := result.(*s3PutResult); ok {
+		put.ETag = resp.Header.Get("ETag")
+		return nil
+	}
+
+	if content, ok := result.(*[]byte); ok {
+		*content, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("couldn't read object, %w", err)
+		}
+		return nil
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("couldn't decode S3 response, %w", err)
+	}
+	return nil
+}
+
+// sign adds the signature version 4 authorization header, the host and all
+// headers already set on the request are signed. The payload hash is taken
+// from X-Amz-Content-Sha256 if it is set.
+func (b *S3Bucket) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+b.creds.SecretAccessKey), date)
+	for _, part := range []string{b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// escapePath escapes every segment of the path the way signature version 4
+// expects it
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes the query sorted by key, with spaces as %20
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes everything except the unreserved characters of
+// RFC 3986
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
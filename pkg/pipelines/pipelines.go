@@ -0,0 +1,560 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pipelines chains presets into small DAGs, a step is submitted once
+// all steps it runs after have succeeded.
+package pipelines
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/spark"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	NotFoundError        error = errors.New("pipeline not found")
+	RunNotFoundError     error = errors.New("pipeline run not found")
+	InvalidPipelineError error = errors.New("invalid pipeline")
+)
+
+// keptRuns is the number of finished runs kept in memory, older ones are dropped
+const keptRuns = 100
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+type Submitter interface {
+	Submit(ctx context.Context, preset string, opts spark.SubmitOptions) (string, error)
+	Job(id string) (jobs.Job, error)
+}
+
+// Step submits a preset after the steps it runs after have succeeded
+type Step struct {
+	Name   string            `yaml:"name" json:"name"`
+	Preset string            `yaml:"preset" json:"preset"`
+	After  []string          `yaml:"after,omitempty" json:"after,omitempty"`
+	Params map[string]string `yaml:"params,omitempty" json:"params,omitempty"`
+}
+
+type Pipeline struct {
+	Name  string `yaml:"-" json:"name"`
+	Steps []Step `yaml:"steps" json:"steps"`
+}
+
+// Parse decodes and validates a pipeline definition
+func Parse(name string, raw []byte) (Pipeline, error) {
+	if !namePattern.MatchString(name) {
+		return Pipeline{}, fmt.Errorf("%w: invalid name %q", InvalidPipelineError, name)
+	}
+	var p Pipeline
+	if err := yaml.UnmarshalStrict(raw, &p); err != nil {
+		return Pipeline{}, fmt.Errorf("%w: %s", InvalidPipelineError, err)
+	}
+	p.Name = name
+	if err := p.validate(); err != nil {
+		return Pipeline{}, err
+	}
+	return p, nil
+}
+
+// validate checks the step references and that the steps don't form a cycle
+func (p Pipeline) validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("%w: no steps", InvalidPipelineError)
+	}
+	steps := make(map[string]Step, len(p.Steps))
+	for _, step := range p.Steps {
+		if !namePattern.MatchString(step.Name) {
+			return fmt.Errorf("%w: invalid step name %q", InvalidPipelineError, step.Name)
+		}
+		if step.Preset == "" {
+			return fmt.Errorf("%w: step %s has no preset", InvalidPipelineError, step.Name)
+		}
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("%w: duplicate step %s", InvalidPipelineError, step.Name)
+		}
+		steps[step.Name] = step
+	}
+	for _, step := range p.Steps {
+		for _, after := range step.After {
+			if _, ok := steps[after]; !ok {
+				return fmt.Errorf("%w: step %s runs after unknown step %s", InvalidPipelineError, step.Name, after)
+			}
+		}
+	}
+
+	// 0: unvisited, 1: on the current path, 2: done
+	visited := make(map[string]int, len(steps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("%w: step %s depends on itself", InvalidPipelineError, name)
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, after := range steps[name].After {
+			if err := visit(after); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	for _, step := range p.Steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type StepState string
+
+const (
+	StepWaiting   StepState = "waiting"
+	StepRunning   StepState = "running"
+	StepSucceeded StepState = "succeeded"
+	StepFailed    StepState = "failed"
+	// StepSkipped steps aren't submitted because a step they run after failed
+	StepSkipped StepState = "skipped"
+)
+
+type RunState string
+
+const (
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+)
+
+type StepRun struct {
+	Name   string    `json:"name"`
+	Preset string    `json:"preset"`
+	State  StepState `json:"state"`
+	JobID  string    `json:"jobId,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Run is a single execution of a pipeline
+type Run struct {
+	ID         string     `json:"id"`
+	Pipeline   string     `json:"pipeline"`
+	State      RunState   `json:"state"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Steps      []StepRun  `json:"steps"`
+
+	definition Pipeline
+}
+
+func (r *Run) copy() Run {
+	c := *r
+	c.Steps = append([]StepRun(nil), r.Steps...)
+	return c
+}
+
+// step returns the run state of the step with the given name
+func (r *Run) step(name string) *StepRun {
+	for i := range r.Steps {
+		if r.Steps[i].Name == name {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// ready marks the waiting steps whose dependencies succeeded as running and
+// skips those with a failed dependency, it returns the steps to submit
+func (r *Run) ready() []Step {
+	var ready []Step
+	for changed := true; changed; {
+		changed = false
+		for _, step := range r.definition.Steps {
+			state := r.step(step.Name)
+			if state.State != StepWaiting {
+				continue
+			}
+			runnable := true
+			for _, after := range step.After {
+				switch r.step(after).State {
+				case StepSucceeded:
+				case StepFailed, StepSkipped:
+					state.State = StepSkipped
+					changed = true
+					runnable = false
+				default:
+					runnable = false
+				}
+				if state.State == StepSkipped {
+					break
+				}
+			}
+			if runnable {
+				state.State = StepRunning
+				ready = append(ready, step)
+			}
+		}
+	}
+	return ready
+}
+
+// finish sets the state of the run once no step is waiting or running anymore
+func (r *Run) finish() {
+	state := RunSucceeded
+	for _, step := range r.Steps {
+		switch step.State {
+		case StepWaiting, StepRunning:
+			return
+		case StepFailed, StepSkipped:
+			state = RunFailed
+		}
+	}
+	now := time.Now().UTC()
+	r.State = state
+	r.FinishedAt = &now
+}
+
+type stepRef struct {
+	run  string
+	step string
+}
+
+// Manager holds the pipeline definitions and tracks their runs, it learns
+// about finished jobs as an events.Publisher
+type Manager struct {
+	mu        sync.Mutex
+	dir       string
+	submitter Submitter
+	pipelines map[string]Pipeline
+	runs      map[string]*Run
+	jobs      map[string]stepRef
+	// wg tracks the submissions of steps started by events
+	wg sync.WaitGroup
+}
+
+// New loads the pipeline files of dir, pipelines saved through the API are
+// written to it. Without a dir pipelines are only kept in memory.
+func New(dir string) (*Manager, error) {
+	m := &Manager{
+		dir:       dir,
+		pipelines: make(map[string]Pipeline),
+		runs:      make(map[string]*Run),
+		jobs:      make(map[string]stepRef),
+	}
+	if dir == "" {
+		return m, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf(`error reading pipeline directory ("%s"), %w`, dir, err)
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read pipeline, %w", err)
+		}
+		p, err := Parse(strings.TrimSuffix(entry.Name(), ext), raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if _, ok := m.pipelines[p.Name]; ok {
+			zap.L().Warn("ignoring duplicate pipeline", zap.String("pipeline", p.Name), zap.String("file", entry.Name()))
+			continue
+		}
+		m.pipelines[p.Name] = p
+	}
+	return m, nil
+}
+
+// SetSubmitter sets the submitter of the steps, the manager has to exist
+// before it to receive its events
+func (m *Manager) SetSubmitter(s Submitter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.submitter = s
+}
+
+func (m *Manager) Pipelines() []Pipeline {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pipelines := make([]Pipeline, 0, len(m.pipelines))
+	for _, p := range m.pipelines {
+		pipelines = append(pipelines, p)
+	}
+	sort.Slice(pipelines, func(i, j int) bool { return pipelines[i].Name < pipelines[j].Name })
+	return pipelines
+}
+
+func (m *Manager) Pipeline(name string) (Pipeline, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pipelines[name]
+	if !ok {
+		return Pipeline{}, NotFoundError
+	}
+	return p, nil
+}
+
+// PutPipeline creates or replaces a pipeline, running runs keep the previous definition
+func (m *Manager) PutPipeline(name string, raw []byte) (Pipeline, error) {
+	p, err := Parse(name, raw)
+	if err != nil {
+		return Pipeline{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir != "" {
+		content, err := yaml.Marshal(p)
+		if err != nil {
+			return Pipeline{}, fmt.Errorf("couldn't encode pipeline, %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(m.dir, name+".yaml"), content); err != nil {
+			return Pipeline{}, fmt.Errorf("couldn't write pipeline, %w", err)
+		}
+	}
+	m.pipelines[name] = p
+	zap.L().Info("saved pipeline", zap.String("pipeline", name))
+	return p, nil
+}
+
+func (m *Manager) DeletePipeline(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pipelines[name]; !ok {
+		return NotFoundError
+	}
+	if m.dir != "" {
+		for _, ext := range []string{".yaml", ".yml"} {
+			if err := os.Remove(filepath.Join(m.dir, name+ext)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("couldn't delete pipeline, %w", err)
+			}
+		}
+	}
+	delete(m.pipelines, name)
+	zap.L().Info("deleted pipeline", zap.String("pipeline", name))
+	return nil
+}
+
+// Start creates a run of the pipeline and submits the steps without
+// dependencies, the returned run contains their job ids
+func (m *Manager) Start(ctx context.Context, name string) (Run, error) {
+	id, err := newID()
+	if err != nil {
+		return Run{}, fmt.Errorf("couldn't generate run id, %w", err)
+	}
+
+	m.mu.Lock()
+	p, ok := m.pipelines[name]
+	if !ok {
+		m.mu.Unlock()
+		return Run{}, NotFoundError
+	}
+	run := &Run{
+		ID:         id,
+		Pipeline:   name,
+		State:      RunRunning,
+		CreatedAt:  time.Now().UTC(),
+		definition: p,
+	}
+	for _, step := range p.Steps {
+		run.Steps = append(run.Steps, StepRun{Name: step.Name, Preset: step.Preset, State: StepWaiting})
+	}
+	m.runs[id] = run
+	ready := run.ready()
+	m.mu.Unlock()
+
+	zap.L().Info("started pipeline run", zap.String("pipeline", name), zap.String("runID", id))
+	m.submit(ctx, id, ready)
+	return m.Run(id)
+}
+
+// Runs returns the runs of a pipeline, the most recent first
+func (m *Manager) Runs(pipeline string) []Run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := []Run{}
+	for _, run := range m.runs {
+		if run.Pipeline == pipeline {
+			runs = append(runs, run.copy())
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+	return runs
+}
+
+func (m *Manager) Run(id string) (Run, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.runs[id]
+	if !ok {
+		return Run{}, RunNotFoundError
+	}
+	return run.copy(), nil
+}
+
+// Publish advances the runs of finished jobs, steps that became ready are
+// submitted in the background since events are published during submissions
+func (m *Manager) Publish(_ context.Context, event events.Event) error {
+	var state StepState
+	switch event.Type {
+	case events.Succeeded:
+		state = StepSucceeded
+	case events.Failed, events.Cancelled:
+		state = StepFailed
+	default:
+		return nil
+	}
+	m.finishStep(event.JobID, state, event.Error)
+	return nil
+}
+
+// finishStep sets the state of the step that submitted the job and submits
+// the steps that became ready, it ignores unknown and already finished jobs
+func (m *Manager) finishStep(jobID string, state StepState, reason string) {
+	m.mu.Lock()
+	ref, ok := m.jobs[jobID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	run := m.runs[ref.run]
+	step := run.step(ref.step)
+	if step.State != StepRunning {
+		m.mu.Unlock()
+		return
+	}
+	step.State = state
+	step.Error = reason
+	delete(m.jobs, jobID)
+	ready := run.ready()
+	m.finishRun(run)
+	m.mu.Unlock()
+
+	if len(ready) > 0 {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.submit(context.Background(), ref.run, ready)
+		}()
+	}
+}
+
+// submit submits the steps of a run, they must have been marked as running
+func (m *Manager) submit(ctx context.Context, runID string, steps []Step) {
+	m.mu.Lock()
+	submitter := m.submitter
+	m.mu.Unlock()
+
+	for _, step := range steps {
+		id, err := submitter.Submit(ctx, step.Preset, spark.SubmitOptions{Params: step.Params})
+		if err != nil {
+			zap.L().Error("couldn't submit pipeline step", zap.String("runID", runID), zap.String("step", step.Name), zap.Error(err))
+			m.failStep(runID, step.Name, err.Error())
+			continue
+		}
+
+		m.mu.Lock()
+		m.runs[runID].step(step.Name).JobID = id
+		m.jobs[id] = stepRef{run: runID, step: step.Name}
+		m.mu.Unlock()
+
+		// the job may have finished before it was assigned to the step
+		if job, err := submitter.Job(id); err == nil && job.State.Terminal() {
+			state := StepFailed
+			if job.State == jobs.StateSucceeded {
+				state = StepSucceeded
+			}
+			m.finishStep(id, state, job.Error)
+		}
+	}
+}
+
+func (m *Manager) failStep(runID, name, reason string) {
+	m.mu.Lock()
+	run := m.runs[runID]
+	step := run.step(name)
+	step.State = StepFailed
+	step.Error = reason
+	run.ready()
+	m.finishRun(run)
+	m.mu.Unlock()
+}
+
+// finishRun finishes the run if all steps are done and drops the oldest
+// finished runs beyond keptRuns, m.mu must be held
+func (m *Manager) finishRun(run *Run) {
+	run.finish()
+	if run.State == RunRunning {
+		return
+	}
+	zap.L().Info("finished pipeline run", zap.String("pipeline", run.Pipeline), zap.String("runID", run.ID), zap.String("state", string(run.State)))
+
+	var finished []*Run
+	for _, r := range m.runs {
+		if r.State != RunRunning {
+			finished = append(finished, r)
+		}
+	}
+	if len(finished) <= keptRuns {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, r := range finished[:len(finished)-keptRuns] {
+		delete(m.runs, r.ID)
+	}
+}
+
+// writeFileAtomic makes sure a pipeline file is never read partially written
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pipeline-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/spark"
+	"github.com/stretchr/testify/require"
+)
+
+type submitterMock struct {
+	mu        sync.Mutex
+	submitted []string
+	params    []map[string]string
+	states    map[string]jobs.State
+	err       error
+}
+
+func (sm *submitterMock) Submit(_ context.Context, preset string, opts spark.SubmitOptions) (string, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.err != nil {
+		return "", sm.err
+	}
+	sm.submitted = append(sm.submitted, preset)
+	sm.params = append(sm.params, opts.Params)
+	return fmt.Sprintf("job-%d", len(sm.submitted)), nil
+}
+
+func (sm *submitterMock) Job(id string) (jobs.Job, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	state, ok := sm.states[id]
+	if !ok {
+		state = jobs.StatePending
+	}
+	return jobs.Job{ID: id, State: state}, nil
+}
+
+func (sm *submitterMock) presets() []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return append([]string(nil), sm.submitted...)
+}
+
+const diamond = `
+steps:
+  - name: extract
+    preset: extract
+    params:
+      day: "2023-01-01"
+  - name: clean
+    preset: clean
+    after: [extract]
+  - name: enrich
+    preset: enrich
+    after: [extract]
+  - name: load
+    preset: load
+    after: [clean, enrich]
+`
+
+func newManager(t *testing.T, submitter Submitter) *Manager {
+	m, err := New("")
+	require.NoError(t, err)
+	m.SetSubmitter(submitter)
+	_, err = m.PutPipeline("etl", []byte(diamond))
+	require.NoError(t, err)
+	return m
+}
+
+// finish publishes the terminal event of a job and waits for the submissions it triggers
+func finish(t *testing.T, m *Manager, jobID string, eventType events.Type) {
+	require.NoError(t, m.Publish(context.Background(), events.Event{Type: eventType, JobID: jobID}))
+	m.wg.Wait()
+}
+
+func TestParse(t *testing.T) {
+	t.Run("parses steps", func(t *testing.T) {
+		p, err := Parse("etl", []byte(diamond))
+		require.NoError(t, err)
+		require.Equal(t, "etl", p.Name)
+		require.Len(t, p.Steps, 4)
+		require.Equal(t, []string{"clean", "enrich"}, p.Steps[3].After)
+		require.Equal(t, map[string]string{"day": "2023-01-01"}, p.Steps[0].Params)
+	})
+
+	for name, raw := range map[string]string{
+		"no steps":       `steps: []`,
+		"unknown field":  `steps: [{name: a, preset: a, retries: 1}]`,
+		"missing preset": `steps: [{name: a}]`,
+		"invalid name":   `steps: [{name: "a b", preset: a}]`,
+		"duplicate step": `steps: [{name: a, preset: a}, {name: a, preset: b}]`,
+		"unknown step":   `steps: [{name: a, preset: a, after: [b]}]`,
+		"self reference": `steps: [{name: a, preset: a, after: [a]}]`,
+		"cycle":          `steps: [{name: a, preset: a, after: [c]}, {name: b, preset: b, after: [a]}, {name: c, preset: c, after: [b]}]`,
+	} {
+		raw := raw
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := Parse("etl", []byte(raw))
+			require.ErrorIs(t, err, InvalidPipelineError)
+		})
+	}
+
+	t.Run("rejects invalid pipeline names", func(t *testing.T) {
+		_, err := Parse("../etl", []byte(diamond))
+		require.ErrorIs(t, err, InvalidPipelineError)
+	})
+}
+
+func TestManager(t *testing.T) {
+	t.Run("runs steps after their dependencies succeeded", func(t *testing.T) {
+		submitter := &submitterMock{}
+		m := newManager(t, submitter)
+
+		run, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+		require.Equal(t, RunRunning, run.State)
+		require.Equal(t, []string{"extract"}, submitter.presets())
+		require.Equal(t, map[string]string{"day": "2023-01-01"}, submitter.params[0])
+		require.Equal(t, "job-1", run.Steps[0].JobID)
+		require.Equal(t, StepWaiting, run.Steps[1].State)
+
+		finish(t, m, "job-1", events.Succeeded)
+		require.ElementsMatch(t, []string{"extract", "clean", "enrich"}, submitter.presets())
+
+		finish(t, m, "job-2", events.Succeeded)
+		require.Len(t, submitter.presets(), 3)
+		finish(t, m, "job-3", events.Succeeded)
+		require.Equal(t, "load", submitter.presets()[3])
+
+		finish(t, m, "job-4", events.Succeeded)
+		run, err = m.Run(run.ID)
+		require.NoError(t, err)
+		require.Equal(t, RunSucceeded, run.State)
+		require.NotNil(t, run.FinishedAt)
+		for _, step := range run.Steps {
+			require.Equal(t, StepSucceeded, step.State)
+		}
+	})
+
+	t.Run("skips the dependents of failed steps", func(t *testing.T) {
+		submitter := &submitterMock{}
+		m := newManager(t, submitter)
+		run, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+		finish(t, m, "job-1", events.Succeeded)
+
+		require.NoError(t, m.Publish(context.Background(), events.Event{Type: events.Failed, JobID: "job-2", Error: "exit status 1"}))
+		m.wg.Wait()
+		run, err = m.Run(run.ID)
+		require.NoError(t, err)
+		require.Equal(t, RunRunning, run.State, "the other branch is still running")
+
+		finish(t, m, "job-3", events.Succeeded)
+		run, err = m.Run(run.ID)
+		require.NoError(t, err)
+		require.Equal(t, RunFailed, run.State)
+		require.Equal(t, StepFailed, run.Steps[1].State)
+		require.Equal(t, "exit status 1", run.Steps[1].Error)
+		require.Equal(t, StepSkipped, run.Steps[3].State)
+		require.Len(t, submitter.presets(), 3)
+	})
+
+	t.Run("fails steps that can't be submitted", func(t *testing.T) {
+		submitter := &submitterMock{err: errors.New("queue is full")}
+		m := newManager(t, submitter)
+		run, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+		require.Equal(t, RunFailed, run.State)
+		require.Equal(t, "queue is full", run.Steps[0].Error)
+		require.Equal(t, StepSkipped, run.Steps[1].State)
+	})
+
+	t.Run("handles jobs that finished before they were assigned", func(t *testing.T) {
+		submitter := &submitterMock{states: map[string]jobs.State{"job-1": jobs.StateFailed}}
+		m := newManager(t, submitter)
+		run, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+		require.Equal(t, RunFailed, run.State)
+
+		// the late event is ignored
+		finish(t, m, "job-1", events.Succeeded)
+		run, err = m.Run(run.ID)
+		require.NoError(t, err)
+		require.Equal(t, StepFailed, run.Steps[0].State)
+	})
+
+	t.Run("ignores unrelated events", func(t *testing.T) {
+		submitter := &submitterMock{}
+		m := newManager(t, submitter)
+		run, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+		finish(t, m, "job-1", events.Retrying)
+		finish(t, m, "other", events.Succeeded)
+
+		run, err = m.Run(run.ID)
+		require.NoError(t, err)
+		require.Equal(t, StepRunning, run.Steps[0].State)
+		require.Len(t, submitter.presets(), 1)
+	})
+
+	t.Run("lists the runs of a pipeline", func(t *testing.T) {
+		m := newManager(t, &submitterMock{})
+		first, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+		second, err := m.Start(context.Background(), "etl")
+		require.NoError(t, err)
+
+		runs := m.Runs("etl")
+		require.Len(t, runs, 2)
+		require.ElementsMatch(t, []string{first.ID, second.ID}, []string{runs[0].ID, runs[1].ID})
+		require.Empty(t, m.Runs("other"))
+	})
+
+	t.Run("returns not found errors", func(t *testing.T) {
+		m := newManager(t, &submitterMock{})
+		_, err := m.Start(context.Background(), "other")
+		require.ErrorIs(t, err, NotFoundError)
+		_, err = m.Run("unknown")
+		require.ErrorIs(t, err, RunNotFoundError)
+		require.ErrorIs(t, m.DeletePipeline("other"), NotFoundError)
+	})
+
+	t.Run("drops the oldest finished runs", func(t *testing.T) {
+		submitter := &submitterMock{err: errors.New("queue is full")}
+		m := newManager(t, submitter)
+		for i := 0; i < keptRuns+5; i++ {
+			_, err := m.Start(context.Background(), "etl")
+			require.NoError(t, err)
+		}
+		require.Len(t, m.Runs("etl"), keptRuns)
+	})
+}
+
+func TestPipelineDir(t *testing.T) {
+	t.Run("loads and saves pipeline files", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "etl.yaml"), []byte(diamond), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# pipelines"), 0644))
+
+		m, err := New(dir)
+		require.NoError(t, err)
+		require.Len(t, m.Pipelines(), 1)
+
+		_, err = m.PutPipeline("report", []byte(`steps: [{name: report, preset: report}]`))
+		require.NoError(t, err)
+		reloaded, err := New(dir)
+		require.NoError(t, err)
+		p, err := reloaded.Pipeline("report")
+		require.NoError(t, err)
+		require.Equal(t, "report", p.Steps[0].Preset)
+
+		require.NoError(t, m.DeletePipeline("etl"))
+		require.NoFileExists(t, filepath.Join(dir, "etl.yaml"))
+	})
+
+	t.Run("fails on invalid pipeline files", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "etl.yaml"), []byte(`steps: [{name: a, preset: a, after: [a]}]`), 0644))
+		_, err := New(dir)
+		require.ErrorIs(t, err, InvalidPipelineError)
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/spark"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+var NotScheduledError error = errors.New("preset isn't scheduled")
+
+type Submitter interface {
+	Submit(ctx context.Context, preset string, opts spark.SubmitOptions) (string, error)
+}
+
+var scheduledCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "scheduled_runs_total",
+	Help: "The total number of scheduled preset submissions",
+}, []string{"preset", "status"})
+
+type entry struct {
+	id       cron.EntryID
+	schedule string
+}
+
+// Scheduler submits presets that define a cron schedule
+type Scheduler struct {
+	mu        sync.Mutex
+	cron      *cron.Cron
+	submitter Submitter
+	entries   map[string]entry
+	// paused presets keep their schedule but their runs are skipped
+	paused    map[string]bool
+	pausedAll bool
+}
+
+// Schedule is the state of a scheduled preset
+type Schedule struct {
+	Preset   string    `json:"preset"`
+	Schedule string    `json:"schedule"`
+	Paused   bool      `json:"paused"`
+	Next     time.Time `json:"next"`
+}
+
+func New(submitter Submitter) *Scheduler {
+	return &Scheduler{
+		cron:      cron.New(),
+		submitter: submitter,
+		entries:   make(map[string]entry),
+		paused:    make(map[string]bool),
+	}
+}
+
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop prevents new scheduled runs, the returned context is done once
+// running submissions have been handed over
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
+
+// Sync updates the scheduled presets to the given preset->schedule mapping.
+// Presets without a schedule are removed.
+func (s *Scheduler) Sync(schedules map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for preset, e := range s.entries {
+		if schedules[preset] != e.schedule {
+			s.cron.Remove(e.id)
+			delete(s.entries, preset)
+			if schedules[preset] == "" {
+				delete(s.paused, preset)
+			}
+			zap.L().Info("unscheduled preset", zap.String("presetName", preset))
+		}
+	}
+
+	for preset, schedule := range schedules {
+		if schedule == "" {
+			continue
+		}
+		if _, ok := s.entries[preset]; ok {
+			continue
+		}
+
+		id, err := s.cron.AddFunc(schedule, s.run(preset))
+		if err != nil {
+			zap.L().Error("couldn't schedule preset", zap.String("presetName", preset), zap.String("schedule", schedule), zap.Error(err))
+			continue
+		}
+		s.entries[preset] = entry{id: id, schedule: schedule}
+		zap.L().Info("scheduled preset", zap.String("presetName", preset), zap.String("schedule", schedule))
+	}
+}
+
+// Schedules returns the scheduled presets sorted by name, pausedAll
+// reports whether the whole scheduler is paused
+func (s *Scheduler) Schedules() (schedules []Schedule, pausedAll bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules = make([]Schedule, 0, len(s.entries))
+	for preset, e := range s.entries {
+		schedules = append(schedules, Schedule{
+			Preset:   preset,
+			Schedule: e.schedule,
+			Paused:   s.paused[preset],
+			Next:     s.cron.Entry(e.id).Next,
+		})
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Preset < schedules[j].Preset })
+	return schedules, s.pausedAll
+}
+
+// SetPaused pauses or resumes the runs of a scheduled preset, the pause
+// survives changes of the schedule but not a restart
+func (s *Scheduler) SetPaused(preset string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[preset]; !ok {
+		return NotScheduledError
+	}
+	if paused {
+		s.paused[preset] = true
+	} else {
+		delete(s.paused, preset)
+	}
+	zap.L().Info("changed scheduled preset", zap.String("presetName", preset), zap.Bool("paused", paused))
+	return nil
+}
+
+// SetPausedAll pauses or resumes the runs of all presets, independently of
+// the pauses of single presets
+func (s *Scheduler) SetPausedAll(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pausedAll = paused
+	zap.L().Info("changed scheduler", zap.Bool("paused", paused))
+}
+
+func (s *Scheduler) isPaused(preset string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pausedAll || s.paused[preset]
+}
+
+func (s *Scheduler) run(preset string) func() {
+	return func() {
+		if s.isPaused(preset) {
+			zap.L().Info("skipped paused scheduled preset", zap.String("presetName", preset))
+			scheduledCounter.WithLabelValues(preset, "paused").Inc()
+			return
+		}
+		id, err := s.submitter.Submit(context.Background(), preset, spark.SubmitOptions{})
+		if err != nil {
+			zap.L().Error("scheduled submission failed", zap.String("presetName", preset), zap.Error(err))
+			scheduledCounter.WithLabelValues(preset, "failure").Inc()
+			return
+		}
+		zap.L().Info("submitted scheduled preset", zap.String("presetName", preset), zap.String("jobID", id))
+		scheduledCounter.WithLabelValues(preset, "success").Inc()
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/spark"
+	"github.com/stretchr/testify/require"
+)
+
+type submitterMock struct {
+	submitted []string
+}
+
+func (sm *submitterMock) Submit(_ context.Context, preset string, opts spark.SubmitOptions) (string, error) {
+	sm.submitted = append(sm.submitted, preset)
+	return "id", nil
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("schedules presets with a schedule", func(t *testing.T) {
+		s := New(&submitterMock{})
+		s.Sync(map[string]string{"pi": "0 * * * *", "etl": ""})
+		require.Len(t, s.entries, 1)
+		require.Contains(t, s.entries, "pi")
+		require.Len(t, s.cron.Entries(), 1)
+	})
+
+	t.Run("removes and updates schedules", func(t *testing.T) {
+		s := New(&submitterMock{})
+		s.Sync(map[string]string{"pi": "0 * * * *", "etl": "0 1 * * *"})
+		s.Sync(map[string]string{"pi": "30 * * * *"})
+		require.Len(t, s.entries, 1)
+		require.Equal(t, "30 * * * *", s.entries["pi"].schedule)
+		require.Len(t, s.cron.Entries(), 1)
+	})
+
+	t.Run("skips invalid schedules", func(t *testing.T) {
+		s := New(&submitterMock{})
+		s.Sync(map[string]string{"pi": "every now and then"})
+		require.Empty(t, s.entries)
+	})
+
+	t.Run("scheduled runs submit the preset", func(t *testing.T) {
+		submitter := &submitterMock{}
+		s := New(submitter)
+		s.Sync(map[string]string{"pi": "0 * * * *"})
+		s.cron.Entry(s.entries["pi"].id).Job.Run()
+		require.Equal(t, []string{"pi"}, submitter.submitted)
+	})
+
+	t.Run("lists the schedules", func(t *testing.T) {
+		s := New(&submitterMock{})
+		s.Sync(map[string]string{"pi": "0 * * * *", "etl": "0 1 * * *"})
+		s.Start()
+		defer s.Stop()
+		require.NoError(t, s.SetPaused("etl", true))
+
+		schedules, pausedAll := s.Schedules()
+		require.False(t, pausedAll)
+		require.Len(t, schedules, 2)
+		require.Equal(t, "etl", schedules[0].Preset)
+		require.True(t, schedules[0].Paused)
+		require.Equal(t, "0 * * * *", schedules[1].Schedule)
+		require.False(t, schedules[1].Paused)
+		require.False(t, schedules[1].Next.IsZero())
+	})
+
+	t.Run("skips the runs of paused presets", func(t *testing.T) {
+		submitter := &submitterMock{}
+		s := New(submitter)
+		s.Sync(map[string]string{"pi": "0 * * * *", "etl": "0 1 * * *"})
+		require.NoError(t, s.SetPaused("pi", true))
+		s.cron.Entry(s.entries["pi"].id).Job.Run()
+		s.cron.Entry(s.entries["etl"].id).Job.Run()
+		require.Equal(t, []string{"etl"}, submitter.submitted)
+
+		require.NoError(t, s.SetPaused("pi", false))
+		s.cron.Entry(s.entries["pi"].id).Job.Run()
+		require.Equal(t, []string{"etl", "pi"}, submitter.submitted)
+	})
+
+	t.Run("skips all runs while paused", func(t *testing.T) {
+		submitter := &submitterMock{}
+		s := New(submitter)
+		s.Sync(map[string]string{"pi": "0 * * * *"})
+		s.SetPausedAll(true)
+		s.cron.Entry(s.entries["pi"].id).Job.Run()
+		require.Empty(t, submitter.submitted)
+
+		s.SetPausedAll(false)
+		s.cron.Entry(s.entries["pi"].id).Job.Run()
+		require.Equal(t, []string{"pi"}, submitter.submitted)
+	})
+
+	t.Run("keeps pauses of changed schedules", func(t *testing.T) {
+		s := New(&submitterMock{})
+		s.Sync(map[string]string{"pi": "0 * * * *", "etl": "0 1 * * *"})
+		require.NoError(t, s.SetPaused("pi", true))
+		require.NoError(t, s.SetPaused("etl", true))
+		s.Sync(map[string]string{"pi": "30 * * * *"})
+		require.True(t, s.isPaused("pi"))
+		require.False(t, s.isPaused("etl"))
+	})
+
+	t.Run("given an unscheduled preset, fails to pause", func(t *testing.T) {
+		s := New(&submitterMock{})
+		require.ErrorIs(t, s.SetPaused("pi", true), NotScheduledError)
+	})
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DynamicAllocation scales the executors of a preset with its workload, it
+// expands to the spark.dynamicAllocation.* configuration
+type DynamicAllocation struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Min and Max bound the number of executors, 0 leaves the defaults of spark
+	Min int `yaml:"min,omitempty" json:"min,omitempty"`
+	Max int `yaml:"max,omitempty" json:"max,omitempty"`
+	// ShuffleTracking keeps executors with shuffle data alive. Kubernetes has
+	// no external shuffle service, so it defaults to true there.
+	ShuffleTracking *bool `yaml:"shuffleTracking,omitempty" json:"shuffleTracking,omitempty"`
+}
+
+func (d DynamicAllocation) validate() error {
+	if d.Min < 0 || d.Max < 0 {
+		return fmt.Errorf("%w: dynamicAllocation min and max can't be negative", InvalidPresetError)
+	}
+	if d.Max > 0 && d.Min > d.Max {
+		return fmt.Errorf("%w: dynamicAllocation min %d is greater than max %d", InvalidPresetError, d.Min, d.Max)
+	}
+	if !d.Enabled && (d.Min > 0 || d.Max > 0 || d.ShuffleTracking != nil) {
+		return fmt.Errorf("%w: dynamicAllocation min, max and shuffleTracking need enabled: true", InvalidPresetError)
+	}
+	return nil
+}
+
+// dynamicAllocationConf translates the dynamic allocation fields to spark
+// configuration
+func dynamicAllocationConf(allocation *DynamicAllocation, kubernetes bool) map[string]string {
+	conf := map[string]string{}
+	if allocation == nil {
+		return conf
+	}
+	conf["spark.dynamicAllocation.enabled"] = strconv.FormatBool(allocation.Enabled)
+	if !allocation.Enabled {
+		return conf
+	}
+	if allocation.Min > 0 {
+		conf["spark.dynamicAllocation.minExecutors"] = strconv.Itoa(allocation.Min)
+	}
+	if allocation.Max > 0 {
+		conf["spark.dynamicAllocation.maxExecutors"] = strconv.Itoa(allocation.Max)
+	}
+	shuffleTracking := kubernetes
+	if allocation.ShuffleTracking != nil {
+		shuffleTracking = *allocation.ShuffleTracking
+	}
+	if shuffleTracking || allocation.ShuffleTracking != nil {
+		conf["spark.dynamicAllocation.shuffleTracking.enabled"] = strconv.FormatBool(shuffleTracking)
+	}
+	return conf
+}
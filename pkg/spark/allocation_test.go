@@ -0,0 +1,79 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicAllocationConf(t *testing.T) {
+	disabled := false
+
+	t.Run("expands the fields and enables shuffle tracking on kubernetes", func(t *testing.T) {
+		allocation := &DynamicAllocation{Enabled: true, Min: 1, Max: 10}
+		require.Equal(t, map[string]string{
+			"spark.dynamicAllocation.enabled":                 "true",
+			"spark.dynamicAllocation.minExecutors":            "1",
+			"spark.dynamicAllocation.maxExecutors":            "10",
+			"spark.dynamicAllocation.shuffleTracking.enabled": "true",
+		}, dynamicAllocationConf(allocation, true))
+		require.Equal(t, map[string]string{
+			"spark.dynamicAllocation.enabled":      "true",
+			"spark.dynamicAllocation.minExecutors": "1",
+			"spark.dynamicAllocation.maxExecutors": "10",
+		}, dynamicAllocationConf(allocation, false))
+	})
+
+	t.Run("sets shuffle tracking explicitly", func(t *testing.T) {
+		require.Equal(t, map[string]string{
+			"spark.dynamicAllocation.enabled":                 "true",
+			"spark.dynamicAllocation.shuffleTracking.enabled": "false",
+		}, dynamicAllocationConf(&DynamicAllocation{Enabled: true, ShuffleTracking: &disabled}, true))
+	})
+
+	t.Run("disables dynamic allocation", func(t *testing.T) {
+		require.Equal(t, map[string]string{"spark.dynamicAllocation.enabled": "false"}, dynamicAllocationConf(&DynamicAllocation{}, true))
+		require.Empty(t, dynamicAllocationConf(nil, true))
+	})
+
+	t.Run("overrides the sparkConf of the preset", func(t *testing.T) {
+		s := &Spark{
+			presets: map[string]configurationPreset{"etl": {
+				Main:              "etl.py",
+				SparkConf:         map[string]string{"spark.dynamicAllocation.maxExecutors": "100"},
+				DynamicAllocation: &DynamicAllocation{Enabled: true, Max: 20},
+			}},
+			master: "k8s://https://kube",
+		}
+		args, err := s.submitArgs("etl", SubmitOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "20", confArg(args, "spark.dynamicAllocation.maxExecutors"))
+	})
+}
+
+func TestDynamicAllocationValidate(t *testing.T) {
+	require.NoError(t, DynamicAllocation{Enabled: true, Min: 2, Max: 2}.validate())
+	require.NoError(t, DynamicAllocation{Enabled: true, Min: 2}.validate())
+	for raw, message := range map[string]string{
+		"{enabled: true, min: 5, max: 2}": "min 5 is greater than max 2",
+		"{enabled: true, min: -1}":        "can't be negative",
+		"{enabled: false, max: 10}":       "need enabled: true",
+	} {
+		_, err := parsePreset("etl", []byte("main: etl.py\ndynamicAllocation: "+raw))
+		require.ErrorIs(t, err, InvalidPresetError, raw)
+		require.ErrorContains(t, err, message, raw)
+	}
+}
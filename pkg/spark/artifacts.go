@@ -0,0 +1,235 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Staffbase/spark-submit/pkg/objstore"
+)
+
+var (
+	ArtifactNotFoundError error = errors.New("artifact not found")
+	InvalidArtifactError  error = errors.New("invalid artifact")
+	ArtifactChangedError  error = errors.New("artifact changed")
+)
+
+// artifactScheme prefixes the references to uploaded artifacts in presets
+// and submit requests, e.g. artifact://etl/etl-1.4.jar
+const artifactScheme = "artifact://"
+
+var artifactPattern = regexp.MustCompile(`artifact://([a-zA-Z0-9][a-zA-Z0-9._-]*(?:/[a-zA-Z0-9][a-zA-Z0-9._-]*)*)`)
+
+// checksumPrefix keeps the checksums of the artifacts next to them, artifact
+// names can't start with a dot
+const checksumPrefix = ".checksums/"
+
+// Artifact is an uploaded jar, Python file or archive
+type Artifact struct {
+	Name string `json:"name"`
+	// URL is the location the driver fetches the artifact from
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex digest of the uploaded content, empty for objects
+	// that weren't uploaded through the server
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// artifactChecksum is stored for every upload. The ETag detects objects
+// that were overwritten in the bucket since, without downloading them.
+type artifactChecksum struct {
+	SHA256 string `json:"sha256"`
+	ETag   string `json:"etag"`
+}
+
+// ArtifactStore keeps uploaded artifacts in an S3 or GCS bucket
+type ArtifactStore struct {
+	bucket objstore.WritableBucket
+	prefix string
+	// baseURL is the bucket URL in the scheme of the hadoop connectors,
+	// s3a:// for S3 and gs:// for GCS
+	baseURL string
+}
+
+// NewArtifactStore creates an artifact store for an s3:// or gs:// URL
+func NewArtifactStore(rawURL string) (*ArtifactStore, error) {
+	bucket, prefix, err := objstore.Open(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	u, _ := url.Parse(rawURL)
+	scheme := u.Scheme
+	if scheme == "s3" {
+		scheme = "s3a"
+	}
+	return &ArtifactStore{bucket: bucket, prefix: prefix, baseURL: scheme + "://" + u.Host + "/"}, nil
+}
+
+func (a *ArtifactStore) url(name string) string {
+	return a.baseURL + a.prefix + name
+}
+
+// verify checks that the artifact exists and wasn't overwritten since its
+// upload, and that it has the digest if one is given
+func (a *ArtifactStore) verify(ctx context.Context, name, digest string) error {
+	objects, err := a.bucket.List(ctx, a.prefix+name)
+	if err != nil {
+		return fmt.Errorf("couldn't look up artifact, %w", err)
+	}
+	var object *objstore.Object
+	for i := range objects {
+		if objects[i].Key == a.prefix+name {
+			object = &objects[i]
+		}
+	}
+	if object == nil {
+		return fmt.Errorf(`%w: "%s"`, ArtifactNotFoundError, name)
+	}
+
+	checksum, ok, err := a.checksum(ctx, name)
+	if err != nil {
+		return err
+	}
+	if ok && checksum.ETag != object.ETag {
+		return fmt.Errorf(`%w: "%s" was overwritten in the bucket since it was uploaded`, ArtifactChangedError, name)
+	}
+	if digest == "" {
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf(`%w: "%s" has no checksum, it wasn't uploaded through the server`, ArtifactChangedError, name)
+	}
+	if !strings.EqualFold(strings.TrimPrefix(digest, "sha256:"), checksum.SHA256) {
+		return fmt.Errorf(`%w: "%s" has the digest sha256:%s, not %s`, ArtifactChangedError, name, checksum.SHA256, digest)
+	}
+	return nil
+}
+
+// validArtifactName checks every slash separated segment of an artifact name
+func validArtifactName(name string) bool {
+	return artifactPattern.FindString(artifactScheme+name) == artifactScheme+name
+}
+
+// UploadArtifact stores an artifact, an existing one of the same name is replaced
+func (s *Spark) UploadArtifact(ctx context.Context, name string, content []byte) (Artifact, error) {
+	if s.artifacts == nil {
+		return Artifact{}, fmt.Errorf("%w: no artifact store configured", InvalidArtifactError)
+	}
+	if !validArtifactName(name) {
+		return Artifact{}, fmt.Errorf(`%w: name "%s" may only contain letters, digits, ".", "_", "-" and "/" between them`, InvalidArtifactError, name)
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	object, err := s.artifacts.bucket.Put(ctx, s.artifacts.prefix+name, content)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("couldn't upload artifact, %w", err)
+	}
+	checksum, _ := json.Marshal(artifactChecksum{SHA256: digest, ETag: object.ETag})
+	if _, err := s.artifacts.bucket.Put(ctx, s.artifacts.prefix+checksumPrefix+name, checksum); err != nil {
+		return Artifact{}, fmt.Errorf("couldn't store artifact checksum, %w", err)
+	}
+	return Artifact{Name: name, URL: s.artifacts.url(name), Size: object.Size, SHA256: digest}, nil
+}
+
+// checksum returns the checksum stored with the upload of an artifact
+func (a *ArtifactStore) checksum(ctx context.Context, name string) (artifactChecksum, bool, error) {
+	var checksum artifactChecksum
+	raw, err := a.bucket.Get(ctx, a.prefix+checksumPrefix+name)
+	if errors.Is(err, objstore.NotFoundError) {
+		return checksum, false, nil
+	}
+	if err != nil {
+		return checksum, false, fmt.Errorf("couldn't read artifact checksum, %w", err)
+	}
+	if err := json.Unmarshal(raw, &checksum); err != nil {
+		return checksum, false, fmt.Errorf("couldn't decode artifact checksum, %w", err)
+	}
+	return checksum, true, nil
+}
+
+// Artifacts lists the uploaded artifacts
+func (s *Spark) Artifacts(ctx context.Context) ([]Artifact, error) {
+	if s.artifacts == nil {
+		return nil, fmt.Errorf("%w: no artifact store configured", InvalidArtifactError)
+	}
+	objects, err := s.artifacts.bucket.List(ctx, s.artifacts.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list artifacts, %w", err)
+	}
+	artifacts := make([]Artifact, 0, len(objects))
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, s.artifacts.prefix)
+		if strings.HasPrefix(name, checksumPrefix) {
+			continue
+		}
+		artifact := Artifact{Name: name, URL: s.artifacts.url(name), Size: object.Size}
+		checksum, ok, err := s.artifacts.checksum(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok && checksum.ETag == object.ETag {
+			artifact.SHA256 = checksum.SHA256
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// resolveArtifacts replaces the artifact:// references of the submit args
+// with the URLs of the uploaded artifacts. They must exist and be unchanged
+// since their upload, digests pin the SHA-256 of artifacts by name.
+func (s *Spark) resolveArtifacts(ctx context.Context, args []string, digests map[string]string) ([]string, error) {
+	names := map[string]bool{}
+	for _, arg := range args {
+		for _, match := range artifactPattern.FindAllStringSubmatch(arg, -1) {
+			names[match[1]] = true
+		}
+	}
+	for name := range digests {
+		if !names[name] {
+			return nil, fmt.Errorf(`%w: the digest of "%s" is pinned, but the submission doesn't reference it`, InvalidArtifactError, name)
+		}
+	}
+	if len(names) == 0 {
+		return args, nil
+	}
+	if s.artifacts == nil {
+		return nil, fmt.Errorf("%w: no artifact store configured", InvalidArtifactError)
+	}
+
+	for name := range names {
+		if err := s.artifacts.verify(ctx, name, digests[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	resolved := make([]string, len(args))
+	for i, arg := range args {
+		resolved[i] = artifactPattern.ReplaceAllStringFunc(arg, func(reference string) string {
+			return s.artifacts.url(strings.TrimPrefix(reference, artifactScheme))
+		})
+	}
+	return resolved, nil
+}
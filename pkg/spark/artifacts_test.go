@@ -0,0 +1,137 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/objstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifacts(t *testing.T) {
+	newSpark := func(t *testing.T) (*Spark, *bucketMock) {
+		bucket := &bucketMock{objects: map[string]objstore.Object{}, content: map[string]string{}}
+		return newTestSpark(t, Config{
+			Master:    "k8s://https://kube",
+			Artifacts: &ArtifactStore{bucket: bucket, prefix: "spark/", baseURL: "s3a://artifacts/"},
+		}, nil, "exit 0", map[string]configurationPreset{"etl": {
+			Main:     "artifact://etl/etl-1.4.jar",
+			Jars:     []string{"s3a://libs/a.jar", "artifact://libs/b.jar"},
+			Archives: []string{"artifact://envs/etl.tar.gz#environment"},
+		}}), bucket
+	}
+
+	t.Run("uploads and lists artifacts", func(t *testing.T) {
+		s, bucket := newSpark(t)
+		artifact, err := s.UploadArtifact(context.Background(), "etl/etl-1.4.jar", []byte("jar"))
+		require.NoError(t, err)
+		require.Equal(t, Artifact{
+			Name:   "etl/etl-1.4.jar",
+			URL:    "s3a://artifacts/spark/etl/etl-1.4.jar",
+			Size:   3,
+			SHA256: "0163f1eea7894350060624d315234d40c508ab251ba121714e234503045faadd",
+		}, artifact)
+		require.Equal(t, "jar", bucket.content["spark/etl/etl-1.4.jar"])
+
+		artifacts, err := s.Artifacts(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []Artifact{artifact}, artifacts)
+	})
+
+	t.Run("given an invalid name, returns InvalidArtifactError", func(t *testing.T) {
+		s, _ := newSpark(t)
+		for _, name := range []string{"", "../etl.jar", "etl/", "etl//a.jar", "etl.jar?x"} {
+			_, err := s.UploadArtifact(context.Background(), name, []byte("jar"))
+			require.ErrorIs(t, err, InvalidArtifactError, name)
+		}
+	})
+
+	t.Run("rewrites the references of submissions", func(t *testing.T) {
+		s, _ := newSpark(t)
+		for _, name := range []string{"etl/etl-1.4.jar", "libs/b.jar", "envs/etl.tar.gz"} {
+			_, err := s.UploadArtifact(context.Background(), name, []byte("content"))
+			require.NoError(t, err)
+		}
+		args, err := s.submitArgs("etl", SubmitOptions{SparkConf: map[string]string{"spark.files": "artifact://libs/b.jar"}})
+		require.NoError(t, err)
+		resolved, err := s.resolveArtifacts(context.Background(), args, nil)
+		require.NoError(t, err)
+		require.Equal(t, "--jars=s3a://libs/a.jar,s3a://artifacts/spark/libs/b.jar", resolved[3])
+		require.Equal(t, "--archives=s3a://artifacts/spark/envs/etl.tar.gz#environment", resolved[4])
+		require.Contains(t, resolved, "--conf=spark.files=s3a://artifacts/spark/libs/b.jar")
+		require.Equal(t, "s3a://artifacts/spark/etl/etl-1.4.jar", resolved[len(resolved)-1])
+
+		_, err = s.Submit(context.Background(), "etl", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("given a missing artifact or no store, rejects the submission", func(t *testing.T) {
+		s, _ := newSpark(t)
+		_, err := s.Submit(context.Background(), "etl", SubmitOptions{})
+		require.ErrorIs(t, err, ArtifactNotFoundError)
+
+		s.artifacts = nil
+		_, err = s.Submit(context.Background(), "etl", SubmitOptions{})
+		require.ErrorIs(t, err, InvalidArtifactError)
+	})
+
+	t.Run("verifies pinned digests and rejects overwritten artifacts", func(t *testing.T) {
+		s, bucket := newSpark(t)
+		for _, name := range []string{"etl/etl-1.4.jar", "libs/b.jar", "envs/etl.tar.gz"} {
+			_, err := s.UploadArtifact(context.Background(), name, []byte("content"))
+			require.NoError(t, err)
+		}
+		args, err := s.submitArgs("etl", SubmitOptions{})
+		require.NoError(t, err)
+		digest := "sha256:ED7002B439E9AC845F22357D822BAC1444730FBDB6016D3EC9432297B9EC9F73"
+		_, err = s.resolveArtifacts(context.Background(), args, map[string]string{"libs/b.jar": digest})
+		require.NoError(t, err)
+
+		_, err = s.resolveArtifacts(context.Background(), args, map[string]string{"libs/b.jar": "sha256:0000"})
+		require.ErrorIs(t, err, ArtifactChangedError)
+		require.ErrorContains(t, err, `"libs/b.jar" has the digest sha256:ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73, not sha256:0000`)
+
+		_, err = s.resolveArtifacts(context.Background(), args, map[string]string{"libs/c.jar": digest})
+		require.ErrorIs(t, err, InvalidArtifactError)
+
+		// overwritten without the API, the ETag changes
+		bucket.objects["spark/libs/b.jar"] = objstore.Object{Key: "spark/libs/b.jar", ETag: "other"}
+		_, err = s.resolveArtifacts(context.Background(), args, nil)
+		require.ErrorIs(t, err, ArtifactChangedError)
+		require.ErrorContains(t, err, "was overwritten in the bucket")
+
+		// uploaded without the API, there is no checksum to pin
+		delete(bucket.content, "spark/.checksums/libs/b.jar")
+		_, err = s.resolveArtifacts(context.Background(), args, nil)
+		require.NoError(t, err)
+		_, err = s.resolveArtifacts(context.Background(), args, map[string]string{"libs/b.jar": digest})
+		require.ErrorContains(t, err, "has no checksum")
+	})
+}
+
+func TestNewArtifactStore(t *testing.T) {
+	store, err := NewArtifactStore("s3://artifacts/spark")
+	require.NoError(t, err)
+	require.Equal(t, "s3a://artifacts/spark/etl.jar", store.url("etl.jar"))
+
+	store, err = NewArtifactStore("gs://artifacts")
+	require.NoError(t, err)
+	require.Equal(t, "gs://artifacts/etl.jar", store.url("etl.jar"))
+
+	_, err = NewArtifactStore("https://artifacts")
+	require.Error(t, err)
+}
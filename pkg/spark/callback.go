@@ -0,0 +1,65 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"go.uber.org/zap"
+)
+
+var InvalidCallbackURLError error = errors.New("invalid callback url")
+
+// CallbackSender posts the result of a job to the callback URL of its
+// submission, it is implemented by events.WebhookPublisher
+type CallbackSender interface {
+	Deliver(ctx context.Context, url string, eventType events.Type, payload any) error
+}
+
+// callbackTimeout limits the delivery of a callback including its retries
+const callbackTimeout = time.Minute
+
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf(`%w: "%s" isn't an absolute http or https URL`, InvalidCallbackURLError, raw)
+	}
+	return nil
+}
+
+// sendCallback posts the finished job to its callback URL in the
+// background, failed deliveries are logged
+func (s *Spark) sendCallback(id string) {
+	if s.callbacks == nil {
+		return
+	}
+	job, err := s.jobs.Get(id)
+	if err != nil || job.CallbackURL == "" {
+		return
+	}
+	job.Events = nil
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+		defer cancel()
+		if err := s.callbacks.Deliver(ctx, job.CallbackURL, stateEvents[job.State], job); err != nil {
+			zap.L().Warn("couldn't deliver callback", zap.String("jobID", id), zap.Error(err))
+		}
+	}()
+}
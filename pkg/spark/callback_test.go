@@ -0,0 +1,81 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+type callbackRecorder chan callbackDelivery
+
+type callbackDelivery struct {
+	url       string
+	eventType events.Type
+	job       jobs.Job
+}
+
+func (r callbackRecorder) Deliver(ctx context.Context, url string, eventType events.Type, payload any) error {
+	r <- callbackDelivery{url: url, eventType: eventType, job: payload.(jobs.Job)}
+	return nil
+}
+
+func TestCallbacks(t *testing.T) {
+	newSpark := func(t *testing.T, script string, recorder callbackRecorder) *Spark {
+		return newTestSpark(t, Config{Backoff: backoff.Config{Retries: 1}, Callbacks: recorder}, nil, script,
+			map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+
+	t.Run("posts the finished job to the callback URL", func(t *testing.T) {
+		recorder := make(callbackRecorder, 1)
+		s := newSpark(t, "exit 1", recorder)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{CallbackURL: "https://example.com/hook"})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		select {
+		case delivery := <-recorder:
+			require.Equal(t, "https://example.com/hook", delivery.url)
+			require.Equal(t, events.Failed, delivery.eventType)
+			require.Equal(t, id, delivery.job.ID)
+			require.Equal(t, jobs.StateFailed, delivery.job.State)
+			require.Nil(t, delivery.job.Events)
+		case <-time.After(5 * time.Second):
+			t.Fatal("callback wasn't delivered")
+		}
+	})
+
+	t.Run("given no callback URL, posts nothing", func(t *testing.T) {
+		recorder := make(callbackRecorder, 1)
+		s := newSpark(t, "exit 0", recorder)
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.Empty(t, recorder)
+	})
+
+	t.Run("given an invalid callback URL, returns InvalidCallbackURLError", func(t *testing.T) {
+		s := newSpark(t, "exit 0", make(callbackRecorder, 1))
+		for _, callbackURL := range []string{"example.com/hook", "ftp://example.com/hook", "https://"} {
+			_, err := s.Submit(context.Background(), "pi", SubmitOptions{CallbackURL: callbackURL})
+			require.ErrorIs(t, err, InvalidCallbackURLError, callbackURL)
+		}
+	})
+}
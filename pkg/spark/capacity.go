@@ -0,0 +1,163 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var CapacityExceededError error = errors.New("not enough cluster capacity")
+
+// CapacityMode decides what happens to kubernetes submissions that don't fit
+// on the nodes of the cluster right now
+type CapacityMode string
+
+const (
+	CapacityCheckOff    CapacityMode = ""
+	CapacityCheckReject CapacityMode = "reject"
+	CapacityCheckQueue  CapacityMode = "queue"
+)
+
+// capacityPollInterval is how often queued submissions check the capacity again
+var capacityPollInterval = 30 * time.Second
+
+// capacityTimeout limits a single capacity check
+const capacityTimeout = 10 * time.Second
+
+var capacityWaitingGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "spark_capacity_waiting",
+	Help: "The number of submissions waiting for enough free cluster capacity",
+}, []string{"tenant", "preset"})
+
+var capacityRejectedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_capacity_rejected_total",
+	Help: "The total number of submissions rejected because they didn't fit on the cluster",
+}, []string{"tenant", "preset"})
+
+// NodeCapacity are the resources a node has left for new pods
+type NodeCapacity struct {
+	Node     string
+	Cores    float64
+	MemoryGB float64
+}
+
+// CapacityChecker reports the free resources of the nodes of the cluster, it
+// is implemented by the kubernetes backend
+type CapacityChecker interface {
+	FreeCapacity(ctx context.Context) ([]NodeCapacity, error)
+}
+
+// SetCapacityChecker sets where the capacity check of the capacity mode gets
+// the free resources from, there's no check without it
+func (s *Spark) SetCapacityChecker(c CapacityChecker) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	s.capacity = c
+}
+
+func (s *Spark) capacityChecker() CapacityChecker {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	return s.capacity
+}
+
+func (r resources) String() string {
+	return fmt.Sprintf("%g cores, %.1fGB", r.Cores, r.MemoryGB)
+}
+
+// fits places the pods of a submission from the largest to the smallest on
+// the node with the least free memory that has room for them. It's an
+// estimate, the scheduler may still place the pods differently.
+func fits(nodes []NodeCapacity, pods podResources) bool {
+	free := append([]NodeCapacity(nil), nodes...)
+	all := []resources{pods.Driver}
+	for i := 0; i < pods.Executors; i++ {
+		all = append(all, pods.Executor)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].MemoryGB != all[j].MemoryGB {
+			return all[i].MemoryGB > all[j].MemoryGB
+		}
+		return all[i].Cores > all[j].Cores
+	})
+	for _, pod := range all {
+		best := -1
+		for i, node := range free {
+			if node.Cores >= pod.Cores && node.MemoryGB >= pod.MemoryGB && (best < 0 || node.MemoryGB < free[best].MemoryGB) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return false
+		}
+		free[best].Cores -= pod.Cores
+		free[best].MemoryGB -= pod.MemoryGB
+	}
+	return true
+}
+
+// checkCapacity returns CapacityExceededError if the pods of a kubernetes
+// submission don't fit on the cluster. Submissions go ahead if the capacity
+// can't be determined.
+func (s *Spark) checkCapacity(ctx context.Context, args []string) error {
+	checker := s.capacityChecker()
+	if checker == nil || submitNamespace(args) == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, capacityTimeout)
+	defer cancel()
+	nodes, err := checker.FreeCapacity(ctx)
+	if err != nil {
+		zap.L().Warn("couldn't check the cluster capacity, submitting anyway", zap.Error(err))
+		return nil
+	}
+	pods := requestedPodResources(args)
+	if !fits(nodes, pods) {
+		return fmt.Errorf("%w, the driver (%s) and %d executors (%s each) don't fit on the nodes", CapacityExceededError, pods.Driver, pods.Executors, pods.Executor)
+	}
+	return nil
+}
+
+// waitForCapacity checks the capacity until the submission fits or ctx is done
+func (s *Spark) waitForCapacity(ctx context.Context, jobID, presetName string, args []string) error {
+	for waiting := false; ; waiting = true {
+		err := s.checkCapacity(ctx, args)
+		if err == nil {
+			return nil
+		}
+		if !waiting {
+			zap.L().Info("waiting for cluster capacity", zap.String("jobID", jobID), zap.Error(err))
+			s.updateJob(jobID, func(j *jobs.Job) {
+				j.Record(jobs.Event{Type: jobs.EventWaiting, Message: "waiting for cluster capacity", Error: err.Error()})
+			})
+			gauge := capacityWaitingGauge.WithLabelValues(s.tenant(presetName), presetName)
+			gauge.Inc()
+			defer gauge.Dec()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(capacityPollInterval):
+		}
+	}
+}
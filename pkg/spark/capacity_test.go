@@ -0,0 +1,118 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+type capacityMock struct {
+	mu    sync.Mutex
+	nodes []NodeCapacity
+	err   error
+}
+
+func (m *capacityMock) FreeCapacity(context.Context) ([]NodeCapacity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]NodeCapacity(nil), m.nodes...), m.err
+}
+
+func (m *capacityMock) set(nodes ...NodeCapacity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes = nodes
+}
+
+func TestFits(t *testing.T) {
+	pods := podResources{
+		Driver:    resources{Cores: 1, MemoryGB: 2},
+		Executor:  resources{Cores: 2, MemoryGB: 4},
+		Executors: 3,
+	}
+
+	t.Run("spreads the pods over the nodes", func(t *testing.T) {
+		require.True(t, fits([]NodeCapacity{{Cores: 4, MemoryGB: 8}, {Cores: 3, MemoryGB: 6}}, pods))
+	})
+
+	t.Run("given too little room on every node, doesn't fit", func(t *testing.T) {
+		require.False(t, fits([]NodeCapacity{{Cores: 3, MemoryGB: 7}, {Cores: 3, MemoryGB: 7}}, pods))
+		require.False(t, fits(nil, pods))
+	})
+}
+
+func TestCapacityCheck(t *testing.T) {
+	newSpark := func(mode CapacityMode, checker *capacityMock) *Spark {
+		s := newTestSpark(t, Config{Master: "k8s://https://kube", CapacityCheck: mode}, nil, "exit 0",
+			map[string]configurationPreset{"pi": {Main: "pi.py", SparkConf: map[string]string{"spark.executor.instances": "1"}}})
+		s.SetCapacityChecker(checker)
+		return s
+	}
+	large := NodeCapacity{Node: "large", Cores: 8, MemoryGB: 32}
+	small := NodeCapacity{Node: "small", Cores: 1, MemoryGB: 1}
+
+	t.Run("given the reject mode, rejects submissions that don't fit", func(t *testing.T) {
+		s := newSpark(CapacityCheckReject, &capacityMock{nodes: []NodeCapacity{small}})
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.ErrorIs(t, err, CapacityExceededError)
+		require.EqualError(t, err, "not enough cluster capacity, the driver (1 cores, 1.4GB) and 1 executors (1 cores, 1.4GB each) don't fit on the nodes")
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("given the capacity can't be determined, submits anyway", func(t *testing.T) {
+		s := newSpark(CapacityCheckReject, &capacityMock{err: errors.New("forbidden")})
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("given the queue mode, waits until the submission fits", func(t *testing.T) {
+		defer func(interval time.Duration) { capacityPollInterval = interval }(capacityPollInterval)
+		capacityPollInterval = 10 * time.Millisecond
+		checker := &capacityMock{nodes: []NodeCapacity{small}}
+		s := newSpark(CapacityCheckQueue, checker)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		state := func() jobs.State {
+			job, err := s.Job(id)
+			require.NoError(t, err)
+			return job.State
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, jobs.StatePending, state())
+		checker.set(large)
+		require.Eventually(t, func() bool { return state() == jobs.StateSucceeded }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("given the queue mode, cancels waiting submissions", func(t *testing.T) {
+		s := newSpark(CapacityCheckQueue, &capacityMock{nodes: []NodeCapacity{small}})
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Cancel(id))
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateCancelled, job.State)
+	})
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var costCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_exec_estimated_cost_total",
+	Help: "The estimated cost of finished jobs from their requested resources and runtime",
+}, []string{"tenant", "preset"})
+
+// Prices of the resources jobs request, jobs get no cost estimate if both
+// are 0
+type Prices struct {
+	CoreHour float64
+	GBHour   float64
+}
+
+func (p Prices) enabled() bool {
+	return p.CoreHour > 0 || p.GBHour > 0
+}
+
+// the defaults of spark for resources the submission doesn't set
+const (
+	defaultCores             = 1
+	defaultMemoryMiB         = 1024
+	defaultExecutorInstances = 2
+	minMemoryOverheadMiB     = 384
+	memoryOverheadFactor     = 0.1
+)
+
+// resources are the cores and memory of a pod or of all pods of a submission
+type resources struct {
+	Cores    float64
+	MemoryGB float64
+}
+
+// podResources are the requests of the driver pod and of each executor pod
+// of a submission
+type podResources struct {
+	Driver    resources
+	Executor  resources
+	Executors int
+}
+
+func (p podResources) total() resources {
+	return resources{
+		Cores:    p.Driver.Cores + float64(p.Executors)*p.Executor.Cores,
+		MemoryGB: p.Driver.MemoryGB + float64(p.Executors)*p.Executor.MemoryGB,
+	}
+}
+
+// requestedResources sums up the resources of the driver and the static
+// number of executors
+func requestedResources(args []string) resources {
+	return requestedPodResources(args).total()
+}
+
+// requestedPodResources reads the pod requests from the sparkConf of the
+// submit args. Memory includes the overhead spark adds to the containers,
+// the request cores of kubernetes take precedence over the spark cores.
+func requestedPodResources(args []string) podResources {
+	conf := make(map[string]string)
+	for _, arg := range args {
+		if keyValue, ok := strings.CutPrefix(arg, "--conf="); ok {
+			key, value, _ := strings.Cut(keyValue, "=")
+			conf[key] = value
+		}
+	}
+	number := func(key string, fallback float64) float64 {
+		value := conf[key]
+		factor := 1.0
+		if millis, ok := strings.CutSuffix(value, "m"); ok {
+			value, factor = millis, 0.001
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil && number >= 0 {
+			return number * factor
+		}
+		return fallback
+	}
+	cores := func(role string) float64 {
+		return number("spark.kubernetes."+role+".request.cores", number("spark."+role+".cores", defaultCores))
+	}
+	memory := func(role string) float64 {
+		heap, ok := parseMemoryMiB(conf["spark."+role+".memory"])
+		if !ok {
+			heap = defaultMemoryMiB
+		}
+		overhead, ok := parseMemoryMiB(conf["spark."+role+".memoryOverhead"])
+		if !ok {
+			overhead = math.Max(minMemoryOverheadMiB, heap*memoryOverheadFactor)
+		}
+		return heap + overhead
+	}
+
+	return podResources{
+		Driver:    resources{Cores: cores("driver"), MemoryGB: memory("driver") / 1024},
+		Executor:  resources{Cores: cores("executor"), MemoryGB: memory("executor") / 1024},
+		Executors: int(number("spark.executor.instances", defaultExecutorInstances)),
+	}
+}
+
+// parseMemoryMiB parses the memory notation of spark like 512m or 4g, plain
+// numbers are MiB
+func parseMemoryMiB(value string) (float64, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	factor := 1.0
+	for _, unit := range []struct {
+		suffix string
+		factor float64
+	}{
+		{"kb", 1.0 / 1024}, {"k", 1.0 / 1024},
+		{"mb", 1}, {"m", 1},
+		{"gb", 1024}, {"g", 1024},
+		{"tb", 1024 * 1024}, {"t", 1024 * 1024},
+	} {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value, factor = number, unit.factor
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, false
+	}
+	return number * factor, true
+}
+
+// attemptsRuntime sums up the attempts of a job, the backoff between them is free
+func attemptsRuntime(attempts []jobs.Attempt) time.Duration {
+	var total time.Duration
+	for _, attempt := range attempts {
+		if attempt.FinishedAt != nil {
+			total += attempt.FinishedAt.Sub(attempt.StartedAt)
+		}
+	}
+	return total
+}
+
+// estimateCost prices the resources for the runtime
+func (p Prices) estimateCost(r resources, runtime time.Duration) float64 {
+	return (r.Cores*p.CoreHour + r.MemoryGB*p.GBHour) * runtime.Hours()
+}
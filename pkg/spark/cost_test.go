@@ -0,0 +1,71 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestedResources(t *testing.T) {
+	t.Run("given no resources, uses the defaults of spark", func(t *testing.T) {
+		r := requestedResources([]string{"--master=k8s://https://kube", "pi.py"})
+		require.Equal(t, float64(3), r.Cores)
+		require.InDelta(t, 3*(1024+384)/1024.0, r.MemoryGB, 1e-9)
+	})
+
+	t.Run("sums up the driver and the executors", func(t *testing.T) {
+		r := requestedResources([]string{
+			"--conf=spark.driver.cores=2",
+			"--conf=spark.driver.memory=4g",
+			"--conf=spark.executor.instances=4",
+			"--conf=spark.executor.cores=4",
+			"--conf=spark.executor.memory=8192m",
+			"--conf=spark.executor.memoryOverhead=1g",
+			"pi.py",
+		})
+		require.Equal(t, float64(18), r.Cores)
+		require.InDelta(t, 4.4+4*9, r.MemoryGB, 1e-9)
+	})
+}
+
+func TestParseMemoryMiB(t *testing.T) {
+	for value, want := range map[string]float64{"512m": 512, "2g": 2048, "2GB": 2048, "1t": 1024 * 1024, "1024k": 1, "300": 300} {
+		got, ok := parseMemoryMiB(value)
+		require.True(t, ok, value)
+		require.Equal(t, want, got, value)
+	}
+	for _, value := range []string{"", "lots", "-1g"} {
+		_, ok := parseMemoryMiB(value)
+		require.False(t, ok, value)
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	t.Run("prices the resources for the runtime of the attempts", func(t *testing.T) {
+		start := time.Now()
+		first, second := start.Add(30*time.Minute), start.Add(2*time.Hour)
+		runtime := attemptsRuntime([]jobs.Attempt{
+			{StartedAt: start, FinishedAt: &first},
+			{StartedAt: start.Add(time.Hour), FinishedAt: &second},
+		})
+		require.Equal(t, 90*time.Minute, runtime)
+
+		prices := Prices{CoreHour: 0.05, GBHour: 0.01}
+		require.InDelta(t, (4*0.05+16*0.01)*1.5, prices.estimateCost(resources{Cores: 4, MemoryGB: 16}, runtime), 1e-9)
+	})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultsFileName is the file of the preset directory that is used as the
+// defaults file if none is configured, it isn't loaded as a preset
+const defaultsFileName = "_defaults.yaml"
+
+// presetDefaults apply to every preset, e.g. the image pull secrets, service
+// account or event log directory of the cluster
+type presetDefaults struct {
+	// SparkConf is merged under the sparkConf of every preset, keys of the
+	// preset win
+	SparkConf map[string]string `yaml:"sparkConf"`
+}
+
+// loadDefaults reads the defaults file, or the defaults file of the preset
+// directory if path is empty. Only a missing file of the preset directory
+// is fine.
+func (s *Spark) loadDefaults(path string) error {
+	required := path != ""
+	if !required {
+		path = filepath.Join(s.confDir, defaultsFileName)
+	}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(`error reading defaults file ("%s"), %w`, path, err)
+	}
+
+	var defaults presetDefaults
+	if err := yaml.UnmarshalStrict(raw, &defaults); err != nil {
+		return fmt.Errorf(`invalid defaults file ("%s"), %w`, path, err)
+	}
+	for _, warning := range s.lintSparkConf(defaults.SparkConf) {
+		zap.L().Warn(warning, zap.String("defaultsFile", path))
+	}
+	s.defaults = defaults
+	zap.L().Info("loaded preset defaults", zap.String("defaultsFile", path), zap.Int("sparkConfCount", len(defaults.SparkConf)))
+	return nil
+}
+
+// withDefaults returns the preset with the defaults merged under it
+func (s *Spark) withDefaults(preset configurationPreset) configurationPreset {
+	if len(s.defaults.SparkConf) == 0 {
+		return preset
+	}
+	conf := make(map[string]string, len(s.defaults.SparkConf)+len(preset.SparkConf))
+	for key, value := range s.defaults.SparkConf {
+		conf[key] = value
+	}
+	for key, value := range preset.SparkConf {
+		conf[key] = value
+	}
+	preset.SparkConf = conf
+	return preset
+}
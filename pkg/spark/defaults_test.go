@@ -0,0 +1,72 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresetDefaults(t *testing.T) {
+	newSpark := func(t *testing.T, defaultsFile string, files map[string]string) (*Spark, error) {
+		dir := t.TempDir()
+		for name, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		}
+		return New(Config{SparkHome: dir, PresetDir: dir, DefaultsFile: defaultsFile, Master: "k8s://cluster"}, jobs.NewMemoryStore())
+	}
+
+	t.Run("merges the _defaults.yaml of the preset directory under every preset", func(t *testing.T) {
+		s, err := newSpark(t, "", map[string]string{
+			"_defaults.yaml": "sparkConf:\n  spark.kubernetes.authenticate.driver.serviceAccountName: spark\n  spark.eventLog.dir: s3a://logs/\n",
+			"pi.yaml":        "main: pi.py\nsparkConf:\n  spark.eventLog.dir: s3a://pi-logs/\n",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"pi"}, s.PresetNames())
+
+		args, err := s.submitArgs("pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.Contains(t, args, "--conf=spark.kubernetes.authenticate.driver.serviceAccountName=spark")
+		require.Contains(t, args, "--conf=spark.eventLog.dir=s3a://pi-logs/")
+		require.NotContains(t, args, "--conf=spark.eventLog.dir=s3a://logs/")
+
+		detail, err := s.PresetDetail("pi", nil)
+		require.NoError(t, err)
+		require.Equal(t, "spark", detail.SparkConf["spark.kubernetes.authenticate.driver.serviceAccountName"])
+	})
+
+	t.Run("reads the defaults file", func(t *testing.T) {
+		defaultsFile := filepath.Join(t.TempDir(), "defaults.yaml")
+		require.NoError(t, os.WriteFile(defaultsFile, []byte("sparkConf:\n  spark.kubernetes.container.image.pullSecrets: registry\n"), 0644))
+		s, err := newSpark(t, defaultsFile, map[string]string{"pi.yaml": "main: pi.py\n"})
+		require.NoError(t, err)
+
+		args, err := s.submitArgs("pi", SubmitOptions{SparkConf: map[string]string{"spark.executor.instances": "2"}})
+		require.NoError(t, err)
+		require.Contains(t, args, "--conf=spark.kubernetes.container.image.pullSecrets=registry")
+		require.Contains(t, args, "--conf=spark.executor.instances=2")
+	})
+
+	t.Run("fails on a missing or invalid defaults file", func(t *testing.T) {
+		_, err := newSpark(t, filepath.Join(t.TempDir(), "missing.yaml"), map[string]string{"pi.yaml": "main: pi.py\n"})
+		require.ErrorContains(t, err, "error reading defaults file")
+
+		_, err = newSpark(t, "", map[string]string{"_defaults.yaml": "sparkconf: {}\n", "pi.yaml": "main: pi.py\n"})
+		require.ErrorContains(t, err, "invalid defaults file")
+	})
+}
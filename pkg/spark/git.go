@@ -0,0 +1,165 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GitSource loads presets from a directory of a git repository, the
+// repository is cloned on the first refresh and pulled on every following
+// one. It needs the git binary.
+type GitSource struct {
+	repo   string
+	branch string
+	path   string
+	dir    string
+
+	mu     sync.Mutex
+	commit string
+}
+
+// NewGitSource creates a preset source for the path within the repository,
+// an empty branch means the default branch. The repository is cloned into
+// dir, a temporary directory is used if it is empty.
+func NewGitSource(repo, branch, path, dir string) (*GitSource, error) {
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "presets-git-"); err != nil {
+			return nil, fmt.Errorf("couldn't create clone directory, %w", err)
+		}
+	}
+	return &GitSource{repo: repo, branch: branch, path: path, dir: dir}, nil
+}
+
+// Name returns the repository without credentials
+func (g *GitSource) Name() string {
+	if u, err := url.Parse(g.repo); err == nil && u.User != nil {
+		u.User = nil
+		return u.String()
+	}
+	return g.repo
+}
+
+// redact removes the credentials of the repository URL from git output, git
+// may echo the URL in its messages
+func (g *GitSource) redact(output string) string {
+	u, err := url.Parse(g.repo)
+	if err != nil || u.User == nil {
+		return output
+	}
+	output = strings.ReplaceAll(output, g.repo, g.Name())
+	output = strings.ReplaceAll(output, u.User.String()+"@", "")
+	if password, ok := u.User.Password(); ok && password != "" {
+		output = strings.ReplaceAll(output, password, "xxxxx")
+	}
+	return output
+}
+
+// Revision returns the commit SHA of the last refresh
+func (g *GitSource) Revision() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.commit
+}
+
+func (g *GitSource) Load(ctx context.Context) (map[string][]byte, error) {
+	if err := g.sync(ctx); err != nil {
+		return nil, err
+	}
+	commit, err := g.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(g.dir, filepath.FromSlash(g.path))
+	files := make(map[string][]byte)
+	err = filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if file != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := presetDecoders[filepath.Ext(file)]; !ok {
+			return nil
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read presets of the repository, %w", err)
+	}
+
+	g.mu.Lock()
+	g.commit = commit
+	g.mu.Unlock()
+	return files, nil
+}
+
+// sync clones the repository or resets the clone to the latest commit of
+// the branch, history isn't needed so only the last commit is fetched
+func (g *GitSource) sync(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); os.IsNotExist(err) {
+		args := []string{"clone", "--depth", "1"}
+		if g.branch != "" {
+			args = append(args, "--branch", g.branch)
+		}
+		_, err := g.git(ctx, append(args, "--", g.repo, ".")...)
+		return err
+	}
+
+	ref := g.branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := g.git(ctx, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return err
+	}
+	_, err := g.git(ctx, "reset", "--hard", "FETCH_HEAD")
+	return err
+}
+
+func (g *GitSource) git(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed, %w: %s", args[0], err, g.redact(strings.TrimSpace(stderr.String())))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
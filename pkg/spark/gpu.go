@@ -0,0 +1,94 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// defaultGPUVendor is the kubernetes resource of GPUs unless a preset sets
+// another vendor
+const defaultGPUVendor = "nvidia.com"
+
+// GPU requests GPUs for the driver and executors of a preset, it expands to
+// the spark.{driver,executor,task}.resource.gpu.* configuration
+type GPU struct {
+	// Driver and Executor are the number of GPUs of each pod
+	Driver   int `yaml:"driver,omitempty" json:"driver,omitempty"`
+	Executor int `yaml:"executor,omitempty" json:"executor,omitempty"`
+	// Task is the share of an executor GPU every task needs, e.g. 0.25 to
+	// run four tasks per GPU. It defaults to 1 if executors have GPUs.
+	Task float64 `yaml:"task,omitempty" json:"task,omitempty"`
+	// DiscoveryScript prints the addresses of the GPUs of a container
+	DiscoveryScript string `yaml:"discoveryScript,omitempty" json:"discoveryScript,omitempty"`
+	// Vendor is the kubernetes resource prefix, only used on kubernetes
+	Vendor string `yaml:"vendor,omitempty" json:"vendor,omitempty"`
+}
+
+func (g GPU) validate() error {
+	if g.Driver < 0 || g.Executor < 0 || g.Task < 0 {
+		return fmt.Errorf("%w: gpu amounts can't be negative", InvalidPresetError)
+	}
+	if g.Task > 0 && g.Executor == 0 {
+		return fmt.Errorf("%w: gpu task needs executor GPUs", InvalidPresetError)
+	}
+	if g.Task > float64(g.Executor) {
+		return fmt.Errorf("%w: gpu task can't need more than the %d GPUs of an executor", InvalidPresetError, g.Executor)
+	}
+	if g.Task > 1 && g.Task != float64(int(g.Task)) {
+		return fmt.Errorf("%w: gpu task must be a whole number or at most 1", InvalidPresetError)
+	}
+	return nil
+}
+
+// gpuConf translates the GPU fields to spark configuration, the vendor is
+// only set for kubernetes masters
+func gpuConf(gpu *GPU, kubernetes bool) map[string]string {
+	conf := map[string]string{}
+	if gpu == nil {
+		return conf
+	}
+	task := gpu.Task
+	if task == 0 {
+		task = 1
+	}
+	vendor := gpu.Vendor
+	if vendor == "" {
+		vendor = defaultGPUVendor
+	}
+	for _, role := range []struct {
+		name   string
+		amount int
+	}{
+		{"driver", gpu.Driver},
+		{"executor", gpu.Executor},
+	} {
+		if role.amount == 0 {
+			continue
+		}
+		prefix := "spark." + role.name + ".resource.gpu."
+		conf[prefix+"amount"] = strconv.Itoa(role.amount)
+		if gpu.DiscoveryScript != "" {
+			conf[prefix+"discoveryScript"] = gpu.DiscoveryScript
+		}
+		if kubernetes {
+			conf[prefix+"vendor"] = vendor
+		}
+		if role.name == "executor" {
+			conf["spark.task.resource.gpu.amount"] = strconv.FormatFloat(task, 'f', -1, 64)
+		}
+	}
+	return conf
+}
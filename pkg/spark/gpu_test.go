@@ -0,0 +1,71 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGPUConf(t *testing.T) {
+	t.Run("expands the GPU fields of kubernetes presets", func(t *testing.T) {
+		gpu := &GPU{Driver: 1, Executor: 2, Task: 0.5, DiscoveryScript: "/opt/spark/getGpus.sh"}
+		require.Equal(t, map[string]string{
+			"spark.driver.resource.gpu.amount":            "1",
+			"spark.driver.resource.gpu.discoveryScript":   "/opt/spark/getGpus.sh",
+			"spark.driver.resource.gpu.vendor":            "nvidia.com",
+			"spark.executor.resource.gpu.amount":          "2",
+			"spark.executor.resource.gpu.discoveryScript": "/opt/spark/getGpus.sh",
+			"spark.executor.resource.gpu.vendor":          "nvidia.com",
+			"spark.task.resource.gpu.amount":              "0.5",
+		}, gpuConf(gpu, true))
+	})
+
+	t.Run("defaults the task amount and skips the vendor of other masters", func(t *testing.T) {
+		require.Equal(t, map[string]string{
+			"spark.executor.resource.gpu.amount": "1",
+			"spark.task.resource.gpu.amount":     "1",
+		}, gpuConf(&GPU{Executor: 1, Vendor: "amd.com"}, false))
+		require.Empty(t, gpuConf(nil, true))
+	})
+
+	t.Run("is overridden by the sparkConf of a submission", func(t *testing.T) {
+		s := &Spark{
+			presets: map[string]configurationPreset{"train": {Main: "train.py", GPU: &GPU{Executor: 1, Vendor: "amd.com"}}},
+			master:  "k8s://https://kube",
+		}
+		args, err := s.submitArgs("train", SubmitOptions{SparkConf: map[string]string{"spark.task.resource.gpu.amount": "0.25"}})
+		require.NoError(t, err)
+		require.Equal(t, "amd.com", confArg(args, "spark.executor.resource.gpu.vendor"))
+		require.Equal(t, "0.25", confArg(args, "spark.task.resource.gpu.amount"))
+	})
+}
+
+func TestGPUValidate(t *testing.T) {
+	require.NoError(t, GPU{Executor: 4, Task: 2}.validate())
+	for gpu, message := range map[GPU]string{
+		{Executor: -1}:           "can't be negative",
+		{Driver: 1, Task: 1}:     "needs executor GPUs",
+		{Executor: 1, Task: 2}:   "can't need more than the 1 GPUs",
+		{Executor: 4, Task: 1.5}: "whole number or at most 1",
+	} {
+		err := gpu.validate()
+		require.ErrorIs(t, err, InvalidPresetError)
+		require.ErrorContains(t, err, message)
+	}
+
+	_, err := parsePreset("train", []byte("main: train.py\ngpu: {executor: 1, task: 2}"))
+	require.ErrorIs(t, err, InvalidPresetError)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long idempotency keys are remembered if
+// nothing else is configured
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+var IdempotencyKeyReusedError error = errors.New("idempotency key was used for another preset")
+
+// idempotentJob returns the id of the job submitted with the key within the
+// TTL, or an empty string if there is none. The caller holds submitMu.
+func (s *Spark) idempotentJob(presetName, key string) (string, error) {
+	ttl := s.idempotencyTTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	list, err := s.jobs.List()
+	if err != nil {
+		return "", fmt.Errorf("couldn't list jobs, %w", err)
+	}
+	for _, job := range list {
+		if job.IdempotencyKey != key || time.Since(job.CreatedAt) > ttl {
+			continue
+		}
+		if job.Preset != presetName {
+			return "", IdempotencyKeyReusedError
+		}
+		return job.ID, nil
+	}
+	return "", nil
+}
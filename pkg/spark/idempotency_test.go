@@ -0,0 +1,65 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	newSpark := func(store jobs.Store) *Spark {
+		return newTestSpark(t, Config{}, store, "exit 0", map[string]configurationPreset{"pi": {Main: "pi.py"}, "etl": {Main: "etl.py"}})
+	}
+	runAt := time.Now().Add(time.Hour)
+
+	t.Run("given a repeated key, returns the first job", func(t *testing.T) {
+		s := newSpark(jobs.NewMemoryStore())
+		first, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, IdempotencyKey: "retry-1"})
+		require.NoError(t, err)
+		second, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, IdempotencyKey: "retry-1"})
+		require.NoError(t, err)
+		require.Equal(t, first, second)
+
+		list, err := s.Jobs()
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.Equal(t, "retry-1", list[0].IdempotencyKey)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("given an expired key, submits again", func(t *testing.T) {
+		store := jobs.NewMemoryStore()
+		old := jobs.Job{ID: "old", Preset: "pi", State: jobs.StateSucceeded, CreatedAt: time.Now().Add(-25 * time.Hour), IdempotencyKey: "retry-1"}
+		require.NoError(t, store.Put(old))
+		s := newSpark(store)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, IdempotencyKey: "retry-1"})
+		require.NoError(t, err)
+		require.NotEqual(t, "old", id)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("given a key of another preset, returns IdempotencyKeyReusedError", func(t *testing.T) {
+		s := newSpark(jobs.NewMemoryStore())
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, IdempotencyKey: "retry-1"})
+		require.NoError(t, err)
+		_, err = s.Submit(context.Background(), "etl", SubmitOptions{RunAt: &runAt, IdempotencyKey: "retry-1"})
+		require.ErrorIs(t, err, IdempotencyKeyReusedError)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// keytabPath returns the path of a keytab in the keytab directory
+func (s *Spark) keytabPath(keytab string) string {
+	return filepath.Join(s.keytabDir, keytab)
+}
+
+// checkKeytab makes sure the keytab of a preset is mounted before it is
+// submitted, spark-submit would only fail after a few retries
+func (s *Spark) checkKeytab(presetName string) error {
+	s.mu.RLock()
+	preset := s.presets[presetName]
+	s.mu.RUnlock()
+	if preset.Keytab == "" {
+		return nil
+	}
+	// presets of sources aren't validated when they are loaded
+	if !filepath.IsLocal(preset.Keytab) {
+		return fmt.Errorf("%w: keytab must be a file name in the keytab directory", InvalidPresetError)
+	}
+
+	info, err := os.Stat(s.keytabPath(preset.Keytab))
+	if err != nil || info.IsDir() {
+		return fmt.Errorf(`%w: keytab "%s" not found in the keytab directory`, InvalidPresetError, preset.Keytab)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKerberos(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "etl.keytab"), []byte("keytab"), 0400))
+	s := Spark{
+		presets: map[string]configurationPreset{
+			"etl":     {Main: "etl.jar", Principal: "etl@EXAMPLE.COM", Keytab: "etl.keytab"},
+			"missing": {Main: "etl.jar", Principal: "etl@EXAMPLE.COM", Keytab: "other.keytab"},
+			"escape":  {Main: "etl.jar", Principal: "etl@EXAMPLE.COM", Keytab: "../etc/passwd"},
+			"pi":      {Main: "pi.py"},
+		},
+		master:    "k8s://http://localhost:8000",
+		keytabDir: dir,
+	}
+
+	t.Run("submitArgs passes the principal and the keytab path", func(t *testing.T) {
+		args, err := s.submitArgs("etl", SubmitOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"--master=k8s://http://localhost:8000",
+			"--deploy-mode=cluster",
+			"--name=etl",
+			"--principal=etl@EXAMPLE.COM",
+			"--keytab=" + filepath.Join(dir, "etl.keytab"),
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=etl",
+			"etl.jar",
+		}, args)
+	})
+
+	t.Run("checkKeytab accepts presets without kerberos", func(t *testing.T) {
+		require.NoError(t, s.checkKeytab("pi"))
+		require.NoError(t, s.checkKeytab("etl"))
+	})
+
+	t.Run("given a keytab that isn't mounted, returns InvalidPresetError", func(t *testing.T) {
+		require.ErrorIs(t, s.checkKeytab("missing"), InvalidPresetError)
+	})
+
+	t.Run("given a keytab outside of the keytab directory, returns InvalidPresetError", func(t *testing.T) {
+		require.ErrorIs(t, s.checkKeytab("escape"), InvalidPresetError)
+	})
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// driverSelector matches the driver pods created by spark-submit
+const driverSelector = "spark-role=driver"
+
+// PresetLabel is set on the driver pods of kubernetes submissions, its value
+// is the preset name with slashes replaced by dots
+const PresetLabel = "spark-submit/preset"
+
+// JobLabel is set on the driver pods of kubernetes submissions, its value is
+// the job id. It finds the job of an application again after a restart.
+const JobLabel = "spark-submit/job"
+
+const (
+	driverLabelPrefix        = "spark.kubernetes.driver.label."
+	executorLabelPrefix      = "spark.kubernetes.executor.label."
+	driverAnnotationPrefix   = "spark.kubernetes.driver.annotation."
+	executorAnnotationPrefix = "spark.kubernetes.executor.annotation."
+)
+
+var InvalidLabelError error = errors.New("invalid labels")
+
+var (
+	labelNamePattern    = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelValuePattern   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	dnsSubdomainPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// validLabelKey checks the syntax kubernetes requires of label and
+// annotation keys, a name with an optional DNS subdomain prefix
+func validLabelKey(key string) bool {
+	prefix, name, prefixed := strings.Cut(key, "/")
+	if !prefixed {
+		prefix, name = "", key
+	}
+	if prefixed && (len(prefix) > 253 || !dnsSubdomainPattern.MatchString(prefix)) {
+		return false
+	}
+	return len(name) <= 63 && labelNamePattern.MatchString(name)
+}
+
+// reservedLabelKey reports whether spark or the server set the label
+func reservedLabelKey(key string) bool {
+	return strings.HasPrefix(key, "spark-submit/") || (strings.HasPrefix(key, "spark-") && !strings.Contains(key, "/"))
+}
+
+// podMetadataConf translates the labels and annotations of a submission to
+// the spark configuration of the driver and executor pods
+func podMetadataConf(labels, annotations map[string]string) (map[string]string, error) {
+	conf := make(map[string]string, 2*(len(labels)+len(annotations)))
+	for _, key := range sortedKeys(labels) {
+		value := labels[key]
+		if !validLabelKey(key) || reservedLabelKey(key) {
+			return nil, fmt.Errorf(`%w: "%s" isn't a valid label key`, InvalidLabelError, key)
+		}
+		if len(value) > 63 || !labelValuePattern.MatchString(value) {
+			return nil, fmt.Errorf(`%w: "%s" isn't a valid value of label "%s"`, InvalidLabelError, value, key)
+		}
+		conf[driverLabelPrefix+key] = value
+		conf[executorLabelPrefix+key] = value
+	}
+	for _, key := range sortedKeys(annotations) {
+		if !validLabelKey(key) {
+			return nil, fmt.Errorf(`%w: "%s" isn't a valid annotation key`, InvalidLabelError, key)
+		}
+		conf[driverAnnotationPrefix+key] = escapeSecrets(annotations[key])
+		conf[executorAnnotationPrefix+key] = escapeSecrets(annotations[key])
+	}
+	return conf, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// presetLabelValue turns a preset name into a valid label value, long names
+// are cut to the 63 characters kubernetes allows
+func presetLabelValue(presetName string) string {
+	value := strings.ReplaceAll(presetName, "/", ".")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.TrimRight(value, "._-")
+}
+
+// KubernetesBackend submits applications with spark-submit, but looks up and
+// kills the driver pods directly through the kubernetes API instead of
+// starting a spark-submit process for every request.
+type KubernetesBackend struct {
+	*Spark
+	client *kube.Client
+}
+
+func NewKubernetesBackend(s *Spark, client *kube.Client) *KubernetesBackend {
+	return &KubernetesBackend{Spark: s, client: client}
+}
+
+// drivers returns the driver pods in the namespace whose name matches the
+// glob pattern, the same way spark-submit resolves the submission id
+func (k *KubernetesBackend) drivers(ctx context.Context, namespace, name string) ([]kube.Pod, error) {
+	pods, err := k.client.ListPods(ctx, namespace, driverSelector)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list driver pods, %w", err)
+	}
+
+	drivers := make([]kube.Pod, 0, len(pods))
+	for _, pod := range pods {
+		matched, err := path.Match(name, pod.Metadata.Name)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid name pattern ("%s"), %w`, name, err)
+		}
+		if matched {
+			drivers = append(drivers, pod)
+		}
+	}
+	return drivers, nil
+}
+
+func (k *KubernetesBackend) Kill(ctx context.Context, namespace, name string) {
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, name)
+	defer span.End()
+	ctx, cancel := k.commandContext(ctx)
+	defer cancel()
+	pods, err := k.drivers(ctx, namespace, name)
+	if err != nil {
+		logging.FromContext(ctx).Error("killing spark app failed", zap.Error(err))
+		span.RecordError(err)
+		return
+	}
+
+	for _, pod := range pods {
+		if err := k.deleteDriver(ctx, pod); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+func (k *KubernetesBackend) deleteDriver(ctx context.Context, pod kube.Pod) error {
+	namespace, name := pod.Metadata.Namespace, pod.Metadata.Name
+	logging.FromContext(ctx).Info("deleting driver pod", zap.String("namespace", namespace), zap.String("pod", name))
+	if err := k.client.DeletePod(ctx, namespace, name); err != nil {
+		logging.FromContext(ctx).Error("killing spark app failed", zap.String("pod", name), zap.Error(err))
+		return err
+	}
+	k.publish(events.Event{Type: events.Killed, Namespace: namespace, Name: name})
+	return nil
+}
+
+// KillApps deletes the running and pending driver pods of the namespace that
+// belong to the preset and match the label selector
+func (k *KubernetesBackend) KillApps(ctx context.Context, namespace string, filter AppFilter) ([]string, error) {
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, "*")
+	defer span.End()
+	ctx, cancel := k.commandContext(ctx)
+	defer cancel()
+
+	selector := driverSelector
+	if filter.Preset != "" {
+		selector += "," + PresetLabel + "=" + presetLabelValue(filter.Preset)
+	}
+	if filter.LabelSelector != "" {
+		selector += "," + filter.LabelSelector
+	}
+	pods, err := k.client.ListPods(ctx, namespace, selector)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("couldn't list driver pods, %w", err)
+	}
+
+	killed := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		if pod.Status.Phase != "Running" && pod.Status.Phase != "Pending" {
+			continue
+		}
+		// the pod might be deleted in the meantime, the other pods are
+		// killed anyway
+		if err := k.deleteDriver(ctx, pod); err != nil {
+			span.RecordError(err)
+			continue
+		}
+		killed = append(killed, pod.Metadata.Name)
+	}
+	return killed, nil
+}
+
+func (k *KubernetesBackend) Status(ctx context.Context, namespace, name string) StatusReport {
+	ctx, span := startAppSpan(ctx, "spark.status", namespace, name)
+	defer span.End()
+	ctx, cancel := k.commandContext(ctx)
+	defer cancel()
+	report := StatusReport{Apps: make([]AppStatus, 0)}
+	pods, err := k.drivers(ctx, namespace, name)
+	if err != nil {
+		logging.FromContext(ctx).Error("requesting spark app status failed", zap.Error(err))
+		span.RecordError(err)
+		return report
+	}
+
+	for _, pod := range pods {
+		report.Apps = append(report.Apps, appStatusFromPod(pod))
+	}
+	return report
+}
+
+func appStatusFromPod(pod kube.Pod) AppStatus {
+	app := AppStatus{
+		PodName:        pod.Metadata.Name,
+		Namespace:      pod.Metadata.Namespace,
+		Labels:         pod.Metadata.Labels,
+		Preset:         pod.Metadata.Labels[PresetLabel],
+		Phase:          pod.Status.Phase,
+		SubmissionDate: pod.Metadata.CreationTimestamp,
+		StartTime:      pod.Status.StartTime,
+	}
+
+	for _, container := range pod.Status.ContainerStatuses {
+		if container.Name != "spark-kubernetes-driver" {
+			continue
+		}
+
+		state := container.State
+		switch {
+		case state.Running != nil:
+			app.ContainerState = "running"
+		case state.Terminated != nil:
+			app.ContainerState = "terminated"
+			exitCode := state.Terminated.ExitCode
+			app.ExitCode = &exitCode
+			app.ExitReason = state.Terminated.Reason
+		case state.Waiting != nil:
+			app.ContainerState = "waiting"
+			app.ExitReason = state.Waiting.Reason
+		}
+	}
+	return app
+}
+
+// FreeCapacity returns the allocatable resources of the schedulable nodes
+// minus the requests of the pods that aren't finished yet
+func (k *KubernetesBackend) FreeCapacity(ctx context.Context) ([]NodeCapacity, error) {
+	nodes, err := k.client.ListNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list nodes, %w", err)
+	}
+	pods, err := k.client.ListActivePods(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list pods, %w", err)
+	}
+
+	free := make(map[string]*NodeCapacity)
+	var capacity []NodeCapacity
+	for _, node := range nodes {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		cores, memory := quantities(node.Status.Allocatable)
+		capacity = append(capacity, NodeCapacity{Node: node.Metadata.Name, Cores: cores, MemoryGB: memory / (1 << 30)})
+	}
+	for i := range capacity {
+		free[capacity[i].Node] = &capacity[i]
+	}
+	for _, pod := range pods {
+		node, ok := free[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			cores, memory := quantities(container.Resources.Requests)
+			node.Cores -= cores
+			node.MemoryGB -= memory / (1 << 30)
+		}
+	}
+	return capacity, nil
+}
+
+// quantities returns the cpu and memory of a resource list, invalid
+// quantities count as 0
+func quantities(list map[string]string) (float64, float64) {
+	cores, _ := kube.ParseQuantity(list["cpu"])
+	memory, _ := kube.ParseQuantity(list["memory"])
+	return cores, memory
+}
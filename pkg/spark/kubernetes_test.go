@@ -0,0 +1,180 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/stretchr/testify/require"
+)
+
+const driverPods = `{"items": [
+	{
+		"metadata": {"name": "pi-1-driver", "namespace": "spark", "labels": {"spark-role": "driver", "spark-submit/preset": "pi"}, "creationTimestamp": "2023-07-10T11:58:12Z"},
+		"status": {"phase": "Failed", "containerStatuses": [
+			{"name": "spark-kubernetes-driver", "state": {"terminated": {"exitCode": 1, "reason": "Error"}}}
+		]}
+	},
+	{
+		"metadata": {"name": "other-1-driver", "namespace": "spark", "labels": {"spark-role": "driver"}},
+		"status": {"phase": "Running", "containerStatuses": [
+			{"name": "spark-kubernetes-driver", "state": {"running": {}}}
+		]}
+	}
+]}`
+
+func newKubernetesBackend(t *testing.T, handler http.HandlerFunc) *KubernetesBackend {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := kube.NewClient(server.URL)
+	require.NoError(t, err)
+	return NewKubernetesBackend(&Spark{}, client)
+}
+
+func TestKubernetesBackend(t *testing.T) {
+	t.Run("Status returns the matching driver pods", func(t *testing.T) {
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/api/v1/namespaces/spark/pods", r.URL.Path)
+			require.Equal(t, driverSelector, r.URL.Query().Get("labelSelector"))
+			_, _ = w.Write([]byte(driverPods))
+		})
+
+		report := backend.Status(context.Background(), "spark", "pi-*")
+		require.Len(t, report.Apps, 1)
+		require.Equal(t, "pi-1-driver", report.Apps[0].PodName)
+		require.Equal(t, "pi", report.Apps[0].Preset)
+		require.Equal(t, "Failed", report.Apps[0].Phase)
+		require.Equal(t, "terminated", report.Apps[0].ContainerState)
+		require.Equal(t, 1, *report.Apps[0].ExitCode)
+		require.Equal(t, "Error", report.Apps[0].ExitReason)
+		require.NotNil(t, report.Apps[0].SubmissionDate)
+	})
+
+	t.Run("Kill deletes the matching driver pods", func(t *testing.T) {
+		deleted := make([]string, 0)
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deleted = append(deleted, r.URL.Path)
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			_, _ = w.Write([]byte(driverPods))
+		})
+
+		backend.Kill(context.Background(), "spark", "*")
+		require.Equal(t, []string{
+			"/api/v1/namespaces/spark/pods/pi-1-driver",
+			"/api/v1/namespaces/spark/pods/other-1-driver",
+		}, deleted)
+	})
+}
+
+func TestKillApps(t *testing.T) {
+	t.Run("deletes the running driver pods of the preset", func(t *testing.T) {
+		deleted := make([]string, 0)
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deleted = append(deleted, r.URL.Path)
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			require.Equal(t, "spark-role=driver,spark-submit/preset=team.etl,team=data", r.URL.Query().Get("labelSelector"))
+			_, _ = w.Write([]byte(driverPods))
+		})
+
+		killed, err := backend.KillApps(context.Background(), "spark", AppFilter{Preset: "team/etl", LabelSelector: "team=data"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"other-1-driver"}, killed)
+		require.Equal(t, []string{"/api/v1/namespaces/spark/pods/other-1-driver"}, deleted)
+	})
+
+	t.Run("the spark-submit backend doesn't support bulk kills", func(t *testing.T) {
+		_, err := (&Spark{}).KillApps(context.Background(), "spark", AppFilter{Preset: "etl"})
+		require.ErrorIs(t, err, UnsupportedError)
+	})
+}
+
+func TestFreeCapacity(t *testing.T) {
+	k := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			_, _ = w.Write([]byte(`{"items": [
+				{"metadata": {"name": "node-1"}, "status": {"allocatable": {"cpu": "3920m", "memory": "16Gi"}}},
+				{"metadata": {"name": "node-2"}, "spec": {"unschedulable": true}, "status": {"allocatable": {"cpu": "4", "memory": "16Gi"}}}
+			]}`))
+		case "/api/v1/pods":
+			require.Equal(t, "status.phase!=Succeeded,status.phase!=Failed", r.URL.Query().Get("fieldSelector"))
+			_, _ = w.Write([]byte(`{"items": [
+				{"metadata": {"name": "etl-driver"}, "spec": {"nodeName": "node-1", "containers": [{"name": "spark-kubernetes-driver", "resources": {"requests": {"cpu": "1", "memory": "4Gi"}}}]}},
+				{"metadata": {"name": "pending-driver"}, "spec": {"containers": [{"name": "spark-kubernetes-driver", "resources": {"requests": {"cpu": "1", "memory": "4Gi"}}}]}}
+			]}`))
+		default:
+			t.Fatalf("unexpected request %s", r.URL.Path)
+		}
+	})
+
+	capacity, err := k.FreeCapacity(context.Background())
+	require.NoError(t, err)
+	require.Len(t, capacity, 1)
+	require.Equal(t, "node-1", capacity[0].Node)
+	require.InDelta(t, 2.92, capacity[0].Cores, 1e-9)
+	require.Equal(t, float64(12), capacity[0].MemoryGB)
+}
+
+func TestPodMetadataConf(t *testing.T) {
+	t.Run("adds the labels and annotations to the driver and executors", func(t *testing.T) {
+		conf, err := podMetadataConf(map[string]string{"ticket": "DATA-123", "example.com/cost-center": "4711"}, map[string]string{"owner": "Data Team <data@example.com>"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			"spark.kubernetes.driver.label.ticket":                    "DATA-123",
+			"spark.kubernetes.executor.label.ticket":                  "DATA-123",
+			"spark.kubernetes.driver.label.example.com/cost-center":   "4711",
+			"spark.kubernetes.executor.label.example.com/cost-center": "4711",
+			"spark.kubernetes.driver.annotation.owner":                "Data Team <data@example.com>",
+			"spark.kubernetes.executor.annotation.owner":              "Data Team <data@example.com>",
+		}, conf)
+	})
+
+	t.Run("given invalid or reserved labels, returns InvalidLabelError", func(t *testing.T) {
+		for _, tc := range []struct {
+			labels, annotations map[string]string
+			message             string
+		}{
+			{map[string]string{"ticket id": "1"}, nil, `"ticket id" isn't a valid label key`},
+			{map[string]string{"ticket": "DATA 123"}, nil, `"DATA 123" isn't a valid value of label "ticket"`},
+			{map[string]string{"ticket": strings.Repeat("a", 64)}, nil, "isn't a valid value"},
+			{map[string]string{PresetLabel: "other"}, nil, `"spark-submit/preset" isn't a valid label key`},
+			{map[string]string{"spark-role": "executor"}, nil, `"spark-role" isn't a valid label key`},
+			{nil, map[string]string{"Example.com/owner": "x"}, `"Example.com/owner" isn't a valid annotation key`},
+		} {
+			_, err := podMetadataConf(tc.labels, tc.annotations)
+			require.ErrorIs(t, err, InvalidLabelError)
+			require.ErrorContains(t, err, tc.message)
+		}
+	})
+}
+
+func TestPresetLabelValue(t *testing.T) {
+	require.Equal(t, "etl", presetLabelValue("etl"))
+	require.Equal(t, "team-a.etl", presetLabelValue("team-a/etl"))
+	require.Len(t, presetLabelValue(strings.Repeat("a", 70)), 63)
+	// the cut must not leave a dot at the end
+	require.Equal(t, strings.Repeat("a", 62), presetLabelValue(strings.Repeat("a", 62)+"/etl"))
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// MockConfig controls the simulated submissions and applications of a
+// MockCluster
+type MockConfig struct {
+	// Latency is how long a spark-submit run takes
+	Latency time.Duration
+	// FailureRate is the fraction of spark-submit runs that fail, 0 to 1
+	FailureRate float64
+	// PendingTime and RunTime are how long applications are pending and
+	// running before they finish
+	PendingTime time.Duration
+	RunTime     time.Duration
+	// AppFailureRate is the fraction of applications that end as failed, 0 to 1
+	AppFailureRate float64
+}
+
+func (c MockConfig) Validate() error {
+	if c.Latency < 0 || c.PendingTime < 0 || c.RunTime < 0 {
+		return fmt.Errorf("mock durations must not be negative")
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 || c.AppFailureRate < 0 || c.AppFailureRate > 1 {
+		return fmt.Errorf("mock failure rates must be between 0 and 1")
+	}
+	return nil
+}
+
+var MockSubmitError error = errors.New("mock spark-submit failed")
+
+// MockCluster simulates spark-submit and the applications it creates, so
+// clients of the API can run end-to-end tests without a spark distribution
+// or a cluster. The applications are reported like driver pods of the
+// kubernetes backend.
+type MockCluster struct {
+	config MockConfig
+	random func() float64
+	now    func() time.Time
+
+	mu   sync.Mutex
+	apps []*mockApp
+}
+
+type mockApp struct {
+	namespace     string
+	podName       string
+	applicationID string
+	labels        map[string]string
+	submitted     time.Time
+	fails         bool
+}
+
+func NewMockCluster(config MockConfig) *MockCluster {
+	return &MockCluster{config: config, random: mathrand.Float64, now: time.Now}
+}
+
+// RunSubmit waits for the latency and then fails or creates an application,
+// which is reported in the output the way spark-submit does on kubernetes
+func (m *MockCluster) RunSubmit(ctx context.Context, args []string, output io.Writer) error {
+	if m.config.Latency > 0 {
+		timer := time.NewTimer(m.config.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if m.random() < m.config.FailureRate {
+		_, _ = fmt.Fprintln(output, "mock spark-submit failed")
+		return MockSubmitError
+	}
+
+	id, err := newMockID()
+	if err != nil {
+		return err
+	}
+	name, conf := mockSubmission(args)
+	app := &mockApp{
+		namespace:     conf[namespaceConfKey],
+		podName:       fmt.Sprintf("%s-%s-driver", strings.ReplaceAll(name, "/", "-"), id[:8]),
+		applicationID: "spark-" + id,
+		labels:        map[string]string{"spark-role": "driver"},
+		submitted:     m.now().UTC(),
+		fails:         m.random() < m.config.AppFailureRate,
+	}
+	if app.namespace == "" {
+		app.namespace = "default"
+	}
+	for key, value := range conf {
+		if label, ok := strings.CutPrefix(key, driverLabelPrefix); ok {
+			app.labels[label] = value
+		}
+	}
+	if _, ok := app.labels[PresetLabel]; !ok {
+		app.labels[PresetLabel] = presetLabelValue(name)
+	}
+
+	m.mu.Lock()
+	m.apps = append(m.apps, app)
+	m.mu.Unlock()
+	_, _ = fmt.Fprintf(output, "Deployed Spark application %s with application ID %s and submission ID %s:%s into Kubernetes\n",
+		name, app.applicationID, app.namespace, app.podName)
+	return nil
+}
+
+// mockSubmission returns the application name and the sparkConf of the args
+func mockSubmission(args []string) (string, map[string]string) {
+	name := ""
+	conf := make(map[string]string)
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--name="); ok {
+			name = value
+		}
+		if keyValue, ok := strings.CutPrefix(arg, "--conf="); ok {
+			key, value, _ := strings.Cut(keyValue, "=")
+			conf[key] = value
+		}
+	}
+	return name, conf
+}
+
+// status returns the state of the application at the current time, it is
+// pending, running and then finished
+func (m *MockCluster) status(app *mockApp) AppStatus {
+	status := AppStatus{
+		PodName:        app.podName,
+		ApplicationID:  app.applicationID,
+		Namespace:      app.namespace,
+		Labels:         app.labels,
+		Preset:         app.labels[PresetLabel],
+		Phase:          "Pending",
+		SubmissionDate: &app.submitted,
+		ContainerState: "waiting",
+	}
+	elapsed := m.now().Sub(app.submitted)
+	if elapsed < m.config.PendingTime {
+		return status
+	}
+	started := app.submitted.Add(m.config.PendingTime)
+	status.StartTime = &started
+	status.Phase, status.ContainerState = "Running", "running"
+	if elapsed < m.config.PendingTime+m.config.RunTime {
+		return status
+	}
+	exitCode := 0
+	status.Phase, status.ContainerState, status.ExitReason = "Succeeded", "terminated", "Completed"
+	if app.fails {
+		exitCode = 1
+		status.Phase, status.ExitReason = "Failed", "Error"
+	}
+	status.ExitCode = &exitCode
+	return status
+}
+
+// matching returns the applications of the namespace whose pod name matches
+// the glob pattern
+func (m *MockCluster) matching(namespace, name string) ([]*mockApp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	matches := make([]*mockApp, 0)
+	for _, app := range m.apps {
+		if app.namespace != namespace {
+			continue
+		}
+		matched, err := path.Match(name, app.podName)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid name pattern ("%s"), %w`, name, err)
+		}
+		if matched {
+			matches = append(matches, app)
+		}
+	}
+	return matches, nil
+}
+
+// remove deletes the application like the driver pod of a killed
+// application on kubernetes
+func (m *MockCluster) remove(app *mockApp) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, candidate := range m.apps {
+		if candidate == app {
+			m.apps = append(m.apps[:i], m.apps[i+1:]...)
+			return
+		}
+	}
+}
+
+func newMockID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MockBackend answers status and kill requests from the simulated
+// applications of a MockCluster, which has to run the submissions of the
+// Spark as well
+type MockBackend struct {
+	*Spark
+	cluster *MockCluster
+}
+
+func NewMockBackend(s *Spark, cluster *MockCluster) *MockBackend {
+	return &MockBackend{Spark: s, cluster: cluster}
+}
+
+func (b *MockBackend) Status(ctx context.Context, namespace, name string) StatusReport {
+	ctx, span := startAppSpan(ctx, "spark.status", namespace, name)
+	defer span.End()
+	report := StatusReport{Apps: make([]AppStatus, 0)}
+	apps, err := b.cluster.matching(namespace, name)
+	if err != nil {
+		logging.FromContext(ctx).Error("requesting spark app status failed", zap.Error(err))
+		span.RecordError(err)
+		return report
+	}
+	for _, app := range apps {
+		report.Apps = append(report.Apps, b.cluster.status(app))
+	}
+	return report
+}
+
+func (b *MockBackend) Kill(ctx context.Context, namespace, name string) {
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, name)
+	defer span.End()
+	apps, err := b.cluster.matching(namespace, name)
+	if err != nil {
+		logging.FromContext(ctx).Error("killing spark app failed", zap.Error(err))
+		span.RecordError(err)
+		return
+	}
+	for _, app := range apps {
+		b.kill(ctx, app)
+	}
+}
+
+func (b *MockBackend) kill(ctx context.Context, app *mockApp) {
+	logging.FromContext(ctx).Info("deleting mock driver", zap.String("namespace", app.namespace), zap.String("pod", app.podName))
+	b.cluster.remove(app)
+	b.publish(events.Event{Type: events.Killed, Namespace: app.namespace, Name: app.podName})
+}
+
+// KillApps kills the pending and running applications of the namespace that
+// belong to the preset and match the label selector, only equality
+// selectors like "team=data,tier=batch" are supported
+func (b *MockBackend) KillApps(ctx context.Context, namespace string, filter AppFilter) ([]string, error) {
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, "*")
+	defer span.End()
+	selector := make(map[string]string)
+	if filter.LabelSelector != "" {
+		for _, requirement := range strings.Split(filter.LabelSelector, ",") {
+			key, value, ok := strings.Cut(requirement, "=")
+			if !ok || strings.ContainsAny(key, "!") || strings.HasPrefix(value, "=") {
+				return nil, fmt.Errorf("%w, the mock backend only supports equality label selectors", UnsupportedError)
+			}
+			selector[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if filter.Preset != "" {
+		selector[PresetLabel] = presetLabelValue(filter.Preset)
+	}
+
+	apps, err := b.cluster.matching(namespace, "*")
+	if err != nil {
+		return nil, err
+	}
+	killed := make([]string, 0, len(apps))
+	for _, app := range apps {
+		if !b.cluster.status(app).Live() || !matchesLabels(app.labels, selector) {
+			continue
+		}
+		b.kill(ctx, app)
+		killed = append(killed, app.podName)
+	}
+	return killed, nil
+}
+
+func matchesLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
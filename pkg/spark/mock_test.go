@@ -0,0 +1,137 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func newMockCluster(config MockConfig, random float64) (*MockCluster, *time.Time) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	cluster := NewMockCluster(config)
+	cluster.random = func() float64 { return random }
+	cluster.now = func() time.Time { return now }
+	return cluster, &now
+}
+
+var mockArgs = []string{"--master=k8s://mock", "--name=pi", "--conf=spark.kubernetes.namespace=etl", "--conf=spark.kubernetes.driver.label.team=data", "pi.py"}
+
+func TestMockCluster(t *testing.T) {
+	t.Run("validates the config", func(t *testing.T) {
+		require.NoError(t, MockConfig{Latency: time.Second, FailureRate: 0.5}.Validate())
+		require.Error(t, MockConfig{FailureRate: 1.5}.Validate())
+		require.Error(t, MockConfig{RunTime: -time.Second}.Validate())
+	})
+
+	t.Run("reports the application like spark-submit on kubernetes", func(t *testing.T) {
+		cluster, _ := newMockCluster(MockConfig{}, 0.5)
+		var output bytes.Buffer
+		require.NoError(t, cluster.RunSubmit(context.Background(), mockArgs, &output))
+
+		var app SubmittedApp
+		parser := newSubmissionParser(func(found SubmittedApp) { app = found })
+		_, _ = parser.Write(output.Bytes())
+		require.Equal(t, "etl", app.Namespace)
+		require.Regexp(t, `^pi-[0-9a-f]{8}-driver$`, app.DriverPod)
+		require.Regexp(t, `^spark-[0-9a-f]{16}$`, app.ApplicationID)
+	})
+
+	t.Run("fails spark-submit runs at the failure rate", func(t *testing.T) {
+		cluster, _ := newMockCluster(MockConfig{FailureRate: 0.6}, 0.5)
+		var output bytes.Buffer
+		require.ErrorIs(t, cluster.RunSubmit(context.Background(), mockArgs, &output), MockSubmitError)
+		require.Contains(t, output.String(), "mock spark-submit failed")
+		require.Empty(t, cluster.apps)
+	})
+
+	t.Run("waits for the latency until ctx is done", func(t *testing.T) {
+		cluster, _ := newMockCluster(MockConfig{Latency: time.Hour}, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, cluster.RunSubmit(ctx, mockArgs, &bytes.Buffer{}), context.Canceled)
+	})
+}
+
+func TestMockBackend(t *testing.T) {
+	t.Run("Status follows the phases of the application", func(t *testing.T) {
+		cluster, now := newMockCluster(MockConfig{PendingTime: time.Minute, RunTime: time.Hour, AppFailureRate: 1}, 0.5)
+		require.NoError(t, cluster.RunSubmit(context.Background(), mockArgs, &bytes.Buffer{}))
+		backend := NewMockBackend(&Spark{}, cluster)
+
+		report := backend.Status(context.Background(), "etl", "pi-*")
+		require.Len(t, report.Apps, 1)
+		require.Equal(t, "Pending", report.Apps[0].Phase)
+		require.Equal(t, "pi", report.Apps[0].Preset)
+		require.Equal(t, "data", report.Apps[0].Labels["team"])
+
+		*now = now.Add(2 * time.Minute)
+		app := backend.Status(context.Background(), "etl", "pi-*").Apps[0]
+		require.Equal(t, "Running", app.Phase)
+		require.NotNil(t, app.StartTime)
+
+		*now = now.Add(2 * time.Hour)
+		app = backend.Status(context.Background(), "etl", "pi-*").Apps[0]
+		require.Equal(t, "Failed", app.Phase)
+		require.Equal(t, 1, *app.ExitCode)
+
+		require.Empty(t, backend.Status(context.Background(), "other", "*").Apps)
+	})
+
+	t.Run("KillApps kills the live applications of the preset", func(t *testing.T) {
+		cluster, _ := newMockCluster(MockConfig{RunTime: time.Hour}, 0.5)
+		require.NoError(t, cluster.RunSubmit(context.Background(), mockArgs, &bytes.Buffer{}))
+		backend := NewMockBackend(&Spark{}, cluster)
+
+		killed, err := backend.KillApps(context.Background(), "etl", AppFilter{Preset: "etl"})
+		require.NoError(t, err)
+		require.Empty(t, killed)
+
+		killed, err = backend.KillApps(context.Background(), "etl", AppFilter{Preset: "pi", LabelSelector: "team=data"})
+		require.NoError(t, err)
+		require.Len(t, killed, 1)
+		require.Empty(t, backend.Status(context.Background(), "etl", "*").Apps)
+
+		_, err = backend.KillApps(context.Background(), "etl", AppFilter{LabelSelector: "team!=data"})
+		require.ErrorIs(t, err, UnsupportedError)
+	})
+
+	t.Run("runs submissions without a spark distribution", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+		cluster, _ := newMockCluster(MockConfig{RunTime: time.Hour}, 0.5)
+		s, err := New(Config{SparkHome: filepath.Join(dir, "missing"), PresetDir: dir, Master: "k8s://mock", SubmitRunner: cluster}, jobs.NewMemoryStore())
+		require.NoError(t, err)
+
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateSucceeded, job.State)
+		require.Equal(t, "default", job.Namespace)
+		require.NotEmpty(t, job.DriverPod)
+		report := NewMockBackend(s, cluster).Status(context.Background(), job.Namespace, job.DriverPod)
+		require.Len(t, report.Apps, 1)
+		require.Equal(t, "Running", report.Apps[0].Phase)
+	})
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"path"
+)
+
+var NamespaceNotAllowedError error = errors.New("namespace not allowed")
+
+// NamespaceAllowed reports whether the server may submit to, kill in or
+// query the kubernetes namespace. All namespaces are allowed if no allowed
+// namespaces are configured.
+func (s *Spark) NamespaceAllowed(namespace string) bool {
+	if len(s.namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range s.namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceAllowed(t *testing.T) {
+	t.Run("allows all namespaces without allowed namespaces", func(t *testing.T) {
+		require.True(t, (&Spark{}).NamespaceAllowed("anything"))
+	})
+
+	t.Run("matches the glob patterns of the allowed namespaces", func(t *testing.T) {
+		s := &Spark{namespaces: []string{"etl", "team-*"}}
+		require.True(t, s.NamespaceAllowed("etl"))
+		require.True(t, s.NamespaceAllowed("team-a"))
+		require.False(t, s.NamespaceAllowed("kube-system"))
+	})
+
+	t.Run("rejects submissions to other namespaces", func(t *testing.T) {
+		s := newTestSpark(t, Config{Master: "k8s://cluster", AllowedNamespaces: []string{"etl"}}, nil, "exit 0", map[string]configurationPreset{
+			"etl":    {Main: "etl.py", SparkConf: map[string]string{namespaceConfKey: "etl"}},
+			"system": {Main: "system.py", SparkConf: map[string]string{namespaceConfKey: "kube-system"}},
+		})
+		runAt := time.Now().Add(time.Hour)
+
+		_, err := s.Submit(context.Background(), "etl", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+		_, err = s.Submit(context.Background(), "system", SubmitOptions{RunAt: &runAt})
+		require.ErrorIs(t, err, NamespaceNotAllowedError)
+		_, err = s.Submit(context.Background(), "etl", SubmitOptions{RunAt: &runAt, SparkConf: map[string]string{namespaceConfKey: "default"}})
+		require.ErrorIs(t, err, NamespaceNotAllowedError)
+		_, err = s.Submit(context.Background(), "etl", SubmitOptions{RunAt: &runAt, NamespaceAllowed: func(namespace string) bool { return namespace == "team" }})
+		require.ErrorIs(t, err, NamespaceNotAllowedError)
+		require.ErrorContains(t, err, "API key")
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+}
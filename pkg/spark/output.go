@@ -0,0 +1,132 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultSubmitOutputLimit is the number of bytes of spark-submit output
+	// kept per job
+	DefaultSubmitOutputLimit = 64 * 1024
+	// maxSubmitOutputs is the number of jobs whose output is kept, the
+	// output of older jobs is dropped
+	maxSubmitOutputs = 100
+	// DefaultStderrTailLimit is the number of bytes of stderr of the last
+	// attempt stored on failed jobs
+	DefaultStderrTailLimit = 8 * 1024
+)
+
+const truncatedMarker = "[earlier output truncated]\n"
+
+// outputBuffer keeps the last limit bytes written to it
+type outputBuffer struct {
+	mu        sync.Mutex
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (o *outputBuffer) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf = append(o.buf, p...)
+	if len(o.buf) > o.limit {
+		o.buf = append(o.buf[:0], o.buf[len(o.buf)-o.limit:]...)
+		o.truncated = true
+	}
+	return len(p), nil
+}
+
+func (o *outputBuffer) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.truncated {
+		return truncatedMarker + string(o.buf)
+	}
+	return string(o.buf)
+}
+
+// stderrTail returns a buffer for the stderr of an attempt
+func (s *Spark) stderrTail() *outputBuffer {
+	limit := s.stderrLimit
+	if limit <= 0 {
+		limit = DefaultStderrTailLimit
+	}
+	return &outputBuffer{limit: limit}
+}
+
+// submitOutput returns the output buffer of the job, creating it on the
+// first attempt
+func (s *Spark) submitOutput(jobID string) *outputBuffer {
+	s.outputMu.Lock()
+	defer s.outputMu.Unlock()
+	if output, ok := s.outputs[jobID]; ok {
+		return output
+	}
+
+	if s.outputs == nil {
+		s.outputs = make(map[string]*outputBuffer)
+	}
+	limit := s.outputLimit
+	if limit <= 0 {
+		limit = DefaultSubmitOutputLimit
+	}
+	output := &outputBuffer{limit: limit}
+	s.outputs[jobID] = output
+	s.outputOrder = append(s.outputOrder, jobID)
+	if len(s.outputOrder) > maxSubmitOutputs {
+		delete(s.outputs, s.outputOrder[0])
+		s.outputOrder = s.outputOrder[1:]
+	}
+	return output
+}
+
+// SubmitOutput returns the captured stdout and stderr of all spark-submit
+// runs of the job. It's empty if the job didn't run yet or its output was
+// dropped to make room for newer jobs.
+func (s *Spark) SubmitOutput(jobID string) (string, error) {
+	if _, err := s.jobs.Get(jobID); err != nil {
+		return "", err
+	}
+
+	s.outputMu.Lock()
+	output, ok := s.outputs[jobID]
+	s.outputMu.Unlock()
+	if !ok {
+		return "", nil
+	}
+	return output.String(), nil
+}
+
+// attemptHeader separates the output of the attempts of a job
+func attemptHeader(attempt int) string {
+	return fmt.Sprintf("=== attempt %d ===\n", attempt)
+}
+
+// syncWriter serializes the writes to w, exec copies stdout and stderr of
+// spark-submit in separate goroutines
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	t.Run("keeps the output below the limit", func(t *testing.T) {
+		output := &outputBuffer{limit: 10}
+		_, _ = output.Write([]byte("hello\n"))
+		require.Equal(t, "hello\n", output.String())
+	})
+
+	t.Run("keeps the tail of longer output", func(t *testing.T) {
+		output := &outputBuffer{limit: 10}
+		_, _ = output.Write([]byte("first line\n"))
+		_, _ = output.Write([]byte("second\n"))
+		require.Equal(t, truncatedMarker+"ne\nsecond\n", output.String())
+	})
+}
+
+func TestSubmitOutput(t *testing.T) {
+	newSpark := func(t *testing.T, script string) *Spark {
+		return newTestSpark(t, Config{Backoff: backoff.Config{Strategy: backoff.Constant, Retries: 2, InitialDelay: time.Millisecond}}, nil, script,
+			map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+
+	t.Run("captures stdout and stderr of all attempts", func(t *testing.T) {
+		s := newSpark(t, "echo submitting; echo 'no such file' >&2; exit 1")
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		output, err := s.SubmitOutput(id)
+		require.NoError(t, err)
+		// stdout and stderr are separate pipes, their lines come in any order
+		require.True(t, strings.HasPrefix(output, "=== attempt 1 ===\n"))
+		first, second, ok := strings.Cut(strings.TrimPrefix(output, "=== attempt 1 ===\n"), "=== attempt 2 ===\n")
+		require.True(t, ok)
+		for _, attempt := range []string{first, second} {
+			require.ElementsMatch(t, []string{"submitting", "no such file", ""}, strings.Split(attempt, "\n"))
+		}
+	})
+
+	t.Run("given an unknown job, returns JobNotFoundError", func(t *testing.T) {
+		_, err := newSpark(t, "true").SubmitOutput("nope")
+		require.ErrorIs(t, err, jobs.JobNotFoundError)
+	})
+
+	t.Run("drops the output of the oldest jobs", func(t *testing.T) {
+		s := newSpark(t, "true")
+		for i := 0; i <= maxSubmitOutputs; i++ {
+			_, _ = s.submitOutput(fmt.Sprint(i)).Write([]byte("output"))
+		}
+		require.Len(t, s.outputs, maxSubmitOutputs)
+		require.NotContains(t, s.outputs, "0")
+		require.Contains(t, s.outputs, fmt.Sprint(maxSubmitOutputs))
+	})
+}
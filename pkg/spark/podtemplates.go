@@ -0,0 +1,170 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var InvalidPodTemplateError error = errors.New("invalid pod template")
+
+const (
+	driverPodTemplateKey   = "spark.kubernetes.driver.podTemplateFile"
+	executorPodTemplateKey = "spark.kubernetes.executor.podTemplateFile"
+)
+
+// PodTemplate overrides the pod templates of the driver and executors for a
+// single submission. A named template applies to both, inline templates take
+// precedence for their role.
+type PodTemplate struct {
+	// Name is a template file of the pod template directory without the
+	// .yaml extension
+	Name string `json:"name,omitempty"`
+	// Driver and Executor are inline pod manifests
+	Driver   json.RawMessage `json:"driver,omitempty"`
+	Executor json.RawMessage `json:"executor,omitempty"`
+}
+
+// podTemplateTmpDir keeps the inline templates of jobs until they finish
+func (s *Spark) podTemplateTmpDir() string {
+	return filepath.Join(os.TempDir(), "spark-submit-pod-templates")
+}
+
+// withPodTemplates points the submit args at the pod templates of the
+// submission, inline templates are written to files named after the job. The
+// tolerations and affinity of the preset are merged into the templates of
+// kubernetes submissions.
+func (s *Spark) withPodTemplates(jobID string, args []string, template *PodTemplate, scheduling podScheduling) ([]string, error) {
+	if template == nil && scheduling.empty() {
+		return args, nil
+	}
+	if submitNamespace(args) == "" {
+		if template == nil {
+			return args, nil
+		}
+		return nil, fmt.Errorf("%w: pod templates need a kubernetes master", InvalidPodTemplateError)
+	}
+	if template == nil {
+		template = &PodTemplate{}
+	}
+
+	files := map[string]string{}
+	inlines := map[string]json.RawMessage{}
+	if template.Name != "" {
+		path, err := s.namedPodTemplate(template.Name)
+		if err != nil {
+			return nil, err
+		}
+		files[driverPodTemplateKey], files[executorPodTemplateKey] = path, path
+	}
+	for _, inline := range []struct {
+		role     string
+		key      string
+		manifest json.RawMessage
+	}{
+		{"driver", driverPodTemplateKey, template.Driver},
+		{"executor", executorPodTemplateKey, template.Executor},
+	} {
+		if len(inline.manifest) == 0 {
+			continue
+		}
+		var pod map[string]interface{}
+		if err := json.Unmarshal(inline.manifest, &pod); err != nil {
+			return nil, fmt.Errorf("%w: the %s template must be a pod manifest", InvalidPodTemplateError, inline.role)
+		}
+		if kind, ok := pod["kind"]; ok && kind != "Pod" {
+			return nil, fmt.Errorf(`%w: the %s template is a "%v", not a Pod`, InvalidPodTemplateError, inline.role, kind)
+		}
+		if !scheduling.empty() {
+			inlines[inline.key] = inline.manifest
+			continue
+		}
+		if err := os.MkdirAll(s.podTemplateTmpDir(), 0700); err != nil {
+			return nil, fmt.Errorf("couldn't create pod template directory, %w", err)
+		}
+		// JSON is valid YAML, spark reads it either way
+		path := filepath.Join(s.podTemplateTmpDir(), jobID+"-"+inline.role+".yaml")
+		if err := os.WriteFile(path, inline.manifest, 0600); err != nil {
+			s.removePodTemplates(jobID)
+			return nil, fmt.Errorf("couldn't write pod template, %w", err)
+		}
+		files[inline.key] = path
+	}
+
+	for _, key := range []string{driverPodTemplateKey, executorPodTemplateKey} {
+		if path, ok := files[key]; ok {
+			args = setConfArg(args, key, path)
+		}
+	}
+	if !scheduling.empty() {
+		merged, err := s.schedulingTemplates(jobID, args, inlines, scheduling)
+		if err != nil {
+			s.removePodTemplates(jobID)
+			return nil, err
+		}
+		for key, path := range merged {
+			args = setConfArg(args, key, path)
+		}
+	}
+	return args, nil
+}
+
+// namedPodTemplate returns the path of a template of the pod template directory
+func (s *Spark) namedPodTemplate(name string) (string, error) {
+	if s.podTemplateDir == "" {
+		return "", fmt.Errorf("%w: no pod template directory configured", InvalidPodTemplateError)
+	}
+	if !filepath.IsLocal(name) || strings.ContainsRune(name, filepath.Separator) {
+		return "", fmt.Errorf("%w: the name must be a file name in the pod template directory", InvalidPodTemplateError)
+	}
+	path := filepath.Join(s.podTemplateDir, name+".yaml")
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf(`%w: pod template "%s" not found`, InvalidPodTemplateError, name)
+	}
+	return path, nil
+}
+
+// removePodTemplates deletes the inline templates of a job
+func (s *Spark) removePodTemplates(jobID string) {
+	paths, _ := filepath.Glob(filepath.Join(s.podTemplateTmpDir(), jobID+"-*.yaml"))
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			zap.L().Warn("couldn't remove pod template", zap.String("jobID", jobID), zap.Error(err))
+		}
+	}
+}
+
+// setConfArg replaces the --conf arg of the key, or adds it in front of the
+// application if there is none
+func setConfArg(args []string, key, value string) []string {
+	arg := "--conf=" + key + "=" + value
+	result := append([]string(nil), args...)
+	for i, existing := range result {
+		if strings.HasPrefix(existing, "--conf="+key+"=") {
+			result[i] = arg
+			return result
+		}
+		if !strings.HasPrefix(existing, "--") {
+			return append(result[:i], append([]string{arg}, result[i:]...)...)
+		}
+	}
+	return append(result, arg)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPodTemplates(t *testing.T) {
+	templateDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(templateDir, "spot.yaml"), []byte("spec:\n  nodeSelector:\n    pool: spot\n"), 0644))
+	newSpark := func(t *testing.T) *Spark {
+		return newTestSpark(t, Config{Master: "k8s://https://kube", PodTemplateDir: templateDir}, nil, "exit 0",
+			map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+	conf := func(args []string, key string) string {
+		for _, arg := range args {
+			if value, ok := strings.CutPrefix(arg, "--conf="+key+"="); ok {
+				return value
+			}
+		}
+		return ""
+	}
+
+	t.Run("writes inline templates and passes them with the named one", func(t *testing.T) {
+		s := newSpark(t)
+		args, err := s.submitArgs("pi", SubmitOptions{})
+		require.NoError(t, err)
+		args, err = s.withPodTemplates("job-1", args, &PodTemplate{
+			Name:   "spot",
+			Driver: json.RawMessage(`{"metadata": {"labels": {"ticket": "DATA-1"}}}`),
+		}, podScheduling{})
+		require.NoError(t, err)
+		defer s.removePodTemplates("job-1")
+
+		require.Equal(t, filepath.Join(templateDir, "spot.yaml"), conf(args, executorPodTemplateKey))
+		driver := conf(args, driverPodTemplateKey)
+		require.Equal(t, filepath.Join(s.podTemplateTmpDir(), "job-1-driver.yaml"), driver)
+		content, err := os.ReadFile(driver)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"metadata": {"labels": {"ticket": "DATA-1"}}}`, string(content))
+		require.Equal(t, "pi.py", args[len(args)-1])
+	})
+
+	t.Run("given invalid templates, returns InvalidPodTemplateError", func(t *testing.T) {
+		s := newSpark(t)
+		args, err := s.submitArgs("pi", SubmitOptions{})
+		require.NoError(t, err)
+		for template, message := range map[*PodTemplate]string{
+			{Name: "nope"}:                                   `pod template "nope" not found`,
+			{Name: "../spot"}:                                "must be a file name",
+			{Executor: json.RawMessage(`["pod"]`)}:           "the executor template must be a pod manifest",
+			{Driver: json.RawMessage(`{"kind": "Service"}`)}: `the driver template is a "Service", not a Pod`,
+		} {
+			_, err := s.withPodTemplates("job-1", args, template, podScheduling{})
+			require.ErrorIs(t, err, InvalidPodTemplateError)
+			require.ErrorContains(t, err, message)
+		}
+
+		s.master = "yarn"
+		args, err = s.submitArgs("pi", SubmitOptions{})
+		require.NoError(t, err)
+		_, err = s.withPodTemplates("job-1", args, &PodTemplate{Name: "spot"}, podScheduling{})
+		require.ErrorContains(t, err, "pod templates need a kubernetes master")
+	})
+
+	t.Run("removes the inline templates once the job finished", func(t *testing.T) {
+		s := newSpark(t)
+		// fails unless the template exists while spark-submit runs
+		s.binaryPath = fakeSparkSubmit(t, `for arg in "$@"; do case "$arg" in --conf=`+executorPodTemplateKey+`=*) test -f "${arg#*=*=}" && exit 0;; esac; done; exit 1`)
+		s.backoff = backoff.Config{Strategy: backoff.Constant, Retries: 1, InitialDelay: time.Millisecond}
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{PodTemplate: &PodTemplate{Executor: json.RawMessage(`{"spec": {}}`)}})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateSucceeded, job.State)
+		require.NoFileExists(t, filepath.Join(s.podTemplateTmpDir(), id+"-executor.yaml"))
+	})
+}
+
+func TestSetConfArg(t *testing.T) {
+	args := []string{"--master=k8s://https://kube", "--conf=a=1", "pi.py", "--arg"}
+	require.Equal(t, []string{"--master=k8s://https://kube", "--conf=a=2", "pi.py", "--arg"}, setConfArg(args, "a", "2"))
+	require.Equal(t, []string{"--master=k8s://https://kube", "--conf=a=1", "--conf=b=1", "pi.py", "--arg"}, setConfArg(args, "b", "1"))
+	require.Equal(t, "--conf=a=1", args[1])
+}
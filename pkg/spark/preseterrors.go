@@ -0,0 +1,67 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var presetLoadErrorsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "preset_load_errors",
+	Help: "The number of preset files of a source that couldn't be loaded on the last load",
+}, []string{"source"})
+
+// presetDirSource is the source of load errors of the preset directory
+const presetDirSource = "directory"
+
+// PresetLoadError is a preset file that was skipped because it couldn't be
+// read or parsed
+type PresetLoadError struct {
+	Source string `json:"source"`
+	File   string `json:"file"`
+	// Preset is the name the preset would have
+	Preset string `json:"preset"`
+	Error  string `json:"error"`
+}
+
+// setPresetLoadErrors replaces the load errors of the source, the caller
+// holds mu
+func (s *Spark) setPresetLoadErrors(source string, errs []PresetLoadError) {
+	if s.presetErrors == nil {
+		s.presetErrors = make(map[string][]PresetLoadError)
+	}
+	s.presetErrors[source] = errs
+	presetLoadErrorsGauge.WithLabelValues(source).Set(float64(len(errs)))
+}
+
+// PresetLoadErrors returns the files of the last load of every source that
+// were skipped, ordered by source and file
+func (s *Spark) PresetLoadErrors() []PresetLoadError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]PresetLoadError, 0)
+	for _, errs := range s.presetErrors {
+		list = append(list, errs...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Source != list[j].Source {
+			return list[i].Source < list[j].Source
+		}
+		return list[i].File < list[j].File
+	})
+	return list
+}
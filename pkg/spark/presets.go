@@ -0,0 +1,690 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	PresetExistsError       error = errors.New("preset already exists")
+	InvalidPresetError      error = errors.New("invalid preset")
+	UnresolvedVariableError error = errors.New("unresolved environment variable")
+	InvalidParamsError      error = errors.New("invalid params")
+	ReadOnlyPresetError     error = errors.New("preset is read-only")
+)
+
+// presetDecoders parse preset files by extension, JSON is parsed as YAML
+// since it is a subset of it
+var presetDecoders = map[string]func(raw []byte, preset *configurationPreset) error{
+	".yaml": decodeYAML,
+	".yml":  decodeYAML,
+	".json": decodeYAML,
+	".toml": decodeTOML,
+}
+
+func decodeYAML(raw []byte, preset *configurationPreset) error {
+	return yaml.Unmarshal(raw, preset)
+}
+
+func decodeTOML(raw []byte, preset *configurationPreset) error {
+	return toml.Unmarshal(raw, preset)
+}
+
+// loadPresets loads all preset files of the preset directory and its
+// subdirectories, presets in subdirectories are named "subdir/preset". If a
+// preset exists in several formats, the first file in lexical order wins.
+func (s *Spark) loadPresets() error {
+	loadErrors := make([]PresetLoadError, 0)
+	err := filepath.WalkDir(s.confDir, func(confPath string, file fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// hidden directories include the ..data directories of mounted ConfigMaps
+		if file.IsDir() {
+			if confPath != s.confDir && strings.HasPrefix(file.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := filepath.Ext(confPath)
+		decode, ok := presetDecoders[ext]
+		if !ok || confPath == filepath.Join(s.confDir, defaultsFileName) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.confDir, confPath)
+		if err != nil {
+			return err
+		}
+		presetName := filepath.ToSlash(strings.TrimSuffix(rel, ext))
+		loadError := PresetLoadError{Source: presetDirSource, File: filepath.ToSlash(rel), Preset: presetName}
+
+		rawConf, err := os.ReadFile(confPath)
+		if err != nil {
+			zap.L().Error("error reading config", zap.Error(err), zap.String("configPath", confPath))
+			loadError.Error = err.Error()
+			loadErrors = append(loadErrors, loadError)
+			return nil
+		}
+
+		var preset configurationPreset
+		if !validPresetName(presetName) {
+			err = invalidPresetNameError(presetName)
+		} else if err = decode(rawConf, &preset); err == nil {
+			err = validatePreset(preset)
+		}
+		if err != nil {
+			zap.L().Warn("couldn't parse preset", zap.Error(err), zap.String("configPath", confPath))
+			loadError.Error = err.Error()
+			loadErrors = append(loadErrors, loadError)
+			return nil
+		}
+
+		if _, ok := s.presets[presetName]; ok {
+			zap.L().Warn("ignoring duplicate preset", zap.String("presetName", presetName), zap.String("configPath", confPath))
+			return nil
+		}
+		s.presets[presetName] = preset
+		s.warnSparkConf(presetName, preset)
+		if ext != ".yaml" {
+			s.presetFiles[presetName] = confPath
+		}
+		zap.L().Debug("loaded preset", zap.String("presetName", presetName))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(`error reading preset directory ("%s"), %w`, s.confDir, err)
+	}
+	s.setPresetLoadErrors(presetDirSource, loadErrors)
+	return nil
+}
+
+var envNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var presetNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// classNamePattern matches fully qualified JVM class names, including nested classes
+var classNamePattern = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*(\.[\p{L}_$][\p{L}\p{N}_$]*)*$`)
+
+// validPresetName checks every slash separated segment of a preset name
+func validPresetName(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if !presetNamePattern.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePreset parses and validates a preset sent through the API. JSON is
+// accepted as well since it is a subset of YAML.
+func parsePreset(name string, raw []byte) (configurationPreset, error) {
+	var preset configurationPreset
+	if !validPresetName(name) {
+		return preset, invalidPresetNameError(name)
+	}
+	if err := yaml.UnmarshalStrict(raw, &preset); err != nil {
+		return preset, fmt.Errorf("%w: %s", InvalidPresetError, err)
+	}
+	return preset, validatePreset(preset)
+}
+
+func invalidPresetNameError(name string) error {
+	return fmt.Errorf(`%w: name "%s" may only contain letters, digits, ".", "_", "-" and "/" between them`, InvalidPresetError, name)
+}
+
+// validatePreset checks a decoded preset, presets of the API, the preset
+// directory and the preset sources all go through it
+func validatePreset(preset configurationPreset) error {
+	if preset.Main == "" {
+		return fmt.Errorf("%w: main is required", InvalidPresetError)
+	}
+	if preset.MainClass != "" {
+		if !classNamePattern.MatchString(preset.MainClass) {
+			return fmt.Errorf(`%w: mainClass "%s" isn't a valid class name`, InvalidPresetError, preset.MainClass)
+		}
+		if !strings.HasSuffix(strings.ToLower(preset.Main), ".jar") {
+			return fmt.Errorf("%w: mainClass needs a jar main", InvalidPresetError)
+		}
+	}
+	for field, values := range map[string][]string{
+		"jars": preset.Jars, "pyFiles": preset.PyFiles, "packages": preset.Packages,
+		"repositories": preset.Repositories, "files": preset.Files, "archives": preset.Archives,
+	} {
+		for _, value := range values {
+			if value == "" || strings.Contains(value, ",") {
+				return fmt.Errorf(`%w: %s entries must be non-empty and can't contain ","`, InvalidPresetError, field)
+			}
+		}
+	}
+	if (preset.Principal == "") != (preset.Keytab == "") {
+		return fmt.Errorf("%w: principal and keytab must be set together", InvalidPresetError)
+	}
+	if preset.Keytab != "" && !filepath.IsLocal(preset.Keytab) {
+		return fmt.Errorf("%w: keytab must be a file name in the keytab directory", InvalidPresetError)
+	}
+	for field, env := range map[string]map[string]string{"driverEnv": preset.DriverEnv, "executorEnv": preset.ExecutorEnv} {
+		for name := range env {
+			if !envNamePattern.MatchString(name) {
+				return fmt.Errorf(`%w: %s name "%s" may only contain letters, digits and "_" and can't start with a digit`, InvalidPresetError, field, name)
+			}
+		}
+	}
+	if preset.Schedule != "" {
+		if _, err := cron.ParseStandard(preset.Schedule); err != nil {
+			return fmt.Errorf("%w: invalid schedule, %s", InvalidPresetError, err)
+		}
+	}
+	if preset.MaxRuntime < 0 {
+		return fmt.Errorf("%w: maxRuntime can't be negative", InvalidPresetError)
+	}
+	if preset.Backoff != nil {
+		if err := preset.Backoff.Validate(); err != nil {
+			return fmt.Errorf("%w: %s", InvalidPresetError, err)
+		}
+	}
+	if err := validateScheduling(preset); err != nil {
+		return err
+	}
+	if preset.GPU != nil {
+		if err := preset.GPU.validate(); err != nil {
+			return err
+		}
+	}
+	if preset.DynamicAllocation != nil {
+		if err := preset.DynamicAllocation.validate(); err != nil {
+			return err
+		}
+	}
+	if preset.PythonEnv != nil {
+		if err := preset.PythonEnv.validate(preset.Main); err != nil {
+			return err
+		}
+	}
+	if preset.R != nil {
+		if !isRMain(preset.Main) {
+			return fmt.Errorf("%w: r options need an R main", InvalidPresetError)
+		}
+		if err := preset.R.validate(); err != nil {
+			return err
+		}
+	}
+	_, err := mapPreset(preset, func(value string) (string, error) {
+		if _, err := template.New("preset").Parse(value); err != nil {
+			return "", fmt.Errorf("%w: %s", InvalidPresetError, err)
+		}
+		for _, match := range variablePattern.FindAllString(value, -1) {
+			if name := match[2 : len(match)-1]; !strings.HasPrefix(match, "$$") && !strings.HasPrefix(name, PresetEnvPrefix) {
+				return "", fmt.Errorf(`%w: variable "%s" doesn't start with %s`, InvalidPresetError, name, PresetEnvPrefix)
+			}
+		}
+		return value, nil
+	})
+	return err
+}
+
+// PresetEnvPrefix is the prefix of the environment variables presets can
+// reference, the other variables of the server like its tokens stay private
+const PresetEnvPrefix = "PRESET_"
+
+// variablePattern matches ${VAR} placeholders, $${VAR} escapes them
+var variablePattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} placeholders with the value of the environment
+// variable, it fails for unset variables and variables without
+// PresetEnvPrefix
+func expandEnv(value string) (string, error) {
+	var err error
+	expanded := variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		name := match[2 : len(match)-1]
+		if !strings.HasPrefix(name, PresetEnvPrefix) {
+			if err == nil {
+				err = fmt.Errorf(`%w "%s", only %s variables are expanded`, UnresolvedVariableError, name, PresetEnvPrefix)
+			}
+			return ""
+		}
+		resolved, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf(`%w "%s"`, UnresolvedVariableError, name)
+		}
+		return resolved
+	})
+	return expanded, err
+}
+
+// keepEnv leaves the ${VAR} placeholders as they are, so that views of a
+// preset don't show the values of the server's environment
+func keepEnv(value string) (string, error) {
+	return value, nil
+}
+
+// renderTemplate executes a Go template with the params of a submission, it
+// fails for params the template uses but the submission doesn't provide
+func renderTemplate(text string, params map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("preset").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", InvalidPresetError, err)
+	}
+	if params == nil {
+		params = map[string]string{}
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, params); err != nil {
+		return "", fmt.Errorf("%w: %s", InvalidParamsError, err)
+	}
+	return rendered.String(), nil
+}
+
+// resolvePreset resolves the environment variables with expand and renders
+// the templates of main, args and the spark configuration values
+func resolvePreset(preset configurationPreset, params map[string]string, expand func(string) (string, error)) (configurationPreset, error) {
+	escaped := make(map[string]string, len(params))
+	for key, value := range params {
+		escaped[key] = escapeSecrets(value)
+	}
+	params = escaped
+	return mapPreset(preset, func(value string) (string, error) {
+		value, err := expand(value)
+		if err != nil {
+			return "", err
+		}
+		// templates are rendered last, so params can't reference variables
+		return renderTemplate(value, params)
+	})
+}
+
+// mapPreset applies fn to main, args and the spark configuration values
+func mapPreset(preset configurationPreset, fn func(string) (string, error)) (configurationPreset, error) {
+	var err error
+	if preset.Main, err = fn(preset.Main); err != nil {
+		return preset, err
+	}
+	if preset.Master, err = fn(preset.Master); err != nil {
+		return preset, err
+	}
+	if preset.Principal, err = fn(preset.Principal); err != nil {
+		return preset, err
+	}
+	if preset.PythonEnv != nil {
+		env := *preset.PythonEnv
+		if env.Archive, err = fn(env.Archive); err != nil {
+			return preset, err
+		}
+		preset.PythonEnv = &env
+	}
+
+	for _, values := range []*[]string{&preset.Args, &preset.Jars, &preset.PyFiles, &preset.Packages, &preset.Repositories, &preset.Files, &preset.Archives} {
+		if *values, err = mapValues(*values, fn); err != nil {
+			return preset, err
+		}
+	}
+
+	for _, values := range []*map[string]string{&preset.SparkConf, &preset.DriverEnv, &preset.ExecutorEnv, &preset.NodeSelector} {
+		if *values, err = mapEntries(*values, fn); err != nil {
+			return preset, err
+		}
+	}
+	return preset, nil
+}
+
+func mapEntries(entries map[string]string, fn func(string) (string, error)) (map[string]string, error) {
+	if entries == nil {
+		return nil, nil
+	}
+	mapped := make(map[string]string, len(entries))
+	for key, value := range entries {
+		var err error
+		if mapped[key], err = fn(value); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+func mapValues(values []string, fn func(string) (string, error)) ([]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	mapped := make([]string, len(values))
+	for i, value := range values {
+		var err error
+		if mapped[i], err = fn(value); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+// redactPattern matches spark configuration keys with sensitive values, it
+// extends the default of spark.redaction.regex
+var redactPattern = regexp.MustCompile(`(?i)secret|password|token|access[.]?key|credential`)
+
+const redacted = "*********(redacted)"
+
+// PresetDetail is the resolved configuration of a preset
+type PresetDetail struct {
+	Name              string                 `json:"name"`
+	Main              string                 `json:"main"`
+	MainClass         string                 `json:"mainClass,omitempty"`
+	Master            string                 `json:"master,omitempty"`
+	Args              []string               `json:"args,omitempty"`
+	Jars              []string               `json:"jars,omitempty"`
+	PyFiles           []string               `json:"pyFiles,omitempty"`
+	Packages          []string               `json:"packages,omitempty"`
+	Repositories      []string               `json:"repositories,omitempty"`
+	Files             []string               `json:"files,omitempty"`
+	Archives          []string               `json:"archives,omitempty"`
+	SparkConf         map[string]string      `json:"sparkConf,omitempty"`
+	Principal         string                 `json:"principal,omitempty"`
+	Keytab            string                 `json:"keytab,omitempty"`
+	DriverEnv         map[string]string      `json:"driverEnv,omitempty"`
+	ExecutorEnv       map[string]string      `json:"executorEnv,omitempty"`
+	Schedule          string                 `json:"schedule,omitempty"`
+	AllowConcurrent   bool                   `json:"allowConcurrent"`
+	Backoff           backoff.Config         `json:"backoff"`
+	MaxRuntime        string                 `json:"maxRuntime,omitempty"`
+	Priority          int                    `json:"priority,omitempty"`
+	NodeSelector      map[string]string      `json:"nodeSelector,omitempty"`
+	Tolerations       []Toleration           `json:"tolerations,omitempty"`
+	Affinity          map[string]interface{} `json:"affinity,omitempty"`
+	PriorityClass     string                 `json:"priorityClass,omitempty"`
+	GPU               *GPU                   `json:"gpu,omitempty"`
+	DynamicAllocation *DynamicAllocation     `json:"dynamicAllocation,omitempty"`
+	R                 *ROptions              `json:"r,omitempty"`
+	PythonEnv         *PythonEnv             `json:"pythonEnv,omitempty"`
+	// Warnings are the problems of the preset that don't prevent submissions
+	Warnings []string `json:"warnings,omitempty"`
+	// Source is the preset source the preset was loaded from, empty for the
+	// preset directory
+	Source string `json:"source,omitempty"`
+	// Revision is the version of the source the preset was loaded at
+	Revision string `json:"revision,omitempty"`
+	// SubmitArgs are the spark-submit arguments of a submission with the
+	// params and no other options
+	SubmitArgs []string `json:"submitArgs"`
+}
+
+// PresetDetail returns the resolved configuration of a preset with the
+// values of sensitive spark configuration keys redacted. Templates are
+// rendered with params, environment variables are shown as their ${VAR}
+// placeholders.
+func (s *Spark) PresetDetail(name string, params map[string]string) (PresetDetail, error) {
+	s.mu.RLock()
+	preset, ok := s.presets[name]
+	source := s.presetSources[name]
+	revision := s.sourceStatus[source].Revision
+	s.mu.RUnlock()
+	if !ok {
+		return PresetDetail{}, PresetNotFoundError
+	}
+	preset = s.withDefaults(preset)
+	args, err := s.presetArgs(name, SubmitOptions{Params: params}, keepEnv)
+	if err != nil {
+		return PresetDetail{}, err
+	}
+
+	return PresetDetail{
+		Name:              name,
+		Main:              preset.Main,
+		MainClass:         preset.MainClass,
+		Master:            preset.Master,
+		Args:              preset.Args,
+		Jars:              preset.Jars,
+		PyFiles:           preset.PyFiles,
+		Packages:          preset.Packages,
+		Repositories:      preset.Repositories,
+		Files:             preset.Files,
+		Archives:          preset.Archives,
+		SparkConf:         redactEntries(preset.SparkConf),
+		Principal:         preset.Principal,
+		Keytab:            preset.Keytab,
+		DriverEnv:         redactEntries(preset.DriverEnv),
+		ExecutorEnv:       redactEntries(preset.ExecutorEnv),
+		Schedule:          preset.Schedule,
+		AllowConcurrent:   preset.AllowConcurrent == nil || *preset.AllowConcurrent,
+		Backoff:           s.presetBackoff(name),
+		MaxRuntime:        formatDuration(preset.MaxRuntime),
+		Priority:          preset.Priority,
+		NodeSelector:      preset.NodeSelector,
+		Tolerations:       preset.Tolerations,
+		Affinity:          affinityDetail(preset.Affinity),
+		PriorityClass:     preset.PriorityClass,
+		GPU:               preset.GPU,
+		DynamicAllocation: preset.DynamicAllocation,
+		R:                 preset.R,
+		PythonEnv:         preset.PythonEnv,
+		Warnings:          s.lintSparkConf(preset.SparkConf),
+		Source:            source,
+		Revision:          revision,
+		SubmitArgs:        redactArgs(args),
+	}, nil
+}
+
+// formatDuration returns an empty string for 0 so that unset durations are
+// omitted
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func redactValue(key, value string) string {
+	if redactPattern.MatchString(key) {
+		return redacted
+	}
+	return value
+}
+
+func redactEntries(entries map[string]string) map[string]string {
+	if len(entries) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(entries))
+	for key, value := range entries {
+		redacted[key] = redactValue(key, value)
+	}
+	return redacted
+}
+
+// redactArgs redacts the values of sensitive --conf arguments
+func redactArgs(args []string) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = arg
+		if conf, ok := strings.CutPrefix(arg, "--conf="); ok {
+			if key, value, ok := strings.Cut(conf, "="); ok {
+				result[i] = "--conf=" + key + "=" + redactValue(key, value)
+			}
+		}
+	}
+	return result
+}
+
+// PresetNames returns the sorted names of all presets
+func (s *Spark) PresetNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.presets))
+	for name := range s.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Schedules returns the cron schedule of every preset that defines one
+func (s *Spark) Schedules() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedules := make(map[string]string)
+	for name, preset := range s.presets {
+		if preset.Schedule != "" {
+			schedules[name] = preset.Schedule
+		}
+	}
+	return schedules
+}
+
+// OnPresetChange registers fn to be called after presets were changed
+// through the API or by a preset source
+func (s *Spark) OnPresetChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *Spark) presetsChanged() {
+	s.mu.RLock()
+	listeners := s.onChange
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// CreatePreset stores a new preset, it fails if the preset already exists
+func (s *Spark) CreatePreset(name string, raw []byte) error {
+	return s.savePreset(name, raw, false)
+}
+
+// PutPreset creates or replaces a preset
+func (s *Spark) PutPreset(name string, raw []byte) error {
+	return s.savePreset(name, raw, true)
+}
+
+func (s *Spark) savePreset(name string, raw []byte, overwrite bool) error {
+	preset, err := parsePreset(name, raw)
+	if err != nil {
+		return err
+	}
+	s.warnSparkConf(name, preset)
+
+	if err := s.storePreset(name, preset, overwrite); err != nil {
+		return err
+	}
+	s.presetsChanged()
+	return nil
+}
+
+func (s *Spark) storePreset(name string, preset configurationPreset, overwrite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if source, ok := s.presetSources[name]; ok {
+		return fmt.Errorf("%w, it is managed by %s", ReadOnlyPresetError, source)
+	}
+	if _, ok := s.presets[name]; ok && !overwrite {
+		return PresetExistsError
+	}
+
+	content, err := yaml.Marshal(preset)
+	if err != nil {
+		return fmt.Errorf("couldn't encode preset, %w", err)
+	}
+	file := filepath.Join(s.confDir, filepath.FromSlash(name)+".yaml")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("couldn't create preset directory, %w", err)
+	}
+	if err := writeFileAtomic(file, content); err != nil {
+		return fmt.Errorf("couldn't write preset, %w", err)
+	}
+	// presets saved through the API are always stored as YAML
+	if file, ok := s.presetFiles[name]; ok {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			zap.L().Warn("couldn't delete previous preset file", zap.Error(err), zap.String("configPath", file))
+		}
+		delete(s.presetFiles, name)
+	}
+
+	s.presets[name] = preset
+	zap.L().Info("saved preset", zap.String("presetName", name))
+	return nil
+}
+
+func (s *Spark) DeletePreset(name string) error {
+	if err := s.removePreset(name); err != nil {
+		return err
+	}
+	s.presetsChanged()
+	return nil
+}
+
+func (s *Spark) removePreset(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.presets[name]; !ok {
+		return PresetNotFoundError
+	}
+	if source, ok := s.presetSources[name]; ok {
+		return fmt.Errorf("%w, it is managed by %s", ReadOnlyPresetError, source)
+	}
+
+	if err := os.Remove(s.presetPath(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("couldn't delete preset, %w", err)
+	}
+
+	delete(s.presets, name)
+	delete(s.presetFiles, name)
+	zap.L().Info("deleted preset", zap.String("presetName", name))
+	return nil
+}
+
+func (s *Spark) presetPath(name string) string {
+	if file, ok := s.presetFiles[name]; ok {
+		return file
+	}
+	return filepath.Join(s.confDir, filepath.FromSlash(name)+".yaml")
+}
+
+// writeFileAtomic makes sure a concurrent reader never sees a partially
+// written preset
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".preset-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
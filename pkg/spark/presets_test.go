@@ -0,0 +1,351 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func newPresetSpark(t *testing.T) *Spark {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+	s, err := New(Config{SparkHome: ".", PresetDir: dir}, jobs.NewMemoryStore())
+	require.NoError(t, err)
+	return s
+}
+
+func TestPresets(t *testing.T) {
+	t.Run("CreatePreset persists the preset to the conf dir", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.NoError(t, s.CreatePreset("etl", []byte(`{"main": "etl.py", "args": ["1"]}`)))
+		require.Equal(t, configurationPreset{Main: "etl.py", Args: []string{"1"}}, s.presets["etl"])
+
+		s, err := New(Config{SparkHome: ".", PresetDir: s.confDir}, jobs.NewMemoryStore())
+		require.NoError(t, err)
+		require.Equal(t, "etl.py", s.presets["etl"].Main)
+	})
+
+	t.Run("PresetNames returns the sorted names", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.NoError(t, s.CreatePreset("etl", []byte("main: etl.py")))
+		require.Equal(t, []string{"etl", "pi"}, s.PresetNames())
+	})
+
+	t.Run("PresetDetail resolves the preset and redacts sensitive values", func(t *testing.T) {
+		s := newPresetSpark(t)
+		s.master = "k8s://cluster"
+		require.NoError(t, s.CreatePreset("etl", []byte(`
+main: etl.py
+args: [daily]
+sparkConf:
+  spark.executor.instances: "4"
+  spark.hadoop.fs.s3a.access.key: AKIA123
+  spark.hadoop.fs.s3a.secret.key: shh
+driverEnv:
+  DB_PASSWORD: hunter2
+backoff:
+  retries: 3
+`)))
+
+		detail, err := s.PresetDetail("etl", nil)
+		require.NoError(t, err)
+		require.True(t, detail.AllowConcurrent)
+		require.Equal(t, 3, detail.Backoff.Retries)
+		require.Equal(t, backoff.Default.InitialDelay, detail.Backoff.InitialDelay)
+		require.Equal(t, map[string]string{
+			"spark.executor.instances":       "4",
+			"spark.hadoop.fs.s3a.access.key": redacted,
+			"spark.hadoop.fs.s3a.secret.key": redacted,
+		}, detail.SparkConf)
+		require.Equal(t, map[string]string{"DB_PASSWORD": redacted}, detail.DriverEnv)
+		require.Equal(t, []string{
+			"--master=k8s://cluster",
+			"--deploy-mode=cluster",
+			"--name=etl",
+			"--conf=spark.executor.instances=4",
+			"--conf=spark.hadoop.fs.s3a.access.key=" + redacted,
+			"--conf=spark.hadoop.fs.s3a.secret.key=" + redacted,
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=etl",
+			"--conf=spark.kubernetes.driverEnv.DB_PASSWORD=" + redacted,
+			"etl.py",
+			"daily",
+		}, detail.SubmitArgs)
+
+		_, err = s.PresetDetail("nope", nil)
+		require.ErrorIs(t, err, PresetNotFoundError)
+	})
+
+	t.Run("PresetDetail keeps the environment variables of the submit args", func(t *testing.T) {
+		t.Setenv("PRESET_IMAGE_TAG", "1.2.3")
+		s := newPresetSpark(t)
+		require.NoError(t, s.CreatePreset("etl", []byte(`
+main: s3a://bucket/etl-${PRESET_IMAGE_TAG}.py
+sparkConf:
+  spark.kubernetes.container.image: spark:${PRESET_IMAGE_TAG}
+`)))
+
+		detail, err := s.PresetDetail("etl", nil)
+		require.NoError(t, err)
+		require.Equal(t, "spark:${PRESET_IMAGE_TAG}", detail.SparkConf["spark.kubernetes.container.image"])
+		require.Contains(t, detail.SubmitArgs, "--conf=spark.kubernetes.container.image=spark:${PRESET_IMAGE_TAG}")
+		require.Contains(t, detail.SubmitArgs, "s3a://bucket/etl-${PRESET_IMAGE_TAG}.py")
+		require.NotContains(t, strings.Join(detail.SubmitArgs, " "), "1.2.3")
+	})
+
+	t.Run("CreatePreset fails for existing presets", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.ErrorIs(t, s.CreatePreset("pi", []byte("main: other.py")), PresetExistsError)
+	})
+
+	t.Run("PutPreset replaces existing presets", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.NoError(t, s.PutPreset("pi", []byte("main: other.py")))
+		require.Equal(t, "other.py", s.presets["pi"].Main)
+	})
+
+	t.Run("given an invalid preset, returns InvalidPresetError", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.ErrorIs(t, s.PutPreset("etl", []byte("args: [1]")), InvalidPresetError)
+		require.ErrorIs(t, s.PutPreset("etl", []byte("main: etl.py\nunknown: true")), InvalidPresetError)
+		require.ErrorIs(t, s.PutPreset("../etl", []byte("main: etl.py")), InvalidPresetError)
+		require.ErrorIs(t, s.PutPreset("etl", []byte("main: etl.py\nschedule: sometimes")), InvalidPresetError)
+		require.ErrorIs(t, s.PutPreset("etl", []byte("main: etl.py\nbackoff: {strategy: random}")), InvalidPresetError)
+		require.ErrorIs(t, s.PutPreset("etl", []byte("main: etl.py\nargs: ['{{ .date'] ")), InvalidPresetError)
+		require.ErrorIs(t, s.PutPreset("etl", []byte("main: etl.py\nargs: ['${AUTH_TOKEN}'] ")), InvalidPresetError)
+	})
+
+	t.Run("given an invalid environment variable name, returns InvalidPresetError", func(t *testing.T) {
+		s := newPresetSpark(t)
+		err := s.CreatePreset("env", []byte("main: etl.py\nexecutorEnv: {1ST-VAR: x}"))
+		require.ErrorIs(t, err, InvalidPresetError)
+		require.ErrorContains(t, err, `executorEnv name "1ST-VAR"`)
+	})
+
+	t.Run("given a dependency with a comma, returns InvalidPresetError", func(t *testing.T) {
+		s := newPresetSpark(t)
+		err := s.CreatePreset("deps", []byte("main: etl.py\njars: [a.jar,b.jar, 'c.jar,d.jar']"))
+		require.ErrorIs(t, err, InvalidPresetError)
+		require.ErrorContains(t, err, "jars entries")
+	})
+
+	t.Run("given a principal without keytab or a keytab path, returns InvalidPresetError", func(t *testing.T) {
+		s := newPresetSpark(t)
+		err := s.CreatePreset("krb", []byte("main: etl.jar\nprincipal: etl@EXAMPLE.COM"))
+		require.ErrorIs(t, err, InvalidPresetError)
+		err = s.CreatePreset("krb", []byte("main: etl.jar\nprincipal: etl@EXAMPLE.COM\nkeytab: /etc/etl.keytab"))
+		require.ErrorIs(t, err, InvalidPresetError)
+	})
+
+	t.Run("passes the mainClass of jar mains", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.NoError(t, s.PutPreset("jvm", []byte("main: s3a://jobs/etl-1.0.JAR\nmainClass: com.example.etl.Main$Job")))
+		args, err := s.submitArgs("jvm", SubmitOptions{})
+		require.NoError(t, err)
+		require.Contains(t, args, "--class=com.example.etl.Main$Job")
+		require.Equal(t, "s3a://jobs/etl-1.0.JAR", args[len(args)-1])
+	})
+
+	t.Run("given a mainClass without a jar main or an invalid one, returns InvalidPresetError", func(t *testing.T) {
+		s := newPresetSpark(t)
+		err := s.CreatePreset("jvm", []byte("main: etl.py\nmainClass: com.example.Main"))
+		require.ErrorIs(t, err, InvalidPresetError)
+		require.ErrorContains(t, err, "mainClass needs a jar main")
+		err = s.CreatePreset("jvm", []byte("main: etl.jar\nmainClass: com..Main"))
+		require.ErrorIs(t, err, InvalidPresetError)
+		require.ErrorContains(t, err, `mainClass "com..Main" isn't a valid class name`)
+	})
+
+	t.Run("parses backoff overrides", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.NoError(t, s.PutPreset("etl", []byte("main: etl.py\nbackoff:\n  strategy: linear\n  initialDelay: 30s\n  jitter: 0.3")))
+		require.Equal(t, &backoff.Config{Strategy: backoff.Linear, InitialDelay: 30 * time.Second, Jitter: 0.3}, s.presets["etl"].Backoff)
+
+		s, err := New(Config{SparkHome: ".", PresetDir: s.confDir}, jobs.NewMemoryStore())
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Second, s.presets["etl"].Backoff.InitialDelay)
+	})
+
+	t.Run("notifies listeners and exposes schedules", func(t *testing.T) {
+		s := newPresetSpark(t)
+		changes := 0
+		s.OnPresetChange(func() { changes++ })
+
+		require.NoError(t, s.PutPreset("etl", []byte("main: etl.py\nschedule: 0 3 * * *")))
+		require.Equal(t, map[string]string{"etl": "0 3 * * *"}, s.Schedules())
+		require.NoError(t, s.DeletePreset("etl"))
+		require.Empty(t, s.Schedules())
+		require.Equal(t, 2, changes)
+	})
+
+	t.Run("DeletePreset removes the preset and its file", func(t *testing.T) {
+		s := newPresetSpark(t)
+		require.NoError(t, s.DeletePreset("pi"))
+		require.NotContains(t, s.presets, "pi")
+		require.NoFileExists(t, filepath.Join(s.confDir, "pi.yaml"))
+		require.ErrorIs(t, s.DeletePreset("pi"), PresetNotFoundError)
+	})
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("PRESET_BUCKET", "data")
+	t.Setenv("PRESET_EMPTY", "")
+
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{value: "s3a://${PRESET_BUCKET}/jobs", want: "s3a://data/jobs"},
+		{value: "${PRESET_BUCKET}-${PRESET_BUCKET}", want: "data-data"},
+		{value: "x${PRESET_EMPTY}y", want: "xy"},
+		{value: "$${PRESET_BUCKET}", want: "${PRESET_BUCKET}"},
+		{value: "$PRESET_BUCKET", want: "$PRESET_BUCKET"},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := expandEnv(tt.value)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("given an unset variable, returns UnresolvedVariableError", func(t *testing.T) {
+		_, err := expandEnv("${PRESET_UNSET}")
+		require.ErrorIs(t, err, UnresolvedVariableError)
+		require.ErrorContains(t, err, "PRESET_UNSET")
+	})
+
+	t.Run("given a variable without the prefix, returns UnresolvedVariableError", func(t *testing.T) {
+		t.Setenv("AUTH_TOKEN", "secret")
+		got, err := expandEnv("${AUTH_TOKEN}")
+		require.ErrorIs(t, err, UnresolvedVariableError)
+		require.NotContains(t, got, "secret")
+	})
+}
+
+func TestRenderTemplate(t *testing.T) {
+	t.Run("renders the params", func(t *testing.T) {
+		got, err := renderTemplate("--date={{ .date }}", map[string]string{"date": "2023-06-01"})
+		require.NoError(t, err)
+		require.Equal(t, "--date=2023-06-01", got)
+	})
+
+	t.Run("given a value without placeholders, returns it as is", func(t *testing.T) {
+		got, err := renderTemplate("{ not a template }", nil)
+		require.NoError(t, err)
+		require.Equal(t, "{ not a template }", got)
+	})
+
+	t.Run("given a missing param, returns InvalidParamsError", func(t *testing.T) {
+		_, err := renderTemplate("{{ .date }}", nil)
+		require.ErrorIs(t, err, InvalidParamsError)
+	})
+
+	t.Run("doesn't expand variables in params", func(t *testing.T) {
+		t.Setenv("PRESET_SECRET", "shh")
+		preset, err := resolvePreset(configurationPreset{Main: "{{ .file }}"}, map[string]string{"file": "${PRESET_SECRET}"}, expandEnv)
+		require.NoError(t, err)
+		require.Equal(t, "${PRESET_SECRET}", preset.Main)
+	})
+}
+
+func TestLoadPresets(t *testing.T) {
+	dir := t.TempDir()
+	for file, content := range map[string]string{
+		"pi.yaml":               "main: pi.py\n",
+		"etl.yml":               "main: etl.py\n",
+		"sql.json":              `{"main": "sql.py", "sparkConf": {"spark.executor.instances": "2"}}`,
+		"ml.toml":               "main = \"ml.py\"\nargs = [\"--fast\"]\nallowConcurrent = false\n\n[backoff]\nretries = 3\ninitialDelay = \"5s\"\n",
+		"pi.json":               `{"main": "other.py"}`,
+		"notes.txt":             "not a preset",
+		"broken.yaml":           "main: [unterminated\n",
+		"secret.yaml":           "main: secret.py\nargs: [\"${TOKEN}\"]\n",
+		"team/no-main.toml":     "args = [\"--fast\"]\n",
+		"team/etl.yaml":         "main: team-etl.py\n",
+		"team/nightly/sql.toml": "main = \"nightly.py\"\n",
+		"team/..data/etl.yaml":  "main: hidden.py\n",
+		".hidden/preset.yaml":   "main: hidden.py\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+	}
+	s, err := New(Config{SparkHome: ".", PresetDir: dir}, jobs.NewMemoryStore())
+	require.NoError(t, err)
+
+	t.Run("loads all formats", func(t *testing.T) {
+		require.Equal(t, []string{"etl", "ml", "pi", "sql", "team/etl", "team/nightly/sql"}, s.PresetNames())
+		require.Equal(t, "etl.py", s.presets["etl"].Main)
+		require.Equal(t, "2", s.presets["sql"].SparkConf["spark.executor.instances"])
+		require.Equal(t, []string{"--fast"}, s.presets["ml"].Args)
+		require.False(t, *s.presets["ml"].AllowConcurrent)
+		require.Equal(t, &backoff.Config{Retries: 3, InitialDelay: 5 * time.Second}, s.presets["ml"].Backoff)
+	})
+
+	t.Run("given a preset in several formats, the first file in lexical order wins", func(t *testing.T) {
+		require.Equal(t, "other.py", s.presets["pi"].Main)
+	})
+
+	t.Run("names presets in subdirectories after their path and skips hidden directories", func(t *testing.T) {
+		require.Equal(t, "team-etl.py", s.presets["team/etl"].Main)
+		require.Equal(t, "nightly.py", s.presets["team/nightly/sql"].Main)
+	})
+
+	t.Run("reports files that can't be parsed", func(t *testing.T) {
+		errs := s.PresetLoadErrors()
+		require.Len(t, errs, 3)
+		require.Equal(t, "directory", errs[0].Source)
+		require.Equal(t, "broken.yaml", errs[0].File)
+		require.Equal(t, "broken", errs[0].Preset)
+		require.NotEmpty(t, errs[0].Error)
+	})
+
+	t.Run("validates the presets like presets of the API", func(t *testing.T) {
+		require.NotContains(t, s.presets, "secret")
+		require.NotContains(t, s.presets, "team/no-main")
+		errs := s.PresetLoadErrors()
+		require.Equal(t, "secret.yaml", errs[1].File)
+		require.Contains(t, errs[1].Error, `variable "TOKEN" doesn't start with PRESET_`)
+		require.Equal(t, "team/no-main.toml", errs[2].File)
+		require.Contains(t, errs[2].Error, "main is required")
+	})
+
+	t.Run("CreatePreset stores presets with subdirectories in nested paths", func(t *testing.T) {
+		require.NoError(t, s.CreatePreset("other-team/report", []byte("main: report.py")))
+		require.FileExists(t, filepath.Join(dir, "other-team", "report.yaml"))
+	})
+
+	t.Run("given a name with an invalid path, returns InvalidPresetError", func(t *testing.T) {
+		for _, name := range []string{"../etl", "team//etl", "/etl", "team/"} {
+			require.ErrorIs(t, s.CreatePreset(name, []byte("main: etl.py")), InvalidPresetError, name)
+		}
+	})
+
+	t.Run("PutPreset replaces files of other formats with YAML", func(t *testing.T) {
+		require.NoError(t, s.PutPreset("ml", []byte("main: ml2.py")))
+		require.NoFileExists(t, filepath.Join(dir, "ml.toml"))
+		require.FileExists(t, filepath.Join(dir, "ml.yaml"))
+	})
+
+	t.Run("DeletePreset deletes files of other formats", func(t *testing.T) {
+		require.NoError(t, s.DeletePreset("sql"))
+		require.NoFileExists(t, filepath.Join(dir, "sql.json"))
+	})
+}
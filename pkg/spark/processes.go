@@ -0,0 +1,150 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var processGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "spark_submit_processes",
+	Help: "The number of spark-submit processes that are currently running",
+})
+
+var processWaitingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "spark_submit_processes_waiting",
+	Help: "The number of spark-submit processes waiting for a free slot",
+})
+
+var forcedKillCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spark_submit_forced_kills_total",
+	Help: "The number of spark-submit process groups that were killed because they were cancelled or timed out",
+})
+
+// reapTimeout limits how long the killed processes of a group are waited for
+const reapTimeout = time.Second
+
+// processLimiter caps the spark-submit processes of submits, status and kill
+// requests together, every process starts a JVM. A nil processLimiter
+// doesn't limit anything.
+type processLimiter struct {
+	mu sync.Mutex
+	// limit is the maximum number of processes, 0 means unlimited
+	limit int
+	used  int
+	// freed is closed and replaced whenever a slot may have become free
+	freed chan struct{}
+}
+
+func newProcessLimiter(limit int) *processLimiter {
+	return &processLimiter{limit: limit, freed: make(chan struct{})}
+}
+
+// acquire waits for a free slot until ctx is done
+func (p *processLimiter) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	waiting := false
+	for {
+		p.mu.Lock()
+		if p.limit <= 0 || p.used < p.limit {
+			p.used++
+			p.mu.Unlock()
+			if waiting {
+				processWaitingGauge.Dec()
+			}
+			return nil
+		}
+		freed := p.freed
+		p.mu.Unlock()
+
+		if !waiting {
+			waiting = true
+			processWaitingGauge.Inc()
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			processWaitingGauge.Dec()
+			return fmt.Errorf("no free slot for spark-submit, %w", ctx.Err())
+		}
+	}
+}
+
+func (p *processLimiter) release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used--
+	p.notify()
+}
+
+// setLimit changes the maximum number of processes, 0 means unlimited.
+// Running processes beyond a lower limit finish undisturbed.
+func (p *processLimiter) setLimit(limit int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = limit
+	p.notify()
+}
+
+func (p *processLimiter) getLimit() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limit
+}
+
+// notify wakes up the waiting processes, the caller must hold the mutex
+func (p *processLimiter) notify() {
+	close(p.freed)
+	p.freed = make(chan struct{})
+}
+
+// SubmitRunner runs a single spark-submit attempt with the args and writes
+// its output, Spark starts the spark-submit binary if none is configured
+type SubmitRunner interface {
+	RunSubmit(ctx context.Context, args []string, output io.Writer) error
+}
+
+// runCommand runs a spark-submit process once the process limit allows it
+func (s *Spark) runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := s.processes.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.processes.release()
+	processGauge.Inc()
+	defer processGauge.Dec()
+	err := cmd.Run()
+	if ctx.Err() != nil && cmd.Process != nil {
+		reapProcessGroup(cmd.Process.Pid)
+	}
+	return err
+}
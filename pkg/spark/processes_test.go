@@ -0,0 +1,85 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessLimiter(t *testing.T) {
+	t.Run("given no limit, never waits", func(t *testing.T) {
+		limiter := newProcessLimiter(0)
+		require.NoError(t, limiter.acquire(context.Background()))
+		require.NoError(t, limiter.acquire(context.Background()))
+		limiter.release()
+		limiter.release()
+	})
+
+	t.Run("given a higher limit, starts waiting processes", func(t *testing.T) {
+		limiter := newProcessLimiter(1)
+		require.NoError(t, limiter.acquire(context.Background()))
+
+		acquired := make(chan error)
+		go func() { acquired <- limiter.acquire(context.Background()) }()
+		limiter.setLimit(2)
+		require.NoError(t, <-acquired)
+		require.Equal(t, 2, limiter.getLimit())
+	})
+
+	t.Run("waits for a free slot", func(t *testing.T) {
+		limiter := newProcessLimiter(1)
+		require.NoError(t, limiter.acquire(context.Background()))
+
+		acquired := make(chan error)
+		go func() { acquired <- limiter.acquire(context.Background()) }()
+		select {
+		case <-acquired:
+			t.Fatal("acquired a slot while the limit is reached")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		limiter.release()
+		require.NoError(t, <-acquired)
+	})
+
+	t.Run("gives up when ctx is done", func(t *testing.T) {
+		limiter := newProcessLimiter(1)
+		require.NoError(t, limiter.acquire(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, limiter.acquire(ctx), context.DeadlineExceeded)
+	})
+}
+
+func TestRunCommand(t *testing.T) {
+	s := &Spark{binaryPath: fakeSparkSubmit(t, "sleep 0.2"), processes: newProcessLimiter(1)}
+	go func() { _ = s.runCommand(context.Background(), s.command(context.Background(), nil)) }()
+	require.Eventually(t, func() bool {
+		s.processes.mu.Lock()
+		defer s.processes.mu.Unlock()
+		return s.processes.used == 1
+	}, time.Second, time.Millisecond)
+
+	// a second process waits for the running one and fails once its deadline
+	// is reached
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.runCommand(ctx, s.command(ctx, nil)), context.DeadlineExceeded)
+	require.NoError(t, s.runCommand(context.Background(), s.command(context.Background(), nil)))
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts spark-submit in its own process group and kills the
+// whole group on cancellation, otherwise the JVM and the processes it started
+// outlive the shell script
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// the negative pid addresses the group, its id is the pid of the leader
+		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+		if err == nil {
+			forcedKillCounter.Inc()
+		}
+		return err
+	}
+}
+
+// reapProcessGroup collects the killed processes of the group that were
+// re-parented to the server, which happens when it runs as PID 1 of a
+// container. The leader has to be waited for already.
+func reapProcessGroup(pgid int) {
+	deadline := time.Now().Add(reapTimeout)
+	for {
+		pid, err := syscall.Wait4(-pgid, nil, syscall.WNOHANG, nil)
+		if err != nil {
+			// ECHILD, no process of the group is a child of the server
+			return
+		}
+		if pid == 0 {
+			if time.Now().After(deadline) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
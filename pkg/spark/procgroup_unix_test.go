@@ -0,0 +1,78 @@
+//go:build unix
+
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// processGone reports whether the process exited, zombies count as gone
+func processGone(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat))
+	return len(fields) > 2 && fields[2] == "Z"
+}
+
+func TestProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc")
+	}
+
+	t.Run("kills the children of a cancelled spark-submit", func(t *testing.T) {
+		pidFile := filepath.Join(t.TempDir(), "pid")
+		s := &Spark{binaryPath: fakeSparkSubmit(t, "sleep 30 & echo $! > "+pidFile+"; wait")}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- s.runCommand(ctx, s.command(ctx, nil)) }()
+
+		var pid int
+		require.Eventually(t, func() bool {
+			raw, err := os.ReadFile(pidFile)
+			if err != nil {
+				return false
+			}
+			pid, err = strconv.Atoi(strings.TrimSpace(string(raw)))
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-done:
+			require.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("spark-submit wasn't killed")
+		}
+		require.Eventually(t, func() bool { return processGone(pid) }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("runs spark-submit in its own process group", func(t *testing.T) {
+		s := &Spark{binaryPath: fakeSparkSubmit(t, "true")}
+		cmd := s.command(context.Background(), nil)
+		require.True(t, cmd.SysProcAttr.Setpgid)
+		require.NoError(t, s.runCommand(context.Background(), cmd))
+	})
+}
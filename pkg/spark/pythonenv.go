@@ -0,0 +1,97 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultPythonEnvName   = "environment"
+	defaultPythonEnvPython = "bin/python"
+)
+
+// PythonEnv ships a packed virtualenv or conda environment with a PySpark
+// application, e.g. one built with venv-pack or conda-pack
+type PythonEnv struct {
+	// Archive is the packed environment, it's added to the archives
+	Archive string `yaml:"archive" json:"archive"`
+	// Name is the directory the archive is unpacked to, "environment" by default
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Python is the interpreter inside the environment, "bin/python" by default
+	Python string `yaml:"python,omitempty" json:"python,omitempty"`
+}
+
+var pythonEnvNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func (e PythonEnv) validate(main string) error {
+	if !strings.HasSuffix(strings.ToLower(main), ".py") {
+		return fmt.Errorf("%w: pythonEnv needs a Python main", InvalidPresetError)
+	}
+	if e.Archive == "" || strings.ContainsAny(e.Archive, ",#") {
+		return fmt.Errorf(`%w: pythonEnv archive is required and can't contain "," or "#"`, InvalidPresetError)
+	}
+	if e.Name != "" && (!pythonEnvNamePattern.MatchString(e.Name) || e.Name == "." || e.Name == "..") {
+		return fmt.Errorf(`%w: pythonEnv name "%s" may only contain letters, digits, ".", "_" and "-"`, InvalidPresetError, e.Name)
+	}
+	if e.Python != "" && (path.IsAbs(e.Python) || strings.HasPrefix(path.Clean(e.Python), "..")) {
+		return fmt.Errorf("%w: pythonEnv python must be a path inside the environment", InvalidPresetError)
+	}
+	return nil
+}
+
+// archive returns the archives entry that unpacks the environment
+func (e PythonEnv) archive() string {
+	name := e.Name
+	if name == "" {
+		name = defaultPythonEnvName
+	}
+	return e.Archive + "#" + name
+}
+
+// interpreter returns the python executable relative to the working
+// directory of the driver and executors
+func (e PythonEnv) interpreter() string {
+	name, python := e.Name, e.Python
+	if name == "" {
+		name = defaultPythonEnvName
+	}
+	if python == "" {
+		python = defaultPythonEnvPython
+	}
+	return "./" + path.Join(name, python)
+}
+
+// pythonEnvConf points the driver and executors at the interpreter of the
+// environment, the PYSPARK_PYTHON variables cover the cluster managers that
+// don't read spark.pyspark.python before the driver starts
+func pythonEnvConf(env *PythonEnv, master string) map[string]string {
+	conf := map[string]string{}
+	if env == nil {
+		return conf
+	}
+	python := env.interpreter()
+	conf["spark.pyspark.python"] = python
+	conf[executorEnvPrefix+"PYSPARK_PYTHON"] = python
+	switch {
+	case strings.HasPrefix(master, "k8s://"):
+		conf[driverEnvPrefix+"PYSPARK_PYTHON"] = python
+	case master == "yarn":
+		conf["spark.yarn.appMasterEnv.PYSPARK_PYTHON"] = python
+	}
+	return conf
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPythonEnv(t *testing.T) {
+	t.Run("ships the environment and points the interpreters at it", func(t *testing.T) {
+		t.Setenv("PRESET_ENV_BUCKET", "envs")
+		s := &Spark{
+			presets: map[string]configurationPreset{"etl": {
+				Main:      "s3a://jobs/etl.py",
+				Archives:  []string{"s3a://data/lookup.zip#lookup"},
+				PythonEnv: &PythonEnv{Archive: "s3a://${PRESET_ENV_BUCKET}/etl-{{.version}}.tar.gz"},
+				DriverEnv: map[string]string{"PYSPARK_PYTHON": "./custom/bin/python"},
+			}},
+			master: "k8s://https://kube",
+		}
+		args, err := s.submitArgs("etl", SubmitOptions{Params: map[string]string{"version": "1.2"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"--master=k8s://https://kube",
+			"--deploy-mode=cluster",
+			"--name=etl",
+			"--archives=s3a://data/lookup.zip#lookup,s3a://envs/etl-1.2.tar.gz#environment",
+			"--conf=spark.executorEnv.PYSPARK_PYTHON=./environment/bin/python",
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=etl",
+			"--conf=spark.kubernetes.driverEnv.PYSPARK_PYTHON=./custom/bin/python",
+			"--conf=spark.pyspark.python=./environment/bin/python",
+			"s3a://jobs/etl.py",
+		}, args)
+		require.Equal(t, []string{"s3a://data/lookup.zip#lookup"}, s.presets["etl"].Archives)
+	})
+
+	t.Run("sets the application master environment on YARN", func(t *testing.T) {
+		require.Equal(t, map[string]string{
+			"spark.pyspark.python":                   "./conda/envs/bin/python3",
+			"spark.executorEnv.PYSPARK_PYTHON":       "./conda/envs/bin/python3",
+			"spark.yarn.appMasterEnv.PYSPARK_PYTHON": "./conda/envs/bin/python3",
+		}, pythonEnvConf(&PythonEnv{Archive: "hdfs:///envs/conda.tar.gz", Name: "conda", Python: "envs/bin/python3"}, "yarn"))
+		require.Empty(t, pythonEnvConf(nil, "yarn"))
+	})
+
+	t.Run("given an invalid environment, returns InvalidPresetError", func(t *testing.T) {
+		for raw, message := range map[string]string{
+			"main: etl.jar\npythonEnv: {archive: env.tar.gz}":               "pythonEnv needs a Python main",
+			"main: etl.py\npythonEnv: {name: env}":                          "archive is required",
+			"main: etl.py\npythonEnv: {archive: 'env.tar.gz#env'}":          "archive is required",
+			"main: etl.py\npythonEnv: {archive: env.tar.gz, name: a/b}":     `name "a/b"`,
+			"main: etl.py\npythonEnv: {archive: env.tar.gz, python: ../py}": "path inside the environment",
+		} {
+			_, err := parsePreset("etl", []byte(raw))
+			require.ErrorIs(t, err, InvalidPresetError, raw)
+			require.ErrorContains(t, err, message, raw)
+		}
+	})
+}
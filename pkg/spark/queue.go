@@ -0,0 +1,204 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var restoredCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_queue_restored_total",
+	Help: "The number of scheduled and pending submissions that were queued again after a restart",
+}, []string{"tenant", "preset"})
+
+// PodTemplateLostError fails restored submissions whose generated pod
+// templates didn't survive the restart
+var PodTemplateLostError error = errors.New("pod template lost with the restart")
+
+// persistedSubmission is what the durable queue keeps of a submission until
+// it starts, the preset, priority and run time are part of the job
+type persistedSubmission struct {
+	Args  []string              `json:"args"`
+	Retry *persistedRetryPolicy `json:"retry,omitempty"`
+}
+
+// persistedRetryPolicy stores the delays of a RetryPolicy as well, which its
+// JSON encoding for requests leaves out
+type persistedRetryPolicy struct {
+	Retries      *int          `json:"retries,omitempty"`
+	InitialDelay time.Duration `json:"initialDelay,omitempty"`
+	MaxDelay     time.Duration `json:"maxDelay,omitempty"`
+}
+
+// persistSubmission keeps the submission of the job in the durable queue
+// until it starts, it does nothing without a queue
+func (s *Spark) persistSubmission(jobID string, args []string, retry *RetryPolicy) error {
+	if s.queue == nil {
+		return nil
+	}
+	submission := persistedSubmission{Args: args}
+	if retry != nil {
+		submission.Retry = &persistedRetryPolicy{Retries: retry.Retries, InitialDelay: retry.InitialDelay, MaxDelay: retry.MaxDelay}
+	}
+	raw, err := json.Marshal(submission)
+	if err != nil {
+		return fmt.Errorf("couldn't encode submission, %w", err)
+	}
+	if err := s.queue.PutSubmission(jobID, raw); err != nil {
+		return fmt.Errorf("couldn't persist submission, %w", err)
+	}
+	return nil
+}
+
+// forgetSubmission removes the submission of the job from the durable queue
+// once it started or ended
+func (s *Spark) forgetSubmission(jobID string) {
+	if s.queue == nil {
+		return
+	}
+	if err := s.queue.DeleteSubmission(jobID); err != nil {
+		zap.L().Warn("couldn't remove queued submission", zap.String("jobID", jobID), zap.Error(err))
+	}
+}
+
+// RestoreQueue queues the scheduled and pending submissions of the durable
+// queue again after a restart, in the order they were submitted. Submissions
+// that can't be restored fail. With a shared job store, it takes over the
+// submissions of replicas whose lease expired and leaves the ones of the live
+// replicas alone. It returns the ids of the restored jobs.
+func (s *Spark) RestoreQueue(ctx context.Context) ([]string, error) {
+	restored := make([]string, 0)
+	if s.queue == nil {
+		return restored, nil
+	}
+	stored, err := s.queue.Submissions()
+	if err != nil {
+		return restored, fmt.Errorf("couldn't list queued submissions, %w", err)
+	}
+	list, err := s.jobs.List()
+	if err != nil {
+		return restored, fmt.Errorf("couldn't list jobs, %w", err)
+	}
+	live, err := jobs.LiveReplicas(s.jobs)
+	if err != nil {
+		return restored, err
+	}
+
+	for _, job := range list {
+		raw, ok := stored[job.ID]
+		if !ok {
+			continue
+		}
+		delete(stored, job.ID)
+		if live[job.Replica] {
+			continue
+		}
+		if job.State != jobs.StateScheduled && job.State != jobs.StatePending {
+			s.forgetSubmission(job.ID)
+			continue
+		}
+		if !s.claim(job) {
+			continue
+		}
+		if err := s.restoreSubmission(ctx, job, raw); err != nil {
+			zap.L().Error("couldn't restore queued submission", zap.String("jobID", job.ID), zap.Error(err))
+			s.setJobState(job.ID, jobs.StateFailed, func(j *jobs.Job) { j.Error = err.Error() })
+			continue
+		}
+		restoredCounter.WithLabelValues(s.tenant(job.Preset), job.Preset).Inc()
+		restored = append(restored, job.ID)
+	}
+	// the jobs of the remaining submissions were deleted, unless another
+	// replica is about to store them
+	if s.replica == "" {
+		for id := range stored {
+			s.forgetSubmission(id)
+		}
+	}
+	return restored, nil
+}
+
+// claim makes this replica the one that runs the job, false if another
+// replica took the job over first
+func (s *Spark) claim(job jobs.Job) bool {
+	if job.Replica == s.replica {
+		return true
+	}
+	claimed := false
+	err := s.jobs.Update(job.ID, func(j *jobs.Job) {
+		if claimed = j.Replica == job.Replica; claimed {
+			j.Replica = s.replica
+		}
+	})
+	if err != nil {
+		zap.L().Warn("couldn't take over job", zap.String("jobID", job.ID), zap.Error(err))
+	}
+	return err == nil && claimed
+}
+
+func (s *Spark) restoreSubmission(ctx context.Context, job jobs.Job, raw []byte) error {
+	var submission persistedSubmission
+	if err := json.Unmarshal(raw, &submission); err != nil {
+		return fmt.Errorf("couldn't decode queued submission, %w", err)
+	}
+	if err := s.checkPodTemplates(submission.Args); err != nil {
+		return err
+	}
+	var retry *RetryPolicy
+	if submission.Retry != nil {
+		retry = &RetryPolicy{Retries: submission.Retry.Retries, InitialDelay: submission.Retry.InitialDelay, MaxDelay: submission.Retry.MaxDelay}
+	}
+
+	if job.State == jobs.StateScheduled && job.RunAt != nil {
+		s.cancelMu.Lock()
+		defer s.cancelMu.Unlock()
+		if s.closed {
+			return ShuttingDownError
+		}
+		s.scheduleRun(ctx, job, submission.Args, retry)
+		return nil
+	}
+	if job.State == jobs.StateScheduled && !s.setJobState(job.ID, jobs.StatePending) {
+		return fmt.Errorf("couldn't queue scheduled job")
+	}
+	return s.enqueue(ctx, job.ID, job.Preset, job.Priority, submission.Args, retry)
+}
+
+// checkPodTemplates fails if an inline pod template of the args is missing,
+// they are kept in the temporary directory which may not survive a restart
+func (s *Spark) checkPodTemplates(args []string) error {
+	for _, key := range []string{driverPodTemplateKey, executorPodTemplateKey} {
+		for _, arg := range args {
+			path, ok := strings.CutPrefix(arg, "--conf="+key+"=")
+			if !ok || !strings.HasPrefix(path, s.podTemplateTmpDir()) {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("%w, %s", PodTemplateLostError, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurableQueue(t *testing.T) {
+	presetDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(presetDir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+	newSpark := func(t *testing.T, store *jobs.BoltStore) *Spark {
+		s, err := New(Config{
+			SparkHome:    filepath.Join(presetDir, "missing"),
+			PresetDir:    presetDir,
+			Master:       "k8s://mock",
+			SubmitRunner: NewMockCluster(MockConfig{}),
+			RetryLimits:  RetryLimits{Retries: 3, Delay: time.Minute},
+			Queue:        store,
+		}, store)
+		require.NoError(t, err)
+		return s
+	}
+	newStore := func(t *testing.T, path string) *jobs.BoltStore {
+		store, err := jobs.NewBoltStore(path)
+		require.NoError(t, err)
+		return store
+	}
+	// queued stores a pending job with its submission like a server that
+	// stopped before a worker was free
+	queued := func(t *testing.T, store *jobs.BoltStore, args []string) jobs.Job {
+		job, err := jobs.New("pi")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(job))
+		raw, err := json.Marshal(persistedSubmission{Args: args})
+		require.NoError(t, err)
+		require.NoError(t, store.PutSubmission(job.ID, raw))
+		return job
+	}
+
+	t.Run("keeps scheduled submissions across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.db")
+		store := newStore(t, path)
+		s := newSpark(t, store)
+		runAt := time.Now().Add(time.Hour)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, Retry: &RetryPolicy{InitialDelay: time.Second}})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateScheduled, job.State)
+		require.NoError(t, store.Close())
+
+		store = newStore(t, path)
+		defer store.Close()
+		interrupted, err := jobs.Interrupt(store)
+		require.NoError(t, err)
+		require.Empty(t, interrupted)
+		submissions, err := store.Submissions()
+		require.NoError(t, err)
+		var submission persistedSubmission
+		require.NoError(t, json.Unmarshal(submissions[id], &submission))
+		require.Equal(t, time.Second, submission.Retry.InitialDelay)
+
+		s = newSpark(t, store)
+		restored, err := s.RestoreQueue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []string{id}, restored)
+		require.Contains(t, s.timers, id)
+		require.NoError(t, s.Cancel(id))
+		submissions, err = store.Submissions()
+		require.NoError(t, err)
+		require.Empty(t, submissions)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("runs restored pending submissions and forgets them once they started", func(t *testing.T) {
+		store := newStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+		defer store.Close()
+		job := queued(t, store, mockArgs)
+		s := newSpark(t, store)
+
+		restored, err := s.RestoreQueue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []string{job.ID}, restored)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		got, err := s.Job(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateSucceeded, got.State)
+		submissions, err := store.Submissions()
+		require.NoError(t, err)
+		require.Empty(t, submissions)
+	})
+
+	t.Run("fails restored submissions whose pod templates are gone", func(t *testing.T) {
+		store := newStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+		defer store.Close()
+		s := newSpark(t, store)
+		missing := filepath.Join(s.podTemplateTmpDir(), "gone-driver.yaml")
+		job := queued(t, store, append([]string{"--conf=" + driverPodTemplateKey + "=" + missing}, mockArgs...))
+
+		restored, err := s.RestoreQueue(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, restored)
+		got, err := s.Job(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateFailed, got.State)
+		require.Contains(t, got.Error, "pod template lost with the restart")
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+}
+
+// sharedStore is a bolt store shared with the live replicas
+type sharedStore struct {
+	*jobs.BoltStore
+	live []string
+}
+
+func (s sharedStore) Heartbeat(replica string, ttl time.Duration) error {
+	return nil
+}
+
+func (s sharedStore) Replicas() ([]string, error) {
+	return s.live, nil
+}
+
+func TestSharedQueue(t *testing.T) {
+	presetDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(presetDir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+	bolt, err := jobs.NewBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+	require.NoError(t, err)
+	defer bolt.Close()
+	store := sharedStore{BoltStore: bolt, live: []string{"live", "me"}}
+	queued := func(t *testing.T, replica string) jobs.Job {
+		job, err := jobs.New("pi")
+		require.NoError(t, err)
+		job.Replica = replica
+		require.NoError(t, store.Put(job))
+		raw, err := json.Marshal(persistedSubmission{Args: mockArgs})
+		require.NoError(t, err)
+		require.NoError(t, store.PutSubmission(job.ID, raw))
+		return job
+	}
+	s, err := New(Config{
+		SparkHome:    filepath.Join(presetDir, "missing"),
+		PresetDir:    presetDir,
+		Master:       "k8s://mock",
+		SubmitRunner: NewMockCluster(MockConfig{}),
+		Queue:        store,
+		Replica:      "me",
+	}, store)
+	require.NoError(t, err)
+
+	ofLive, ofExpired := queued(t, "live"), queued(t, "expired")
+	restored, err := s.RestoreQueue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{ofExpired.ID}, restored)
+	runAt := time.Now().Add(time.Hour)
+	id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+	require.NoError(t, err)
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	job, err := s.Job(ofExpired.ID)
+	require.NoError(t, err)
+	require.Equal(t, "me", job.Replica)
+	require.Equal(t, jobs.StateSucceeded, job.State)
+	job, err = s.Job(id)
+	require.NoError(t, err)
+	require.Equal(t, "me", job.Replica)
+	job, err = s.Job(ofLive.ID)
+	require.NoError(t, err)
+	require.Equal(t, jobs.StatePending, job.State)
+	submissions, err := store.Submissions()
+	require.NoError(t, err)
+	require.Contains(t, submissions, ofLive.ID)
+	require.NotContains(t, submissions, ofExpired.ID)
+}
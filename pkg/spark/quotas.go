@@ -0,0 +1,265 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var NamespaceQuotaExceededError error = errors.New("namespace quota exceeded")
+
+// DefaultNamespaceQuota is the key of the quota of namespaces without their own
+const DefaultNamespaceQuota = "*"
+
+const namespaceConfKey = "spark.kubernetes.namespace"
+
+var quotaWaitingGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "spark_namespace_quota_waiting",
+	Help: "The number of submissions waiting for a free slot of the quota of their namespace",
+}, []string{"namespace"})
+
+var quotaRejectedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_namespace_quota_rejected_total",
+	Help: "The total number of submissions rejected because their namespace was at its quota",
+}, []string{"namespace"})
+
+// heldSubmission waits for a free slot of its namespace, start hands it to
+// the worker pool
+type heldSubmission struct {
+	jobID    string
+	priority int
+	start    func() error
+}
+
+// namespaceQuotas limits the submissions of a namespace that are queued for or
+// running on a worker. Submissions beyond the quota are held back until one
+// of the namespace finishes, or rejected. A nil namespaceQuotas admits all
+// submissions.
+type namespaceQuotas struct {
+	limits map[string]int
+	reject bool
+
+	mu     sync.Mutex
+	closed bool
+	// admitted maps the job ids that hold a slot to their namespace
+	admitted map[string]string
+	counts   map[string]int
+	// held are the submissions per namespace by descending priority and in
+	// FIFO order within a priority
+	held map[string][]*heldSubmission
+}
+
+func newNamespaceQuotas(limits map[string]int, reject bool) *namespaceQuotas {
+	return &namespaceQuotas{
+		limits:   limits,
+		reject:   reject,
+		admitted: make(map[string]string),
+		counts:   make(map[string]int),
+		held:     make(map[string][]*heldSubmission),
+	}
+}
+
+// limit returns the quota of the namespace, 0 means unlimited
+func (q *namespaceQuotas) limit(namespace string) int {
+	if limit, ok := q.limits[namespace]; ok {
+		return limit
+	}
+	return q.limits[DefaultNamespaceQuota]
+}
+
+// admit takes a slot of the namespace for the submission, it returns true if
+// the submission is held back until start is called by release
+func (q *namespaceQuotas) admit(namespace string, submission *heldSubmission) (bool, error) {
+	if q == nil || namespace == "" {
+		return false, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, ShuttingDownError
+	}
+	if limit := q.limit(namespace); limit <= 0 || q.counts[namespace] < limit {
+		q.admitted[submission.jobID] = namespace
+		q.counts[namespace]++
+		return false, nil
+	}
+	if q.reject {
+		quotaRejectedCounter.WithLabelValues(namespace).Inc()
+		return false, fmt.Errorf(`%w, namespace "%s" is limited to %d jobs`, NamespaceQuotaExceededError, namespace, q.limit(namespace))
+	}
+
+	held := q.held[namespace]
+	i := sort.Search(len(held), func(i int) bool { return held[i].priority < submission.priority })
+	held = append(held, nil)
+	copy(held[i+1:], held[i:])
+	held[i] = submission
+	q.held[namespace] = held
+	quotaWaitingGauge.WithLabelValues(namespace).Inc()
+	return true, nil
+}
+
+// getLimits returns a copy of the quotas and whether submissions beyond them
+// are rejected
+func (q *namespaceQuotas) getLimits() (map[string]int, bool) {
+	limits := make(map[string]int)
+	if q == nil {
+		return limits, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for namespace, limit := range q.limits {
+		limits[namespace] = limit
+	}
+	return limits, q.reject
+}
+
+// setLimits replaces the quotas, it returns the held submissions that fit
+// into the new quotas and must be started
+func (q *namespaceQuotas) setLimits(limits map[string]int, reject bool) []*heldSubmission {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limits, q.reject = limits, reject
+	var admitted []*heldSubmission
+	for namespace, held := range q.held {
+		for len(held) > 0 && !q.closed {
+			if limit := q.limit(namespace); limit > 0 && q.counts[namespace] >= limit {
+				break
+			}
+			q.admitted[held[0].jobID] = namespace
+			q.counts[namespace]++
+			quotaWaitingGauge.WithLabelValues(namespace).Dec()
+			admitted = append(admitted, held[0])
+			held = held[1:]
+		}
+		q.held[namespace] = held
+	}
+	return admitted
+}
+
+// release frees the slot of the job, it returns the held submission that
+// takes over the slot and must be started, if any
+func (q *namespaceQuotas) release(jobID string) *heldSubmission {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	namespace, ok := q.admitted[jobID]
+	if !ok {
+		return nil
+	}
+	delete(q.admitted, jobID)
+	held := q.held[namespace]
+	if q.closed || len(held) == 0 {
+		q.counts[namespace]--
+		return nil
+	}
+	next := held[0]
+	q.held[namespace] = held[1:]
+	q.admitted[next.jobID] = namespace
+	quotaWaitingGauge.WithLabelValues(namespace).Dec()
+	return next
+}
+
+// remove drops a held submission, it returns false if the job isn't held
+func (q *namespaceQuotas) remove(jobID string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for namespace, held := range q.held {
+		for i, submission := range held {
+			if submission.jobID == jobID {
+				q.held[namespace] = append(held[:i], held[i+1:]...)
+				quotaWaitingGauge.WithLabelValues(namespace).Dec()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// drain stops holding submissions and returns the job ids of the held ones
+func (q *namespaceQuotas) drain() []string {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	var ids []string
+	for namespace, held := range q.held {
+		for _, submission := range held {
+			ids = append(ids, submission.jobID)
+			quotaWaitingGauge.WithLabelValues(namespace).Dec()
+		}
+		delete(q.held, namespace)
+	}
+	return ids
+}
+
+// submitNamespace returns the kubernetes namespace the submission runs in, or
+// an empty string if the master isn't a kubernetes cluster
+func submitNamespace(args []string) string {
+	namespace := ""
+	for _, arg := range args {
+		if master, ok := strings.CutPrefix(arg, "--master="); ok {
+			if !strings.HasPrefix(master, "k8s://") {
+				return ""
+			}
+			namespace = "default"
+		}
+		if value, ok := strings.CutPrefix(arg, "--conf="+namespaceConfKey+"="); ok {
+			namespace = value
+		}
+	}
+	return namespace
+}
+
+// releaseQuota frees the namespace slot of a finished or removed job and
+// starts the held submission taking it over
+func (s *Spark) releaseQuota(jobID string) {
+	for next := s.quotas.release(jobID); next != nil; next = s.quotas.release(next.jobID) {
+		if s.startHeld(next) {
+			return
+		}
+	}
+}
+
+// startHeld hands a submission that got a slot of its namespace to the
+// workers, a submission that can't be started fails
+func (s *Spark) startHeld(submission *heldSubmission) bool {
+	err := submission.start()
+	if err == nil {
+		return true
+	}
+	s.forgetCancel(submission.jobID)
+	s.wg.Done()
+	s.setJobState(submission.jobID, jobs.StateFailed, func(j *jobs.Job) { j.Error = err.Error() })
+	zap.L().Error("couldn't start held submission", zap.String("jobID", submission.jobID), zap.Error(err))
+	return false
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitNamespace(t *testing.T) {
+	t.Run("given a kubernetes master without namespace, returns default", func(t *testing.T) {
+		require.Equal(t, "default", submitNamespace([]string{"--master=k8s://https://kube", "pi.py"}))
+	})
+
+	t.Run("given a namespace in the spark configuration, returns it", func(t *testing.T) {
+		require.Equal(t, "etl", submitNamespace([]string{"--master=k8s://https://kube", "--conf=spark.kubernetes.namespace=etl", "pi.py"}))
+	})
+
+	t.Run("given another master, returns no namespace", func(t *testing.T) {
+		require.Equal(t, "", submitNamespace([]string{"--master=yarn", "--conf=spark.kubernetes.namespace=etl", "pi.py"}))
+	})
+}
+
+func TestNamespaceQuotas(t *testing.T) {
+	submission := func(jobID string, priority int) *heldSubmission {
+		return &heldSubmission{jobID: jobID, priority: priority}
+	}
+
+	t.Run("holds submissions beyond the quota and hands over the slots by priority", func(t *testing.T) {
+		q := newNamespaceQuotas(map[string]int{"etl": 1}, false)
+		held, err := q.admit("etl", submission("first", 0))
+		require.NoError(t, err)
+		require.False(t, held)
+		for _, s := range []*heldSubmission{submission("low", 0), submission("high", 10)} {
+			held, err := q.admit("etl", s)
+			require.NoError(t, err)
+			require.True(t, held)
+		}
+
+		require.Equal(t, "high", q.release("first").jobID)
+		require.Equal(t, "low", q.release("high").jobID)
+		require.Nil(t, q.release("low"))
+		held, err = q.admit("etl", submission("next", 0))
+		require.NoError(t, err)
+		require.False(t, held)
+	})
+
+	t.Run("applies the default quota to other namespaces", func(t *testing.T) {
+		q := newNamespaceQuotas(map[string]int{"etl": 2, DefaultNamespaceQuota: 1}, false)
+		_, _ = q.admit("ml", submission("first", 0))
+		held, err := q.admit("ml", submission("second", 0))
+		require.NoError(t, err)
+		require.True(t, held)
+	})
+
+	t.Run("given the reject mode, rejects submissions beyond the quota", func(t *testing.T) {
+		q := newNamespaceQuotas(map[string]int{"etl": 1}, true)
+		_, _ = q.admit("etl", submission("first", 0))
+		_, err := q.admit("etl", submission("second", 0))
+		require.ErrorIs(t, err, NamespaceQuotaExceededError)
+		require.EqualError(t, err, `namespace quota exceeded, namespace "etl" is limited to 1 jobs`)
+	})
+
+	t.Run("given no quota, admits all submissions", func(t *testing.T) {
+		q := newNamespaceQuotas(nil, true)
+		for _, id := range []string{"first", "second"} {
+			held, err := q.admit("etl", submission(id, 0))
+			require.NoError(t, err)
+			require.False(t, held)
+		}
+	})
+
+	t.Run("removes and drains held submissions", func(t *testing.T) {
+		q := newNamespaceQuotas(map[string]int{"etl": 1}, false)
+		_, _ = q.admit("etl", submission("first", 0))
+		_, _ = q.admit("etl", submission("second", 0))
+		_, _ = q.admit("etl", submission("third", 0))
+		require.True(t, q.remove("second"))
+		require.False(t, q.remove("second"))
+		require.Equal(t, []string{"third"}, q.drain())
+		require.Nil(t, q.release("first"))
+		_, err := q.admit("etl", submission("fourth", 0))
+		require.ErrorIs(t, err, ShuttingDownError)
+	})
+}
+
+func TestSubmitNamespaceQuota(t *testing.T) {
+	done := filepath.Join(t.TempDir(), "done")
+	s := newTestSpark(t, Config{Master: "k8s://https://kube", NamespaceQuotas: map[string]int{"etl": 1}}, nil,
+		"while [ ! -f "+done+" ]; do sleep 0.01; done",
+		map[string]configurationPreset{"pi": {Main: "pi.py", SparkConf: map[string]string{namespaceConfKey: "etl"}}})
+	state := func(id string) jobs.State {
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		return job.State
+	}
+
+	first, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return state(first) == jobs.StateRunning }, 5*time.Second, 10*time.Millisecond)
+	second, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+	require.NoError(t, err)
+	third, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+	require.NoError(t, err)
+
+	job, err := s.Job(second)
+	require.NoError(t, err)
+	require.Equal(t, "etl", job.Namespace)
+	require.Equal(t, jobs.StatePending, job.State)
+
+	require.NoError(t, s.Cancel(third))
+	require.Equal(t, jobs.StateCancelled, state(third))
+
+	require.NoError(t, os.WriteFile(done, nil, 0644))
+	require.Eventually(t, func() bool { return state(second) == jobs.StateSucceeded }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, jobs.StateSucceeded, state(first))
+	require.NoError(t, s.Shutdown(context.Background()))
+}
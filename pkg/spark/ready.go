@@ -0,0 +1,60 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// Drain marks the server as draining, it reports not ready so load
+// balancers stop sending submissions, but still accepts the ones that arrive
+func (s *Spark) Drain() {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if !s.draining {
+		zap.L().Info("draining")
+	}
+	s.draining = true
+}
+
+// Resume ends draining
+func (s *Spark) Resume() {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if s.draining {
+		zap.L().Info("resuming")
+	}
+	s.draining = false
+}
+
+// Ready returns why the server shouldn't get new submissions, or nil
+func (s *Spark) Ready() error {
+	s.cancelMu.Lock()
+	closed, draining := s.closed, s.draining
+	s.cancelMu.Unlock()
+	if closed {
+		return fmt.Errorf("shutting down")
+	}
+	if draining {
+		return fmt.Errorf("draining")
+	}
+
+	if _, err := os.Stat(s.binaryPath); err != nil {
+		return fmt.Errorf("spark-submit not found, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReady(t *testing.T) {
+	t.Run("is ready until it drains", func(t *testing.T) {
+		s := &Spark{binaryPath: fakeSparkSubmit(t, "true"), workers: newWorkerPool(0, 0)}
+		require.NoError(t, s.Ready())
+
+		s.Drain()
+		require.ErrorContains(t, s.Ready(), "draining")
+		s.Resume()
+		require.NoError(t, s.Ready())
+	})
+
+	t.Run("isn't ready after shutdown", func(t *testing.T) {
+		s := &Spark{binaryPath: fakeSparkSubmit(t, "true"), workers: newWorkerPool(0, 0)}
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.ErrorContains(t, s.Ready(), "shutting down")
+	})
+
+	t.Run("given a missing spark-submit, isn't ready", func(t *testing.T) {
+		s := &Spark{binaryPath: filepath.Join(t.TempDir(), "spark-submit")}
+		require.ErrorContains(t, s.Ready(), "spark-submit not found")
+	})
+}
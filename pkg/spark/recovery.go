@@ -0,0 +1,186 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var recoveredCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_jobs_recovered_total",
+	Help: "The number of jobs that were reconciled with their driver pods after a restart",
+}, []string{"tenant", "preset"})
+
+// JobRecoverer reconciles the job store with the applications of the
+// cluster after a restart, it is implemented by the kubernetes backend
+type JobRecoverer interface {
+	RecoverJobs(ctx context.Context) ([]string, error)
+}
+
+// RecoverJobs lists the driver pods of the submissions of the server and
+// rebuilds the job records of their applications. Running jobs whose
+// spark-submit run was cut off by the restart finish with the phase of their
+// driver pod, live applications without a job record get a new record. Jobs
+// without a driver pod are left to jobs.Interrupt, the jobs of the live
+// replicas of a shared job store are left alone. It returns the ids of the
+// recovered jobs.
+func (k *KubernetesBackend) RecoverJobs(ctx context.Context) ([]string, error) {
+	recovered := make([]string, 0)
+	pods, err := k.client.ListPods(ctx, "", driverSelector+","+PresetLabel)
+	if err != nil {
+		return recovered, fmt.Errorf("couldn't list driver pods, %w", err)
+	}
+	list, err := k.jobs.List()
+	if err != nil {
+		return recovered, fmt.Errorf("couldn't list jobs, %w", err)
+	}
+	live, err := jobs.LiveReplicas(k.jobs)
+	if err != nil {
+		return recovered, err
+	}
+
+	byID := make(map[string]jobs.Job, len(list))
+	byPod := make(map[string]jobs.Job, len(list))
+	for _, job := range list {
+		byID[job.ID] = job
+		if job.DriverPod != "" {
+			byPod[job.Namespace+"/"+job.DriverPod] = job
+		}
+	}
+	for _, pod := range pods {
+		if !k.NamespaceAllowed(pod.Metadata.Namespace) {
+			continue
+		}
+		// pods of submissions from before the job label are matched by name
+		job, ok := byID[pod.Metadata.Labels[JobLabel]]
+		if !ok {
+			job, ok = byPod[pod.Metadata.Namespace+"/"+pod.Metadata.Name]
+		}
+
+		var id string
+		switch {
+		// the replica running the job keeps it up to date
+		case ok && live[job.Replica]:
+		case ok && job.State == jobs.StateRunning:
+			id = k.finishRecovered(job, pod)
+		case !ok && appStatusFromPod(pod).Live():
+			id, err = k.rebuildJob(pod)
+			if err != nil {
+				return recovered, err
+			}
+		}
+		if id == "" {
+			continue
+		}
+		job, err = k.jobs.Get(id)
+		if err != nil {
+			return recovered, err
+		}
+		if appStatusFromPod(pod).Live() {
+			elapsed := time.Duration(0)
+			if created := pod.Metadata.CreationTimestamp; created != nil {
+				elapsed = time.Since(*created)
+			}
+			k.watchRuntime(job.ID, job.Preset, elapsed)
+		}
+		recoveredCounter.WithLabelValues(k.tenant(job.Preset), job.Preset).Inc()
+		recovered = append(recovered, job.ID)
+	}
+	return recovered, nil
+}
+
+// finishRecovered ends a running job whose spark-submit run didn't survive
+// the restart. The submission created the driver pod, so the job succeeded
+// unless the driver failed already, like after a spark-submit run in cluster
+// mode that doesn't wait for the application.
+func (k *KubernetesBackend) finishRecovered(job jobs.Job, pod kube.Pod) string {
+	app := appStatusFromPod(pod)
+	state := jobs.StateSucceeded
+	if app.Phase == "Failed" {
+		state = jobs.StateFailed
+	}
+	ok := k.setJobState(job.ID, state, func(j *jobs.Job) {
+		if len(j.Attempts) > 0 && j.Attempts[len(j.Attempts)-1].FinishedAt == nil {
+			j.FinishAttempt(nil)
+		}
+		j.Namespace, j.DriverPod = pod.Metadata.Namespace, pod.Metadata.Name
+		j.AppPhase = driverPhase(kube.PodEvent{Type: kube.Added, Pod: pod})
+		if state == jobs.StateFailed {
+			j.Error = driverFailure(app)
+			j.ExitCode = app.ExitCode
+		}
+		j.Record(jobs.Event{Type: jobs.EventRecovered, Message: recoveredMessage(pod)})
+	})
+	if !ok {
+		return ""
+	}
+	zap.L().Info("recovered job", zap.String("jobID", job.ID), zap.String("namespace", pod.Metadata.Namespace), zap.String("pod", pod.Metadata.Name), zap.String("state", string(state)))
+	return job.ID
+}
+
+// rebuildJob stores a job for a live application whose record is gone, e.g.
+// with the memory job store. The job keeps the id of the job label.
+func (k *KubernetesBackend) rebuildJob(pod kube.Pod) (string, error) {
+	job, err := jobs.New(k.presetOfLabel(pod.Metadata.Labels[PresetLabel]))
+	if err != nil {
+		return "", err
+	}
+	if id := pod.Metadata.Labels[JobLabel]; id != "" {
+		job.ID = id
+	}
+	if created := pod.Metadata.CreationTimestamp; created != nil {
+		job.CreatedAt = created.UTC()
+		job.Events[0].Time = job.CreatedAt
+	}
+	job.Namespace, job.DriverPod = pod.Metadata.Namespace, pod.Metadata.Name
+	job.AppPhase = driverPhase(kube.PodEvent{Type: kube.Added, Pod: pod})
+	job.StartedAt = pod.Status.StartTime
+	job.SetState(jobs.StateSucceeded)
+	job.Record(jobs.Event{Type: jobs.EventRecovered, Message: recoveredMessage(pod)})
+	if err := k.jobs.Put(job); err != nil {
+		return "", fmt.Errorf("couldn't store recovered job, %w", err)
+	}
+	zap.L().Info("rebuilt job of live application", zap.String("jobID", job.ID), zap.String("namespace", pod.Metadata.Namespace), zap.String("pod", pod.Metadata.Name))
+	return job.ID, nil
+}
+
+// presetOfLabel returns the preset whose label value is value, or the value
+// itself if no loaded preset has it
+func (k *KubernetesBackend) presetOfLabel(value string) string {
+	for _, name := range k.PresetNames() {
+		if presetLabelValue(name) == value {
+			return name
+		}
+	}
+	return value
+}
+
+func recoveredMessage(pod kube.Pod) string {
+	return fmt.Sprintf(`recovered after a restart, driver pod "%s" is %s`, pod.Metadata.Name, pod.Status.Phase)
+}
+
+func driverFailure(app AppStatus) string {
+	if app.ExitReason != "" {
+		return "driver pod failed, " + app.ExitReason
+	}
+	return "driver pod failed"
+}
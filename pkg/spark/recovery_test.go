@@ -0,0 +1,152 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+const recoveryPods = `{"items": [
+	{
+		"metadata": {"name": "pi-1-driver", "namespace": "spark", "labels": {"spark-role": "driver", "spark-submit/preset": "pi", "spark-submit/job": "job-running"}, "creationTimestamp": "2023-07-10T11:58:12Z"},
+		"status": {"phase": "Running", "containerStatuses": [
+			{"name": "spark-kubernetes-driver", "state": {"running": {}}}
+		]}
+	},
+	{
+		"metadata": {"name": "pi-2-driver", "namespace": "spark", "labels": {"spark-role": "driver", "spark-submit/preset": "pi"}},
+		"status": {"phase": "Failed", "containerStatuses": [
+			{"name": "spark-kubernetes-driver", "state": {"terminated": {"exitCode": 137, "reason": "OOMKilled"}}}
+		]}
+	},
+	{
+		"metadata": {"name": "etl-1-driver", "namespace": "spark", "labels": {"spark-role": "driver", "spark-submit/preset": "etl.daily", "spark-submit/job": "job-lost"}, "creationTimestamp": "2023-07-10T11:58:12Z"},
+		"status": {"phase": "Pending"}
+	},
+	{
+		"metadata": {"name": "etl-2-driver", "namespace": "spark", "labels": {"spark-role": "driver", "spark-submit/preset": "etl.daily", "spark-submit/job": "job-done"}},
+		"status": {"phase": "Succeeded"}
+	},
+	{
+		"metadata": {"name": "pi-3-driver", "namespace": "other", "labels": {"spark-role": "driver", "spark-submit/preset": "pi"}},
+		"status": {"phase": "Running"}
+	}
+]}`
+
+func TestRecoverJobs(t *testing.T) {
+	t.Run("reconciles the jobs with their driver pods", func(t *testing.T) {
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/api/v1/pods", r.URL.Path)
+			require.Equal(t, driverSelector+","+PresetLabel, r.URL.Query().Get("labelSelector"))
+			_, _ = w.Write([]byte(recoveryPods))
+		})
+		store := jobs.NewMemoryStore()
+		backend.jobs = store
+		backend.namespaces = []string{"spark"}
+		backend.presets = map[string]configurationPreset{"pi": {Main: "pi.py"}, "etl/daily": {Main: "etl.py"}}
+		running := jobs.Job{ID: "job-running", Preset: "pi", State: jobs.StateRunning, Attempts: []jobs.Attempt{{}}}
+		failed := jobs.Job{ID: "job-failed", Preset: "pi", State: jobs.StateRunning, Namespace: "spark", DriverPod: "pi-2-driver"}
+		lost := jobs.Job{ID: "job-without-pod", Preset: "pi", State: jobs.StateRunning}
+		for _, job := range []jobs.Job{running, failed, lost} {
+			require.NoError(t, store.Put(job))
+		}
+
+		recovered, err := backend.RecoverJobs(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []string{"job-running", "job-failed", "job-lost"}, recovered)
+
+		job, err := store.Get("job-running")
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateSucceeded, job.State)
+		require.Equal(t, "pi-1-driver", job.AppName())
+		require.Equal(t, "spark", job.Namespace)
+		require.NotNil(t, job.Attempts[0].FinishedAt)
+		require.Equal(t, jobs.EventRecovered, job.Events[len(job.Events)-1].Type)
+
+		job, err = store.Get("job-failed")
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateFailed, job.State)
+		require.Equal(t, "driver pod failed, OOMKilled", job.Error)
+		require.Equal(t, 137, *job.ExitCode)
+		require.Equal(t, "OOMKilled", job.AppPhase)
+
+		job, err = store.Get("job-lost")
+		require.NoError(t, err)
+		require.Equal(t, "etl/daily", job.Preset)
+		require.Equal(t, jobs.StateSucceeded, job.State)
+		require.Equal(t, "etl-1-driver", job.AppName())
+		require.Equal(t, 2023, job.CreatedAt.Year())
+
+		// finished applications without a job and unknown jobs without a
+		// driver pod are left alone
+		_, err = store.Get("job-done")
+		require.ErrorIs(t, err, jobs.JobNotFoundError)
+		job, err = store.Get("job-without-pod")
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateRunning, job.State)
+	})
+
+	t.Run("leaves the jobs of live replicas alone", func(t *testing.T) {
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(recoveryPods))
+		})
+		bolt, err := jobs.NewBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+		require.NoError(t, err)
+		defer bolt.Close()
+		backend.jobs = sharedStore{BoltStore: bolt, live: []string{"live"}}
+		backend.namespaces = []string{"spark"}
+		running := jobs.Job{ID: "job-running", Preset: "pi", State: jobs.StateRunning, Replica: "live"}
+		require.NoError(t, backend.jobs.Put(running))
+
+		recovered, err := backend.RecoverJobs(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []string{"job-lost"}, recovered)
+		job, err := backend.jobs.Get("job-running")
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateRunning, job.State)
+	})
+
+	t.Run("fails without the kubernetes API", func(t *testing.T) {
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+		backend.jobs = jobs.NewMemoryStore()
+
+		recovered, err := backend.RecoverJobs(context.Background())
+		require.ErrorContains(t, err, "couldn't list driver pods")
+		require.Empty(t, recovered)
+	})
+
+	t.Run("labels the driver pods with the job id", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+		cluster := NewMockCluster(MockConfig{})
+		s, err := New(Config{SparkHome: dir, PresetDir: dir, Master: "k8s://mock", SubmitRunner: cluster}, jobs.NewMemoryStore())
+		require.NoError(t, err)
+
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		report := NewMockBackend(s, cluster).Status(context.Background(), "default", "*")
+		require.Len(t, report.Apps, 1)
+		require.Equal(t, id, report.Apps[0].Labels[JobLabel])
+	})
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"go.uber.org/zap"
+)
+
+// submitResult is the outcome of the spark-submit runs of a job, it drives
+// both the job record and the metrics
+type submitResult struct {
+	State    jobs.State
+	Err      error
+	Attempts int
+	// Duration is the time from the start of the first attempt to the
+	// outcome, 0 if no attempt started
+	Duration time.Duration
+	// ExitCode is the one of the last spark-submit process, nil if none ran
+	ExitCode *int
+	// Stderr is the tail of the stderr of the last attempt of failed jobs
+	Stderr string
+	// Resources are the requested resources the cost estimate is based on
+	Resources resources
+}
+
+// newSubmitResult maps the error of the retries to the final state
+func newSubmitResult(err error, attempts int, start time.Time) submitResult {
+	result := submitResult{State: jobs.StateSucceeded, Err: err, Attempts: attempts}
+	if errors.Is(err, context.Canceled) {
+		result.State = jobs.StateCancelled
+	} else if err != nil {
+		result.State = jobs.StateFailed
+	}
+	if !start.IsZero() {
+		result.Duration = time.Since(start)
+	}
+	if attempts > 0 {
+		result.ExitCode = exitCode(err)
+	}
+	return result
+}
+
+// exitCode returns the exit code of a spark-submit run, 0 if it succeeded and
+// nil if the process didn't run. Processes killed by a signal report -1.
+func exitCode(err error) *int {
+	if err == nil {
+		code := 0
+		return &code
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		return &code
+	}
+	return nil
+}
+
+// status is the status label of the metrics
+func (r submitResult) status() string {
+	switch r.State {
+	case jobs.StateSucceeded:
+		return "success"
+	case jobs.StateCancelled:
+		return "cancelled"
+	default:
+		return "failure"
+	}
+}
+
+// recordResult stores the outcome on the job and counts it exactly once
+func (s *Spark) recordResult(jobID, presetName string, result submitResult) {
+	switch result.State {
+	case jobs.StateCancelled:
+		zap.L().Info("cancelled submission", zap.String("jobID", jobID))
+	case jobs.StateFailed:
+		zap.L().Error("spark submit failed with retries", zap.String("jobID", jobID), zap.Int("attempts", result.Attempts), zap.Error(result.Err))
+	}
+	var cost *float64
+	updated := s.setJobState(jobID, result.State, func(j *jobs.Job) {
+		if result.Err != nil {
+			j.Error = result.Err.Error()
+		}
+		j.ExitCode = result.ExitCode
+		j.Stderr = result.Stderr
+		if s.prices.enabled() && result.Attempts > 0 {
+			estimate := s.prices.estimateCost(result.Resources, attemptsRuntime(j.Attempts))
+			j.EstimatedCost, cost = &estimate, &estimate
+		}
+	})
+	if !updated {
+		return
+	}
+	tenant := s.tenant(presetName)
+	if cost != nil {
+		costCounter.WithLabelValues(tenant, presetName).Add(*cost)
+	}
+	submitCounter.WithLabelValues(tenant, presetName, result.status()).Inc()
+	if result.Attempts > 0 {
+		durationHistogram.WithLabelValues(tenant, presetName, result.status()).Observe(result.Duration.Seconds())
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitResult(t *testing.T) {
+	counter := func(preset, status string) float64 {
+		var metric dto.Metric
+		require.NoError(t, submitCounter.WithLabelValues("", preset, status).Write(&metric))
+		return metric.GetCounter().GetValue()
+	}
+	newSpark := func(t *testing.T, state jobs.State) *Spark {
+		s := &Spark{jobs: jobs.NewMemoryStore()}
+		require.NoError(t, s.jobs.Put(jobs.Job{ID: "1", Preset: "pi", State: state}))
+		return s
+	}
+
+	t.Run("maps errors to the final state", func(t *testing.T) {
+		start := time.Now().Add(-time.Second)
+		require.Equal(t, jobs.StateSucceeded, newSubmitResult(nil, 1, start).State)
+		require.Equal(t, jobs.StateFailed, newSubmitResult(errors.New("exit status 1"), 3, start).State)
+		require.Equal(t, jobs.StateCancelled, newSubmitResult(fmt.Errorf("retry, %w", context.Canceled), 1, start).State)
+		require.GreaterOrEqual(t, newSubmitResult(nil, 1, start).Duration, time.Second)
+		require.Zero(t, newSubmitResult(context.Canceled, 0, time.Time{}).Duration)
+	})
+
+	for _, tc := range []struct {
+		err    error
+		state  jobs.State
+		status string
+	}{
+		{nil, jobs.StateSucceeded, "success"},
+		{errors.New("exit status 1"), jobs.StateFailed, "failure"},
+		{context.Canceled, jobs.StateCancelled, "cancelled"},
+	} {
+		t.Run(fmt.Sprintf("records a running job as %s", tc.state), func(t *testing.T) {
+			preset := "result-" + string(tc.state)
+			s := newSpark(t, jobs.StateRunning)
+			s.recordResult("1", preset, newSubmitResult(tc.err, 2, time.Now()))
+
+			job, err := s.jobs.Get("1")
+			require.NoError(t, err)
+			require.Equal(t, tc.state, job.State)
+			require.Equal(t, float64(1), counter(preset, tc.status))
+			for _, other := range []string{"success", "failure", "cancelled"} {
+				if other != tc.status {
+					require.Zero(t, counter(preset, other))
+				}
+			}
+		})
+	}
+
+	t.Run("reports the exit code of the last attempt", func(t *testing.T) {
+		failed := exec.Command("sh", "-c", "exit 3").Run()
+		require.Equal(t, 3, *newSubmitResult(fmt.Errorf("retries exceeded, %w", failed), 2, time.Now()).ExitCode)
+		require.Equal(t, 0, *newSubmitResult(nil, 1, time.Now()).ExitCode)
+		require.Nil(t, newSubmitResult(errors.New("couldn't resolve secret"), 1, time.Now()).ExitCode)
+		require.Nil(t, newSubmitResult(context.Canceled, 0, time.Time{}).ExitCode)
+	})
+
+	t.Run("stores the error, exit code and stderr on failed jobs", func(t *testing.T) {
+		s := newTestSpark(t, Config{
+			Backoff:         backoff.Config{Strategy: backoff.Constant, Retries: 2, InitialDelay: time.Millisecond},
+			StderrTailLimit: 13,
+		}, nil, "echo submitting; echo 'Exception: no such file' >&2; exit 101", map[string]configurationPreset{"pi": {Main: "pi.py"}})
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateFailed, job.State)
+		require.Equal(t, "retries exceeded, exit status 101", job.Error)
+		require.Equal(t, 101, *job.ExitCode)
+		require.Equal(t, truncatedMarker+"no such file\n", job.Stderr)
+		require.Equal(t, 101, *job.Attempts[0].ExitCode)
+	})
+
+	t.Run("given prices, stores and counts the estimated cost", func(t *testing.T) {
+		s := newSpark(t, jobs.StateRunning)
+		s.prices = Prices{CoreHour: 0.04, GBHour: 0.005}
+		started := time.Now().Add(-2 * time.Hour)
+		finished := started.Add(time.Hour)
+		require.NoError(t, s.jobs.Put(jobs.Job{ID: "1", Preset: "pi", State: jobs.StateRunning, Attempts: []jobs.Attempt{{StartedAt: started, FinishedAt: &finished}}}))
+		result := newSubmitResult(nil, 1, started)
+		result.Resources = resources{Cores: 10, MemoryGB: 40}
+		s.recordResult("1", "result-cost", result)
+
+		job, err := s.jobs.Get("1")
+		require.NoError(t, err)
+		require.InDelta(t, 0.6, *job.EstimatedCost, 1e-9)
+		var metric dto.Metric
+		require.NoError(t, costCounter.WithLabelValues("", "result-cost").Write(&metric))
+		require.InDelta(t, 0.6, metric.GetCounter().GetValue(), 1e-9)
+	})
+
+	t.Run("given no prices, estimates no cost", func(t *testing.T) {
+		s := newSpark(t, jobs.StateRunning)
+		s.recordResult("1", "pi", newSubmitResult(nil, 1, time.Now()))
+
+		job, err := s.jobs.Get("1")
+		require.NoError(t, err)
+		require.Nil(t, job.EstimatedCost)
+	})
+
+	t.Run("given a finished job, doesn't count again", func(t *testing.T) {
+		s := newSpark(t, jobs.StateCancelled)
+		s.recordResult("1", "result-finished", newSubmitResult(nil, 1, time.Now()))
+
+		job, err := s.jobs.Get("1")
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateCancelled, job.State)
+		require.Zero(t, counter("result-finished", "success"))
+	})
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var prunedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spark_jobs_pruned_total",
+	Help: "The number of finished job records deleted by the retention",
+})
+
+// PruneJobs deletes the records, events and captured output of finished jobs
+// that are older than retention or beyond the newest maxJobs, 0 disables
+// either limit
+func (s *Spark) PruneJobs(retention time.Duration, maxJobs int) ([]string, error) {
+	deleted, err := jobs.Prune(s.jobs, retention, maxJobs, time.Now().UTC())
+	if len(deleted) == 0 {
+		return deleted, err
+	}
+
+	pruned := make(map[string]bool, len(deleted))
+	for _, id := range deleted {
+		pruned[id] = true
+	}
+	s.outputMu.Lock()
+	order := s.outputOrder[:0]
+	for _, id := range s.outputOrder {
+		if pruned[id] {
+			delete(s.outputs, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	s.outputOrder = order
+	s.outputMu.Unlock()
+
+	prunedCounter.Add(float64(len(deleted)))
+	return deleted, err
+}
+
+// WatchJobRetention prunes jobs every interval until ctx is done
+func (s *Spark) WatchJobRetention(ctx context.Context, interval, retention time.Duration, maxJobs int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := s.PruneJobs(retention, maxJobs)
+		if err != nil {
+			zap.L().Warn("couldn't prune jobs", zap.Error(err))
+		}
+		if len(deleted) > 0 {
+			zap.L().Info("pruned finished jobs", zap.Int("count", len(deleted)))
+		}
+	}
+}
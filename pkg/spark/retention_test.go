@@ -0,0 +1,42 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneJobs(t *testing.T) {
+	t.Run("deletes expired jobs with their output", func(t *testing.T) {
+		store := jobs.NewMemoryStore()
+		finished := time.Now().UTC().Add(-48 * time.Hour)
+		require.NoError(t, store.Put(jobs.Job{ID: "old", State: jobs.StateSucceeded, CreatedAt: finished, FinishedAt: &finished}))
+		require.NoError(t, store.Put(jobs.Job{ID: "running", State: jobs.StateRunning, CreatedAt: finished}))
+		s := &Spark{jobs: store}
+		_, _ = s.submitOutput("old").Write([]byte("output"))
+		_, _ = s.submitOutput("running").Write([]byte("output"))
+
+		deleted, err := s.PruneJobs(24*time.Hour, 0)
+		require.NoError(t, err)
+		require.Equal(t, []string{"old"}, deleted)
+		_, err = s.Job("old")
+		require.ErrorIs(t, err, jobs.JobNotFoundError)
+		require.NotContains(t, s.outputs, "old")
+		require.Equal(t, []string{"running"}, s.outputOrder)
+	})
+}
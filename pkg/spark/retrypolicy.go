@@ -0,0 +1,123 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+)
+
+var InvalidRetryPolicyError error = errors.New("invalid retry policy")
+
+// RetryPolicy overrides the backoff of the preset for a single submission,
+// e.g. to submit a manual one-off run without retries
+type RetryPolicy struct {
+	// Retries is the number of tries after the first one, 0 submits once
+	Retries *int `json:"retries,omitempty"`
+	// InitialDelay and MaxDelay override the delays of the backoff if not zero
+	InitialDelay time.Duration `json:"-"`
+	MaxDelay     time.Duration `json:"-"`
+}
+
+// RetryLimits bound the retry policies of submissions, so that a request
+// can't keep a worker busy with retries for longer than the server allows.
+// Retries counts the tries after the first one like RetryPolicy, unlike the
+// Retries of the backoff.
+type RetryLimits struct {
+	Retries int
+	Delay   time.Duration
+}
+
+// MarshalJSON formats the delays as duration strings like "1m30s"
+func (p RetryPolicy) MarshalJSON() ([]byte, error) {
+	type policy RetryPolicy
+	return json.Marshal(struct {
+		policy
+		InitialDelay string `json:"initialDelay,omitempty"`
+		MaxDelay     string `json:"maxDelay,omitempty"`
+	}{policy(p), formatDuration(p.InitialDelay), formatDuration(p.MaxDelay)})
+}
+
+// UnmarshalJSON parses the delays from duration strings like "1m30s"
+func (p *RetryPolicy) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Retries      *int   `json:"retries"`
+		InitialDelay string `json:"initialDelay"`
+		MaxDelay     string `json:"maxDelay"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	decoded := RetryPolicy{Retries: raw.Retries}
+	for _, delay := range []struct {
+		value  string
+		target *time.Duration
+	}{{raw.InitialDelay, &decoded.InitialDelay}, {raw.MaxDelay, &decoded.MaxDelay}} {
+		if delay.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(delay.value)
+		if err != nil {
+			return fmt.Errorf("invalid retry delay, %w", err)
+		}
+		*delay.target = d
+	}
+	*p = decoded
+	return nil
+}
+
+func (p RetryPolicy) validate(limits RetryLimits) error {
+	if p.Retries != nil {
+		if *p.Retries < 0 {
+			return fmt.Errorf("%w: retries can't be negative", InvalidRetryPolicyError)
+		}
+		if *p.Retries > limits.Retries {
+			return fmt.Errorf("%w: at most %d retries are allowed", InvalidRetryPolicyError, limits.Retries)
+		}
+	}
+	for _, delay := range []time.Duration{p.InitialDelay, p.MaxDelay} {
+		if delay < 0 {
+			return fmt.Errorf("%w: delays can't be negative", InvalidRetryPolicyError)
+		}
+		if delay > limits.Delay {
+			return fmt.Errorf("%w: delays may not exceed %s", InvalidRetryPolicyError, limits.Delay)
+		}
+	}
+	if p.InitialDelay > 0 && p.MaxDelay > 0 && p.InitialDelay > p.MaxDelay {
+		return fmt.Errorf("%w: initialDelay can't exceed maxDelay", InvalidRetryPolicyError)
+	}
+	return nil
+}
+
+// apply returns the backoff with the overrides of the policy
+func (p *RetryPolicy) apply(config backoff.Config) backoff.Config {
+	if p == nil {
+		return config
+	}
+	if p.Retries != nil {
+		// the backoff counts the first try as well
+		config.Retries = *p.Retries + 1
+	}
+	if p.InitialDelay > 0 {
+		config.InitialDelay = p.InitialDelay
+	}
+	if p.MaxDelay > 0 {
+		config.MaxDelay = p.MaxDelay
+	}
+	return config
+}
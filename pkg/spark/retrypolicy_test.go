@@ -0,0 +1,90 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	limits := RetryLimits{Retries: 5, Delay: time.Minute}
+	retries := func(n int) *int { return &n }
+
+	t.Run("decodes duration strings", func(t *testing.T) {
+		var policy RetryPolicy
+		require.NoError(t, json.Unmarshal([]byte(`{"retries": 0, "initialDelay": "5s", "maxDelay": "30s"}`), &policy))
+		require.Equal(t, RetryPolicy{Retries: retries(0), InitialDelay: 5 * time.Second, MaxDelay: 30 * time.Second}, policy)
+
+		encoded, err := json.Marshal(policy)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"retries": 0, "initialDelay": "5s", "maxDelay": "30s"}`, string(encoded))
+
+		require.Error(t, json.Unmarshal([]byte(`{"initialDelay": "soon"}`), &policy))
+	})
+
+	t.Run("validates the policy against the limits", func(t *testing.T) {
+		require.NoError(t, RetryPolicy{Retries: retries(5), MaxDelay: time.Minute}.validate(limits))
+		require.NoError(t, RetryPolicy{Retries: retries(0)}.validate(RetryLimits{}))
+		for _, policy := range []RetryPolicy{
+			{Retries: retries(-1)},
+			{Retries: retries(6)},
+			{InitialDelay: -time.Second},
+			{MaxDelay: 2 * time.Minute},
+			{InitialDelay: time.Minute, MaxDelay: 30 * time.Second},
+		} {
+			require.ErrorIs(t, policy.validate(limits), InvalidRetryPolicyError)
+		}
+	})
+
+	t.Run("overrides the backoff", func(t *testing.T) {
+		config := backoff.Config{Strategy: backoff.Exponential, Retries: 10, InitialDelay: time.Second, MaxDelay: time.Minute}
+		require.Equal(t, config, (*RetryPolicy)(nil).apply(config))
+
+		applied := (&RetryPolicy{Retries: retries(0), MaxDelay: 10 * time.Second}).apply(config)
+		require.Equal(t, 1, applied.Retries, "the first try counts")
+		require.Equal(t, time.Second, applied.InitialDelay)
+		require.Equal(t, 10*time.Second, applied.MaxDelay)
+	})
+
+	t.Run("submits with the policy of the request", func(t *testing.T) {
+		s := newTestSpark(t, Config{Backoff: backoff.Config{Retries: 3, InitialDelay: time.Millisecond}, RetryLimits: limits}, nil, "exit 1",
+			map[string]configurationPreset{"pi": {Main: "pi.py"}})
+		once, err := s.Submit(context.Background(), "pi", SubmitOptions{Retry: &RetryPolicy{Retries: retries(0)}})
+		require.NoError(t, err)
+		preset, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		job, err := s.Job(once)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateFailed, job.State)
+		require.Len(t, job.Attempts, 1)
+		job, err = s.Job(preset)
+		require.NoError(t, err)
+		require.Len(t, job.Attempts, 3)
+	})
+
+	t.Run("rejects policies beyond the limits", func(t *testing.T) {
+		s := &Spark{presets: map[string]configurationPreset{"pi": {Main: "pi.py"}}, retryLimits: limits}
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{Retry: &RetryPolicy{Retries: retries(20)}})
+		require.ErrorIs(t, err, InvalidRetryPolicyError)
+	})
+}
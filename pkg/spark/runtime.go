@@ -0,0 +1,134 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var maxRuntimeCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_max_runtime_exceeded_total",
+	Help: "The number of jobs that were killed because they exceeded the max runtime of their preset",
+}, []string{"tenant", "preset"})
+
+// killTimeout limits the status and kill requests of the max runtime check
+const killTimeout = time.Minute
+
+// AppController kills and inspects spark applications, it is implemented by
+// Spark and the backends
+type AppController interface {
+	Kill(ctx context.Context, namespace, name string)
+	Status(ctx context.Context, namespace, name string) StatusReport
+}
+
+// SetAppController sets the backend that kills applications which exceed
+// the max runtime of their preset, Spark itself is used if it isn't set
+func (s *Spark) SetAppController(c AppController) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	s.apps = c
+}
+
+func (s *Spark) appController() AppController {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if s.apps == nil {
+		return s
+	}
+	return s.apps
+}
+
+// watchRuntime enforces the max runtime of the preset for the rest of it,
+// elapsed is the time the application ran already. The timer outlives the
+// spark-submit run, in cluster mode the application keeps running after
+// spark-submit returns.
+func (s *Spark) watchRuntime(jobID, presetName string, elapsed time.Duration) {
+	s.mu.RLock()
+	limit := s.presets[presetName].MaxRuntime
+	s.mu.RUnlock()
+	if limit <= 0 {
+		return
+	}
+
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.runtimeTimers == nil {
+		s.runtimeTimers = make(map[string]*time.Timer)
+	}
+	s.runtimeTimers[jobID] = time.AfterFunc(limit-elapsed, func() {
+		s.cancelMu.Lock()
+		delete(s.runtimeTimers, jobID)
+		s.cancelMu.Unlock()
+		s.enforceRuntime(jobID, presetName, limit)
+	})
+}
+
+// stopRuntimeTimers is called on shutdown with cancelMu held
+func (s *Spark) stopRuntimeTimers() {
+	for id, timer := range s.runtimeTimers {
+		timer.Stop()
+		delete(s.runtimeTimers, id)
+	}
+}
+
+// enforceRuntime aborts the spark-submit run of the job and kills its live
+// application
+func (s *Spark) enforceRuntime(jobID, presetName string, limit time.Duration) {
+	job, err := s.jobs.Get(jobID)
+	if err != nil {
+		zap.L().Error("couldn't load job to enforce the max runtime", zap.String("jobID", jobID), zap.Error(err))
+		return
+	}
+
+	exceeded := false
+	if !job.State.Terminal() {
+		if err := s.Cancel(jobID); err == nil {
+			exceeded = true
+		}
+	}
+	if name := job.AppName(); name != "" {
+		ctx, cancel := context.WithTimeout(WithMaster(context.Background(), job.Master), killTimeout)
+		defer cancel()
+		apps := s.appController()
+		for _, app := range apps.Status(ctx, job.Namespace, name).Apps {
+			if app.Live() {
+				apps.Kill(ctx, job.Namespace, name)
+				exceeded = true
+				break
+			}
+		}
+	}
+	if !exceeded {
+		return
+	}
+
+	maxRuntimeCounter.WithLabelValues(s.tenant(presetName), presetName).Inc()
+	s.publish(events.Event{
+		Type:   events.MaxRuntimeExceeded,
+		JobID:  jobID,
+		Preset: presetName,
+		Error:  fmt.Sprintf("exceeded the max runtime of %s", limit),
+	})
+	zap.L().Warn("job exceeded the max runtime", zap.String("jobID", jobID), zap.String("presetName", presetName), zap.Duration("maxRuntime", limit))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+type appControllerMock struct {
+	mu     sync.Mutex
+	apps   []AppStatus
+	killed []string
+}
+
+func (m *appControllerMock) Kill(_ context.Context, namespace, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.killed = append(m.killed, namespace+"/"+name)
+}
+
+func (m *appControllerMock) Status(context.Context, string, string) StatusReport {
+	return StatusReport{Apps: m.apps}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	newSpark := func(t *testing.T, script string, maxRuntime time.Duration, recorder *eventRecorder) *Spark {
+		return newTestSpark(t, Config{Backoff: backoff.Config{Retries: 0}, Events: recorder}, nil, script,
+			map[string]configurationPreset{"pi": {Main: "pi.py", MaxRuntime: maxRuntime}})
+	}
+
+	t.Run("cancels a submission that runs too long", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		s := newSpark(t, "sleep 10", 50*time.Millisecond, recorder)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			job, err := s.Job(id)
+			return err == nil && job.State == jobs.StateCancelled
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.Contains(t, recorder.types(), events.MaxRuntimeExceeded)
+	})
+
+	t.Run("kills the live application of a finished submission", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		s := newSpark(t, "true", time.Hour, recorder)
+		apps := &appControllerMock{apps: []AppStatus{{PodName: "pi-driver", Namespace: "spark", Phase: "Running"}}}
+		s.SetAppController(apps)
+		job := jobs.Job{ID: "1", Preset: "pi", State: jobs.StateSucceeded, Namespace: "spark", DriverPod: "pi-driver"}
+		require.NoError(t, s.jobs.Put(job))
+
+		s.enforceRuntime("1", "pi", time.Hour)
+		require.Equal(t, []string{"spark/pi-driver"}, apps.killed)
+		require.Equal(t, []events.Event{{
+			Type:   events.MaxRuntimeExceeded,
+			Time:   recorder.events[0].Time,
+			JobID:  "1",
+			Preset: "pi",
+			Error:  "exceeded the max runtime of 1h0m0s",
+		}}, recorder.events)
+	})
+
+	t.Run("leaves finished applications alone", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		s := newSpark(t, "true", time.Hour, recorder)
+		apps := &appControllerMock{apps: []AppStatus{{PodName: "pi-driver", Namespace: "spark", Phase: "Succeeded"}}}
+		s.SetAppController(apps)
+		job := jobs.Job{ID: "1", Preset: "pi", State: jobs.StateSucceeded, Namespace: "spark", DriverPod: "pi-driver"}
+		require.NoError(t, s.jobs.Put(job))
+
+		s.enforceRuntime("1", "pi", time.Hour)
+		require.Empty(t, apps.killed)
+		require.Empty(t, recorder.events)
+	})
+
+	t.Run("stops the timers on shutdown", func(t *testing.T) {
+		s := newSpark(t, "true", time.Hour, &eventRecorder{})
+		s.watchRuntime("1", "pi", 0)
+		require.Len(t, s.runtimeTimers, 1)
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.Empty(t, s.runtimeTimers)
+	})
+
+	t.Run("given a negative max runtime, returns InvalidPresetError", func(t *testing.T) {
+		_, err := parsePreset("pi", []byte("main: pi.py\nmaxRuntime: -1h"))
+		require.ErrorIs(t, err, InvalidPresetError)
+	})
+
+	t.Run("parses the max runtime of a preset", func(t *testing.T) {
+		preset, err := parsePreset("pi", []byte("main: pi.py\nmaxRuntime: 2h"))
+		require.NoError(t, err)
+		require.Equal(t, 2*time.Hour, preset.MaxRuntime)
+	})
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const nodeSelectorPrefix = "spark.kubernetes.node.selector."
+
+var InvalidPriorityClassError error = errors.New("invalid priority class")
+
+// priorityClassPattern matches the DNS subdomain names of priority classes
+var priorityClassPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// validatePriorityClass wraps kind, since priority classes of presets and
+// submissions fail differently
+func validatePriorityClass(name string, kind error) error {
+	if len(name) > 253 || !priorityClassPattern.MatchString(name) {
+		return fmt.Errorf(`%w: "%s" isn't a valid priority class name`, kind, name)
+	}
+	return nil
+}
+
+// Toleration lets the driver and executor pods of a preset schedule onto
+// nodes with matching taints, the fields are the ones of kubernetes
+type Toleration struct {
+	Key               string `yaml:"key,omitempty" json:"key,omitempty"`
+	Operator          string `yaml:"operator,omitempty" json:"operator,omitempty"`
+	Value             string `yaml:"value,omitempty" json:"value,omitempty"`
+	Effect            string `yaml:"effect,omitempty" json:"effect,omitempty"`
+	TolerationSeconds *int64 `yaml:"tolerationSeconds,omitempty" json:"tolerationSeconds,omitempty"`
+}
+
+var affinityKinds = map[string]bool{"nodeAffinity": true, "podAffinity": true, "podAntiAffinity": true}
+
+// validateScheduling checks the node selector, tolerations, priority class
+// and affinity of a preset
+func validateScheduling(preset configurationPreset) error {
+	for _, key := range sortedKeys(preset.NodeSelector) {
+		if !validLabelKey(key) {
+			return fmt.Errorf(`%w: "%s" isn't a valid nodeSelector key`, InvalidPresetError, key)
+		}
+		// values may still be templates, they are checked by kubernetes
+		if value := preset.NodeSelector[key]; !strings.Contains(value, "{{") && (len(value) > 63 || !labelValuePattern.MatchString(value)) {
+			return fmt.Errorf(`%w: "%s" isn't a valid value of nodeSelector "%s"`, InvalidPresetError, value, key)
+		}
+	}
+	for i, toleration := range preset.Tolerations {
+		switch toleration.Operator {
+		case "", "Equal":
+			if toleration.Key == "" {
+				return fmt.Errorf(`%w: toleration %d needs a key with operator "Equal"`, InvalidPresetError, i)
+			}
+		case "Exists":
+			if toleration.Value != "" {
+				return fmt.Errorf(`%w: toleration %d can't have a value with operator "Exists"`, InvalidPresetError, i)
+			}
+		default:
+			return fmt.Errorf(`%w: toleration %d has operator "%s", expected "Equal" or "Exists"`, InvalidPresetError, i, toleration.Operator)
+		}
+		switch toleration.Effect {
+		case "", "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return fmt.Errorf(`%w: toleration %d has effect "%s", expected "NoSchedule", "PreferNoSchedule" or "NoExecute"`, InvalidPresetError, i, toleration.Effect)
+		}
+		if toleration.TolerationSeconds != nil && toleration.Effect != "NoExecute" {
+			return fmt.Errorf(`%w: toleration %d can only set tolerationSeconds with effect "NoExecute"`, InvalidPresetError, i)
+		}
+	}
+	if preset.PriorityClass != "" {
+		if err := validatePriorityClass(preset.PriorityClass, InvalidPresetError); err != nil {
+			return err
+		}
+	}
+	for kind, value := range preset.Affinity {
+		if !affinityKinds[kind] {
+			return fmt.Errorf(`%w: unknown affinity "%s", expected "nodeAffinity", "podAffinity" or "podAntiAffinity"`, InvalidPresetError, kind)
+		}
+		if _, ok := jsonCompatible(value).(map[string]interface{}); !ok {
+			return fmt.Errorf("%w: affinity %s must be an object", InvalidPresetError, kind)
+		}
+	}
+	return nil
+}
+
+// podScheduling are the tolerations, affinity and priority class of a
+// preset, spark has no configuration keys for them so they are merged into
+// the pod templates
+type podScheduling struct {
+	Tolerations   []Toleration
+	Affinity      map[string]interface{}
+	PriorityClass string
+}
+
+func (p podScheduling) empty() bool {
+	return len(p.Tolerations) == 0 && len(p.Affinity) == 0 && p.PriorityClass == ""
+}
+
+// podScheduling returns the scheduling of the preset, priorityClass
+// overrides the one of the preset if not empty
+func (s *Spark) podScheduling(presetName, priorityClass string) podScheduling {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	preset := s.presets[presetName]
+	scheduling := podScheduling{Tolerations: preset.Tolerations, Affinity: preset.Affinity, PriorityClass: preset.PriorityClass}
+	if priorityClass != "" {
+		scheduling.PriorityClass = priorityClass
+	}
+	return scheduling
+}
+
+// apply adds the tolerations to the ones of the pod manifest, sets the
+// affinities the manifest doesn't define itself and replaces its priority
+// class
+func (p podScheduling) apply(manifest []byte) ([]byte, error) {
+	pod := map[string]interface{}{"apiVersion": "v1", "kind": "Pod"}
+	if len(manifest) > 0 {
+		var decoded interface{}
+		if err := yaml.Unmarshal(manifest, &decoded); err != nil {
+			return nil, err
+		}
+		if decoded != nil {
+			var ok bool
+			if pod, ok = jsonCompatible(decoded).(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("not a pod manifest")
+			}
+		}
+	}
+	spec, ok := pod["spec"].(map[string]interface{})
+	if !ok {
+		spec = map[string]interface{}{}
+		pod["spec"] = spec
+	}
+	if len(p.Tolerations) > 0 {
+		tolerations, _ := spec["tolerations"].([]interface{})
+		for _, toleration := range p.Tolerations {
+			tolerations = append(tolerations, toleration)
+		}
+		spec["tolerations"] = tolerations
+	}
+	if p.PriorityClass != "" {
+		spec["priorityClassName"] = p.PriorityClass
+	}
+	if len(p.Affinity) > 0 {
+		affinity, ok := spec["affinity"].(map[string]interface{})
+		if !ok {
+			affinity = map[string]interface{}{}
+			spec["affinity"] = affinity
+		}
+		for kind, value := range p.Affinity {
+			if _, ok := affinity[kind]; !ok {
+				affinity[kind] = jsonCompatible(value)
+			}
+		}
+	}
+	return json.Marshal(pod)
+}
+
+// schedulingTemplates writes the pod templates of a job with the
+// tolerations and affinity merged in. The base of a role is its inline
+// template, or else the template file the args point at.
+func (s *Spark) schedulingTemplates(jobID string, args []string, inline map[string]json.RawMessage, scheduling podScheduling) (map[string]string, error) {
+	files := map[string]string{}
+	for _, role := range []struct {
+		name string
+		key  string
+	}{
+		{"driver", driverPodTemplateKey},
+		{"executor", executorPodTemplateKey},
+	} {
+		manifest := []byte(inline[role.key])
+		if len(manifest) == 0 {
+			if path := confArg(args, role.key); path != "" {
+				var err error
+				if manifest, err = os.ReadFile(path); err != nil {
+					return nil, fmt.Errorf("couldn't read pod template, %w", err)
+				}
+			}
+		}
+		merged, err := scheduling.apply(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: the %s template must be a pod manifest, %s", InvalidPodTemplateError, role.name, err)
+		}
+		if err := os.MkdirAll(s.podTemplateTmpDir(), 0700); err != nil {
+			return nil, fmt.Errorf("couldn't create pod template directory, %w", err)
+		}
+		path := filepath.Join(s.podTemplateTmpDir(), jobID+"-"+role.name+".yaml")
+		if err := os.WriteFile(path, merged, 0600); err != nil {
+			return nil, fmt.Errorf("couldn't write pod template, %w", err)
+		}
+		files[role.key] = path
+	}
+	return files, nil
+}
+
+// confArg returns the value of the --conf arg of the key
+func confArg(args []string, key string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			break
+		}
+		if value, ok := strings.CutPrefix(arg, "--conf="+key+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// jsonCompatible converts the maps decoded by yaml.v2 to maps with string
+// keys, so they can be encoded as JSON
+func jsonCompatible(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, entry := range value {
+			converted[fmt.Sprint(key)] = jsonCompatible(entry)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, entry := range value {
+			converted[key] = jsonCompatible(entry)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, entry := range value {
+			converted[i] = jsonCompatible(entry)
+		}
+		return converted
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(value))
+		for i, entry := range value {
+			converted[i] = jsonCompatible(entry)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+// affinityDetail returns the affinity of a preset in a JSON compatible form
+func affinityDetail(affinity map[string]interface{}) map[string]interface{} {
+	if len(affinity) == 0 {
+		return nil
+	}
+	return jsonCompatible(affinity).(map[string]interface{})
+}
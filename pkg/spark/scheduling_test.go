@@ -0,0 +1,190 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateScheduling(t *testing.T) {
+	t.Run("accepts valid scheduling fields", func(t *testing.T) {
+		_, err := parsePreset("gpu", []byte(`
+main: pi.py
+nodeSelector:
+  cloud.google.com/gke-accelerator: nvidia-tesla-t4
+tolerations:
+- key: nvidia.com/gpu
+  operator: Exists
+  effect: NoSchedule
+- key: spot
+  value: "true"
+  effect: NoExecute
+  tolerationSeconds: 60
+affinity:
+  nodeAffinity:
+    requiredDuringSchedulingIgnoredDuringExecution:
+      nodeSelectorTerms:
+      - matchExpressions:
+        - {key: pool, operator: In, values: [gpu]}
+priorityClass: batch-critical
+`))
+		require.NoError(t, err)
+	})
+
+	t.Run("given invalid scheduling fields, returns InvalidPresetError", func(t *testing.T) {
+		for raw, message := range map[string]string{
+			"nodeSelector: {'bad key': a}":                                      `"bad key" isn't a valid nodeSelector key`,
+			"nodeSelector: {pool: 'not valid'}":                                 `isn't a valid value of nodeSelector "pool"`,
+			"tolerations: [{operator: Equal}]":                                  `needs a key with operator "Equal"`,
+			"tolerations: [{key: a, operator: Exists, value: b}]":               `can't have a value with operator "Exists"`,
+			"tolerations: [{key: a, operator: Matches}]":                        `has operator "Matches"`,
+			"tolerations: [{key: a, effect: NoRun}]":                            `has effect "NoRun"`,
+			"tolerations: [{key: a, effect: NoSchedule, tolerationSeconds: 5}]": "can only set tolerationSeconds",
+			"affinity: {nodeAntiAffinity: {}}":                                  `unknown affinity "nodeAntiAffinity"`,
+			"affinity: {podAffinity: [a]}":                                      "affinity podAffinity must be an object",
+			"priorityClass: Critical_Jobs":                                      `"Critical_Jobs" isn't a valid priority class name`,
+		} {
+			_, err := parsePreset("gpu", []byte("main: pi.py\n"+raw))
+			require.ErrorIs(t, err, InvalidPresetError, raw)
+			require.ErrorContains(t, err, message, raw)
+		}
+	})
+}
+
+func TestPodSchedulingApply(t *testing.T) {
+	seconds := int64(60)
+	scheduling := podScheduling{
+		Tolerations: []Toleration{{Key: "spot", Operator: "Exists", Effect: "NoExecute", TolerationSeconds: &seconds}},
+		Affinity: map[string]interface{}{
+			"nodeAffinity":    map[interface{}]interface{}{"preferred": "preset"},
+			"podAntiAffinity": map[interface{}]interface{}{"required": "preset"},
+		},
+	}
+
+	t.Run("creates a pod without a template", func(t *testing.T) {
+		merged, err := scheduling.apply(nil)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"apiVersion": "v1", "kind": "Pod", "spec": {
+			"tolerations": [{"key": "spot", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 60}],
+			"affinity": {"nodeAffinity": {"preferred": "preset"}, "podAntiAffinity": {"required": "preset"}}
+		}}`, string(merged))
+	})
+
+	t.Run("merges into the template, the affinities of the template win", func(t *testing.T) {
+		merged, err := scheduling.apply([]byte(`
+metadata:
+  labels: {team: data}
+spec:
+  tolerations:
+  - {key: dedicated, value: data}
+  affinity:
+    nodeAffinity: {preferred: template}
+`))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"metadata": {"labels": {"team": "data"}}, "spec": {
+			"tolerations": [{"key": "dedicated", "value": "data"}, {"key": "spot", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 60}],
+			"affinity": {"nodeAffinity": {"preferred": "template"}, "podAntiAffinity": {"required": "preset"}}
+		}}`, string(merged))
+	})
+
+	t.Run("replaces the priority class of the template", func(t *testing.T) {
+		merged, err := podScheduling{PriorityClass: "critical"}.apply([]byte("spec:\n  priorityClassName: low\n"))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"spec": {"priorityClassName": "critical"}}`, string(merged))
+	})
+
+	t.Run("given a template that isn't an object, returns an error", func(t *testing.T) {
+		_, err := scheduling.apply([]byte(`[pod]`))
+		require.Error(t, err)
+	})
+}
+
+func TestSchedulingTemplates(t *testing.T) {
+	templateDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(templateDir, "spot.yaml"), []byte("spec:\n  priorityClassName: low\n"), 0644))
+	scheduling := podScheduling{Tolerations: []Toleration{{Key: "spot", Operator: "Exists"}}}
+	params := map[string]string{"pool": "gpu"}
+	newSpark := func(master string) *Spark {
+		return &Spark{
+			presets: map[string]configurationPreset{"pi": {
+				Main:         "pi.py",
+				NodeSelector: map[string]string{"pool": "{{.pool}}"},
+			}},
+			master:         master,
+			podTemplateDir: templateDir,
+		}
+	}
+
+	t.Run("sets the node selector of kubernetes submissions", func(t *testing.T) {
+		args, err := newSpark("k8s://https://kube").submitArgs("pi", SubmitOptions{Params: params})
+		require.NoError(t, err)
+		require.Equal(t, "gpu", confArg(args, nodeSelectorPrefix+"pool"))
+
+		args, err = newSpark("yarn").submitArgs("pi", SubmitOptions{Params: params})
+		require.NoError(t, err)
+		require.Empty(t, confArg(args, nodeSelectorPrefix+"pool"))
+	})
+
+	t.Run("merges the tolerations into the templates of both roles", func(t *testing.T) {
+		s := newSpark("k8s://https://kube")
+		args, err := s.submitArgs("pi", SubmitOptions{Params: params})
+		require.NoError(t, err)
+		args, err = s.withPodTemplates("job-1", args, &PodTemplate{
+			Name:   "spot",
+			Driver: json.RawMessage(`{"kind": "Pod", "metadata": {"name": "driver"}}`),
+		}, scheduling)
+		require.NoError(t, err)
+		defer s.removePodTemplates("job-1")
+
+		for path, expected := range map[string]string{
+			confArg(args, driverPodTemplateKey):   `{"kind": "Pod", "metadata": {"name": "driver"}, "spec": {"tolerations": [{"key": "spot", "operator": "Exists"}]}}`,
+			confArg(args, executorPodTemplateKey): `{"spec": {"priorityClassName": "low", "tolerations": [{"key": "spot", "operator": "Exists"}]}}`,
+		} {
+			require.Equal(t, s.podTemplateTmpDir(), filepath.Dir(path))
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.JSONEq(t, expected, string(content))
+		}
+	})
+
+	t.Run("the priority class of the submission overrides the preset", func(t *testing.T) {
+		s := &Spark{presets: map[string]configurationPreset{"pi": {Main: "pi.py", PriorityClass: "best-effort"}}}
+		require.Equal(t, "best-effort", s.podScheduling("pi", "").PriorityClass)
+		require.Equal(t, "critical", s.podScheduling("pi", "critical").PriorityClass)
+	})
+
+	t.Run("rejects invalid priority classes of submissions", func(t *testing.T) {
+		_, err := newSpark("k8s://https://kube").Submit(context.Background(), "pi", SubmitOptions{Params: params, PriorityClass: "Not Valid"})
+		require.ErrorIs(t, err, InvalidPriorityClassError)
+
+		_, err = newSpark("yarn").Submit(context.Background(), "pi", SubmitOptions{Params: params, PriorityClass: "critical"})
+		require.ErrorIs(t, err, InvalidPriorityClassError)
+		require.ErrorContains(t, err, "need a kubernetes master")
+	})
+
+	t.Run("ignores the scheduling fields of other masters", func(t *testing.T) {
+		s := newSpark("yarn")
+		args, err := s.submitArgs("pi", SubmitOptions{Params: params})
+		require.NoError(t, err)
+		scheduled, err := s.withPodTemplates("job-1", args, nil, scheduling)
+		require.NoError(t, err)
+		require.Equal(t, args, scheduled)
+	})
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretPattern matches ${secretEnv:NAME}, ${secretFile:/path} and
+// ${vault:path#field} placeholders, $${...} escapes them like environment
+// variables
+var secretPattern = regexp.MustCompile(`\$?\$\{(secretEnv|secretFile|vault):([^}]+)\}`)
+
+// SecretStore reads the secrets of ${vault:path#field} placeholders
+type SecretStore interface {
+	Read(ctx context.Context, path, field string) (string, error)
+}
+
+// resolveSecrets replaces the secret placeholders of the spark-submit
+// arguments right before spark-submit is started, so the values never end up
+// in logs, job records or the API
+func (s *Spark) resolveSecrets(ctx context.Context, args []string) ([]string, error) {
+	resolved := make([]string, len(args))
+	for i, arg := range args {
+		var err error
+		resolved[i] = secretPattern.ReplaceAllStringFunc(arg, func(match string) string {
+			if strings.HasPrefix(match, "$$") {
+				return match[1:]
+			}
+			groups := secretPattern.FindStringSubmatch(match)
+			value, lookupErr := s.lookupSecret(ctx, groups[1], groups[2])
+			if lookupErr != nil && err == nil {
+				err = lookupErr
+			}
+			return value
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+func (s *Spark) lookupSecret(ctx context.Context, kind, ref string) (string, error) {
+	switch kind {
+	case "secretEnv":
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf(`%w "%s"`, UnresolvedVariableError, ref)
+		}
+		return value, nil
+	case "vault":
+		path, field, ok := strings.Cut(ref, "#")
+		if !ok {
+			return "", fmt.Errorf(`%w, vault reference "%s" has no #field`, UnresolvedVariableError, ref)
+		}
+		if s.secrets == nil {
+			return "", fmt.Errorf(`%w, vault reference "%s" but no vault is configured`, UnresolvedVariableError, ref)
+		}
+		value, err := s.secrets.Read(ctx, path, field)
+		if err != nil {
+			return "", fmt.Errorf(`%w, couldn't read "%s" from vault: %s`, UnresolvedVariableError, ref, err)
+		}
+		return value, nil
+	}
+
+	content, err := os.ReadFile(ref)
+	if err != nil {
+		return "", fmt.Errorf(`%w, couldn't read secret file "%s"`, UnresolvedVariableError, ref)
+	}
+	// files created with echo or kubectl often end with a newline
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// escapeSecrets keeps secret placeholders of request values from being
+// resolved, only presets may reference secrets
+func escapeSecrets(value string) string {
+	return secretPattern.ReplaceAllStringFunc(value, func(match string) string {
+		return "$" + match
+	})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter2")
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("s3cr3t\n"), 0600))
+
+	t.Run("resolves environment variables and files", func(t *testing.T) {
+		resolved, err := (&Spark{}).resolveSecrets(context.Background(), []string{
+			"--conf=spark.db.password=${secretEnv:DB_PASSWORD}",
+			"--conf=spark.api.token=Bearer ${secretFile:" + file + "}",
+			"etl.py",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"--conf=spark.db.password=hunter2", "--conf=spark.api.token=Bearer s3cr3t", "etl.py"}, resolved)
+	})
+
+	t.Run("keeps escaped placeholders", func(t *testing.T) {
+		resolved, err := (&Spark{}).resolveSecrets(context.Background(), []string{"$${secretEnv:DB_PASSWORD}", escapeSecrets("${secretEnv:DB_PASSWORD}")})
+		require.NoError(t, err)
+		require.Equal(t, []string{"${secretEnv:DB_PASSWORD}", "${secretEnv:DB_PASSWORD}"}, resolved)
+	})
+
+	t.Run("given a missing secret, returns UnresolvedVariableError without the value", func(t *testing.T) {
+		_, err := (&Spark{}).resolveSecrets(context.Background(), []string{"${secretEnv:NOPE}"})
+		require.ErrorIs(t, err, UnresolvedVariableError)
+		_, err = (&Spark{}).resolveSecrets(context.Background(), []string{"${secretFile:/nope}"})
+		require.ErrorIs(t, err, UnresolvedVariableError)
+		require.ErrorContains(t, err, `couldn't read secret file "/nope"`)
+	})
+}
+
+func TestSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter2")
+	out := filepath.Join(t.TempDir(), "args")
+	s := newTestSpark(t, Config{}, nil, `echo "$@" > `+out, map[string]configurationPreset{
+		"etl": {Main: "etl.py", Args: []string{"{{ .user }}"}, SparkConf: map[string]string{"spark.db.password": "${secretEnv:DB_PASSWORD}"}},
+	})
+
+	t.Run("passes the secrets only to spark-submit", func(t *testing.T) {
+		args, err := s.submitArgs("etl", SubmitOptions{Params: map[string]string{"user": "etl"}})
+		require.NoError(t, err)
+		require.Contains(t, args, "--conf=spark.db.password=${secretEnv:DB_PASSWORD}")
+
+		_, err = s.Submit(context.Background(), "etl", SubmitOptions{Params: map[string]string{"user": "etl"}})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		content, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Contains(t, string(content), "--conf=spark.db.password=hunter2")
+	})
+
+	t.Run("doesn't resolve secrets of request values", func(t *testing.T) {
+		args, err := s.submitArgs("etl", SubmitOptions{
+			Params:    map[string]string{"user": "${secretEnv:DB_PASSWORD}"},
+			SparkConf: map[string]string{"spark.leak": "${secretEnv:DB_PASSWORD}"},
+			Args:      []string{"${secretEnv:DB_PASSWORD}"},
+		})
+		require.NoError(t, err)
+		resolved, err := s.resolveSecrets(context.Background(), args)
+		require.NoError(t, err)
+		require.Equal(t, 1, strings.Count(strings.Join(resolved, " "), "hunter2"))
+		require.Contains(t, resolved, "--conf=spark.leak=${secretEnv:DB_PASSWORD}")
+	})
+
+	t.Run("rejects secret placeholders in request sparkConf keys", func(t *testing.T) {
+		for _, conf := range []map[string]string{
+			{"spark.x.${secretEnv:DB_PASSWORD}": "1"},
+			{"spark.x.${secretFile:/var/run/secrets/kubernetes.io/serviceaccount/token": "}"},
+			{"spark.x.$": "{secretEnv:DB_PASSWORD}"},
+		} {
+			_, err := s.submitArgs("etl", SubmitOptions{Params: map[string]string{"user": "etl"}, SparkConf: conf})
+			require.ErrorIs(t, err, InvalidSparkConfError)
+		}
+	})
+
+	t.Run("given a missing secret, rejects the submission", func(t *testing.T) {
+		s.presets["missing"] = configurationPreset{Main: "etl.py", SparkConf: map[string]string{"spark.key": "${secretEnv:NOPE}"}}
+		_, err := s.submit(context.Background(), "missing", SubmitOptions{})
+		require.ErrorIs(t, err, UnresolvedVariableError)
+	})
+}
+
+type secretStoreMock map[string]string
+
+func (m secretStoreMock) Read(ctx context.Context, path, field string) (string, error) {
+	value, ok := m[path+"#"+field]
+	if !ok {
+		return "", errors.New("secret not found")
+	}
+	return value, nil
+}
+
+func TestVaultSecrets(t *testing.T) {
+	s := &Spark{secrets: secretStoreMock{"secret/data/spark#password": "hunter2"}}
+
+	t.Run("resolves vault references", func(t *testing.T) {
+		resolved, err := s.resolveSecrets(context.Background(), []string{"--conf=spark.db.password=${vault:secret/data/spark#password}"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"--conf=spark.db.password=hunter2"}, resolved)
+	})
+
+	t.Run("given a missing secret or field, returns UnresolvedVariableError", func(t *testing.T) {
+		_, err := s.resolveSecrets(context.Background(), []string{"${vault:secret/data/spark#nope}"})
+		require.ErrorIs(t, err, UnresolvedVariableError)
+		_, err = s.resolveSecrets(context.Background(), []string{"${vault:secret/data/spark}"})
+		require.ErrorContains(t, err, "has no #field")
+	})
+
+	t.Run("given no vault, returns UnresolvedVariableError", func(t *testing.T) {
+		_, err := (&Spark{}).resolveSecrets(context.Background(), []string{"${vault:secret/data/spark#password}"})
+		require.ErrorIs(t, err, UnresolvedVariableError)
+		require.ErrorContains(t, err, "no vault is configured")
+	})
+}
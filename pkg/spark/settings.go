@@ -0,0 +1,133 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"go.uber.org/zap"
+)
+
+var InvalidSettingsError error = errors.New("invalid settings")
+
+var MaintenanceError error = errors.New("server is in maintenance")
+
+// Settings are the settings that can change while the server is running
+type Settings struct {
+	MaxConcurrentSubmits int            `json:"maxConcurrentSubmits"`
+	MaxQueuedSubmits     int            `json:"maxQueuedSubmits"`
+	MaxProcesses         int            `json:"maxSparkProcesses"`
+	NamespaceQuotas      map[string]int `json:"namespaceQuotas"`
+	RejectOverQuota      bool           `json:"rejectOverQuota"`
+	// Backoff are the retry defaults of presets without their own backoff
+	Backoff backoff.Config `json:"backoff"`
+	// Maintenance rejects new submissions, other requests are still served
+	Maintenance bool `json:"maintenance"`
+}
+
+// SettingsUpdate changes the settings that aren't nil, the backoff overrides
+// the non-zero fields of the current one
+type SettingsUpdate struct {
+	MaxConcurrentSubmits *int            `json:"maxConcurrentSubmits"`
+	MaxQueuedSubmits     *int            `json:"maxQueuedSubmits"`
+	MaxProcesses         *int            `json:"maxSparkProcesses"`
+	NamespaceQuotas      map[string]int  `json:"namespaceQuotas"`
+	RejectOverQuota      *bool           `json:"rejectOverQuota"`
+	Backoff              *backoff.Config `json:"backoff"`
+	Maintenance          *bool           `json:"maintenance"`
+}
+
+// Settings returns the current settings
+func (s *Spark) Settings() Settings {
+	workers, queueSize := s.workers.limits()
+	limits, reject := s.quotas.getLimits()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Settings{
+		MaxConcurrentSubmits: workers,
+		MaxQueuedSubmits:     queueSize,
+		MaxProcesses:         s.processes.getLimit(),
+		NamespaceQuotas:      limits,
+		RejectOverQuota:      reject,
+		Backoff:              backoff.Default.Override(s.backoff),
+		Maintenance:          s.maintenance,
+	}
+}
+
+// UpdateSettings validates and applies the update at once, it returns the
+// resulting settings
+func (s *Spark) UpdateSettings(update SettingsUpdate) (Settings, error) {
+	current := s.Settings()
+	if update.MaxConcurrentSubmits != nil {
+		if *update.MaxConcurrentSubmits <= 0 {
+			return current, fmt.Errorf("%w: maxConcurrentSubmits must be positive", InvalidSettingsError)
+		}
+		current.MaxConcurrentSubmits = *update.MaxConcurrentSubmits
+	}
+	if update.MaxQueuedSubmits != nil {
+		if *update.MaxQueuedSubmits <= 0 {
+			return current, fmt.Errorf("%w: maxQueuedSubmits must be positive", InvalidSettingsError)
+		}
+		current.MaxQueuedSubmits = *update.MaxQueuedSubmits
+	}
+	if update.MaxProcesses != nil {
+		if *update.MaxProcesses < 0 {
+			return current, fmt.Errorf("%w: maxSparkProcesses can't be negative", InvalidSettingsError)
+		}
+		current.MaxProcesses = *update.MaxProcesses
+	}
+	if update.NamespaceQuotas != nil {
+		for namespace, limit := range update.NamespaceQuotas {
+			if limit < 0 {
+				return current, fmt.Errorf(`%w: the quota of namespace "%s" can't be negative`, InvalidSettingsError, namespace)
+			}
+		}
+		current.NamespaceQuotas = update.NamespaceQuotas
+	}
+	if update.RejectOverQuota != nil {
+		current.RejectOverQuota = *update.RejectOverQuota
+	}
+	if update.Backoff != nil {
+		current.Backoff = current.Backoff.Override(*update.Backoff)
+		if err := current.Backoff.Validate(); err != nil {
+			return current, fmt.Errorf("%w: %s", InvalidSettingsError, err)
+		}
+	}
+	if update.Maintenance != nil {
+		current.Maintenance = *update.Maintenance
+	}
+
+	s.workers.resize(current.MaxConcurrentSubmits, current.MaxQueuedSubmits)
+	s.processes.setLimit(current.MaxProcesses)
+	s.mu.Lock()
+	s.backoff = current.Backoff
+	s.maintenance = current.Maintenance
+	s.mu.Unlock()
+	for _, held := range s.quotas.setLimits(current.NamespaceQuotas, current.RejectOverQuota) {
+		if !s.startHeld(held) {
+			s.releaseQuota(held.jobID)
+		}
+	}
+	zap.L().Info("updated settings", zap.Any("settings", current))
+	return current, nil
+}
+
+// inMaintenance reports whether new submissions are rejected
+func (s *Spark) inMaintenance() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maintenance
+}
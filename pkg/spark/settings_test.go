@@ -0,0 +1,101 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateSettings(t *testing.T) {
+	newSpark := func() *Spark {
+		return newTestSpark(t, Config{}, nil, "exit 0", map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+	intPtr := func(i int) *int { return &i }
+	boolPtr := func(b bool) *bool { return &b }
+
+	t.Run("applies the given settings and keeps the others", func(t *testing.T) {
+		s := newSpark()
+		settings, err := s.UpdateSettings(SettingsUpdate{
+			MaxConcurrentSubmits: intPtr(3),
+			MaxProcesses:         intPtr(2),
+			Backoff:              &backoff.Config{Retries: 5},
+		})
+		require.NoError(t, err)
+		require.Equal(t, settings, s.Settings())
+		require.Equal(t, 3, settings.MaxConcurrentSubmits)
+		require.Equal(t, DefaultMaxQueuedSubmits, settings.MaxQueuedSubmits)
+		require.Equal(t, 2, settings.MaxProcesses)
+		require.Equal(t, 5, settings.Backoff.Retries)
+		require.Equal(t, backoff.Default.InitialDelay, settings.Backoff.InitialDelay)
+		s.workers.close()
+	})
+
+	t.Run("given invalid settings, returns InvalidSettingsError and changes nothing", func(t *testing.T) {
+		s := newSpark()
+		before := s.Settings()
+		_, err := s.UpdateSettings(SettingsUpdate{MaxConcurrentSubmits: intPtr(4), MaxQueuedSubmits: intPtr(0)})
+		require.ErrorIs(t, err, InvalidSettingsError)
+		require.EqualError(t, err, "invalid settings: maxQueuedSubmits must be positive")
+		_, err = s.UpdateSettings(SettingsUpdate{NamespaceQuotas: map[string]int{"etl": -1}})
+		require.ErrorIs(t, err, InvalidSettingsError)
+		require.Equal(t, before, s.Settings())
+		s.workers.close()
+	})
+
+	t.Run("given maintenance, rejects new submissions", func(t *testing.T) {
+		s := newSpark()
+		_, err := s.UpdateSettings(SettingsUpdate{Maintenance: boolPtr(true)})
+		require.NoError(t, err)
+		_, err = s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.ErrorIs(t, err, MaintenanceError)
+		s.workers.close()
+	})
+
+	t.Run("given a higher namespace quota, starts held submissions", func(t *testing.T) {
+		done := filepath.Join(t.TempDir(), "done")
+		s := newSpark()
+		s.presets = map[string]configurationPreset{"pi": {Main: "pi.py", SparkConf: map[string]string{namespaceConfKey: "etl"}}}
+		s.binaryPath = fakeSparkSubmit(t, "while [ ! -f "+done+" ]; do sleep 0.01; done")
+		s.master = "k8s://https://kube"
+		s.quotas = newNamespaceQuotas(map[string]int{"etl": 1}, false)
+		state := func(id string) jobs.State {
+			job, err := s.Job(id)
+			require.NoError(t, err)
+			return job.State
+		}
+
+		first, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		second, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return state(first) == jobs.StateRunning }, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, jobs.StatePending, state(second))
+
+		_, err = s.UpdateSettings(SettingsUpdate{NamespaceQuotas: map[string]int{"etl": 2}})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return state(second) == jobs.StateRunning }, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, os.WriteFile(done, nil, 0644))
+		require.Eventually(t, func() bool { return state(second) == jobs.StateSucceeded }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/Staffbase/spark-submit/pkg/objstore"
+	"go.uber.org/zap"
+)
+
+// PresetSource provides presets from outside the preset directory, like
+// ConfigMaps or object stores. Its presets are refreshed periodically and
+// can't be changed through the API.
+type PresetSource interface {
+	// Name identifies the source in logs and errors
+	Name() string
+	// Load returns the content of all preset files by their slash separated
+	// path, the extension selects the format like in the preset directory
+	Load(ctx context.Context) (map[string][]byte, error)
+}
+
+// Revisioner is implemented by preset sources that know the version of the
+// presets they loaded last, like the commit of a git repository
+type Revisioner interface {
+	Revision() string
+}
+
+// PresetSourceStatus is the state of a preset source after its last refresh
+type PresetSourceStatus struct {
+	Name        string     `json:"name"`
+	Revision    string     `json:"revision,omitempty"`
+	PresetCount int        `json:"presetCount"`
+	RefreshedAt *time.Time `json:"refreshedAt,omitempty"`
+	// Error is the reason the last refresh failed, the presets of the
+	// previous refresh are used in the meantime
+	Error string `json:"error,omitempty"`
+}
+
+// decodePresetFiles parses the files of a source, files with unknown
+// extensions are skipped and files with invalid names or content are
+// returned as load errors
+func decodePresetFiles(source string, files map[string][]byte) (map[string]configurationPreset, []PresetLoadError) {
+	paths := make([]string, 0, len(files))
+	for file := range files {
+		paths = append(paths, file)
+	}
+	sort.Strings(paths)
+
+	presets := make(map[string]configurationPreset)
+	loadErrors := make([]PresetLoadError, 0)
+	for _, file := range paths {
+		ext := path.Ext(file)
+		decode, ok := presetDecoders[ext]
+		if !ok {
+			continue
+		}
+
+		name := strings.TrimSuffix(file, ext)
+		if !validPresetName(name) {
+			zap.L().Warn("ignoring preset with invalid name", zap.String("source", source), zap.String("file", file))
+			loadErrors = append(loadErrors, PresetLoadError{Source: source, File: file, Preset: name, Error: invalidPresetNameError(name).Error()})
+			continue
+		}
+
+		var preset configurationPreset
+		err := decode(files[file], &preset)
+		if err == nil {
+			err = validatePreset(preset)
+		}
+		if err != nil {
+			zap.L().Warn("couldn't parse preset", zap.Error(err), zap.String("source", source), zap.String("file", file))
+			loadErrors = append(loadErrors, PresetLoadError{Source: source, File: file, Preset: name, Error: err.Error()})
+			continue
+		}
+		if _, ok := presets[name]; ok {
+			zap.L().Warn("ignoring duplicate preset", zap.String("source", source), zap.String("file", file))
+			continue
+		}
+		presets[name] = preset
+	}
+	return presets, loadErrors
+}
+
+// refreshPresetSource replaces the presets of the source with its current
+// content. Presets of the preset directory and other sources take
+// precedence over presets of the same name.
+func (s *Spark) refreshPresetSource(ctx context.Context, source PresetSource) error {
+	files, err := source.Load(ctx)
+	if err != nil {
+		err = fmt.Errorf("couldn't load presets of %s, %w", source.Name(), err)
+		s.mu.Lock()
+		status := s.sourceStatus[source.Name()]
+		status.Name, status.Error = source.Name(), err.Error()
+		s.sourceStatus[source.Name()] = status
+		s.mu.Unlock()
+		return err
+	}
+	presets, loadErrors := decodePresetFiles(source.Name(), files)
+
+	changed := false
+	s.mu.Lock()
+	now := time.Now()
+	status := PresetSourceStatus{Name: source.Name(), PresetCount: len(presets), RefreshedAt: &now}
+	if revisioner, ok := source.(Revisioner); ok {
+		status.Revision = revisioner.Revision()
+	}
+	s.sourceStatus[source.Name()] = status
+	s.setPresetLoadErrors(source.Name(), loadErrors)
+	for name, owner := range s.presetSources {
+		if _, ok := presets[name]; owner == source.Name() && !ok {
+			delete(s.presets, name)
+			delete(s.presetSources, name)
+			changed = true
+		}
+	}
+	for name, preset := range presets {
+		existing, exists := s.presets[name]
+		if exists && s.presetSources[name] != source.Name() {
+			zap.L().Warn("ignoring duplicate preset", zap.String("source", source.Name()), zap.String("presetName", name))
+			continue
+		}
+		if !exists || !reflect.DeepEqual(existing, preset) {
+			changed = true
+			s.warnSparkConf(name, preset)
+		}
+		s.presets[name] = preset
+		s.presetSources[name] = source.Name()
+	}
+	s.mu.Unlock()
+
+	if changed {
+		zap.L().Info("refreshed presets", zap.String("source", source.Name()), zap.Int("presetCount", len(presets)))
+		s.presetsChanged()
+	}
+	return nil
+}
+
+// PresetSources returns the state of all preset sources
+func (s *Spark) PresetSources() []PresetSourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make([]PresetSourceStatus, 0, len(s.sources))
+	for _, source := range s.sources {
+		statuses = append(statuses, s.sourceStatus[source.Name()])
+	}
+	return statuses
+}
+
+// WatchPresetSources refreshes the presets of all sources every interval
+// until ctx is done. If a source can't be loaded, its last presets are kept.
+func (s *Spark) WatchPresetSources(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, source := range s.sources {
+			if err := s.refreshPresetSource(ctx, source); err != nil {
+				zap.L().Warn("couldn't refresh presets", zap.String("source", source.Name()), zap.Error(err))
+			}
+		}
+	}
+}
+
+// ConfigMapSource loads presets from the ConfigMaps matching a label
+// selector, every data key is a preset file like "etl.yaml"
+type ConfigMapSource struct {
+	client    *kube.Client
+	namespace string
+	selector  string
+}
+
+// NewConfigMapSource creates a preset source for the ConfigMaps of the
+// namespace, an empty namespace means all namespaces
+func NewConfigMapSource(client *kube.Client, namespace, selector string) *ConfigMapSource {
+	return &ConfigMapSource{client: client, namespace: namespace, selector: selector}
+}
+
+func (c *ConfigMapSource) Name() string {
+	return fmt.Sprintf(`ConfigMaps "%s"`, c.selector)
+}
+
+// Load returns the data of all matching ConfigMaps, if several ConfigMaps
+// contain the same key the first one in namespace and name order wins
+func (c *ConfigMapSource) Load(ctx context.Context) (map[string][]byte, error) {
+	configMaps, err := c.client.ListConfigMaps(ctx, c.namespace, c.selector)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list ConfigMaps, %w", err)
+	}
+
+	files := make(map[string][]byte)
+	for _, configMap := range configMaps {
+		for key, value := range configMap.Data {
+			if _, ok := files[key]; ok {
+				zap.L().Warn("ignoring duplicate preset file", zap.String("namespace", configMap.Metadata.Namespace), zap.String("configMap", configMap.Metadata.Name), zap.String("file", key))
+				continue
+			}
+			files[key] = []byte(value)
+		}
+	}
+	return files, nil
+}
+
+// ObjectStoreSource loads presets from the objects below a prefix of an S3
+// or GCS bucket, keys are named like the files of the preset directory
+type ObjectStoreSource struct {
+	url    string
+	bucket objstore.Bucket
+	prefix string
+
+	mu sync.Mutex
+	// cache keeps the content of unchanged objects between refreshes
+	cache map[string]cachedObject
+}
+
+type cachedObject struct {
+	etag    string
+	content []byte
+}
+
+// NewObjectStoreSource creates a preset source for an s3:// or gs:// URL
+func NewObjectStoreSource(rawURL string) (*ObjectStoreSource, error) {
+	bucket, prefix, err := objstore.Open(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &ObjectStoreSource{url: rawURL, bucket: bucket, prefix: prefix, cache: make(map[string]cachedObject)}, nil
+}
+
+func (o *ObjectStoreSource) Name() string {
+	return o.url
+}
+
+// Load lists the prefix and downloads the preset files whose ETag changed
+// since the last refresh
+func (o *ObjectStoreSource) Load(ctx context.Context) (map[string][]byte, error) {
+	objects, err := o.bucket.List(ctx, o.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list objects, %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	files := make(map[string][]byte)
+	cache := make(map[string]cachedObject)
+	for _, object := range objects {
+		if _, ok := presetDecoders[path.Ext(object.Key)]; !ok {
+			continue
+		}
+
+		cached, ok := o.cache[object.Key]
+		if !ok || cached.etag != object.ETag || object.ETag == "" {
+			content, err := o.bucket.Get(ctx, object.Key)
+			if errors.Is(err, objstore.NotFoundError) {
+				// deleted since listing
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf(`couldn't read object "%s", %w`, object.Key, err)
+			}
+			cached = cachedObject{etag: object.ETag, content: content}
+		}
+		cache[object.Key] = cached
+		files[strings.TrimPrefix(object.Key, o.prefix)] = cached.content
+	}
+	o.cache = cache
+	return files, nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/Staffbase/spark-submit/pkg/objstore"
+	"github.com/stretchr/testify/require"
+)
+
+type sourceMock struct {
+	files map[string][]byte
+	err   error
+}
+
+func (m *sourceMock) Revision() string {
+	return "v1"
+}
+
+func (m *sourceMock) Name() string {
+	return "mock"
+}
+
+func (m *sourceMock) Load(ctx context.Context) (map[string][]byte, error) {
+	return m.files, m.err
+}
+
+func TestPresetSources(t *testing.T) {
+	source := &sourceMock{files: map[string][]byte{
+		"etl.yaml":      []byte("main: etl.py"),
+		"team/sql.json": []byte(`{"main": "sql.py"}`),
+		"pi.yaml":       []byte("main: other.py"),
+		"README.md":     []byte("# presets"),
+		"../up.yaml":    []byte("main: up.py"),
+		"nightly.yaml":  []byte("main: nightly.py\nschedule: every night"),
+	}}
+	s, err := New(Config{SparkHome: ".", PresetDir: "../../example/sparkConf", PresetSources: []PresetSource{source}}, jobs.NewMemoryStore())
+	require.NoError(t, err)
+
+	t.Run("loads the presets of the source on start", func(t *testing.T) {
+		require.Equal(t, "etl.py", s.presets["etl"].Main)
+		require.Equal(t, "sql.py", s.presets["team/sql"].Main)
+		require.NotContains(t, s.presets, "../up")
+		require.NotContains(t, s.presets, "nightly")
+		errs := s.PresetLoadErrors()
+		require.Len(t, errs, 2)
+		require.Equal(t, PresetLoadError{Source: "mock", File: "../up.yaml", Preset: "../up", Error: invalidPresetNameError("../up").Error()}, errs[0])
+		require.Equal(t, "nightly.yaml", errs[1].File)
+		require.Contains(t, errs[1].Error, "invalid schedule")
+	})
+
+	t.Run("presets of the preset directory take precedence", func(t *testing.T) {
+		require.NotEqual(t, "other.py", s.presets["pi"].Main)
+	})
+
+	t.Run("presets of a source can't be changed through the API", func(t *testing.T) {
+		require.ErrorIs(t, s.PutPreset("etl", []byte("main: etl2.py")), ReadOnlyPresetError)
+		require.ErrorIs(t, s.DeletePreset("etl"), ReadOnlyPresetError)
+	})
+
+	t.Run("refreshing replaces the presets and notifies listeners", func(t *testing.T) {
+		changes := 0
+		s.OnPresetChange(func() { changes++ })
+
+		require.NoError(t, s.refreshPresetSource(context.Background(), source))
+		require.Equal(t, 0, changes)
+
+		source.files = map[string][]byte{"etl.yaml": []byte("main: etl2.py")}
+		require.NoError(t, s.refreshPresetSource(context.Background(), source))
+		require.Equal(t, 1, changes)
+		require.Equal(t, "etl2.py", s.presets["etl"].Main)
+		require.NotContains(t, s.presets, "team/sql")
+		require.Empty(t, s.PresetLoadErrors())
+	})
+
+	t.Run("PresetDetail reports the source and its revision", func(t *testing.T) {
+		detail, err := s.PresetDetail("etl", nil)
+		require.NoError(t, err)
+		require.Equal(t, "mock", detail.Source)
+		require.Equal(t, "v1", detail.Revision)
+	})
+
+	t.Run("given a failing source, keeps its presets", func(t *testing.T) {
+		source.err = errors.New("unavailable")
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		s.WatchPresetSources(ctx, 10*time.Millisecond)
+		require.Equal(t, "etl2.py", s.presets["etl"].Main)
+
+		statuses := s.PresetSources()
+		require.Len(t, statuses, 1)
+		require.Equal(t, "v1", statuses[0].Revision)
+		require.Equal(t, 1, statuses[0].PresetCount)
+		require.NotNil(t, statuses[0].RefreshedAt)
+		require.Contains(t, statuses[0].Error, "unavailable")
+	})
+}
+
+type bucketMock struct {
+	objects map[string]objstore.Object
+	content map[string]string
+	gets    []string
+	puts    int
+}
+
+func (m *bucketMock) List(ctx context.Context, prefix string) ([]objstore.Object, error) {
+	objects := make([]objstore.Object, 0, len(m.objects))
+	for _, object := range m.objects {
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (m *bucketMock) Get(ctx context.Context, key string) ([]byte, error) {
+	m.gets = append(m.gets, key)
+	content, ok := m.content[key]
+	if !ok {
+		return nil, objstore.NotFoundError
+	}
+	return []byte(content), nil
+}
+
+func (m *bucketMock) Put(ctx context.Context, key string, content []byte) (objstore.Object, error) {
+	m.puts++
+	object := objstore.Object{Key: key, ETag: fmt.Sprint(m.puts), Size: int64(len(content))}
+	m.objects[key] = object
+	m.content[key] = string(content)
+	return object, nil
+}
+
+func TestConfigMapSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/namespaces/data/configmaps", r.URL.Path)
+		require.Equal(t, "spark-submit/presets=true", r.URL.Query().Get("labelSelector"))
+		_, _ = w.Write([]byte(`{"items": [
+			{"metadata": {"name": "a", "namespace": "data"}, "data": {"etl.yaml": "main: etl.py", "sql.toml": "main = 'sql.py'"}},
+			{"metadata": {"name": "b", "namespace": "data"}, "data": {"etl.yaml": "main: other.py"}}
+		]}`))
+	}))
+	defer server.Close()
+	client, err := kube.NewClient(server.URL)
+	require.NoError(t, err)
+
+	files, err := NewConfigMapSource(client, "data", "spark-submit/presets=true").Load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{
+		"etl.yaml": []byte("main: etl.py"),
+		"sql.toml": []byte("main = 'sql.py'"),
+	}, files)
+}
+
+func TestObjectStoreSource(t *testing.T) {
+	bucket := &bucketMock{
+		objects: map[string]objstore.Object{
+			"spark/etl.yaml":      {Key: "spark/etl.yaml", ETag: "1"},
+			"spark/team/sql.toml": {Key: "spark/team/sql.toml", ETag: "1"},
+			"spark/README.md":     {Key: "spark/README.md", ETag: "1"},
+			"spark/gone.yaml":     {Key: "spark/gone.yaml", ETag: "1"},
+		},
+		content: map[string]string{
+			"spark/etl.yaml":      "main: etl.py",
+			"spark/team/sql.toml": "main = 'sql.py'",
+		},
+	}
+	source := &ObjectStoreSource{url: "s3://presets/spark", bucket: bucket, prefix: "spark/", cache: make(map[string]cachedObject)}
+
+	t.Run("loads the preset files below the prefix", func(t *testing.T) {
+		files, err := source.Load(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, map[string][]byte{
+			"etl.yaml":      []byte("main: etl.py"),
+			"team/sql.toml": []byte("main = 'sql.py'"),
+		}, files)
+	})
+
+	t.Run("downloads only changed objects", func(t *testing.T) {
+		bucket.gets = nil
+		bucket.objects["spark/etl.yaml"] = objstore.Object{Key: "spark/etl.yaml", ETag: "2"}
+		bucket.content["spark/etl.yaml"] = "main: etl2.py"
+
+		files, err := source.Load(context.Background())
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"spark/etl.yaml", "spark/gone.yaml"}, bucket.gets)
+		require.Equal(t, "main: etl2.py", string(files["etl.yaml"]))
+	})
+
+	t.Run("NewObjectStoreSource adds a slash to the prefix", func(t *testing.T) {
+		source, err := NewObjectStoreSource("gs://presets/spark")
+		require.NoError(t, err)
+		require.Equal(t, "spark/", source.prefix)
+	})
+}
@@ -15,164 +15,1182 @@ package spark
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/Staffbase/spark-submit/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapio"
-	"gopkg.in/yaml.v2"
 )
 
 type Spark struct {
-	presets    map[string]configurationPreset
-	binaryPath string
-	master     string
-	debug      bool
+	mu      sync.RWMutex
+	presets map[string]configurationPreset
+	// presetFiles are the files of presets that aren't stored as name.yaml
+	presetFiles map[string]string
+	// presetSources are the names of the sources of presets that don't come
+	// from the preset directory
+	presetSources map[string]string
+	sources       []PresetSource
+	sourceStatus  map[string]PresetSourceStatus
+	// presetErrors are the skipped files of the last load by source
+	presetErrors map[string][]PresetLoadError
+	// defaults are merged under every preset
+	defaults       presetDefaults
+	secrets        SecretStore
+	keytabDir      string
+	confDir        string
+	binaryPath     string
+	master         string
+	debug          bool
+	timeout        time.Duration
+	backoff        backoff.Config
+	retryLimits    RetryLimits
+	events         events.Publisher
+	callbacks      CallbackSender
+	jobs           jobs.Store
+	queue          jobs.QueueStore
+	replica        string
+	onChange       []func()
+	cancelMu       sync.Mutex
+	timers         map[string]*time.Timer
+	cancels        map[string]context.CancelFunc
+	closed         bool
+	draining       bool
+	wg             sync.WaitGroup
+	workers        *workerPool
+	quotas         *namespaceQuotas
+	submitMu       sync.Mutex
+	outputMu       sync.Mutex
+	outputs        map[string]*outputBuffer
+	outputOrder    []string
+	outputLimit    int
+	stderrLimit    int
+	processes      *processLimiter
+	idempotencyTTL time.Duration
+	// allowedSparkConf are glob patterns of sparkConf keys missing from the catalog
+	allowedSparkConf []string
+	allowedMasters   []string
+	namespaces       []string
+	apps             AppController
+	tenantOf         func(presetName string) string
+	prices           Prices
+	capacity         CapacityChecker
+	capacityMode     CapacityMode
+	podTemplateDir   string
+	artifacts        *ArtifactStore
+	dryRun           bool
+	runner           SubmitRunner
+	// maintenance rejects new submissions, it is guarded by mu
+	maintenance   bool
+	runtimeTimers map[string]*time.Timer
+}
+
+type Config struct {
+	SparkHome string
+	PresetDir string
+	// DefaultsFile is merged under every preset, the _defaults.yaml of the
+	// preset directory if empty
+	DefaultsFile string
+	Master       string
+	// Debug writes the spark-submit output to the logger
+	Debug bool
+	// SubmitOutputLimit is the number of bytes of spark-submit output kept
+	// per job, DefaultSubmitOutputLimit if 0
+	SubmitOutputLimit int
+	// StderrTailLimit is the number of bytes of stderr of the last attempt
+	// stored on failed jobs, DefaultStderrTailLimit if 0
+	StderrTailLimit int
+	// MaxConcurrentSubmits is the number of workers running submissions,
+	// DefaultSubmitWorkers if 0
+	MaxConcurrentSubmits int
+	// MaxQueuedSubmits limits the submissions waiting for a free worker,
+	// DefaultMaxQueuedSubmits if 0
+	MaxQueuedSubmits int
+	// MaxProcesses limits the spark-submit processes of submits, status and
+	// kill requests at the same time, 0 means unlimited
+	MaxProcesses int
+	// CommandTimeout limits how long a single spark-submit run may take, 0 means no limit
+	CommandTimeout time.Duration
+	// Backoff controls the retries of failed submissions, presets can override it
+	Backoff backoff.Config
+	// RetryLimits bound the retry policies of submissions, zero limits only
+	// allow submissions without retries
+	RetryLimits RetryLimits
+	// Events receives the lifecycle events of all jobs, optional
+	Events events.Publisher
+	// Callbacks posts finished jobs to the callback URLs of their
+	// submissions, unsigned webhook deliveries if it isn't set
+	Callbacks CallbackSender
+	// PresetSources provide presets in addition to the preset directory, optional
+	PresetSources []PresetSource
+	// Secrets resolves ${vault:path#field} placeholders, optional
+	Secrets SecretStore
+	// KeytabDir contains the keytabs presets refer to
+	KeytabDir string
+	// IdempotencyTTL is how long idempotency keys of submissions are
+	// remembered, DefaultIdempotencyTTL if 0
+	IdempotencyTTL time.Duration
+	// AllowedSparkConf are glob patterns of sparkConf keys that aren't
+	// reported as unknown, e.g. the properties of spark plugins
+	AllowedSparkConf []string
+	// AllowedMasters are the masters submissions may select instead of the
+	// master of the preset or server, none if empty
+	AllowedMasters []string
+	// AllowedNamespaces are glob patterns of the kubernetes namespaces the
+	// server may submit to, kill in or query, all if empty
+	AllowedNamespaces []string
+	// NamespaceQuotas limit the queued and running submissions per
+	// kubernetes namespace, DefaultNamespaceQuota applies to all others
+	NamespaceQuotas map[string]int
+	// RejectOverQuota rejects submissions beyond the quota of their
+	// namespace instead of holding them back
+	RejectOverQuota bool
+	// Tenant returns the tenant of a preset for the metric labels, optional
+	Tenant func(presetName string) string
+	// Prices estimate the cost of finished jobs, optional
+	Prices Prices
+	// CapacityCheck rejects or queues kubernetes submissions that don't fit
+	// on the cluster, it needs a CapacityChecker
+	CapacityCheck CapacityMode
+	// PodTemplateDir contains the pod templates submissions can select by name
+	PodTemplateDir string
+	// Artifacts stores uploaded artifacts that presets and submissions
+	// reference as artifact://<name>, optional
+	Artifacts *ArtifactStore
+	// DryRun processes submissions like always but skips running
+	// spark-submit and everything that reaches out of the server, the
+	// attempts succeed
+	DryRun bool
+	// SubmitRunner runs the spark-submit attempts instead of the
+	// spark-submit binary, SparkHome isn't needed then
+	SubmitRunner SubmitRunner
+	// Queue keeps the scheduled and pending submissions across restarts,
+	// optional. Shutdown leaves them queued for RestoreQueue.
+	Queue jobs.QueueStore
+	// Replica names this replica of the server if the job store is shared
+	// with other replicas, the jobs it runs carry it
+	Replica string
 }
 
 type configurationPreset struct {
-	Main      string            `yaml:"main"`
-	Args      []string          `yaml:"args"`
-	SparkConf map[string]string `yaml:"sparkConf"`
+	Main string `yaml:"main"`
+	// MainClass is the entry point of JVM applications, main must be a jar
+	MainClass string            `yaml:"mainClass,omitempty"`
+	Args      []string          `yaml:"args,omitempty"`
+	SparkConf map[string]string `yaml:"sparkConf,omitempty"`
+	// Master overrides the spark master of the server, e.g. for a GPU cluster
+	Master string `yaml:"master,omitempty"`
+	// the dependencies are passed as the spark-submit flags of the same name
+	Jars         []string `yaml:"jars,omitempty"`
+	PyFiles      []string `yaml:"pyFiles,omitempty"`
+	Packages     []string `yaml:"packages,omitempty"`
+	Repositories []string `yaml:"repositories,omitempty"`
+	Files        []string `yaml:"files,omitempty"`
+	Archives     []string `yaml:"archives,omitempty"`
+	// DriverEnv and ExecutorEnv set environment variables of the driver and
+	// executor containers
+	DriverEnv   map[string]string `yaml:"driverEnv,omitempty"`
+	ExecutorEnv map[string]string `yaml:"executorEnv,omitempty"`
+	// Principal and Keytab log in to kerberized clusters, the keytab is a
+	// file name in the keytab directory of the server
+	Principal string `yaml:"principal,omitempty"`
+	Keytab    string `yaml:"keytab,omitempty"`
+	// Schedule is a cron expression, the preset is submitted automatically when set
+	Schedule string `yaml:"schedule,omitempty"`
+	// AllowConcurrent=false rejects submissions while another one of the preset is pending or running
+	AllowConcurrent *bool `yaml:"allowConcurrent,omitempty"`
+	// Backoff overrides the non-zero fields of the default backoff
+	Backoff *backoff.Config `yaml:"backoff,omitempty"`
+	// MaxRuntime kills the application once it runs longer, 0 means no limit
+	MaxRuntime time.Duration `yaml:"maxRuntime,omitempty"`
+	// Priority orders the waiting submissions, higher ones start first
+	Priority int `yaml:"priority,omitempty"`
+	// NodeSelector, Tolerations and Affinity constrain the nodes of the
+	// driver and executor pods of kubernetes submissions
+	NodeSelector map[string]string      `yaml:"nodeSelector,omitempty"`
+	Tolerations  []Toleration           `yaml:"tolerations,omitempty"`
+	Affinity     map[string]interface{} `yaml:"affinity,omitempty"`
+	// PriorityClass is the kubernetes priority class of the driver and
+	// executor pods, so critical jobs can preempt best-effort ones
+	PriorityClass string `yaml:"priorityClass,omitempty"`
+	// GPU expands to the GPU resource configuration of spark
+	GPU *GPU `yaml:"gpu,omitempty"`
+	// DynamicAllocation expands to the dynamic allocation configuration of spark
+	DynamicAllocation *DynamicAllocation `yaml:"dynamicAllocation,omitempty"`
+	// R configures the R runtime of SparkR applications
+	R *ROptions `yaml:"r,omitempty"`
+	// PythonEnv ships a packed Python environment with PySpark applications
+	PythonEnv *PythonEnv `yaml:"pythonEnv,omitempty"`
 }
 
-func New(sparkHome, sparkConfDir, master string, debug bool) (*Spark, error) {
+func New(cfg Config, jobStore jobs.Store) (*Spark, error) {
 	spark := Spark{
-		presets: make(map[string]configurationPreset),
-		master:  master,
-		debug:   debug,
+		presets:          make(map[string]configurationPreset),
+		presetFiles:      make(map[string]string),
+		presetSources:    make(map[string]string),
+		sources:          cfg.PresetSources,
+		sourceStatus:     make(map[string]PresetSourceStatus),
+		secrets:          cfg.Secrets,
+		keytabDir:        cfg.KeytabDir,
+		confDir:          cfg.PresetDir,
+		master:           cfg.Master,
+		debug:            cfg.Debug,
+		timeout:          cfg.CommandTimeout,
+		backoff:          cfg.Backoff,
+		retryLimits:      cfg.RetryLimits,
+		events:           cfg.Events,
+		callbacks:        cfg.Callbacks,
+		jobs:             jobStore,
+		queue:            cfg.Queue,
+		replica:          cfg.Replica,
+		timers:           make(map[string]*time.Timer),
+		cancels:          make(map[string]context.CancelFunc),
+		workers:          newWorkerPool(cfg.MaxConcurrentSubmits, cfg.MaxQueuedSubmits),
+		quotas:           newNamespaceQuotas(cfg.NamespaceQuotas, cfg.RejectOverQuota),
+		outputs:          make(map[string]*outputBuffer),
+		outputLimit:      cfg.SubmitOutputLimit,
+		stderrLimit:      cfg.StderrTailLimit,
+		processes:        newProcessLimiter(cfg.MaxProcesses),
+		idempotencyTTL:   cfg.IdempotencyTTL,
+		tenantOf:         cfg.Tenant,
+		allowedSparkConf: cfg.AllowedSparkConf,
+		allowedMasters:   cfg.AllowedMasters,
+		namespaces:       cfg.AllowedNamespaces,
+		prices:           cfg.Prices,
+		capacityMode:     cfg.CapacityCheck,
+		podTemplateDir:   cfg.PodTemplateDir,
+		artifacts:        cfg.Artifacts,
+		dryRun:           cfg.DryRun,
+		runner:           cfg.SubmitRunner,
 	}
 
-	if _, err := os.Stat(sparkHome); os.IsNotExist(err) {
-		return nil, fmt.Errorf(`directory for spark home found ("%s")`, sparkHome)
+	if spark.callbacks == nil {
+		spark.callbacks = events.NewWebhookPublisher("", "")
 	}
-	spark.binaryPath = filepath.Join(sparkHome, "/bin/spark-submit")
 
-	if _, err := os.Stat(sparkConfDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf(`directory for spark configuration presets not found ("%s")`, sparkConfDir)
+	if _, err := os.Stat(cfg.SparkHome); os.IsNotExist(err) && cfg.SubmitRunner == nil {
+		return nil, fmt.Errorf(`directory for spark home found ("%s")`, cfg.SparkHome)
 	}
+	spark.binaryPath = filepath.Join(cfg.SparkHome, "/bin/spark-submit")
 
-	files, err := os.ReadDir(sparkConfDir)
-	if err != nil {
-		return nil, fmt.Errorf(`error reading preset directory ("%s"), %w`, sparkConfDir, err)
+	if _, err := os.Stat(cfg.PresetDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf(`directory for spark configuration presets not found ("%s")`, cfg.PresetDir)
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		fn := file.Name()
-		if !strings.HasSuffix(fn, ".yaml") {
-			continue
-		}
-
-		confPath := path.Join(sparkConfDir, fn)
-		rawConf, err := os.ReadFile(confPath)
-		if err != nil {
-			zap.L().Error("error reading config", zap.Error(err), zap.String("configPath", confPath))
-			continue
-		}
-
-		var preset configurationPreset
-		err = yaml.Unmarshal(rawConf, &preset)
-		if err != nil {
-			zap.L().Debug("couldn't parse preset", zap.Error(err), zap.String("rawConf", string(rawConf)))
-			continue
+	if err := spark.loadDefaults(cfg.DefaultsFile); err != nil {
+		return nil, err
+	}
+	if err := spark.loadPresets(); err != nil {
+		return nil, err
+	}
+	for _, source := range spark.sources {
+		if err := spark.refreshPresetSource(context.Background(), source); err != nil {
+			return nil, err
 		}
-
-		presetName := strings.TrimSuffix(fn, ".yaml")
-		spark.presets[presetName] = preset
-		zap.L().Debug("loaded preset", zap.String("presetName", presetName))
 	}
 
 	if len(spark.presets) == 0 {
-		return nil, fmt.Errorf(`no presets found, please add some presets to the spark configuration preset directory: "%s"`, sparkConfDir)
+		return nil, fmt.Errorf(`no presets found, please add some presets to the spark configuration preset directory: "%s"`, cfg.PresetDir)
 	}
 	zap.L().Info("presets initialized", zap.Int("presetCount", len(spark.presets)))
 
 	return &spark, nil
 }
 
-var PresetNotFoundError error = fmt.Errorf("preset not found")
+var (
+	PresetNotFoundError   error = fmt.Errorf("preset not found")
+	MasterNotAllowedError error = fmt.Errorf("master not allowed")
+	InvalidSparkConfError error = fmt.Errorf("invalid sparkConf")
+)
+
+// SubmitOptions are per-request additions to a preset
+type SubmitOptions struct {
+	// SparkConf entries are added to the preset configuration, existing keys are overridden
+	SparkConf map[string]string `json:"sparkConf"`
+	// Args are appended to the application arguments of the preset
+	Args []string `json:"args"`
+	// Params are the values of the template placeholders in the preset
+	Params map[string]string `json:"params"`
+	// RunAt delays the submission until the given time
+	RunAt *time.Time `json:"-"`
+	// Priority overrides the priority of the preset, submissions with a higher
+	// priority are started first when all workers are busy
+	Priority *int `json:"priority"`
+	// IdempotencyKey makes repeated submissions with the same key return the
+	// job of the first one instead of submitting again
+	IdempotencyKey string `json:"-"`
+	// PodTemplate overrides the pod templates of the driver and executors
+	PodTemplate *PodTemplate `json:"podTemplate"`
+	// Labels and Annotations are added to the driver and executor pods
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// ArtifactDigests pin the SHA-256 of the referenced artifacts by name
+	ArtifactDigests map[string]string `json:"artifactDigests"`
+	// Retry overrides the backoff of the preset for this submission only
+	Retry *RetryPolicy `json:"retry"`
+	// PriorityClass overrides the priority class of the preset's pods
+	PriorityClass string `json:"priorityClass"`
+	// CallbackURL receives the job as JSON once it finished
+	CallbackURL string `json:"callbackUrl"`
+	// Master overrides the master of the preset and server, it must be one
+	// of the allowed masters
+	Master string `json:"-"`
+	// NamespaceAllowed restricts the namespaces the submission may run in
+	// further than the allowed namespaces of the server, e.g. to the
+	// namespaces of the API key
+	NamespaceAllowed func(namespace string) bool `json:"-"`
+}
+
+const (
+	driverEnvPrefix   = "spark.kubernetes.driverEnv."
+	executorEnvPrefix = "spark.executorEnv."
+)
+
+func (s *Spark) submitArgs(presetName string, opts SubmitOptions) ([]string, error) {
+	return s.presetArgs(presetName, opts, expandEnv)
+}
 
-func (s *Spark) submitArgs(presetName string) ([]string, error) {
+// presetArgs builds the spark-submit arguments of a submission, expand
+// resolves the ${VAR} placeholders of the preset
+func (s *Spark) presetArgs(presetName string, opts SubmitOptions, expand func(string) (string, error)) ([]string, error) {
+	s.mu.RLock()
 	preset, ok := s.presets[presetName]
+	s.mu.RUnlock()
 	if !ok {
 		return nil, PresetNotFoundError
 	}
+
+	preset, err := resolvePreset(s.withDefaults(preset), opts.Params, expand)
+	if err != nil {
+		return nil, err
+	}
+
+	master := s.master
+	if preset.Master != "" {
+		master = preset.Master
+	}
+	if opts.Master != "" {
+		if !s.masterAllowed(opts.Master) {
+			return nil, fmt.Errorf(`%w, "%s" isn't one of the allowed masters`, MasterNotAllowedError, opts.Master)
+		}
+		master = opts.Master
+	}
+
+	conf := make(map[string]string, len(preset.SparkConf)+len(preset.DriverEnv)+len(preset.ExecutorEnv)+len(opts.SparkConf))
+	for key, value := range preset.SparkConf {
+		conf[key] = value
+	}
+	for key, value := range gpuConf(preset.GPU, strings.HasPrefix(master, "k8s://")) {
+		conf[key] = value
+	}
+	for key, value := range dynamicAllocationConf(preset.DynamicAllocation, strings.HasPrefix(master, "k8s://")) {
+		conf[key] = value
+	}
+	for key, value := range rConf(preset.R) {
+		conf[key] = value
+	}
+	for key, value := range pythonEnvConf(preset.PythonEnv, master) {
+		conf[key] = value
+	}
+	for name, value := range preset.DriverEnv {
+		conf[driverEnvPrefix+name] = value
+	}
+	for name, value := range preset.ExecutorEnv {
+		conf[executorEnvPrefix+name] = value
+	}
+	for key, value := range opts.SparkConf {
+		// placeholders are resolved in the whole --conf=key=value argument, a
+		// key could also complete one with the start of its value
+		if strings.ContainsAny(key, "${}=") {
+			return nil, fmt.Errorf(`%w: key "%s" may not contain "$", "{", "}" or "="`, InvalidSparkConfError, key)
+		}
+		conf[key] = escapeSecrets(value)
+	}
+
+	if len(opts.Labels) > 0 || len(opts.Annotations) > 0 {
+		if !strings.HasPrefix(master, "k8s://") {
+			return nil, fmt.Errorf("%w: labels and annotations need a kubernetes master", InvalidLabelError)
+		}
+		metadata, err := podMetadataConf(opts.Labels, opts.Annotations)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range metadata {
+			conf[key] = value
+		}
+	}
+	if strings.HasPrefix(master, "k8s://") {
+		conf[driverLabelPrefix+PresetLabel] = presetLabelValue(presetName)
+		// the sparkConf of the submission still wins
+		for key, value := range preset.NodeSelector {
+			if _, ok := opts.SparkConf[nodeSelectorPrefix+key]; !ok {
+				conf[nodeSelectorPrefix+key] = value
+			}
+		}
+	}
+	keys := make([]string, 0, len(conf))
+	for key := range conf {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	archives := preset.Archives
+	if preset.PythonEnv != nil {
+		archives = append(append([]string(nil), archives...), preset.PythonEnv.archive())
+	}
+
 	args := make([]string, 0)
-	args = append(args, fmt.Sprintf("--master=%s", s.master))
+	args = append(args, fmt.Sprintf("--master=%s", master))
 	args = append(args, "--deploy-mode=cluster")
 	args = append(args, fmt.Sprintf("--name=%s", presetName))
-	for key, value := range preset.SparkConf {
-		args = append(args, fmt.Sprintf("--conf=%s=%s", key, value))
+	if preset.MainClass != "" {
+		args = append(args, fmt.Sprintf("--class=%s", preset.MainClass))
+	}
+	if preset.Principal != "" {
+		args = append(args, fmt.Sprintf("--principal=%s", preset.Principal))
+		args = append(args, fmt.Sprintf("--keytab=%s", s.keytabPath(preset.Keytab)))
+	}
+	for _, dependency := range []struct {
+		flag   string
+		values []string
+	}{
+		{"jars", preset.Jars},
+		{"py-files", preset.PyFiles},
+		{"packages", preset.Packages},
+		{"repositories", preset.Repositories},
+		{"files", preset.Files},
+		{"archives", archives},
+	} {
+		if len(dependency.values) > 0 {
+			args = append(args, fmt.Sprintf("--%s=%s", dependency.flag, strings.Join(dependency.values, ",")))
+		}
+	}
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("--conf=%s=%s", key, conf[key]))
 	}
 	args = append(args, preset.Main)
 	args = append(args, preset.Args...)
+	for _, arg := range opts.Args {
+		args = append(args, escapeSecrets(arg))
+	}
 	return args, nil
 }
 
+func (s *Spark) masterAllowed(master string) bool {
+	for _, allowed := range s.allowedMasters {
+		if master == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 var submitCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "spark_exec_total",
 	Help: "The total number of spark-submit runs",
-}, []string{"preset", "status"})
+}, []string{"tenant", "preset", "status"})
 
 var retryCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "retry_total",
 	Help: "The total number of retries",
-}, []string{"preset"})
+}, []string{"tenant", "preset"})
+
+var durationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "spark_exec_duration_seconds",
+	Help:    "The duration of submissions from the first spark-submit attempt to the final outcome",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 15),
+}, []string{"tenant", "preset", "status"})
 
-func (s *Spark) Submit(presetName string) error {
-	args, err := s.submitArgs(presetName)
+var runningGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "spark_exec_running",
+	Help: "The number of submissions that are currently running or retrying",
+}, []string{"tenant", "preset"})
+
+var PresetRunningError error = fmt.Errorf("preset is already running")
+
+// Submit stores a job for the preset and queues the spark-submit run. The run
+// itself isn't bound to ctx, it outlives the request that triggered it.
+func (s *Spark) Submit(ctx context.Context, presetName string, opts SubmitOptions) (string, error) {
+	ctx, span := tracing.Start(ctx, "spark.submit")
+	defer span.End()
+	span.SetAttribute("preset", presetName)
+	id, err := s.submit(ctx, presetName, opts)
+	span.SetAttribute("job.id", id)
+	span.RecordError(err)
+	return id, err
+}
+
+func (s *Spark) submit(ctx context.Context, presetName string, opts SubmitOptions) (string, error) {
+	if s.inMaintenance() {
+		return "", MaintenanceError
+	}
+	if opts.Retry != nil {
+		if err := opts.Retry.validate(s.retryLimits); err != nil {
+			return "", err
+		}
+	}
+	if opts.CallbackURL != "" {
+		if err := validateCallbackURL(opts.CallbackURL); err != nil {
+			return "", err
+		}
+	}
+	args, err := s.submitArgs(presetName, opts)
 	if err != nil {
-		return fmt.Errorf("couldn't build submit args, %w", err)
+		return "", fmt.Errorf("couldn't build submit args, %w", err)
 	}
-	zap.L().Info("submit with args", zap.Any("args", args))
-	go func() {
-		isFirstRun := true
-		if err := retry(10, 1*time.Second, 2, 3*time.Minute, func() error {
-			cmd := exec.Command(s.binaryPath, args...)
-			zap.L().Info("spark-submit", zap.Strings("args", args))
-			if s.debug {
-				writer := &zapio.Writer{Log: zap.L(), Level: zap.DebugLevel}
-				cmd.Stderr = writer
-				cmd.Stdout = writer
-				defer writer.Close()
-			}
-			if !isFirstRun {
-				retryCounter.WithLabelValues(presetName).Inc()
+	if namespace := submitNamespace(args); namespace != "" && !s.NamespaceAllowed(namespace) {
+		return "", fmt.Errorf(`%w, the server may not submit to namespace "%s"`, NamespaceNotAllowedError, namespace)
+	} else if namespace != "" && opts.NamespaceAllowed != nil && !opts.NamespaceAllowed(namespace) {
+		return "", fmt.Errorf(`%w, the API key may not submit to namespace "%s"`, NamespaceNotAllowedError, namespace)
+	}
+	if opts.PriorityClass != "" {
+		if err := validatePriorityClass(opts.PriorityClass, InvalidPriorityClassError); err != nil {
+			return "", err
+		}
+		if submitNamespace(args) == "" {
+			return "", fmt.Errorf("%w: priority classes need a kubernetes master", InvalidPriorityClassError)
+		}
+	}
+	// dry runs don't reach out to the artifact store, the secret stores or the
+	// cluster, the references stay in the args
+	if !s.dryRun {
+		if args, err = s.resolveArtifacts(ctx, args, opts.ArtifactDigests); err != nil {
+			return "", err
+		}
+		// the secrets are resolved again for every try, this only checks they exist
+		if _, err := s.resolveSecrets(ctx, args); err != nil {
+			return "", err
+		}
+	}
+	if err := s.checkKeytab(presetName); err != nil {
+		return "", err
+	}
+	if s.capacityMode == CapacityCheckReject && !s.dryRun {
+		if err := s.checkCapacity(ctx, args); err != nil {
+			capacityRejectedCounter.WithLabelValues(s.tenant(presetName), presetName).Inc()
+			return "", err
+		}
+	}
+
+	// the check for running submissions and storing the new job must not interleave
+	s.submitMu.Lock()
+	defer s.submitMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if opts.IdempotencyKey != "" {
+		id, err := s.idempotentJob(presetName, opts.IdempotencyKey)
+		if err != nil || id != "" {
+			return id, err
+		}
+	}
+	if err := s.checkConcurrency(presetName); err != nil {
+		return "", err
+	}
+
+	job, err := jobs.New(presetName)
+	if err != nil {
+		return "", err
+	}
+	job.Priority = s.priority(presetName, opts)
+	job.DryRun = s.dryRun
+	job.IdempotencyKey = opts.IdempotencyKey
+	job.CallbackURL = opts.CallbackURL
+	job.Replica = s.replica
+	job.Master = submitMaster(args)
+	job.Namespace = submitNamespace(args)
+	if job.Namespace != "" {
+		args = setConfArg(args, driverLabelPrefix+JobLabel, job.ID)
+	}
+	if args, err = s.withPodTemplates(job.ID, args, opts.PodTemplate, s.podScheduling(presetName, opts.PriorityClass)); err != nil {
+		return "", err
+	}
+
+	if opts.RunAt != nil && opts.RunAt.After(time.Now()) {
+		runAt := opts.RunAt.UTC()
+		job.Schedule(runAt)
+
+		s.cancelMu.Lock()
+		defer s.cancelMu.Unlock()
+		if s.closed {
+			s.removePodTemplates(job.ID)
+			return "", ShuttingDownError
+		}
+		if err := s.storeJob(job, args, opts.Retry); err != nil {
+			return "", err
+		}
+		s.publish(events.Event{Type: events.Submitted, JobID: job.ID, Preset: presetName})
+		s.scheduleRun(ctx, job, args, opts.Retry)
+		logging.FromContext(ctx).Info("scheduled submission", zap.String("jobID", job.ID), zap.Time("runAt", runAt))
+		return job.ID, nil
+	}
+
+	if s.isClosed() {
+		s.removePodTemplates(job.ID)
+		return "", ShuttingDownError
+	}
+	if err := s.storeJob(job, args, opts.Retry); err != nil {
+		return "", err
+	}
+	s.publish(events.Event{Type: events.Submitted, JobID: job.ID, Preset: presetName})
+	if err := s.enqueue(ctx, job.ID, presetName, job.Priority, args, opts.Retry); err != nil {
+		s.setJobState(job.ID, jobs.StateFailed)
+		return "", err
+	}
+	logging.FromContext(ctx).Info("queued submission", zap.String("jobID", job.ID), zap.String("presetName", presetName))
+	return job.ID, nil
+}
+
+// storeJob stores the new job and keeps its submission in the durable queue
+func (s *Spark) storeJob(job jobs.Job, args []string, retry *RetryPolicy) error {
+	if err := s.persistSubmission(job.ID, args, retry); err != nil {
+		s.removePodTemplates(job.ID)
+		return err
+	}
+	if err := s.jobs.Put(job); err != nil {
+		s.forgetSubmission(job.ID)
+		s.removePodTemplates(job.ID)
+		return fmt.Errorf("couldn't store job, %w", err)
+	}
+	return nil
+}
+
+// scheduleRun queues the run of the scheduled job at its RunAt, the caller
+// holds cancelMu
+func (s *Spark) scheduleRun(ctx context.Context, job jobs.Job, args []string, retry *RetryPolicy) {
+	s.timers[job.ID] = time.AfterFunc(time.Until(*job.RunAt), func() {
+		s.cancelMu.Lock()
+		delete(s.timers, job.ID)
+		s.cancelMu.Unlock()
+		s.setJobState(job.ID, jobs.StatePending)
+		if err := s.enqueue(ctx, job.ID, job.Preset, job.Priority, args, retry); err != nil {
+			logging.FromContext(ctx).Error("couldn't start scheduled submission", zap.String("jobID", job.ID), zap.Error(err))
+			s.setJobState(job.ID, jobs.StateFailed)
+		}
+	})
+}
+
+// tenant returns the tenant of the preset, empty without tenants
+func (s *Spark) tenant(presetName string) string {
+	if s.tenantOf == nil {
+		return ""
+	}
+	return s.tenantOf(presetName)
+}
+
+// priority returns the priority of the submission, the one of the options
+// takes precedence over the one of the preset
+func (s *Spark) priority(presetName string, opts SubmitOptions) int {
+	if opts.Priority != nil {
+		return *opts.Priority
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.presets[presetName].Priority
+}
+
+var ShuttingDownError error = fmt.Errorf("server is shutting down")
+
+func (s *Spark) isClosed() bool {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	return s.closed
+}
+
+// enqueue queues the spark-submit run, ctx only carries the trace of the submission
+func (s *Spark) enqueue(ctx context.Context, jobID, presetName string, priority int, args []string, retry *RetryPolicy) error {
+	// the cancel func is registered before the job is queued so that there's
+	// no gap in which a job can't be cancelled
+	ctx, cancel := context.WithCancel(tracing.Detach(ctx))
+	s.cancelMu.Lock()
+	if s.closed {
+		s.cancelMu.Unlock()
+		cancel()
+		return ShuttingDownError
+	}
+	s.cancels[jobID] = cancel
+	s.wg.Add(1)
+	s.cancelMu.Unlock()
+
+	submission := &heldSubmission{jobID: jobID, priority: priority}
+	submission.start = func() error {
+		return s.workers.enqueue(jobID, priority, func() {
+			defer s.wg.Done()
+			defer s.forgetCancel(jobID)
+			defer s.releaseQuota(jobID)
+			if s.capacityMode == CapacityCheckQueue && !s.dryRun {
+				if err := s.waitForCapacity(ctx, jobID, presetName, args); err != nil {
+					s.recordResult(jobID, presetName, newSubmitResult(err, 0, time.Time{}))
+					return
+				}
 			}
-			isFirstRun = false
-			return cmd.Run()
-		}); err != nil {
-			zap.L().Error("spark submit failed with retries", zap.Error(err))
-			submitCounter.WithLabelValues(presetName, "failure").Inc()
+			s.run(ctx, jobID, presetName, args, retry)
+		})
+	}
+	held, err := s.quotas.admit(submitNamespace(args), submission)
+	if err == nil && held {
+		logging.FromContext(ctx).Info("holding submission back for the namespace quota", zap.String("jobID", jobID))
+		s.updateJob(jobID, func(j *jobs.Job) {
+			j.Record(jobs.Event{Type: jobs.EventWaiting, Message: "held back by the quota of the namespace"})
+		})
+		return nil
+	}
+	if err == nil {
+		if err = submission.start(); err != nil {
+			s.releaseQuota(jobID)
+		}
+	}
+	if err != nil {
+		s.forgetCancel(jobID)
+		s.wg.Done()
+	}
+	return err
+}
+
+// Shutdown stops accepting submissions and waits for the running ones to
+// finish. Scheduled and queued submissions are abandoned right away, or left
+// to RestoreQueue with a durable queue, running ones are aborted once ctx is
+// done.
+func (s *Spark) Shutdown(ctx context.Context) error {
+	s.cancelMu.Lock()
+	s.closed = true
+	for id, timer := range s.timers {
+		if timer.Stop() {
+			s.abandon(id, "scheduled")
 		}
-		submitCounter.WithLabelValues(presetName, "success").Inc()
+		delete(s.timers, id)
+	}
+	s.stopRuntimeTimers()
+	for _, id := range s.quotas.drain() {
+		s.cancels[id]()
+		delete(s.cancels, id)
+		s.wg.Done()
+		s.abandon(id, "held")
+	}
+	for _, id := range s.workers.drain() {
+		s.quotas.release(id)
+		s.cancels[id]()
+		delete(s.cancels, id)
+		s.wg.Done()
+		s.abandon(id, "queued")
+	}
+	s.cancelMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		s.workers.close()
+		close(done)
 	}()
 
+	select {
+	case <-done:
+		zap.L().Info("all submissions finished")
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.cancelMu.Lock()
+	for id, cancel := range s.cancels {
+		cancel()
+		zap.L().Warn("aborted running submission", zap.String("jobID", id))
+	}
+	s.cancelMu.Unlock()
+	// the processes are killed, so the submissions return right away
+	<-done
+	return ctx.Err()
+}
+
+// abandon cancels a submission that didn't start when the server shuts down,
+// unless the durable queue keeps it for the restart
+func (s *Spark) abandon(jobID, kind string) {
+	if s.queue != nil {
+		zap.L().Info("kept "+kind+" submission for the restart", zap.String("jobID", jobID))
+		return
+	}
+	s.setJobState(jobID, jobs.StateCancelled)
+	zap.L().Warn("abandoned "+kind+" submission", zap.String("jobID", jobID))
+}
+
+func (s *Spark) forgetCancel(jobID string) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if cancel, ok := s.cancels[jobID]; ok {
+		cancel()
+		delete(s.cancels, jobID)
+	}
+}
+
+func (s *Spark) checkConcurrency(presetName string) error {
+	s.mu.RLock()
+	preset := s.presets[presetName]
+	s.mu.RUnlock()
+	if preset.AllowConcurrent == nil || *preset.AllowConcurrent {
+		return nil
+	}
+
+	list, err := s.jobs.List()
+	if err != nil {
+		return fmt.Errorf("couldn't list jobs, %w", err)
+	}
+	for _, job := range list {
+		if job.Preset == presetName && (job.State == jobs.StatePending || job.State == jobs.StateRunning) {
+			return PresetRunningError
+		}
+	}
 	return nil
 }
 
-func (s *Spark) buildArgs(kind string, namespace, name string) []string {
+var JobNotCancellableError error = fmt.Errorf("job can't be cancelled")
+
+// Cancel aborts a submission that is scheduled, waiting in the queue or
+// running. A running spark-submit process is killed and no retries follow.
+func (s *Spark) Cancel(id string) error {
+	if _, err := s.jobs.Get(id); err != nil {
+		return err
+	}
+
+	// the slot of a removed submission is released once cancelMu is unlocked,
+	// a held submission taking it over may fail to start and be forgotten
+	released := false
+	defer func() {
+		if released {
+			s.releaseQuota(id)
+		}
+	}()
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if timer, ok := s.timers[id]; ok && timer.Stop() {
+		delete(s.timers, id)
+		s.setJobState(id, jobs.StateCancelled)
+		zap.L().Info("cancelled scheduled submission", zap.String("jobID", id))
+		return nil
+	}
+
+	if s.quotas.remove(id) {
+		s.cancels[id]()
+		delete(s.cancels, id)
+		s.wg.Done()
+		s.setJobState(id, jobs.StateCancelled)
+		zap.L().Info("cancelled held submission", zap.String("jobID", id))
+		return nil
+	}
+
+	if s.workers.remove(id) {
+		released = true
+		s.cancels[id]()
+		delete(s.cancels, id)
+		s.wg.Done()
+		s.setJobState(id, jobs.StateCancelled)
+		zap.L().Info("cancelled queued submission", zap.String("jobID", id))
+		return nil
+	}
+
+	if cancel, ok := s.cancels[id]; ok {
+		// run sets the cancelled state once the process is gone
+		cancel()
+		zap.L().Info("cancelling running submission", zap.String("jobID", id))
+		return nil
+	}
+
+	return JobNotCancellableError
+}
+
+// run submits the job with the backoff of the preset, retry overrides it if not nil
+func (s *Spark) run(ctx context.Context, jobID, presetName string, args []string, retry *RetryPolicy) {
+	zap.L().Info("submit with args", zap.String("jobID", jobID), zap.Any("args", args))
+	s.setJobState(jobID, jobs.StateRunning)
+	if !s.dryRun {
+		s.watchRuntime(jobID, presetName, 0)
+	}
+	runningGauge.WithLabelValues(s.tenant(presetName), presetName).Inc()
+	defer runningGauge.WithLabelValues(s.tenant(presetName), presetName).Dec()
+	ctx, span := tracing.Start(ctx, "spark.run")
+	defer span.End()
+	span.SetAttribute("job.id", jobID)
+	span.SetAttribute("preset", presetName)
+	try := 0
+	var start time.Time
+	var stderr *outputBuffer
+	err := backoff.Retry(ctx, retry.apply(s.presetBackoff(presetName)), func() error {
+		cmdCtx, cancel := s.commandContext(ctx)
+		defer cancel()
+		cmdCtx, attemptSpan := tracing.Start(cmdCtx, "spark-submit")
+		defer attemptSpan.End()
+		attemptSpan.SetAttribute("attempt", strconv.Itoa(try+1))
+		var err error
+		resolved := args
+		if !s.dryRun {
+			if resolved, err = s.resolveSecrets(cmdCtx, args); err != nil {
+				return err
+			}
+		}
+		cmd := s.command(cmdCtx, resolved)
+		zap.L().Info("spark-submit", zap.Strings("args", args))
+		output := s.submitOutput(jobID)
+		_, _ = output.Write([]byte(attemptHeader(try + 1)))
+		parser := newSubmissionParser(func(app SubmittedApp) {
+			s.updateJob(jobID, func(j *jobs.Job) {
+				// the phase of the driver of an earlier attempt doesn't apply
+				if app.DriverPod != j.DriverPod {
+					j.AppPhase = ""
+				}
+				j.Namespace, j.DriverPod, j.ApplicationID = app.Namespace, app.DriverPod, app.ApplicationID
+			})
+		})
+		writer := io.MultiWriter(output, parser)
+		if s.debug {
+			logWriter := &zapio.Writer{Log: zap.L(), Level: zap.DebugLevel}
+			writer = io.MultiWriter(output, parser, logWriter)
+			defer logWriter.Close()
+		}
+		writer = &syncWriter{w: writer}
+		stderr = s.stderrTail()
+		cmd.Stderr = io.MultiWriter(writer, stderr)
+		cmd.Stdout = writer
+		if try == 0 {
+			start = time.Now()
+		} else {
+			retryCounter.WithLabelValues(s.tenant(presetName), presetName).Inc()
+			s.publish(events.Event{Type: events.Retrying, JobID: jobID, Preset: presetName, Attempt: try + 1})
+		}
+		try++
+		s.updateJob(jobID, func(j *jobs.Job) { j.StartAttempt() })
+		if s.dryRun {
+			zap.L().Info("dry run, skipped spark-submit", zap.String("jobID", jobID))
+			_, _ = fmt.Fprintf(writer, "dry run, skipped spark-submit %s\n", strings.Join(args, " "))
+			err = nil
+		} else if s.runner != nil {
+			err = s.runner.RunSubmit(cmdCtx, resolved, io.MultiWriter(writer, stderr))
+		} else {
+			err = s.runCommand(cmdCtx, cmd)
+		}
+		if err == nil && !s.dryRun {
+			err = s.driverFailed(jobID)
+		}
+		s.updateJob(jobID, func(j *jobs.Job) {
+			j.FinishAttempt(err)
+			j.Attempts[len(j.Attempts)-1].ExitCode = exitCode(err)
+		})
+		attemptSpan.RecordError(err)
+		return err
+	})
+	span.RecordError(err)
+	result := newSubmitResult(err, try, start)
+	result.Resources = requestedResources(args)
+	if stderr != nil && result.State == jobs.StateFailed {
+		result.Stderr = stderr.String()
+	}
+	s.recordResult(jobID, presetName, result)
+}
+
+// Jobs returns all jobs without their events
+func (s *Spark) Jobs() ([]jobs.Job, error) {
+	list, err := s.jobs.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		s.setQueuePosition(&list[i])
+		list[i].Events = nil
+	}
+	return list, nil
+}
+
+// ForEachJob calls fn with every job without its events ordered by their
+// creation, the jobs aren't loaded all at once
+func (s *Spark) ForEachJob(fn func(job jobs.Job) error) error {
+	return s.jobs.ForEach(func(job jobs.Job) error {
+		s.setQueuePosition(&job)
+		job.Events = nil
+		return fn(job)
+	})
+}
+
+// Job returns the job without its events
+func (s *Spark) Job(id string) (jobs.Job, error) {
+	job, err := s.jobs.Get(id)
+	if err != nil {
+		return job, err
+	}
+	s.setQueuePosition(&job)
+	job.Events = nil
+	return job, nil
+}
+
+// JobEvents returns the timeline of the job, oldest events first
+func (s *Spark) JobEvents(id string) ([]jobs.Event, error) {
+	job, err := s.jobs.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Events == nil {
+		return []jobs.Event{}, nil
+	}
+	return job.Events, nil
+}
+
+func (s *Spark) setQueuePosition(job *jobs.Job) {
+	if job.State == jobs.StatePending {
+		job.QueuePosition = s.workers.position(job.ID)
+	}
+}
+
+// stateEvents maps the job states that are published to their event type
+var stateEvents = map[jobs.State]events.Type{
+	jobs.StateSucceeded: events.Succeeded,
+	jobs.StateFailed:    events.Failed,
+	jobs.StateCancelled: events.Cancelled,
+}
+
+// setJobState changes the state of the job, applies the updates and publishes
+// the event of the new state, it returns false if the state machine doesn't allow the change
+func (s *Spark) setJobState(id string, state jobs.State, updates ...func(j *jobs.Job)) bool {
+	event := events.Event{JobID: id}
+	var err error
+	s.updateJob(id, func(j *jobs.Job) {
+		if err = j.Transition(state); err != nil {
+			return
+		}
+		for _, update := range updates {
+			update(j)
+		}
+		event.Preset = j.Preset
+		event.Attempt = len(j.Attempts)
+		if state == jobs.StateFailed && len(j.Attempts) > 0 {
+			event.Error = j.Attempts[len(j.Attempts)-1].Error
+		}
+	})
+	if err != nil {
+		zap.L().Warn("ignored job state change", zap.String("jobID", id), zap.Error(err))
+		return false
+	}
+	if state == jobs.StateRunning || state.Terminal() {
+		s.forgetSubmission(id)
+	}
+	if state.Terminal() {
+		s.removePodTemplates(id)
+		s.sendCallback(id)
+	}
+	if eventType, ok := stateEvents[state]; ok {
+		event.Type = eventType
+		s.publish(event)
+	}
+	return true
+}
+
+const publishTimeout = 10 * time.Second
+
+// publish sends a lifecycle event, failures are logged but don't affect the job
+func (s *Spark) publish(event events.Event) {
+	if s.events == nil {
+		return
+	}
+	event.Time = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := s.events.Publish(ctx, event); err != nil {
+		zap.L().Error("couldn't publish event", zap.String("type", string(event.Type)), zap.String("jobID", event.JobID), zap.Error(err))
+	}
+}
+
+func (s *Spark) updateJob(id string, fn func(j *jobs.Job)) {
+	if err := s.jobs.Update(id, fn); err != nil {
+		zap.L().Error("couldn't update job", zap.String("jobID", id), zap.Error(err))
+	}
+}
+
+type masterContextKey struct{}
+
+// WithMaster returns a context whose kill and status requests go to master
+// instead of the master of the server, e.g. the master a job was submitted to
+func WithMaster(ctx context.Context, master string) context.Context {
+	return context.WithValue(ctx, masterContextKey{}, master)
+}
+
+// submitMaster returns the master of spark-submit arguments
+func submitMaster(args []string) string {
+	for _, arg := range args {
+		if master, ok := strings.CutPrefix(arg, "--master="); ok {
+			return master
+		}
+	}
+	return ""
+}
+
+func (s *Spark) buildArgs(ctx context.Context, kind string, namespace, name string) []string {
+	master := s.master
+	if override, ok := ctx.Value(masterContextKey{}).(string); ok && override != "" {
+		master = override
+	}
 	args := make([]string, 0)
-	args = append(args, fmt.Sprintf("--master=%s", s.master))
+	args = append(args, fmt.Sprintf("--master=%s", master))
 	args = append(args, fmt.Sprintf("--%s=%s:%s", kind, namespace, name))
 	return args
 }
 
-func (s *Spark) Kill(namespace, name string) {
-	args := s.buildArgs("kill", namespace, name)
-	cmd := exec.Command(s.binaryPath, args...)
-	zap.L().Info("spark-submit", zap.Strings("args", args))
+// presetBackoff returns the configured backoff with the preset's overrides applied
+func (s *Spark) presetBackoff(presetName string) backoff.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	config := backoff.Default.Override(s.backoff)
+	if preset, ok := s.presets[presetName]; ok && preset.Backoff != nil {
+		return config.Override(*preset.Backoff)
+	}
+	return config
+}
+
+// commandContext bounds ctx by the configured command timeout
+func (s *Spark) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// command creates a spark-submit command that is killed when ctx is done. The
+// output is abandoned shortly after, even if spark-submit left child processes
+// behind that still hold it open.
+func (s *Spark) command(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, s.binaryPath, args...)
+	cmd.WaitDelay = time.Second
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// startAppSpan starts a span for a request on spark applications
+func startAppSpan(ctx context.Context, name, namespace, app string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name)
+	span.SetAttribute("namespace", namespace)
+	span.SetAttribute("app", app)
+	return ctx, span
+}
+
+func (s *Spark) Kill(ctx context.Context, namespace, name string) {
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, name)
+	defer span.End()
+	ctx, cancel := s.commandContext(ctx)
+	defer cancel()
+	args := s.buildArgs(ctx, "kill", namespace, name)
+	cmd := s.command(ctx, args)
+	logging.FromContext(ctx).Info("spark-submit", zap.Strings("args", args))
 	if s.debug {
 		writer := &zapio.Writer{Log: zap.L(), Level: zap.DebugLevel}
 		cmd.Stderr = writer
@@ -180,42 +1198,49 @@ func (s *Spark) Kill(namespace, name string) {
 		defer writer.Close()
 	}
 
-	if err := cmd.Run(); err != nil {
-		zap.L().Error("killing spark app failed", zap.Error(err))
+	if err := s.runCommand(ctx, cmd); err != nil {
+		logging.FromContext(ctx).Error("killing spark app failed", zap.Error(err))
+		span.RecordError(err)
+		return
 	}
+	s.publish(events.Event{Type: events.Killed, Namespace: namespace, Name: name})
+}
+
+// AppFilter selects the applications of a bulk kill
+type AppFilter struct {
+	// Preset matches the applications submitted for the preset
+	Preset string
+	// LabelSelector matches the labels of the driver pods
+	LabelSelector string
 }
 
-func (s *Spark) Status(namespace, name string) string {
-	args := s.buildArgs("status", namespace, name)
-	zap.L().Info("spark-submit", zap.Strings("args", args))
+var UnsupportedError error = errors.New("not supported by the backend")
 
-	cmd := exec.Command(s.binaryPath, args...)
+// KillApps kills all running applications matching the filter. spark-submit
+// can only kill applications by name, so this needs the kubernetes or YARN
+// backend.
+func (s *Spark) KillApps(ctx context.Context, namespace string, filter AppFilter) ([]string, error) {
+	return nil, fmt.Errorf("%w, bulk kills need the kubernetes or yarn backend", UnsupportedError)
+}
+
+func (s *Spark) Status(ctx context.Context, namespace, name string) StatusReport {
+	ctx, span := startAppSpan(ctx, "spark.status", namespace, name)
+	defer span.End()
+	ctx, cancel := s.commandContext(ctx)
+	defer cancel()
+	args := s.buildArgs(ctx, "status", namespace, name)
+	logging.FromContext(ctx).Info("spark-submit", zap.Strings("args", args))
+
+	cmd := s.command(ctx, args)
 	var buffer bytes.Buffer
 	cmd.Stdout = &buffer
 	cmd.Stderr = &buffer
-	if err := cmd.Run(); err != nil {
-		zap.L().Error("spark-submit failed", zap.Error(err))
+	if err := s.runCommand(ctx, cmd); err != nil {
+		logging.FromContext(ctx).Error("spark-submit failed", zap.Error(err))
+		span.RecordError(err)
 	}
-	return buffer.String()
-}
-
-func retry(retries int, initialDelay time.Duration, mult int, maxWait time.Duration, fn func() error) error {
-	delay := initialDelay
-	for try := 0; try < retries; try++ {
-		if err := fn(); err == nil {
-			return nil
-		} else {
-			zap.L().Warn(
-				"retry failed",
-				zap.Int("try", try),
-				zap.String("waitDuration", delay.String()),
-			)
-		}
-		time.Sleep(delay)
-		delay = delay * time.Duration(mult)
-		if delay >= maxWait {
-			delay = maxWait
-		}
+	return StatusReport{
+		Raw:  buffer.String(),
+		Apps: parseStatus(buffer.String()),
 	}
-	return fmt.Errorf("retries exceeded")
 }
@@ -14,16 +14,24 @@ limitations under the License.
 package spark
 
 import (
-	"fmt"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 )
 
 func TestSpark(t *testing.T) {
 	t.Run("should be able to read spark templates", func(t *testing.T) {
-		s, err := New(".", "../../example/sparkConf", "", false)
+		s, err := New(Config{SparkHome: ".", PresetDir: "../../example/sparkConf"}, jobs.NewMemoryStore())
 		require.NoError(t, err)
 		require.Len(t, s.presets, 1)
 		require.Equal(t, configurationPreset{
@@ -57,37 +65,624 @@ func TestSpark(t *testing.T) {
 			master: "k8s://http://localhost:8000",
 		}
 
-		args, err := s.submitArgs("mypreset")
+		args, err := s.submitArgs("mypreset", SubmitOptions{})
 		require.NoError(t, err)
 		require.Equal(t, []string{
 			"--master=k8s://http://localhost:8000",
 			"--deploy-mode=cluster",
 			"--name=mypreset",
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=mypreset",
 			"--conf=spark.kubernetes.namespace=spark",
 			"/app/example.py",
 			"--verbose=true",
 		}, args)
 	})
 
+	t.Run("submitArgs merges the submit options into the preset", func(t *testing.T) {
+		s := Spark{
+			presets: map[string]configurationPreset{
+				"mypreset": {
+					Main: "/app/example.py",
+					Args: []string{"--verbose=true"},
+					SparkConf: map[string]string{
+						"spark.kubernetes.namespace": "spark",
+						"spark.executor.instances":   "2",
+					},
+				},
+			},
+			master: "k8s://http://localhost:8000",
+		}
+
+		args, err := s.submitArgs("mypreset", SubmitOptions{
+			SparkConf: map[string]string{
+				"spark.executor.instances": "4",
+				"spark.executor.memory":    "1g",
+			},
+			Args: []string{"--date=2023-07-10"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"--master=k8s://http://localhost:8000",
+			"--deploy-mode=cluster",
+			"--name=mypreset",
+			"--conf=spark.executor.instances=4",
+			"--conf=spark.executor.memory=1g",
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=mypreset",
+			"--conf=spark.kubernetes.namespace=spark",
+			"/app/example.py",
+			"--verbose=true",
+			"--date=2023-07-10",
+		}, args)
+		require.Equal(t, "2", s.presets["mypreset"].SparkConf["spark.executor.instances"])
+	})
+
+	t.Run("submitArgs adds the labels and annotations of the submit options", func(t *testing.T) {
+		s := Spark{
+			presets: map[string]configurationPreset{"mypreset": {Main: "/app/example.py"}},
+			master:  "k8s://http://localhost:8000",
+		}
+
+		args, err := s.submitArgs("mypreset", SubmitOptions{
+			Labels:      map[string]string{"ticket": "DATA-123"},
+			Annotations: map[string]string{"owner": "data"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"--master=k8s://http://localhost:8000",
+			"--deploy-mode=cluster",
+			"--name=mypreset",
+			"--conf=spark.kubernetes.driver.annotation.owner=data",
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=mypreset",
+			"--conf=spark.kubernetes.driver.label.ticket=DATA-123",
+			"--conf=spark.kubernetes.executor.annotation.owner=data",
+			"--conf=spark.kubernetes.executor.label.ticket=DATA-123",
+			"/app/example.py",
+		}, args)
+
+		s.master = "yarn"
+		_, err = s.submitArgs("mypreset", SubmitOptions{Labels: map[string]string{"ticket": "DATA-123"}})
+		require.ErrorIs(t, err, InvalidLabelError)
+	})
+
+	t.Run("submitArgs renders the preset templates with the params", func(t *testing.T) {
+		s := Spark{
+			presets: map[string]configurationPreset{
+				"mypreset": {
+					Main:      "/app/example.py",
+					Args:      []string{"--date={{ .date }}"},
+					SparkConf: map[string]string{"spark.app.name": "report-{{ .date }}"},
+				},
+			},
+			master: "k8s://http://localhost:8000",
+		}
+
+		args, err := s.submitArgs("mypreset", SubmitOptions{Params: map[string]string{"date": "2023-07-10"}})
+		require.NoError(t, err)
+		require.Contains(t, args, "--conf=spark.app.name=report-2023-07-10")
+		require.Equal(t, "--date=2023-07-10", args[len(args)-1])
+
+		_, err = s.submitArgs("mypreset", SubmitOptions{})
+		require.ErrorIs(t, err, InvalidParamsError)
+	})
+
+	t.Run("submitArgs translates the environment variables to spark configuration", func(t *testing.T) {
+		s := Spark{
+			presets: map[string]configurationPreset{
+				"env": {
+					Main:        "/app/etl.py",
+					SparkConf:   map[string]string{"spark.executorEnv.MODE": "conf"},
+					DriverEnv:   map[string]string{"MODE": "driver"},
+					ExecutorEnv: map[string]string{"MODE": "executor", "REGION": "eu"},
+				},
+			},
+		}
+
+		args, err := s.submitArgs("env", SubmitOptions{SparkConf: map[string]string{"spark.executorEnv.REGION": "us"}})
+		require.NoError(t, err)
+		require.Contains(t, args, "--conf=spark.kubernetes.driverEnv.MODE=driver")
+		require.Contains(t, args, "--conf=spark.executorEnv.MODE=executor")
+		require.Contains(t, args, "--conf=spark.executorEnv.REGION=us")
+	})
+
+	t.Run("submitArgs passes the dependencies as flags", func(t *testing.T) {
+		s := Spark{
+			presets: map[string]configurationPreset{
+				"deps": {
+					Main:         "/app/etl.py",
+					Jars:         []string{"s3a://libs/a.jar", "s3a://libs/b.jar"},
+					PyFiles:      []string{"s3a://libs/utils.zip"},
+					Packages:     []string{"org.apache.hadoop:hadoop-aws:3.3.4"},
+					Repositories: []string{"https://repo.example.com/maven"},
+					Files:        []string{"s3a://conf/${PRESET_ENV_NAME}.conf"},
+					Archives:     []string{"s3a://envs/venv.tar.gz#environment"},
+				},
+			},
+			master: "k8s://http://localhost:8000",
+		}
+		t.Setenv("PRESET_ENV_NAME", "prod")
+
+		args, err := s.submitArgs("deps", SubmitOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"--master=k8s://http://localhost:8000",
+			"--deploy-mode=cluster",
+			"--name=deps",
+			"--jars=s3a://libs/a.jar,s3a://libs/b.jar",
+			"--py-files=s3a://libs/utils.zip",
+			"--packages=org.apache.hadoop:hadoop-aws:3.3.4",
+			"--repositories=https://repo.example.com/maven",
+			"--files=s3a://conf/prod.conf",
+			"--archives=s3a://envs/venv.tar.gz#environment",
+			"--conf=spark.kubernetes.driver.label.spark-submit/preset=deps",
+			"/app/etl.py",
+		}, args)
+	})
+
+	t.Run("submitArgs uses the master of the preset", func(t *testing.T) {
+		t.Setenv("PRESET_GPU_CLUSTER", "gpu.example.com")
+		s := Spark{
+			presets: map[string]configurationPreset{
+				"gpu":   {Main: "/app/train.py", Master: "k8s://https://${PRESET_GPU_CLUSTER}:443"},
+				"other": {Main: "/app/example.py"},
+			},
+			master: "k8s://http://localhost:8000",
+		}
+
+		args, err := s.submitArgs("gpu", SubmitOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "--master=k8s://https://gpu.example.com:443", args[0])
+
+		args, err = s.submitArgs("other", SubmitOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "--master=k8s://http://localhost:8000", args[0])
+	})
+
+	t.Run("Submit stores the master of the submission on the job", func(t *testing.T) {
+		s := newTestSpark(t, Config{Master: "k8s://http://localhost:8000", AllowedMasters: []string{"k8s://https://streaming:443"}}, nil, "exit 0",
+			map[string]configurationPreset{"pi": {Main: "/app/pi.py", Master: "k8s://https://batch:443"}})
+		runAt := time.Now().Add(time.Hour)
+
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, "k8s://https://batch:443", job.Master)
+
+		id, err = s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, Master: "k8s://https://streaming:443"})
+		require.NoError(t, err)
+		job, err = s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, "k8s://https://streaming:443", job.Master)
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+
+	t.Run("submitArgs uses the master of the submission if it is allowed", func(t *testing.T) {
+		s := Spark{
+			presets:        map[string]configurationPreset{"pi": {Main: "/app/pi.py", Master: "k8s://https://batch:443"}},
+			master:         "k8s://http://localhost:8000",
+			allowedMasters: []string{"k8s://https://batch:443", "k8s://https://streaming:443"},
+		}
+
+		args, err := s.submitArgs("pi", SubmitOptions{Master: "k8s://https://streaming:443"})
+		require.NoError(t, err)
+		require.Equal(t, "--master=k8s://https://streaming:443", args[0])
+
+		_, err = s.submitArgs("pi", SubmitOptions{Master: "k8s://https://other:443"})
+		require.ErrorIs(t, err, MasterNotAllowedError)
+	})
+
+	t.Run("submitArgs returns PresetNotFoundError for unknown presets", func(t *testing.T) {
+		s := Spark{}
+		_, err := s.submitArgs("nope", SubmitOptions{})
+		require.ErrorIs(t, err, PresetNotFoundError)
+	})
+
 	t.Run("buildArgs bulds the correct arguments", func(t *testing.T) {
 		s := Spark{master: "k8s://http://localhost:8000"}
-		args := s.buildArgs("status", "namespace", "name")
+		args := s.buildArgs(context.Background(), "status", "namespace", "name")
 		require.Equal(t, []string{
 			"--master=k8s://http://localhost:8000",
 			"--status=namespace:name",
 		}, args)
 	})
 
-	t.Run("retry works", func(t *testing.T) {
-		try := 0
-		fn := func() error {
-			if try >= 2 {
-				return nil
-			}
-			try++
-			return fmt.Errorf("error in fn")
+	t.Run("buildArgs uses the master of the context", func(t *testing.T) {
+		s := Spark{master: "k8s://http://localhost:8000"}
+		args := s.buildArgs(WithMaster(context.Background(), "k8s://https://gpu.example.com:443"), "kill", "namespace", "name")
+		require.Equal(t, []string{
+			"--master=k8s://https://gpu.example.com:443",
+			"--kill=namespace:name",
+		}, args)
+	})
+}
+
+func TestPresetBackoff(t *testing.T) {
+	jitter := 0.5
+	s := Spark{
+		presets: map[string]configurationPreset{
+			"pi":  {Main: "pi.py"},
+			"etl": {Main: "etl.py", Backoff: &backoff.Config{Strategy: backoff.Constant, InitialDelay: time.Minute}},
+		},
+		backoff: backoff.Config{Retries: 3, Jitter: jitter},
+	}
+
+	t.Run("applies the configured values to the defaults", func(t *testing.T) {
+		config := s.presetBackoff("pi")
+		require.Equal(t, backoff.Exponential, config.Strategy)
+		require.Equal(t, 3, config.Retries)
+		require.Equal(t, jitter, config.Jitter)
+	})
+
+	t.Run("applies the preset overrides", func(t *testing.T) {
+		config := s.presetBackoff("etl")
+		require.Equal(t, backoff.Constant, config.Strategy)
+		require.Equal(t, time.Minute, config.InitialDelay)
+		require.Equal(t, 3, config.Retries)
+	})
+}
+
+func TestScheduledSubmit(t *testing.T) {
+	newSpark := func() *Spark {
+		return newTestSpark(t, Config{}, nil, "exit 0", map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+
+	t.Run("given run_at in the future, schedules the job", func(t *testing.T) {
+		s := newSpark()
+		runAt := time.Now().Add(time.Hour)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateScheduled, job.State)
+		require.WithinDuration(t, runAt, *job.RunAt, time.Second)
+		require.Contains(t, s.timers, id)
+		require.Nil(t, job.Events)
+
+		events, err := s.JobEvents(id)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		require.Equal(t, jobs.EventScheduled, events[1].Type)
+	})
+
+	t.Run("scheduled jobs can be cancelled", func(t *testing.T) {
+		s := newSpark()
+		runAt := time.Now().Add(time.Hour)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+
+		require.NoError(t, s.Cancel(id))
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateCancelled, job.State)
+		require.NotNil(t, job.FinishedAt)
+		require.ErrorIs(t, s.Cancel(id), JobNotCancellableError)
+	})
+
+	t.Run("given an unknown job, Cancel returns JobNotFoundError", func(t *testing.T) {
+		s := newSpark()
+		require.ErrorIs(t, s.Cancel("nope"), jobs.JobNotFoundError)
+	})
+}
+
+func TestConcurrentSubmit(t *testing.T) {
+	disallow := false
+	newSpark := func(store jobs.Store) *Spark {
+		return newTestSpark(t, Config{}, store, "exit 0", map[string]configurationPreset{"pi": {Main: "pi.py", AllowConcurrent: &disallow}})
+	}
+
+	t.Run("given allowConcurrent=false and a running job, rejects the submission", func(t *testing.T) {
+		store := jobs.NewMemoryStore()
+		running, err := jobs.New("pi")
+		require.NoError(t, err)
+		running.State = jobs.StateRunning
+		require.NoError(t, store.Put(running))
+
+		_, err = newSpark(store).Submit(context.Background(), "pi", SubmitOptions{})
+		require.ErrorIs(t, err, PresetRunningError)
+	})
+
+	t.Run("given allowConcurrent=false and only finished jobs, accepts the submission", func(t *testing.T) {
+		store := jobs.NewMemoryStore()
+		finished, err := jobs.New("pi")
+		require.NoError(t, err)
+		finished.State = jobs.StateFailed
+		require.NoError(t, store.Put(finished))
+
+		s := newSpark(store)
+		runAt := time.Now().Add(time.Hour)
+		_, err = s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+	})
+}
+
+func TestSubmitPriority(t *testing.T) {
+	s := newTestSpark(t, Config{}, nil, "exit 0", map[string]configurationPreset{"pi": {Main: "pi.py", Priority: 5}})
+	runAt := time.Now().Add(time.Hour)
+
+	t.Run("uses the priority of the preset", func(t *testing.T) {
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, 5, job.Priority)
+	})
+
+	t.Run("the priority of the request overrides the preset", func(t *testing.T) {
+		priority := -1
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt, Priority: &priority})
+		require.NoError(t, err)
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, -1, job.Priority)
+	})
+	require.NoError(t, s.Shutdown(context.Background()))
+}
+
+// fakeSparkSubmit creates a spark-submit binary that runs the given shell script
+func fakeSparkSubmit(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "spark-submit")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	return path
+}
+
+// newTestSpark creates a Spark with New whose spark-submit runs the given
+// shell script, a nil store is a memory store. The presets replace the ones
+// of the preset directory, they needn't be valid preset files, e.g.
+// templates that only resolve with params.
+func newTestSpark(t *testing.T, cfg Config, store jobs.Store, script string, presets map[string]configurationPreset) *Spark {
+	t.Helper()
+	cfg.SparkHome, cfg.PresetDir = t.TempDir(), t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(cfg.SparkHome, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.SparkHome, "bin", "spark-submit"), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.PresetDir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+	if store == nil {
+		store = jobs.NewMemoryStore()
+	}
+	s, err := New(cfg, store)
+	require.NoError(t, err)
+	s.presets, s.presetFiles = presets, make(map[string]string)
+	return s
+}
+
+func TestCancel(t *testing.T) {
+	newSpark := func(t *testing.T, limit int) *Spark {
+		return newTestSpark(t, Config{MaxConcurrentSubmits: limit}, nil, "sleep 10", map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+	waitForState := func(t *testing.T, s *Spark, id string, state jobs.State) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			job, err := s.Job(id)
+			require.NoError(t, err)
+			return job.State == state
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	t.Run("cancels a running submission", func(t *testing.T) {
+		s := newSpark(t, 0)
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		waitForState(t, s, id, jobs.StateRunning)
+
+		require.NoError(t, s.Cancel(id))
+		waitForState(t, s, id, jobs.StateCancelled)
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Len(t, job.Attempts, 1)
+	})
+
+	t.Run("cancels a queued submission", func(t *testing.T) {
+		s := newSpark(t, 1)
+		running, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		queued, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+
+		require.NoError(t, s.Cancel(queued))
+		waitForState(t, s, queued, jobs.StateCancelled)
+		require.NoError(t, s.Cancel(running))
+		waitForState(t, s, running, jobs.StateCancelled)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	newSpark := func(t *testing.T, script string) *Spark {
+		return newTestSpark(t, Config{MaxConcurrentSubmits: 1}, nil, script, map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+
+	t.Run("waits for running submissions and abandons the rest", func(t *testing.T) {
+		s := newSpark(t, "sleep 0.2")
+		running, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		queued, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		runAt := time.Now().Add(time.Hour)
+		scheduled, err := s.Submit(context.Background(), "pi", SubmitOptions{RunAt: &runAt})
+		require.NoError(t, err)
+
+		require.NoError(t, s.Shutdown(context.Background()))
+		for id, want := range map[string]jobs.State{
+			running:   jobs.StateSucceeded,
+			queued:    jobs.StateCancelled,
+			scheduled: jobs.StateCancelled,
+		} {
+			job, err := s.Job(id)
+			require.NoError(t, err)
+			require.Equal(t, want, job.State)
+		}
+
+		_, err = s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.ErrorIs(t, err, ShuttingDownError)
+	})
+
+	t.Run("aborts running submissions once the context is done", func(t *testing.T) {
+		s := newSpark(t, "sleep 10")
+		running, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+		job, err := s.Job(running)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateCancelled, job.State)
+	})
+}
+
+func TestCommandTimeout(t *testing.T) {
+	s := &Spark{
+		presets:    map[string]configurationPreset{"pi": {Main: "pi.py"}},
+		binaryPath: fakeSparkSubmit(t, "echo started; sleep 10"),
+		timeout:    100 * time.Millisecond,
+	}
+
+	t.Run("aborts a hanging status request", func(t *testing.T) {
+		start := time.Now()
+		report := s.Status(context.Background(), "spark", "pi")
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.Equal(t, "started\n", report.Raw)
+	})
+
+	t.Run("aborts a hanging kill request", func(t *testing.T) {
+		start := time.Now()
+		s.Kill(context.Background(), "spark", "pi")
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("aborts when the caller's context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s := &Spark{
+			presets: map[string]configurationPreset{"pi": {Main: "pi.py"}},
+			jobs:    jobs.NewMemoryStore(),
+		}
+		_, err := s.Submit(ctx, "pi", SubmitOptions{})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *eventRecorder) Publish(_ context.Context, event events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *eventRecorder) types() []events.Type {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]events.Type, 0, len(r.events))
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEvents(t *testing.T) {
+	newSpark := func(t *testing.T, script string, recorder *eventRecorder) *Spark {
+		return newTestSpark(t, Config{Backoff: backoff.Config{Retries: 2, InitialDelay: time.Millisecond}, Events: recorder}, nil, script,
+			map[string]configurationPreset{"pi": {Main: "pi.py"}})
+	}
+
+	t.Run("publishes submitted and succeeded", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		s := newSpark(t, "exit 0", recorder)
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.Equal(t, []events.Type{events.Submitted, events.Succeeded}, recorder.types())
+		require.Equal(t, "pi", recorder.events[1].Preset)
+	})
+
+	t.Run("publishes retries and failures", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		s := newSpark(t, "exit 1", recorder)
+		_, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.Equal(t, []events.Type{events.Submitted, events.Retrying, events.Failed}, recorder.types())
+		require.Equal(t, 2, recorder.events[1].Attempt)
+		require.Equal(t, "exit status 1", recorder.events[2].Error)
+	})
+
+	t.Run("publishes kills", func(t *testing.T) {
+		recorder := &eventRecorder{}
+		s := newSpark(t, "exit 0", recorder)
+		s.Kill(context.Background(), "spark", "pi-driver")
+		require.Equal(t, []events.Event{{Type: events.Killed, Time: recorder.events[0].Time, Namespace: "spark", Name: "pi-driver"}}, recorder.events)
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	s := newPresetSpark(t)
+	s.binaryPath = fakeSparkSubmit(t, "exit 1")
+	s.dryRun = true
+	s.capacityMode = CapacityCheckReject
+	require.NoError(t, s.CreatePreset("etl", []byte(`
+main: artifact://etl/etl.jar
+args: ["${vault:secret/etl#password}"]
+maxRuntime: 1h
+`)))
+
+	id, err := s.Submit(context.Background(), "etl", SubmitOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := s.Job(id)
+		return err == nil && job.State.Terminal()
+	}, 5*time.Second, 10*time.Millisecond)
+	s.cancelMu.Lock()
+	require.Empty(t, s.runtimeTimers)
+	s.cancelMu.Unlock()
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	job, err := s.Job(id)
+	require.NoError(t, err)
+	require.Equal(t, jobs.StateSucceeded, job.State)
+	require.True(t, job.DryRun)
+	require.Len(t, job.Attempts, 1)
+
+	output, err := s.SubmitOutput(id)
+	require.NoError(t, err)
+	require.Contains(t, output, "dry run, skipped spark-submit")
+	require.Contains(t, output, "--name=etl")
+	require.Contains(t, output, "artifact://etl/etl.jar ${vault:secret/etl#password}")
+}
+
+func TestMetrics(t *testing.T) {
+	newSpark := func(t *testing.T, preset, script string) *Spark {
+		return newTestSpark(t, Config{}, nil, script, map[string]configurationPreset{preset: {Main: "pi.py"}})
+	}
+
+	t.Run("observes the submission duration", func(t *testing.T) {
+		s := newSpark(t, "histogram", "exit 0")
+		_, err := s.Submit(context.Background(), "histogram", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		var metric dto.Metric
+		require.NoError(t, durationHistogram.WithLabelValues("", "histogram", "success").(prometheus.Histogram).Write(&metric))
+		require.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+	})
+
+	t.Run("counts running submissions", func(t *testing.T) {
+		running := func() float64 {
+			var metric dto.Metric
+			require.NoError(t, runningGauge.WithLabelValues("", "gauge").Write(&metric))
+			return metric.GetGauge().GetValue()
 		}
-		require.NoError(t, retry(3, 1*time.Nanosecond, 2, 1*time.Second, fn))
-		require.Greater(t, try, 1)
+		s := newSpark(t, "gauge", "sleep 10")
+		id, err := s.Submit(context.Background(), "gauge", SubmitOptions{})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return running() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, s.Cancel(id))
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.Equal(t, float64(0), running())
 	})
 }
@@ -0,0 +1,246 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	_ "embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// PresetLint is the result of checking a preset without saving it
+type PresetLint struct {
+	// Errors prevent saving the preset
+	Errors []string `json:"errors"`
+	// Warnings point out likely mistakes, e.g. misspelled sparkConf keys
+	Warnings []string `json:"warnings"`
+	// Findings are the errors and warnings with their severity and field,
+	// for tools that annotate preset files
+	Findings []LintFinding `json:"findings"`
+}
+
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+)
+
+// LintFinding is a single error or warning of a preset
+type LintFinding struct {
+	Severity LintSeverity `json:"severity"`
+	// Field is the path of the field in the preset, e.g.
+	// sparkConf.spark.executor.memory, it's empty if the finding isn't about
+	// a single field
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (l *PresetLint) add(finding LintFinding) {
+	l.Findings = append(l.Findings, finding)
+	if finding.Severity == LintError {
+		l.Errors = append(l.Errors, finding.Message)
+	} else {
+		l.Warnings = append(l.Warnings, finding.Message)
+	}
+}
+
+// sparkConfCatalog lists the known spark properties, one per line
+//
+//go:embed sparkconf.txt
+var sparkConfCatalog string
+
+// sparkConfKeys are the exact keys and sparkConfPrefixes the prefixes of the
+// wildcard entries of the catalog
+var sparkConfKeys, sparkConfPrefixes = parseSparkConfCatalog(sparkConfCatalog)
+
+func parseSparkConfCatalog(catalog string) (map[string]bool, []string) {
+	keys := make(map[string]bool)
+	var prefixes []string
+	for _, line := range strings.Split(catalog, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(line, "*"); ok {
+			prefixes = append(prefixes, prefix)
+			continue
+		}
+		keys[line] = true
+	}
+	sort.Strings(prefixes)
+	return keys, prefixes
+}
+
+// knownSparkConf reports whether the key is in the catalog or matches one of
+// the allowed glob patterns
+func (s *Spark) knownSparkConf(key string) bool {
+	if sparkConfKeys[key] {
+		return true
+	}
+	for _, prefix := range sparkConfPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range s.allowedSparkConf {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// lintSparkConf returns a warning for every key of conf that spark doesn't know
+func (s *Spark) lintSparkConf(conf map[string]string) []string {
+	var warnings []string
+	for _, finding := range s.sparkConfFindings(conf) {
+		warnings = append(warnings, finding.Message)
+	}
+	return warnings
+}
+
+func (s *Spark) sparkConfFindings(conf map[string]string) []LintFinding {
+	keys := make([]string, 0, len(conf))
+	for key := range conf {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var findings []LintFinding
+	for _, key := range keys {
+		finding := LintFinding{Severity: LintWarning, Field: "sparkConf." + key}
+		if !strings.HasPrefix(key, "spark.") {
+			finding.Message = fmt.Sprintf(`sparkConf key "%s" isn't a spark property, spark-submit ignores it`, key)
+			findings = append(findings, finding)
+			continue
+		}
+		if s.knownSparkConf(key) {
+			continue
+		}
+		finding.Message = fmt.Sprintf(`unknown sparkConf key "%s"`, key)
+		if suggestion := suggestSparkConf(key); suggestion != "" {
+			finding.Message += fmt.Sprintf(`, did you mean "%s"?`, suggestion)
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+const (
+	// minHeapMiB is the smallest heap spark starts with, it reserves 300 MiB
+	// and needs half as much on top
+	minHeapMiB = 450
+	// maxHeapMiB is larger than the memory of common cluster nodes
+	maxHeapMiB = 512 * 1024
+)
+
+// memoryFindings warns about memory values of conf that spark can't parse or
+// that are likely a forgotten unit, e.g. 4 instead of 4g. Values with
+// templates or variables are only known at submit time and skipped.
+func memoryFindings(conf map[string]string) []LintFinding {
+	var findings []LintFinding
+	for _, key := range []string{"spark.driver.memory", "spark.executor.memory", "spark.driver.memoryOverhead", "spark.executor.memoryOverhead"} {
+		value, ok := conf[key]
+		if !ok || strings.Contains(value, "{{") || strings.Contains(value, "${") {
+			continue
+		}
+		finding := LintFinding{Severity: LintWarning, Field: "sparkConf." + key}
+		mib, ok := parseMemoryMiB(value)
+		switch {
+		case !ok:
+			finding.Message = fmt.Sprintf(`%s "%s" isn't a size like 512m or 4g`, key, value)
+		case strings.HasSuffix(key, ".memoryOverhead"):
+			continue
+		case mib < minHeapMiB:
+			finding.Message = fmt.Sprintf(`%s "%s" is below the %dm spark needs, plain numbers are MiB`, key, value, minHeapMiB)
+		case mib > maxHeapMiB:
+			finding.Message = fmt.Sprintf(`%s "%s" is larger than %dg`, key, value, maxHeapMiB/1024)
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// LintPreset checks a preset in the format of CreatePreset without saving it
+func (s *Spark) LintPreset(name string, raw []byte) PresetLint {
+	lint := PresetLint{Errors: []string{}, Warnings: []string{}, Findings: []LintFinding{}}
+	preset, err := parsePreset(name, raw)
+	if err != nil {
+		lint.add(LintFinding{Severity: LintError, Message: err.Error()})
+		return lint
+	}
+	for _, finding := range s.sparkConfFindings(preset.SparkConf) {
+		lint.add(finding)
+	}
+	for _, finding := range memoryFindings(preset.SparkConf) {
+		lint.add(finding)
+	}
+	return lint
+}
+
+// warnSparkConf logs the unknown sparkConf keys of a loaded preset
+func (s *Spark) warnSparkConf(name string, preset configurationPreset) {
+	for _, warning := range s.lintSparkConf(preset.SparkConf) {
+		zap.L().Warn(warning, zap.String("presetName", name))
+	}
+}
+
+// maxSuggestionDistance is the number of edits a typo may be away from a
+// known key to be suggested
+const maxSuggestionDistance = 3
+
+// suggestSparkConf returns the closest known key, or an empty string if none
+// is close enough
+func suggestSparkConf(key string) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for known := range sparkConfKeys {
+		if distance := levenshtein(key, known); distance < bestDistance || distance == bestDistance && known < best {
+			best, bestDistance = known, distance
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintSparkConf(t *testing.T) {
+	s := &Spark{allowedSparkConf: []string{"spark.myplugin.*"}}
+
+	t.Run("given known keys, returns no warnings", func(t *testing.T) {
+		require.Empty(t, s.lintSparkConf(map[string]string{
+			"spark.executor.memory":                       "4g",
+			"spark.hadoop.fs.s3a.endpoint":                "s3.amazonaws.com",
+			"spark.kubernetes.driver.label.team":          "data",
+			"spark.sql.shuffle.partitions":                "200",
+			"spark.executor.resource.gpu.discoveryScript": "/opt/gpu.sh",
+		}))
+	})
+
+	t.Run("given a typo, suggests the closest key", func(t *testing.T) {
+		require.Equal(t, []string{
+			`unknown sparkConf key "spark.executer.memory", did you mean "spark.executor.memory"?`,
+		}, s.lintSparkConf(map[string]string{"spark.executer.memory": "4g"}))
+	})
+
+	t.Run("given an unknown key without a close match, warns without a suggestion", func(t *testing.T) {
+		require.Equal(t, []string{
+			`unknown sparkConf key "spark.completely.different.thing"`,
+		}, s.lintSparkConf(map[string]string{"spark.completely.different.thing": "1"}))
+	})
+
+	t.Run("given a key matching an allowed pattern, returns no warnings", func(t *testing.T) {
+		require.Empty(t, s.lintSparkConf(map[string]string{"spark.myplugin.enabled": "true"}))
+	})
+
+	t.Run("given a key outside spark.*, warns that it is ignored", func(t *testing.T) {
+		require.Equal(t, []string{
+			`sparkConf key "executor.memory" isn't a spark property, spark-submit ignores it`,
+		}, s.lintSparkConf(map[string]string{"executor.memory": "4g"}))
+	})
+}
+
+func TestLintPreset(t *testing.T) {
+	s := &Spark{}
+
+	t.Run("given an invalid preset, returns the error", func(t *testing.T) {
+		lint := s.LintPreset("etl", []byte("args: [x]"))
+		require.Equal(t, []string{"invalid preset: main is required"}, lint.Errors)
+		require.Empty(t, lint.Warnings)
+	})
+
+	t.Run("given a preset with a typo, returns a warning", func(t *testing.T) {
+		lint := s.LintPreset("etl", []byte("main: etl.py\nsparkConf:\n  spark.driver.memroy: 2g\n"))
+		require.Empty(t, lint.Errors)
+		require.Equal(t, []string{`unknown sparkConf key "spark.driver.memroy", did you mean "spark.driver.memory"?`}, lint.Warnings)
+		require.Equal(t, []LintFinding{{Severity: LintWarning, Field: "sparkConf.spark.driver.memroy", Message: lint.Warnings[0]}}, lint.Findings)
+	})
+
+	t.Run("given suspicious memory values, returns warnings", func(t *testing.T) {
+		lint := s.LintPreset("etl", []byte(`main: etl.py
+sparkConf:
+  spark.driver.memory: "4"
+  spark.executor.memory: 2tb
+  spark.executor.memoryOverhead: lots
+  spark.driver.memoryOverhead: 384m
+`))
+		require.Empty(t, lint.Errors)
+		require.Equal(t, []string{
+			`spark.driver.memory "4" is below the 450m spark needs, plain numbers are MiB`,
+			`spark.executor.memory "2tb" is larger than 512g`,
+			`spark.executor.memoryOverhead "lots" isn't a size like 512m or 4g`,
+		}, lint.Warnings)
+		require.Equal(t, "sparkConf.spark.executor.memory", lint.Findings[1].Field)
+	})
+
+	t.Run("skips memory values of templates", func(t *testing.T) {
+		lint := s.LintPreset("etl", []byte("main: etl.py\nsparkConf:\n  spark.executor.memory: \"{{ .memory }}\"\n"))
+		require.Empty(t, lint.Warnings)
+	})
+
+	t.Run("given an invalid preset, returns the error as finding", func(t *testing.T) {
+		lint := s.LintPreset("etl", []byte("args: [x]"))
+		require.Equal(t, []LintFinding{{Severity: LintError, Message: "invalid preset: main is required"}}, lint.Findings)
+	})
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ROptions configure the R runtime of SparkR applications, they expand to
+// the spark.r.* configuration
+type ROptions struct {
+	// Command runs R scripts on the executors, DriverCommand on the driver.
+	// Both default to Rscript.
+	Command       string `yaml:"command,omitempty" json:"command,omitempty"`
+	DriverCommand string `yaml:"driverCommand,omitempty" json:"driverCommand,omitempty"`
+	// BackendConnectionTimeout is how long the R process waits for the JVM backend
+	BackendConnectionTimeout time.Duration `yaml:"backendConnectionTimeout,omitempty" json:"-"`
+	// BackendThreads is the number of threads of the RBackend
+	BackendThreads int `yaml:"backendThreads,omitempty" json:"backendThreads,omitempty"`
+}
+
+// MarshalJSON formats the timeout like the other durations of presets
+func (o ROptions) MarshalJSON() ([]byte, error) {
+	type options ROptions
+	return json.Marshal(struct {
+		options
+		BackendConnectionTimeout string `json:"backendConnectionTimeout,omitempty"`
+	}{options(o), formatDuration(o.BackendConnectionTimeout)})
+}
+
+// isRMain reports whether the application is an R script
+func isRMain(main string) bool {
+	return strings.HasSuffix(strings.ToLower(main), ".r")
+}
+
+func (o ROptions) validate() error {
+	if o.BackendConnectionTimeout < 0 {
+		return fmt.Errorf("%w: r backendConnectionTimeout can't be negative", InvalidPresetError)
+	}
+	if o.BackendConnectionTimeout%time.Second != 0 {
+		return fmt.Errorf("%w: r backendConnectionTimeout must be whole seconds", InvalidPresetError)
+	}
+	if o.BackendThreads < 0 {
+		return fmt.Errorf("%w: r backendThreads can't be negative", InvalidPresetError)
+	}
+	return nil
+}
+
+// rConf translates the R options to spark configuration
+func rConf(options *ROptions) map[string]string {
+	conf := map[string]string{}
+	if options == nil {
+		return conf
+	}
+	if options.Command != "" {
+		conf["spark.r.command"] = options.Command
+	}
+	if options.DriverCommand != "" {
+		conf["spark.r.driver.command"] = options.DriverCommand
+	}
+	if options.BackendConnectionTimeout > 0 {
+		conf["spark.r.backendConnectionTimeout"] = strconv.Itoa(int(options.BackendConnectionTimeout / time.Second))
+	}
+	if options.BackendThreads > 0 {
+		conf["spark.r.numRBackendThreads"] = strconv.Itoa(options.BackendThreads)
+	}
+	return conf
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparkR(t *testing.T) {
+	t.Run("builds the args of R applications", func(t *testing.T) {
+		s := &Spark{
+			presets: map[string]configurationPreset{"report": {
+				Main:  "s3a://jobs/report.R",
+				Args:  []string{"--month", "{{.month}}"},
+				Files: []string{"s3a://jobs/helpers.R"},
+				R: &ROptions{
+					Command:                  "/opt/R/4.3/bin/Rscript",
+					BackendConnectionTimeout: 2 * time.Minute,
+					BackendThreads:           4,
+				},
+			}},
+			master: "yarn",
+		}
+		args, err := s.submitArgs("report", SubmitOptions{Params: map[string]string{"month": "2023-05"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"--master=yarn",
+			"--deploy-mode=cluster",
+			"--name=report",
+			"--files=s3a://jobs/helpers.R",
+			"--conf=spark.r.backendConnectionTimeout=120",
+			"--conf=spark.r.command=/opt/R/4.3/bin/Rscript",
+			"--conf=spark.r.numRBackendThreads=4",
+			"s3a://jobs/report.R",
+			"--month",
+			"2023-05",
+		}, args)
+	})
+
+	t.Run("parses R presets", func(t *testing.T) {
+		preset, err := parsePreset("report", []byte("main: report.r\nr:\n  driverCommand: Rscript-4\n  backendConnectionTimeout: 30s"))
+		require.NoError(t, err)
+		require.Equal(t, &ROptions{DriverCommand: "Rscript-4", BackendConnectionTimeout: 30 * time.Second}, preset.R)
+		require.Equal(t, map[string]string{
+			"spark.r.driver.command":           "Rscript-4",
+			"spark.r.backendConnectionTimeout": "30",
+		}, rConf(preset.R))
+
+		detail, err := json.Marshal(preset.R)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"driverCommand": "Rscript-4", "backendConnectionTimeout": "30s"}`, string(detail))
+	})
+
+	t.Run("given invalid R options, returns InvalidPresetError", func(t *testing.T) {
+		for raw, message := range map[string]string{
+			"main: report.py\nr: {command: Rscript}":                "r options need an R main",
+			"main: report.R\nr: {backendConnectionTimeout: 1500ms}": "must be whole seconds",
+			"main: report.R\nr: {backendThreads: -1}":               "backendThreads can't be negative",
+		} {
+			_, err := parsePreset("report", []byte(raw))
+			require.ErrorIs(t, err, InvalidPresetError, raw)
+			require.ErrorContains(t, err, message, raw)
+		}
+	})
+}
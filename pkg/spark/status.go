@@ -0,0 +1,126 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusReport is the result of a status request. Raw keeps the unparsed
+// spark-submit output for clients that still rely on it.
+type StatusReport struct {
+	Raw  string      `json:"status"`
+	Apps []AppStatus `json:"apps"`
+}
+
+// AppStatus describes the driver pod of a spark application, or the
+// application report of the YARN backend
+type AppStatus struct {
+	PodName       string            `json:"podName"`
+	ApplicationID string            `json:"applicationId,omitempty"`
+	Namespace     string            `json:"namespace"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	// Preset is the preset the application was submitted for, taken from
+	// the spark-submit/preset label or the YARN application name
+	Preset         string     `json:"preset,omitempty"`
+	Phase          string     `json:"phase"`
+	SubmissionDate *time.Time `json:"submissionDate,omitempty"`
+	StartTime      *time.Time `json:"startTime,omitempty"`
+	ContainerState string     `json:"containerState,omitempty"`
+	ExitCode       *int       `json:"exitCode,omitempty"`
+	ExitReason     string     `json:"exitReason,omitempty"`
+	FinalStatus    string     `json:"finalStatus,omitempty"`
+}
+
+// Live reports whether the application is still pending or running
+func (a AppStatus) Live() bool {
+	switch a.Phase {
+	case "Pending", "Running", "NEW", "NEW_SAVING", "SUBMITTED", "ACCEPTED", "RUNNING":
+		return true
+	}
+	return false
+}
+
+const driverStatusHeader = "Application status (driver):"
+
+// parseStatus extracts the driver pod details from the output of
+// `spark-submit --status`. Every matching driver is printed as a block of
+// "key: value" lines, missing values are printed as "N/A".
+func parseStatus(output string) []AppStatus {
+	apps := make([]AppStatus, 0)
+	blocks := strings.Split(output, driverStatusHeader)
+	for _, block := range blocks[1:] {
+		var app AppStatus
+		for _, line := range strings.Split(block, "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+			if !ok {
+				continue
+			}
+			if value == "N/A" {
+				value = ""
+			}
+
+			switch key {
+			case "pod name":
+				app.PodName = value
+			case "namespace":
+				app.Namespace = value
+			case "labels":
+				app.Labels = parseLabels(value)
+				app.Preset = app.Labels[PresetLabel]
+			case "creation time":
+				app.SubmissionDate = parseTime(value)
+			case "start time":
+				app.StartTime = parseTime(value)
+			case "phase":
+				app.Phase = value
+			case "container state":
+				app.ContainerState = value
+			case "exit code":
+				if code, err := strconv.Atoi(value); err == nil {
+					app.ExitCode = &code
+				}
+			case "termination reason", "pending reason":
+				app.ExitReason = value
+			}
+		}
+		if app.PodName != "" {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
+
+// parseLabels parses labels formatted as "key -> value, key2 -> value2"
+func parseLabels(value string) map[string]string {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ", ") {
+		k, v, ok := strings.Cut(pair, " -> ")
+		if !ok {
+			continue
+		}
+		labels[k] = v
+	}
+	return labels
+}
+
+func parseTime(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const statusOutput = `23/07/10 12:00:01 WARN Utils: Your hostname resolves to a loopback address
+Application status (driver): 
+	 pod name: pi-3f1c2a8945c7e1d2-driver
+	 namespace: spark
+	 labels: spark-app-name -> pi, spark-app-selector -> spark-8d2d0bd8f1c44f55a8e2d6b7e1d6e1b5, spark-role -> driver, spark-submit/preset -> pi
+	 pod uid: 9b1f7a8c-2f1d-4d5e-9a3b-1c2d3e4f5a6b
+	 creation time: 2023-07-10T11:58:12Z
+	 service account name: spark
+	 volumes: spark-local-dir-1, spark-conf-volume-driver, kube-api-access-7qkzp
+	 node name: kind-control-plane
+	 start time: 2023-07-10T11:58:12Z
+	 phase: Succeeded
+	 container status: 
+		 container name: spark-kubernetes-driver
+		 container image: apache/spark:3.4.0-python3
+		 container state: terminated
+		 container started at: 2023-07-10T11:58:14Z
+		 container finished at: 2023-07-10T11:59:40Z
+		 exit code: 0
+		 termination reason: Completed
+Application status (driver): 
+	 pod name: pi-77aa2a8945c7e1d2-driver
+	 namespace: spark
+	 labels: spark-app-name -> pi, spark-role -> driver
+	 pod uid: 1b1f7a8c-2f1d-4d5e-9a3b-1c2d3e4f5a6b
+	 creation time: 2023-07-10T12:00:00Z
+	 service account name: spark
+	 volumes: spark-local-dir-1
+	 node name: N/A
+	 start time: N/A
+	 phase: Pending
+	 container status: 
+		 container name: spark-kubernetes-driver
+		 container image: apache/spark:3.4.0-python3
+		 container state: waiting
+		 pending reason: ContainerCreating
+`
+
+func TestParseStatus(t *testing.T) {
+	t.Run("parses all driver blocks", func(t *testing.T) {
+		apps := parseStatus(statusOutput)
+		require.Len(t, apps, 2)
+
+		exitCode := 0
+		submissionDate := time.Date(2023, 7, 10, 11, 58, 12, 0, time.UTC)
+		require.Equal(t, AppStatus{
+			PodName:   "pi-3f1c2a8945c7e1d2-driver",
+			Namespace: "spark",
+			Labels: map[string]string{
+				"spark-app-name":      "pi",
+				"spark-app-selector":  "spark-8d2d0bd8f1c44f55a8e2d6b7e1d6e1b5",
+				"spark-role":          "driver",
+				"spark-submit/preset": "pi",
+			},
+			Preset:         "pi",
+			Phase:          "Succeeded",
+			SubmissionDate: &submissionDate,
+			StartTime:      &submissionDate,
+			ContainerState: "terminated",
+			ExitCode:       &exitCode,
+			ExitReason:     "Completed",
+		}, apps[0])
+
+		require.False(t, apps[0].Live())
+		require.Equal(t, "Pending", apps[1].Phase)
+		require.True(t, apps[1].Live())
+		require.Nil(t, apps[1].StartTime)
+		require.Nil(t, apps[1].ExitCode)
+		require.Equal(t, "ContainerCreating", apps[1].ExitReason)
+	})
+
+	t.Run("given no application, returns an empty list", func(t *testing.T) {
+		apps := parseStatus("Application not found.")
+		require.NotNil(t, apps)
+		require.Empty(t, apps)
+	})
+}
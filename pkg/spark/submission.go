@@ -0,0 +1,98 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+)
+
+// SubmittedApp identifies the application spark-submit created
+type SubmittedApp struct {
+	Namespace     string
+	DriverPod     string
+	ApplicationID string
+}
+
+var (
+	// kubernetes: "Deployed Spark application pi with application ID spark-123 and submission ID spark:pi-driver into Kubernetes"
+	submissionIDPattern  = regexp.MustCompile(`submission ID ([^:\s]+):(\S+)`)
+	applicationIDPattern = regexp.MustCompile(`application ID (\S+)`)
+	// kubernetes, printed by the pod status watcher before the application is deployed
+	podNamePattern      = regexp.MustCompile(`^\s*pod name: (\S+)`)
+	podNamespacePattern = regexp.MustCompile(`^\s*namespace: (\S+)`)
+	appSelectorPattern  = regexp.MustCompile(`spark-app-selector -> ([^,\s]+)`)
+	// YARN: "Submitted application application_1689000000000_0001"
+	yarnApplicationPattern = regexp.MustCompile(`Submitted application (application_\d+_\d+)`)
+)
+
+// submissionParser scans the spark-submit output line by line and reports
+// the application as soon as a part of it is found
+type submissionParser struct {
+	mu      sync.Mutex
+	partial []byte
+	app     SubmittedApp
+	found   func(app SubmittedApp)
+}
+
+func newSubmissionParser(found func(app SubmittedApp)) *submissionParser {
+	return &submissionParser{found: found}
+}
+
+func (p *submissionParser) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.parseLine(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+	// lines without a newline are only kept up to a sane length
+	if len(p.partial) > 64*1024 {
+		p.partial = p.partial[:0]
+	}
+	return len(b), nil
+}
+
+func (p *submissionParser) parseLine(line string) {
+	app := p.app
+	if match := submissionIDPattern.FindStringSubmatch(line); match != nil {
+		app.Namespace, app.DriverPod = match[1], match[2]
+	}
+	if match := applicationIDPattern.FindStringSubmatch(line); match != nil {
+		app.ApplicationID = match[1]
+	}
+	if match := podNamePattern.FindStringSubmatch(line); match != nil && app.DriverPod == "" {
+		app.DriverPod = match[1]
+	}
+	if match := podNamespacePattern.FindStringSubmatch(line); match != nil && app.Namespace == "" {
+		app.Namespace = match[1]
+	}
+	if match := appSelectorPattern.FindStringSubmatch(line); match != nil && app.ApplicationID == "" {
+		app.ApplicationID = match[1]
+	}
+	if match := yarnApplicationPattern.FindStringSubmatch(line); match != nil {
+		app.ApplicationID = match[1]
+	}
+
+	if app != p.app {
+		p.app = app
+		p.found(app)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const kubernetesSubmitOutput = `23/07/10 11:58:12 INFO LoggingPodStatusWatcherImpl: State changed, new state:
+	 pod name: spark-pi-2b7c3e894040e5e8-driver
+	 namespace: spark
+	 labels: spark-app-name -> spark-pi, spark-app-selector -> spark-6f5a2c1e4d6b, spark-role -> driver
+	 phase: Pending
+23/07/10 11:58:13 INFO LoggingPodStatusWatcherImpl: Deployed Spark application spark-pi with application ID spark-6f5a2c1e4d6b and submission ID spark:spark-pi-2b7c3e894040e5e8-driver into Kubernetes
+`
+
+func TestSubmissionParser(t *testing.T) {
+	t.Run("finds the driver pod and application id on kubernetes", func(t *testing.T) {
+		var found []SubmittedApp
+		parser := newSubmissionParser(func(app SubmittedApp) { found = append(found, app) })
+		// the output arrives in arbitrary chunks
+		for _, chunk := range []string{kubernetesSubmitOutput[:50], kubernetesSubmitOutput[50:200], kubernetesSubmitOutput[200:]} {
+			_, err := parser.Write([]byte(chunk))
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, SubmittedApp{Namespace: "spark", DriverPod: "spark-pi-2b7c3e894040e5e8-driver", ApplicationID: "spark-6f5a2c1e4d6b"}, found[len(found)-1])
+		require.Len(t, found, 3)
+	})
+
+	t.Run("finds the application id on YARN", func(t *testing.T) {
+		var found SubmittedApp
+		parser := newSubmissionParser(func(app SubmittedApp) { found = app })
+		_, _ = parser.Write([]byte("23/07/10 11:58:12 INFO Client: Submitted application application_1689000000000_0001\n"))
+		require.Equal(t, SubmittedApp{ApplicationID: "application_1689000000000_0001"}, found)
+	})
+
+	t.Run("stores the application on the job", func(t *testing.T) {
+		s := newTestSpark(t, Config{}, nil, "cat <<'EOF'\n"+kubernetesSubmitOutput+"EOF", map[string]configurationPreset{"pi": {Main: "pi.py"}})
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, "spark", job.Namespace)
+		require.Equal(t, "spark-pi-2b7c3e894040e5e8-driver", job.DriverPod)
+		require.Equal(t, "spark-6f5a2c1e4d6b", job.ApplicationID)
+	})
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var driverPhaseCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spark_driver_phase_changes_total",
+	Help: "The number of phase changes of the driver pods of jobs, by new phase",
+}, []string{"tenant", "preset", "phase"})
+
+// the phases of AppPhase besides the pod phases of kubernetes
+const (
+	phaseOOMKilled = "OOMKilled"
+	phaseDeleted   = "Deleted"
+)
+
+// DriverFailedError fails spark-submit runs that returned successfully
+// although the driver pod of the job failed, spark-submit doesn't report the
+// outcome of the application on kubernetes
+var DriverFailedError error = errors.New("driver pod failed")
+
+// DriverWatcher keeps the jobs up to date with their applications, it is
+// implemented by the kubernetes backend
+type DriverWatcher interface {
+	WatchDrivers(ctx context.Context)
+}
+
+// WatchDrivers watches the driver pods of the jobs and sets the AppPhase of
+// the jobs on every phase change until ctx is done
+func (k *KubernetesBackend) WatchDrivers(ctx context.Context) {
+	informer := kube.NewPodInformer(k.client, "", driverSelector+","+JobLabel)
+	informer.AddHandler(k.driverChanged)
+	informer.Run(ctx)
+}
+
+func (k *KubernetesBackend) driverChanged(event kube.PodEvent) {
+	pod := event.Pod
+	id := pod.Metadata.Labels[JobLabel]
+	if id == "" || !k.NamespaceAllowed(pod.Metadata.Namespace) {
+		return
+	}
+	phase := driverPhase(event)
+	if phase == "" {
+		return
+	}
+
+	changed := false
+	var preset string
+	err := k.jobs.Update(id, func(j *jobs.Job) {
+		if !currentDriver(j, pod) || (j.AppPhase == phase && j.DriverPod == pod.Metadata.Name) {
+			return
+		}
+		changed = true
+		preset = j.Preset
+		j.Namespace, j.DriverPod, j.AppPhase = pod.Metadata.Namespace, pod.Metadata.Name, phase
+		j.Record(jobs.Event{Type: jobs.EventAppPhaseChanged, Message: fmt.Sprintf(`driver pod "%s" is %s`, pod.Metadata.Name, phase)})
+	})
+	if err != nil {
+		// the pods of pruned jobs are no concern
+		if !errors.Is(err, jobs.JobNotFoundError) {
+			zap.L().Warn("couldn't update app phase of job", zap.String("jobID", id), zap.Error(err))
+		}
+		return
+	}
+	if !changed {
+		return
+	}
+	driverPhaseCounter.WithLabelValues(k.tenant(preset), preset, phase).Inc()
+	zap.L().Info("driver pod phase changed", zap.String("jobID", id), zap.String("namespace", pod.Metadata.Namespace), zap.String("pod", pod.Metadata.Name), zap.String("phase", phase))
+}
+
+// currentDriver reports whether the pod belongs to the last attempt of the
+// job, the pods of earlier attempts were created before it started
+func currentDriver(job *jobs.Job, pod kube.Pod) bool {
+	if job.DriverPod == "" || job.DriverPod == pod.Metadata.Name {
+		return true
+	}
+	created := pod.Metadata.CreationTimestamp
+	return len(job.Attempts) > 0 && created != nil && !created.Before(job.Attempts[len(job.Attempts)-1].StartedAt)
+}
+
+// driverPhase returns the AppPhase of a watch event, deleted pods that were
+// still live are Deleted and the other deletions are ignored
+func driverPhase(event kube.PodEvent) string {
+	app := appStatusFromPod(event.Pod)
+	if event.Type == kube.Deleted {
+		if app.Live() {
+			return phaseDeleted
+		}
+		return ""
+	}
+	if app.ExitReason == phaseOOMKilled {
+		return phaseOOMKilled
+	}
+	return app.Phase
+}
+
+// driverFailed returns DriverFailedError if the watcher saw the driver pod
+// of the job fail, nil otherwise
+func (s *Spark) driverFailed(jobID string) error {
+	job, err := s.jobs.Get(jobID)
+	if err != nil {
+		return nil
+	}
+	if job.AppPhase == "Failed" || job.AppPhase == phaseOOMKilled {
+		return fmt.Errorf(`%w, "%s" is %s`, DriverFailedError, job.DriverPod, job.AppPhase)
+	}
+	return nil
+}
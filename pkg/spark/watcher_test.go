@@ -0,0 +1,131 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/backoff"
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/kube"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchDrivers(t *testing.T) {
+	driver := func(name, phase, reason string, created time.Time) kube.Pod {
+		pod := kube.Pod{Metadata: kube.ObjectMeta{
+			Name:              name,
+			Namespace:         "spark",
+			Labels:            map[string]string{"spark-role": "driver", JobLabel: "job-1"},
+			CreationTimestamp: &created,
+		}}
+		pod.Status.Phase = phase
+		if reason != "" {
+			status := kube.ContainerStatus{Name: "spark-kubernetes-driver"}
+			status.State.Terminated = &struct {
+				ExitCode   int        `json:"exitCode"`
+				Reason     string     `json:"reason,omitempty"`
+				StartedAt  *time.Time `json:"startedAt,omitempty"`
+				FinishedAt *time.Time `json:"finishedAt,omitempty"`
+			}{ExitCode: 137, Reason: reason}
+			pod.Status.ContainerStatuses = []kube.ContainerStatus{status}
+		}
+		return pod
+	}
+	newBackend := func(t *testing.T, job jobs.Job) *KubernetesBackend {
+		backend := newKubernetesBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+		backend.jobs = jobs.NewMemoryStore()
+		require.NoError(t, backend.jobs.Put(job))
+		return backend
+	}
+
+	t.Run("sets the app phase of the job on phase changes", func(t *testing.T) {
+		started := time.Now().UTC()
+		backend := newBackend(t, jobs.Job{ID: "job-1", Preset: "pi", State: jobs.StateRunning, Attempts: []jobs.Attempt{{StartedAt: started}}})
+
+		backend.driverChanged(kube.PodEvent{Type: kube.Added, Pod: driver("pi-1-driver", "Pending", "", started)})
+		backend.driverChanged(kube.PodEvent{Type: kube.Modified, Pod: driver("pi-1-driver", "Running", "", started)})
+		backend.driverChanged(kube.PodEvent{Type: kube.Modified, Pod: driver("pi-1-driver", "Running", "", started)})
+		job, err := backend.jobs.Get("job-1")
+		require.NoError(t, err)
+		require.Equal(t, "Running", job.AppPhase)
+		require.Equal(t, "pi-1-driver", job.DriverPod)
+		require.Equal(t, "spark", job.Namespace)
+		require.Len(t, job.Events, 2)
+		require.Equal(t, jobs.EventAppPhaseChanged, job.Events[1].Type)
+		require.Equal(t, `driver pod "pi-1-driver" is Running`, job.Events[1].Message)
+
+		backend.driverChanged(kube.PodEvent{Type: kube.Modified, Pod: driver("pi-1-driver", "Failed", "OOMKilled", started)})
+		job, err = backend.jobs.Get("job-1")
+		require.NoError(t, err)
+		require.Equal(t, "OOMKilled", job.AppPhase)
+		require.ErrorIs(t, backend.driverFailed("job-1"), DriverFailedError)
+	})
+
+	t.Run("ignores the driver pods of earlier attempts", func(t *testing.T) {
+		started := time.Now().UTC()
+		backend := newBackend(t, jobs.Job{ID: "job-1", State: jobs.StateRunning, DriverPod: "pi-2-driver", AppPhase: "Running", Attempts: []jobs.Attempt{{StartedAt: started}}})
+
+		backend.driverChanged(kube.PodEvent{Type: kube.Deleted, Pod: driver("pi-1-driver", "Failed", "Error", started.Add(-time.Minute))})
+		backend.driverChanged(kube.PodEvent{Type: kube.Modified, Pod: driver("pi-1-driver", "Failed", "Error", started.Add(-time.Minute))})
+		job, err := backend.jobs.Get("job-1")
+		require.NoError(t, err)
+		require.Equal(t, "Running", job.AppPhase)
+		require.NoError(t, backend.driverFailed("job-1"))
+
+		backend.driverChanged(kube.PodEvent{Type: kube.Deleted, Pod: driver("pi-2-driver", "Running", "", started)})
+		job, err = backend.jobs.Get("job-1")
+		require.NoError(t, err)
+		require.Equal(t, "Deleted", job.AppPhase)
+	})
+
+	t.Run("fails submissions whose driver pod failed", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "pi.yaml"), []byte("main: pi.py\n"), 0644))
+		runner := &driverFailingRunner{}
+		s, err := New(Config{SparkHome: dir, PresetDir: dir, Master: "k8s://mock", SubmitRunner: runner, Backoff: backoff.Config{Retries: 1}}, jobs.NewMemoryStore())
+		require.NoError(t, err)
+		runner.s = s
+
+		id, err := s.Submit(context.Background(), "pi", SubmitOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Shutdown(context.Background()))
+		job, err := s.Job(id)
+		require.NoError(t, err)
+		require.Equal(t, jobs.StateFailed, job.State)
+		require.Contains(t, job.Error, `driver pod failed, "pi-driver" is Failed`)
+	})
+}
+
+// driverFailingRunner succeeds like spark-submit does on kubernetes, while
+// the watcher saw the driver pod fail
+type driverFailingRunner struct {
+	s *Spark
+}
+
+func (r *driverFailingRunner) RunSubmit(ctx context.Context, args []string, output io.Writer) error {
+	for _, arg := range args {
+		if jobID, ok := strings.CutPrefix(arg, "--conf="+driverLabelPrefix+JobLabel+"="); ok {
+			r.s.updateJob(jobID, func(j *jobs.Job) { j.DriverPod, j.AppPhase = "pi-driver", "Failed" })
+		}
+	}
+	return nil
+}
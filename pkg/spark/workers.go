@@ -0,0 +1,224 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var QueueFullError error = errors.New("submission queue is full")
+
+const (
+	// DefaultSubmitWorkers is the number of workers if none is configured
+	DefaultSubmitWorkers = 32
+	// DefaultMaxQueuedSubmits is the size of the queue if none is configured
+	DefaultMaxQueuedSubmits = 1000
+)
+
+// queuedSubmission is guarded by the mutex of the pool
+type queuedSubmission struct {
+	jobID    string
+	priority int
+	start    func()
+}
+
+// workerPool runs submissions on a limited number of workers. Waiting
+// submissions are started by priority once a worker is free, the number of
+// workers and the size of the queue can change at runtime.
+type workerPool struct {
+	wg sync.WaitGroup
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// workers limits the busy workers, running counts the worker goroutines
+	workers int
+	running int
+	maxSize int
+	closed  bool
+	// assigned submissions are started by the next free worker, they can't
+	// be removed anymore
+	assigned []*queuedSubmission
+	// busy counts the running and assigned submissions
+	busy int
+	// waiting are the submissions that wait for a worker, by descending
+	// priority and in FIFO order within a priority
+	waiting []*queuedSubmission
+}
+
+// newWorkerPool starts the workers, 0 workers or queue size mean the defaults
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{}
+	p.cond = sync.NewCond(&p.mu)
+	p.resize(workers, queueSize)
+	return p
+}
+
+// resize changes the number of workers and the size of the queue, 0 means
+// the defaults. Surplus workers stop once they're idle, waiting submissions
+// beyond a smaller queue size stay queued.
+func (p *workerPool) resize(workers, queueSize int) {
+	if workers <= 0 {
+		workers = DefaultSubmitWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultMaxQueuedSubmits
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workers, p.maxSize = workers, queueSize
+	for ; p.running < workers; p.running++ {
+		p.wg.Add(1)
+		go p.work(zap.L().With(zap.Int("worker", p.running)))
+	}
+	p.assignWaiting()
+	p.cond.Broadcast()
+}
+
+// limits returns the number of workers and the size of the queue
+func (p *workerPool) limits() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.workers, p.maxSize
+}
+
+func (p *workerPool) work(log *zap.Logger) {
+	defer p.wg.Done()
+	for {
+		submission := p.next()
+		if submission == nil {
+			log.Debug("worker stopped")
+			return
+		}
+		log.Debug("starting submission", zap.String("jobID", submission.jobID), zap.Int("priority", submission.priority))
+		submission.start()
+		log.Debug("finished submission", zap.String("jobID", submission.jobID))
+		p.finish()
+	}
+}
+
+// next waits for an assigned submission, it returns nil once the pool is
+// closed or has more workers than it needs
+func (p *workerPool) next() *queuedSubmission {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if len(p.assigned) > 0 {
+			submission := p.assigned[0]
+			p.assigned = p.assigned[1:]
+			return submission
+		}
+		if p.closed || p.running > p.workers {
+			p.running--
+			return nil
+		}
+		p.cond.Wait()
+	}
+}
+
+// finish hands the worker over to the next waiting submission, so that it
+// can't be removed anymore in the meantime
+func (p *workerPool) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy--
+	p.assignWaiting()
+}
+
+// assignWaiting assigns waiting submissions to the free workers, the caller
+// must hold the mutex
+func (p *workerPool) assignWaiting() {
+	for p.busy < p.workers && len(p.waiting) > 0 {
+		p.assigned = append(p.assigned, p.waiting[0])
+		p.waiting = p.waiting[1:]
+		p.busy++
+		p.cond.Signal()
+	}
+}
+
+// enqueue starts the submission on a free worker or queues it by priority
+func (p *workerPool) enqueue(jobID string, priority int, start func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ShuttingDownError
+	}
+
+	submission := &queuedSubmission{jobID: jobID, priority: priority, start: start}
+	if p.busy < p.workers {
+		p.assigned = append(p.assigned, submission)
+		p.busy++
+		p.cond.Signal()
+		return nil
+	}
+	if len(p.waiting) >= p.maxSize {
+		return QueueFullError
+	}
+	i := sort.Search(len(p.waiting), func(i int) bool { return p.waiting[i].priority < priority })
+	p.waiting = append(p.waiting, nil)
+	copy(p.waiting[i+1:], p.waiting[i:])
+	p.waiting[i] = submission
+	return nil
+}
+
+// position returns the 1-based position of the job in the queue, or 0 if it
+// isn't waiting
+func (p *workerPool) position(jobID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, submission := range p.waiting {
+		if submission.jobID == jobID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// remove drops a waiting submission, it returns false if the job isn't waiting
+func (p *workerPool) remove(jobID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, submission := range p.waiting {
+		if submission.jobID == jobID {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// drain removes all waiting submissions and returns their job ids
+func (p *workerPool) drain() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.waiting))
+	for _, submission := range p.waiting {
+		ids = append(ids, submission.jobID)
+	}
+	p.waiting = nil
+	return ids
+}
+
+// close stops accepting submissions and waits for the workers to finish the
+// running ones, waiting submissions must be drained before
+func (p *workerPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool(t *testing.T) {
+	// blocking returns a start func that reports the start and waits for release
+	blocking := func(started chan<- string, release <-chan struct{}, id string) func() {
+		return func() {
+			started <- id
+			<-release
+		}
+	}
+
+	t.Run("runs at most one submission per worker", func(t *testing.T) {
+		p := newWorkerPool(1, 0)
+		started := make(chan string, 3)
+		release := make(chan struct{})
+
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.Equal(t, "first", <-started)
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+		require.NoError(t, p.enqueue("third", 0, blocking(started, release, "third")))
+		require.Equal(t, 1, p.position("second"))
+		require.Equal(t, 2, p.position("third"))
+		require.Equal(t, 0, p.position("first"))
+
+		release <- struct{}{}
+		require.Equal(t, "second", <-started)
+		require.Equal(t, 1, p.position("third"))
+		select {
+		case id := <-started:
+			t.Fatalf("%s started before a worker was free", id)
+		case <-time.After(10 * time.Millisecond):
+		}
+		close(release)
+		p.close()
+		require.Equal(t, "third", <-started)
+	})
+
+	t.Run("skips removed submissions", func(t *testing.T) {
+		p := newWorkerPool(1, 0)
+		started := make(chan string, 3)
+		release := make(chan struct{})
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.Equal(t, "first", <-started)
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+		require.NoError(t, p.enqueue("third", 0, blocking(started, release, "third")))
+
+		require.True(t, p.remove("second"))
+		require.False(t, p.remove("second"))
+		require.False(t, p.remove("first"))
+		require.Equal(t, 1, p.position("third"))
+
+		close(release)
+		require.Equal(t, "third", <-started)
+		p.close()
+	})
+
+	t.Run("starts waiting submissions by priority", func(t *testing.T) {
+		p := newWorkerPool(1, 0)
+		started := make(chan string, 4)
+		release := make(chan struct{})
+		require.NoError(t, p.enqueue("running", 0, blocking(started, release, "running")))
+		require.Equal(t, "running", <-started)
+		require.NoError(t, p.enqueue("backfill", -1, blocking(started, release, "backfill")))
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.NoError(t, p.enqueue("critical", 10, blocking(started, release, "critical")))
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+		require.Equal(t, 1, p.position("critical"))
+		require.Equal(t, 4, p.position("backfill"))
+
+		close(release)
+		require.Equal(t, "critical", <-started)
+		require.Equal(t, "first", <-started)
+		require.Equal(t, "second", <-started)
+		require.Equal(t, "backfill", <-started)
+		p.close()
+	})
+
+	t.Run("given a full queue, returns QueueFullError", func(t *testing.T) {
+		p := newWorkerPool(1, 1)
+		started := make(chan string, 2)
+		release := make(chan struct{})
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.Equal(t, "first", <-started)
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+		require.ErrorIs(t, p.enqueue("third", 0, func() {}), QueueFullError)
+		close(release)
+		p.close()
+	})
+
+	t.Run("drains waiting submissions and stops the workers", func(t *testing.T) {
+		p := newWorkerPool(1, 0)
+		started := make(chan string, 2)
+		release := make(chan struct{})
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.Equal(t, "first", <-started)
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+
+		require.Equal(t, []string{"second"}, p.drain())
+		close(release)
+		p.close()
+		require.Empty(t, started)
+		require.ErrorIs(t, p.enqueue("third", 0, func() {}), ShuttingDownError)
+	})
+
+	t.Run("given more workers, starts waiting submissions at once", func(t *testing.T) {
+		p := newWorkerPool(1, 1)
+		started := make(chan string, 2)
+		release := make(chan struct{})
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.Equal(t, "first", <-started)
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+
+		p.resize(2, 5)
+		require.Equal(t, "second", <-started)
+		workers, queueSize := p.limits()
+		require.Equal(t, 2, workers)
+		require.Equal(t, 5, queueSize)
+		close(release)
+		p.close()
+	})
+
+	t.Run("given fewer workers, lets running submissions finish", func(t *testing.T) {
+		p := newWorkerPool(2, 0)
+		started := make(chan string, 3)
+		release := make(chan struct{})
+		require.NoError(t, p.enqueue("first", 0, blocking(started, release, "first")))
+		require.NoError(t, p.enqueue("second", 0, blocking(started, release, "second")))
+		<-started
+		<-started
+
+		p.resize(1, 0)
+		require.NoError(t, p.enqueue("third", 0, blocking(started, release, "third")))
+		release <- struct{}{}
+		select {
+		case id := <-started:
+			t.Fatalf("%s started before the running submissions dropped below the limit", id)
+		case <-time.After(10 * time.Millisecond):
+		}
+		release <- struct{}{}
+		require.Equal(t, "third", <-started)
+		close(release)
+		p.close()
+	})
+
+	t.Run("given no limits, uses the defaults", func(t *testing.T) {
+		p := newWorkerPool(0, 0)
+		require.Equal(t, DefaultSubmitWorkers, p.workers)
+		require.Equal(t, DefaultMaxQueuedSubmits, p.maxSize)
+		p.close()
+	})
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/Staffbase/spark-submit/pkg/events"
+	"github.com/Staffbase/spark-submit/pkg/logging"
+	"github.com/Staffbase/spark-submit/pkg/yarn"
+	"go.uber.org/zap"
+)
+
+// YarnBackend submits applications with spark-submit, but looks up and kills
+// them through the REST API of the YARN ResourceManager. The namespace of
+// status and kill requests is the YARN queue, the name matches the
+// application id or name.
+type YarnBackend struct {
+	*Spark
+	client *yarn.Client
+}
+
+func NewYarnBackend(s *Spark, client *yarn.Client) *YarnBackend {
+	return &YarnBackend{Spark: s, client: client}
+}
+
+// apps returns the spark applications in the queue whose id or name matches
+// the glob pattern
+func (y *YarnBackend) apps(ctx context.Context, queue, name string) ([]yarn.Application, error) {
+	apps, err := y.client.ListApps(ctx, queue)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list YARN applications, %w", err)
+	}
+
+	matches := make([]yarn.Application, 0, len(apps))
+	for _, app := range apps {
+		matchedID, err := path.Match(name, app.ID)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid name pattern ("%s"), %w`, name, err)
+		}
+		matchedName, _ := path.Match(name, app.Name)
+		if matchedID || matchedName {
+			matches = append(matches, app)
+		}
+	}
+	return matches, nil
+}
+
+func (y *YarnBackend) Kill(ctx context.Context, namespace, name string) {
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, name)
+	defer span.End()
+	ctx, cancel := y.commandContext(ctx)
+	defer cancel()
+	apps, err := y.apps(ctx, namespace, name)
+	if err != nil {
+		logging.FromContext(ctx).Error("killing spark app failed", zap.Error(err))
+		span.RecordError(err)
+		return
+	}
+
+	for _, app := range apps {
+		if app.Final() {
+			continue
+		}
+		if err := y.killApp(ctx, app); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+func (y *YarnBackend) killApp(ctx context.Context, app yarn.Application) error {
+	logging.FromContext(ctx).Info("killing YARN application", zap.String("queue", app.Queue), zap.String("applicationId", app.ID))
+	err := y.client.KillApp(ctx, app.ID)
+	if errors.Is(err, yarn.NotFoundError) {
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("killing spark app failed", zap.String("applicationId", app.ID), zap.Error(err))
+		return err
+	}
+	y.publish(events.Event{Type: events.Killed, Namespace: app.Queue, Name: app.ID})
+	return nil
+}
+
+// KillApps kills the running applications of the queue that were submitted
+// for the preset, YARN applications have no labels to select them by
+func (y *YarnBackend) KillApps(ctx context.Context, namespace string, filter AppFilter) ([]string, error) {
+	if filter.LabelSelector != "" {
+		return nil, fmt.Errorf("%w, YARN applications have no labels", UnsupportedError)
+	}
+	ctx, span := startAppSpan(ctx, "spark.kill", namespace, "*")
+	defer span.End()
+	ctx, cancel := y.commandContext(ctx)
+	defer cancel()
+	apps, err := y.client.ListApps(ctx, namespace)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("couldn't list YARN applications, %w", err)
+	}
+
+	killed := make([]string, 0, len(apps))
+	for _, app := range apps {
+		// the preset is the name of the application
+		if app.Final() || (filter.Preset != "" && app.Name != filter.Preset) {
+			continue
+		}
+		if err := y.killApp(ctx, app); err != nil {
+			span.RecordError(err)
+			continue
+		}
+		killed = append(killed, app.ID)
+	}
+	return killed, nil
+}
+
+func (y *YarnBackend) Status(ctx context.Context, namespace, name string) StatusReport {
+	ctx, span := startAppSpan(ctx, "spark.status", namespace, name)
+	defer span.End()
+	ctx, cancel := y.commandContext(ctx)
+	defer cancel()
+	report := StatusReport{Apps: make([]AppStatus, 0)}
+	apps, err := y.apps(ctx, namespace, name)
+	if err != nil {
+		logging.FromContext(ctx).Error("requesting spark app status failed", zap.Error(err))
+		span.RecordError(err)
+		return report
+	}
+
+	for _, app := range apps {
+		report.Apps = append(report.Apps, appStatusFromYarn(app))
+	}
+	return report
+}
+
+func appStatusFromYarn(app yarn.Application) AppStatus {
+	status := AppStatus{
+		ApplicationID:  app.ID,
+		Namespace:      app.Queue,
+		Preset:         app.Name,
+		Phase:          app.State,
+		FinalStatus:    app.FinalStatus,
+		SubmissionDate: millisToTime(app.StartedTime),
+		StartTime:      millisToTime(app.LaunchTime),
+	}
+	if app.FinalStatus != "SUCCEEDED" {
+		status.ExitReason = app.Diagnostics
+	}
+	return status
+}
+
+func millisToTime(millis int64) *time.Time {
+	if millis <= 0 {
+		return nil
+	}
+	t := time.UnixMilli(millis).UTC()
+	return &t
+}
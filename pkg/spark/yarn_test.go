@@ -0,0 +1,104 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Staffbase/spark-submit/pkg/yarn"
+	"github.com/stretchr/testify/require"
+)
+
+const yarnApps = `{"apps": {"app": [
+	{"id": "application_1689000000000_0001", "name": "pi", "queue": "etl", "state": "FINISHED", "finalStatus": "FAILED",
+	 "diagnostics": "User class threw exception", "startedTime": 1689000000000, "launchTime": 1689000005000, "finishedTime": 1689000060000},
+	{"id": "application_1689000000000_0002", "name": "pi", "queue": "etl", "state": "RUNNING", "finalStatus": "UNDEFINED",
+	 "startedTime": 1689000100000, "launchTime": 0}
+]}}`
+
+func newYarnBackend(t *testing.T, handler http.HandlerFunc) *YarnBackend {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := yarn.NewClient(server.URL, "")
+	require.NoError(t, err)
+	return NewYarnBackend(&Spark{}, client)
+}
+
+func TestYarnBackend(t *testing.T) {
+	t.Run("Status returns the matching applications of the queue", func(t *testing.T) {
+		backend := newYarnBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/ws/v1/cluster/apps", r.URL.Path)
+			require.Equal(t, "etl", r.URL.Query().Get("queue"))
+			_, _ = w.Write([]byte(yarnApps))
+		})
+
+		report := backend.Status(context.Background(), "etl", "application_*_0001")
+		require.Len(t, report.Apps, 1)
+		app := report.Apps[0]
+		require.Equal(t, "application_1689000000000_0001", app.ApplicationID)
+		require.Equal(t, "etl", app.Namespace)
+		require.Equal(t, "pi", app.Preset)
+		require.Equal(t, "FINISHED", app.Phase)
+		require.Equal(t, "FAILED", app.FinalStatus)
+		require.Equal(t, "User class threw exception", app.ExitReason)
+		require.Equal(t, int64(1689000000000), app.SubmissionDate.UnixMilli())
+		require.Equal(t, int64(1689000005000), app.StartTime.UnixMilli())
+	})
+
+	t.Run("Status matches application names", func(t *testing.T) {
+		backend := newYarnBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(yarnApps))
+		})
+
+		report := backend.Status(context.Background(), "etl", "pi")
+		require.Len(t, report.Apps, 2)
+		require.Nil(t, report.Apps[1].StartTime)
+	})
+
+	t.Run("Kill kills the matching applications that are still running", func(t *testing.T) {
+		killed := make([]string, 0)
+		backend := newYarnBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				killed = append(killed, r.URL.Path)
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			_, _ = w.Write([]byte(yarnApps))
+		})
+
+		backend.Kill(context.Background(), "etl", "*")
+		require.Equal(t, []string{"/ws/v1/cluster/apps/application_1689000000000_0002/state"}, killed)
+	})
+
+	t.Run("KillApps kills the running applications of the preset", func(t *testing.T) {
+		backend := newYarnBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			_, _ = w.Write([]byte(yarnApps))
+		})
+
+		killed, err := backend.KillApps(context.Background(), "etl", AppFilter{Preset: "pi"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"application_1689000000000_0002"}, killed)
+
+		_, err = backend.KillApps(context.Background(), "etl", AppFilter{LabelSelector: "team=data"})
+		require.ErrorIs(t, err, UnsupportedError)
+	})
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statsd exports the prometheus metrics of the server over StatsD or
+// DogStatsD for monitoring stacks that don't scrape prometheus
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+type Flavor string
+
+const (
+	// StatsD appends the labels to the metric name, e.g. spark_exec_total.preset.pi
+	StatsD Flavor = "statsd"
+	// DogStatsD sends the labels as tags, e.g. spark_exec_total|c|#preset:pi
+	DogStatsD Flavor = "dogstatsd"
+)
+
+// maxPacketSize keeps the packets below the common MTU
+const maxPacketSize = 1432
+
+type Config struct {
+	// Address is the host:port of the StatsD agent
+	Address string
+	Flavor  Flavor
+	// Prefix is prepended to all metric names, e.g. "spark_submit."
+	Prefix   string
+	Interval time.Duration
+	// MetricPrefix selects the metric families that are exported
+	MetricPrefix string
+}
+
+// Exporter periodically gathers the metrics and sends counters as the
+// increase since the last export, gauges as their value and histograms as
+// the increase of their count and sum
+type Exporter struct {
+	config   Config
+	gatherer prometheus.Gatherer
+	conn     net.Conn
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+func NewExporter(config Config, gatherer prometheus.Gatherer) (*Exporter, error) {
+	if config.Flavor != StatsD && config.Flavor != DogStatsD {
+		return nil, fmt.Errorf(`unsupported StatsD flavor "%s"`, config.Flavor)
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to StatsD, %w", err)
+	}
+	return &Exporter{config: config, gatherer: gatherer, conn: conn, last: make(map[string]float64)}, nil
+}
+
+// Start exports the metrics every interval until Close
+func (e *Exporter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := e.Export(); err != nil {
+					zap.L().Warn("couldn't export metrics to StatsD", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Close exports the metrics a last time and closes the connection
+func (e *Exporter) Close() error {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+	}
+	err := e.Export()
+	if closeErr := e.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Export sends the current metrics
+func (e *Exporter) Export() error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("couldn't gather metrics, %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var lines []string
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), e.config.MetricPrefix) {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			lines = append(lines, e.lines(family.GetName(), family.GetType(), metric)...)
+		}
+	}
+	return e.send(lines)
+}
+
+func (e *Exporter) lines(name string, kind dto.MetricType, metric *dto.Metric) []string {
+	labels := metric.GetLabel()
+	switch kind {
+	case dto.MetricType_COUNTER:
+		return e.counter(name, labels, metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return []string{e.line(name, labels, metric.GetGauge().GetValue(), "g")}
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		return append(
+			e.counter(name+"_count", labels, float64(histogram.GetSampleCount())),
+			e.counter(name+"_sum", labels, histogram.GetSampleSum())...,
+		)
+	}
+	return nil
+}
+
+// counter returns the increase since the last export, nothing if there is none
+func (e *Exporter) counter(name string, labels []*dto.LabelPair, value float64) []string {
+	key := name + "{" + labelString(labels) + "}"
+	delta := value - e.last[key]
+	e.last[key] = value
+	if delta <= 0 {
+		return nil
+	}
+	return []string{e.line(name, labels, delta, "c")}
+}
+
+func (e *Exporter) line(name string, labels []*dto.LabelPair, value float64, kind string) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	if e.config.Flavor == DogStatsD {
+		line := e.config.Prefix + name + ":" + formatted + "|" + kind
+		if len(labels) > 0 {
+			tags := make([]string, 0, len(labels))
+			for _, label := range labels {
+				tags = append(tags, sanitize(label.GetName(), tagSeparators)+":"+sanitize(label.GetValue(), tagSeparators))
+			}
+			line += "|#" + strings.Join(tags, ",")
+		}
+		return line
+	}
+
+	parts := []string{e.config.Prefix + name}
+	for _, label := range labels {
+		parts = append(parts, sanitize(label.GetName(), nameSeparators), sanitize(label.GetValue(), nameSeparators))
+	}
+	return strings.Join(parts, ".") + ":" + formatted + "|" + kind
+}
+
+// send batches the lines into packets
+func (e *Exporter) send(lines []string) error {
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write([]byte(packet.String()))
+		packet.Reset()
+		return err
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+func labelString(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label.GetName()+"="+label.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+const (
+	// tagSeparators separate the fields and tags of a DogStatsD line
+	tagSeparators = "|,#@ \n"
+	// nameSeparators additionally separate the value and the name components
+	// of a StatsD line
+	nameSeparators = tagSeparators + ":./"
+)
+
+// sanitize replaces the separators in a label name or value
+func sanitize(value, separators string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(separators, r) {
+			return '_'
+		}
+		return r
+	}, value)
+}
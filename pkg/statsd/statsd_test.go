@@ -0,0 +1,102 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	newExporter := func(t *testing.T, flavor Flavor) (*Exporter, *prometheus.Registry, func() []string) {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = listener.Close() })
+
+		registry := prometheus.NewRegistry()
+		exporter, err := NewExporter(Config{Address: listener.LocalAddr().String(), Flavor: flavor, Prefix: "app.", MetricPrefix: "spark_"}, registry)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = exporter.conn.Close() })
+
+		receive := func() []string {
+			buf := make([]byte, maxPacketSize)
+			require.NoError(t, listener.SetReadDeadline(time.Now().Add(time.Second)))
+			n, _, err := listener.ReadFrom(buf)
+			require.NoError(t, err)
+			lines := strings.Split(string(buf[:n]), "\n")
+			sort.Strings(lines)
+			return lines
+		}
+		return exporter, registry, receive
+	}
+
+	t.Run("sends counter increases, gauges and histograms as DogStatsD", func(t *testing.T) {
+		exporter, registry, receive := newExporter(t, DogStatsD)
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spark_exec_total"}, []string{"preset", "status"})
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "spark_submit_processes"})
+		histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "spark_exec_duration_seconds"})
+		ignored := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines"})
+		registry.MustRegister(counter, gauge, histogram, ignored)
+
+		counter.WithLabelValues("team/etl", "success").Add(2)
+		gauge.Set(3)
+		histogram.Observe(1.5)
+		ignored.Set(1)
+		require.NoError(t, exporter.Export())
+		require.Equal(t, []string{
+			"app.spark_exec_duration_seconds_count:1|c",
+			"app.spark_exec_duration_seconds_sum:1.5|c",
+			"app.spark_exec_total:2|c|#preset:team/etl,status:success",
+			"app.spark_submit_processes:3|g",
+		}, receive())
+
+		counter.WithLabelValues("team/etl", "success").Inc()
+		require.NoError(t, exporter.Export())
+		require.Equal(t, []string{
+			"app.spark_exec_total:1|c|#preset:team/etl,status:success",
+			"app.spark_submit_processes:3|g",
+		}, receive())
+	})
+
+	t.Run("appends the labels to the name for StatsD", func(t *testing.T) {
+		exporter, registry, receive := newExporter(t, StatsD)
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spark_exec_total"}, []string{"preset"})
+		registry.MustRegister(counter)
+
+		counter.WithLabelValues("team/etl.v2").Inc()
+		require.NoError(t, exporter.Export())
+		require.Equal(t, []string{"app.spark_exec_total.preset.team_etl_v2:1|c"}, receive())
+	})
+
+	t.Run("splits large exports into packets", func(t *testing.T) {
+		lines := make([]string, 100)
+		for i := range lines {
+			lines[i] = strings.Repeat("x", 100)
+		}
+		exporter, _, receive := newExporter(t, StatsD)
+		require.NoError(t, exporter.send(lines))
+		require.Len(t, receive(), maxPacketSize/101)
+	})
+
+	t.Run("given an unknown flavor, fails", func(t *testing.T) {
+		_, err := NewExporter(Config{Address: "127.0.0.1:8125", Flavor: "graphite"}, prometheus.NewRegistry())
+		require.ErrorContains(t, err, "unsupported StatsD flavor")
+	})
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparent(t *testing.T) {
+	t.Run("round trips span contexts", func(t *testing.T) {
+		header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		sc, ok := ParseTraceparent(header)
+		require.True(t, ok)
+		require.Equal(t, header, Traceparent(sc))
+	})
+
+	t.Run("rejects invalid headers", func(t *testing.T) {
+		for _, header := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+			_, ok := ParseTraceparent(header)
+			require.False(t, ok, header)
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	tracer := newTestTracer(t)
+	var handlerSpan SpanContext
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, tracer.queued, 1)
+	span := tracer.queued[0]
+	require.Equal(t, "GET /jobs/{id}", span.name)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.context.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", span.parent.String())
+	require.Equal(t, span.context, handlerSpan)
+	require.Equal(t, "500", span.attributes["http.status_code"])
+	require.NotEmpty(t, span.err)
+}
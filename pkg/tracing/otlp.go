@@ -0,0 +1,313 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	batchSize     = 512
+	maxQueued     = 2048
+	flushInterval = 5 * time.Second
+)
+
+// Tracer batches ended spans and exports them to an OTLP/HTTP endpoint
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	http     *http.Client
+
+	mu      sync.Mutex
+	queued  []*Span
+	dropped int
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// FromEnv creates a tracer from the standard OpenTelemetry environment
+// variables. It returns nil if no OTLP endpoint is configured.
+func FromEnv() (*Tracer, error) {
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil, nil
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf(`unsupported OTLP protocol "%s", only http/json is supported`, protocol)
+	}
+
+	headers, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, err
+	}
+	traceHeaders, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range traceHeaders {
+		headers[key] = value
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "spark-submit-server"
+	}
+	return NewTracer(endpoint, service, headers), nil
+}
+
+// parseHeaders parses the key1=value1,key2=value2 format of the OTLP header variables
+func parseHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf(`invalid OTLP header "%s"`, pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf(`invalid OTLP header "%s", %w`, pair, err)
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	return headers, nil
+}
+
+// NewTracer creates a tracer and starts exporting in the background
+func NewTracer(endpoint, service string, headers map[string]string) *Tracer {
+	t := &Tracer{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+func (t *Tracer) export(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queued) >= maxQueued {
+		t.dropped++
+		return
+	}
+	t.queued = append(t.queued, span)
+	if len(t.queued) >= batchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.stop:
+			return
+		}
+		if err := t.Flush(context.Background()); err != nil {
+			zap.L().Warn("couldn't export spans", zap.Error(err))
+		}
+	}
+}
+
+// Shutdown stops the background export and exports the remaining spans
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.stop)
+	<-t.done
+	return t.Flush(ctx)
+}
+
+// Flush exports all queued spans
+func (t *Tracer) Flush(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		if t.dropped > 0 {
+			zap.L().Warn("dropped spans, the export can't keep up", zap.Int("count", t.dropped))
+			t.dropped = 0
+		}
+		n := len(t.queued)
+		if n > batchSize {
+			n = batchSize
+		}
+		batch := t.queued[:n:n]
+		t.queued = t.queued[n:]
+		t.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.send(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tracer) send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return fmt.Errorf("couldn't encode spans, %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't create request, %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to OTLP endpoint failed, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// the OTLP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const otlpStatusError = 2
+
+func (t *Tracer) request(spans []*Span) otlpRequest {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := otlpSpan{
+			TraceID:           span.context.TraceID.String(),
+			SpanID:            span.context.SpanID.String(),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent != (SpanID{}) {
+			s.ParentSpanID = span.parent.String()
+		}
+		keys := make([]string, 0, len(span.attributes))
+		for key := range span.attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: span.attributes[key]}})
+		}
+		if span.err != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.err}
+		}
+		span.mu.Unlock()
+		converted = append(converted, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: t.service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/Staffbase/spark-submit"},
+			Spans: converted,
+		}},
+	}}}
+}
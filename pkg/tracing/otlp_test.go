@@ -0,0 +1,98 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer(t *testing.T) {
+	t.Run("exports spans as OTLP JSON", func(t *testing.T) {
+		var received otlpRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			require.Equal(t, "secret", r.Header.Get("api-key"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		}))
+		defer server.Close()
+
+		tracer := NewTracer(server.URL+"/v1/traces", "spark-submit-server", map[string]string{"api-key": "secret"})
+		SetTracer(tracer)
+		defer SetTracer(nil)
+
+		_, span := Start(context.Background(), "spark.submit")
+		span.SetAttribute("preset", "pi")
+		span.RecordError(errors.New("failed"))
+		span.End()
+		require.NoError(t, tracer.Shutdown(context.Background()))
+
+		require.Len(t, received.ResourceSpans, 1)
+		resource := received.ResourceSpans[0]
+		require.Equal(t, "spark-submit-server", resource.Resource.Attributes[0].Value.StringValue)
+		exported := resource.ScopeSpans[0].Spans[0]
+		require.Equal(t, "spark.submit", exported.Name)
+		require.Equal(t, span.context.TraceID.String(), exported.TraceID)
+		require.Equal(t, []otlpAttribute{{Key: "preset", Value: otlpValue{StringValue: "pi"}}}, exported.Attributes)
+		require.Equal(t, otlpStatus{Code: otlpStatusError, Message: "failed"}, exported.Status)
+	})
+
+	t.Run("returns errors of the endpoint", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		tracer := &Tracer{endpoint: server.URL, http: http.DefaultClient}
+		tracer.export(&Span{name: "span"})
+		require.Error(t, tracer.Flush(context.Background()))
+	})
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("is disabled without an endpoint", func(t *testing.T) {
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+		t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+		tracer, err := FromEnv()
+		require.NoError(t, err)
+		require.Nil(t, tracer)
+	})
+
+	t.Run("reads the standard variables", func(t *testing.T) {
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+		t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret,x-scope=a%20b")
+		t.Setenv("OTEL_SERVICE_NAME", "spark")
+		tracer, err := FromEnv()
+		require.NoError(t, err)
+		defer tracer.Shutdown(context.Background())
+		require.Equal(t, "http://collector:4318/v1/traces", tracer.endpoint)
+		require.Equal(t, map[string]string{"api-key": "secret", "x-scope": "a b"}, tracer.headers)
+		require.Equal(t, "spark", tracer.service)
+	})
+
+	t.Run("rejects unsupported protocols", func(t *testing.T) {
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+		t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+		_, err := FromEnv()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTracer installs a tracer whose spans are only collected
+func newTestTracer(t *testing.T) *Tracer {
+	t.Helper()
+	tracer := &Tracer{}
+	SetTracer(tracer)
+	t.Cleanup(func() { SetTracer(nil) })
+	return tracer
+}
+
+func TestSpans(t *testing.T) {
+	t.Run("returns nil spans when tracing is disabled", func(t *testing.T) {
+		ctx, span := Start(context.Background(), "disabled")
+		require.Nil(t, span)
+		span.SetAttribute("key", "value")
+		span.RecordError(errors.New("failed"))
+		span.End()
+		require.False(t, SpanContextFromContext(ctx).IsValid())
+	})
+
+	t.Run("creates child spans in the same trace", func(t *testing.T) {
+		tracer := newTestTracer(t)
+		ctx, parent := Start(context.Background(), "parent")
+		_, child := Start(ctx, "child")
+		child.End()
+		parent.End()
+		parent.End()
+
+		require.Len(t, tracer.queued, 2)
+		require.Equal(t, parent.context.TraceID, child.context.TraceID)
+		require.Equal(t, parent.context.SpanID, child.parent)
+		require.Equal(t, SpanID{}, parent.parent)
+	})
+
+	t.Run("Detach keeps the trace but not the cancellation", func(t *testing.T) {
+		newTestTracer(t)
+		ctx, span := Start(context.Background(), "request")
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		detached := Detach(ctx)
+		require.NoError(t, detached.Err())
+		require.Equal(t, span.Context(), SpanContextFromContext(detached))
+	})
+}
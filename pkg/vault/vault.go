@@ -0,0 +1,273 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault is a minimal HashiCorp Vault client for reading secrets with
+// token or Kubernetes authentication. Secrets are cached, renewable leases
+// and tokens are renewed before they expire.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+var NotFoundError error = errors.New("secret not found")
+
+type Config struct {
+	Address string
+	// Token authenticates directly, it isn't renewed
+	Token string
+	// KubernetesRole logs in with the service account of the pod if no
+	// token is set
+	KubernetesRole string
+	// KubernetesMount is the path of the Kubernetes auth method, "kubernetes" by default
+	KubernetesMount string
+	// JWTFile is the service account token, the mounted one by default
+	JWTFile string
+	// CacheTTL is how long secrets without a lease are cached
+	CacheTTL time.Duration
+}
+
+type Client struct {
+	cfg  Config
+	http *http.Client
+	now  func() time.Time
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+	renewable    bool
+	issued       time.Time
+	cache        map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	data      map[string]interface{}
+	leaseID   string
+	renewable bool
+	fetched   time.Time
+	expires   time.Time
+}
+
+type secretResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *authResponse          `json:"auth"`
+}
+
+type authResponse struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+type errorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("no vault address configured")
+	}
+	if cfg.Token == "" && cfg.KubernetesRole == "" {
+		return nil, fmt.Errorf("either a vault token or a kubernetes role is required")
+	}
+	if cfg.KubernetesMount == "" {
+		cfg.KubernetesMount = "kubernetes"
+	}
+	if cfg.JWTFile == "" {
+		cfg.JWTFile = serviceAccountToken
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	return &Client{
+		cfg:   cfg,
+		http:  &http.Client{Timeout: 30 * time.Second},
+		now:   time.Now,
+		token: cfg.Token,
+		cache: make(map[string]cachedSecret),
+	}, nil
+}
+
+// Read returns a field of the secret at path. KV version 2 secrets are
+// addressed with their API path, e.g. secret/data/spark.
+func (c *Client) Read(ctx context.Context, path, field string) (string, error) {
+	data, err := c.secret(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	// KV version 2 nests the values of the secret in data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf(`%w: field "%s" of "%s"`, NotFoundError, field, path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+func (c *Client) secret(ctx context.Context, path string) (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if cached, ok := c.cache[path]; ok && now.Before(cached.expires) {
+		// renewable leases are extended once half of their duration passed
+		if cached.renewable && now.After(cached.fetched.Add(cached.expires.Sub(cached.fetched)/2)) {
+			var renewed secretResponse
+			if err := c.do(ctx, http.MethodPut, "/v1/sys/leases/renew", map[string]string{"lease_id": cached.leaseID}, &renewed); err == nil && renewed.LeaseDuration > 0 {
+				cached.fetched, cached.expires = now, now.Add(time.Duration(renewed.LeaseDuration)*time.Second)
+				c.cache[path] = cached
+			}
+		}
+		return cached.data, nil
+	}
+
+	var resp secretResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), nil, &resp); err != nil {
+		return nil, err
+	}
+	ttl := c.cfg.CacheTTL
+	if resp.LeaseDuration > 0 {
+		ttl = time.Duration(resp.LeaseDuration) * time.Second
+	}
+	if ttl > 0 {
+		c.cache[path] = cachedSecret{
+			data:      resp.Data,
+			leaseID:   resp.LeaseID,
+			renewable: resp.Renewable && resp.LeaseID != "",
+			fetched:   now,
+			expires:   now.Add(ttl),
+		}
+	}
+	return resp.Data, nil
+}
+
+// authenticate logs in with kubernetes auth if there is no token yet or it
+// expires soon, renewable tokens are renewed instead. It must be called with
+// the lock held.
+func (c *Client) authenticate(ctx context.Context) error {
+	if c.cfg.KubernetesRole == "" || c.cfg.Token != "" {
+		return nil
+	}
+	now := c.now()
+	if c.token != "" && (c.tokenExpires.IsZero() || now.Before(c.issued.Add(c.tokenExpires.Sub(c.issued)*2/3))) {
+		return nil
+	}
+
+	if c.token != "" && c.renewable && now.Before(c.tokenExpires) {
+		var resp secretResponse
+		if err := c.request(ctx, http.MethodPost, "/v1/auth/token/renew-self", nil, &resp); err == nil && resp.Auth != nil {
+			c.setToken(*resp.Auth, now)
+			return nil
+		}
+	}
+
+	jwt, err := os.ReadFile(c.cfg.JWTFile)
+	if err != nil {
+		return fmt.Errorf("couldn't read service account token, %w", err)
+	}
+	var resp secretResponse
+	login := map[string]string{"role": c.cfg.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))}
+	c.token = ""
+	if err := c.request(ctx, http.MethodPost, "/v1/auth/"+c.cfg.KubernetesMount+"/login", login, &resp); err != nil {
+		return fmt.Errorf("vault login failed, %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault login failed, no token in the response")
+	}
+	c.setToken(*resp.Auth, now)
+	return nil
+}
+
+func (c *Client) setToken(auth authResponse, now time.Time) {
+	c.token = auth.ClientToken
+	c.renewable = auth.Renewable
+	c.issued = now
+	c.tokenExpires = time.Time{}
+	if auth.LeaseDuration > 0 {
+		c.tokenExpires = now.Add(time.Duration(auth.LeaseDuration) * time.Second)
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+	return c.request(ctx, method, path, body, result)
+}
+
+func (c *Client) request(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("couldn't encode request, %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.Address+path, reader)
+	if err != nil {
+		return fmt.Errorf("couldn't create request, %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to vault failed, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf(`%w: "%s"`, NotFoundError, strings.TrimPrefix(path, "/v1/"))
+	}
+	if resp.StatusCode >= 300 {
+		var vaultErr errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&vaultErr); err != nil || len(vaultErr.Errors) == 0 {
+			return fmt.Errorf("vault responded with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("vault responded with status %d: %s", resp.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("couldn't decode vault response, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeVault struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeVault) handler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var login map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+			require.Equal(t, map[string]string{"role": "spark", "jwt": "service-account"}, login)
+			_, _ = w.Write([]byte(`{"auth": {"client_token": "k8s-token", "lease_duration": 3600, "renewable": true}}`))
+			return
+		case "/v1/auth/token/renew-self":
+			require.Equal(t, "k8s-token", r.Header.Get("X-Vault-Token"))
+			_, _ = w.Write([]byte(`{"auth": {"client_token": "k8s-token", "lease_duration": 3600, "renewable": true}}`))
+			return
+		case "/v1/sys/leases/renew":
+			_, _ = w.Write([]byte(`{"lease_id": "database/creds/etl/1", "lease_duration": 600, "renewable": true}`))
+			return
+		}
+
+		if token := r.Header.Get("X-Vault-Token"); token != "root" && token != "k8s-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/spark":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "hunter2", "port": 5432}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/spark":
+			_, _ = w.Write([]byte(`{"lease_duration": 60, "data": {"password": "v1-secret"}}`))
+		case "/v1/database/creds/etl":
+			_, _ = w.Write([]byte(`{"lease_id": "database/creds/etl/1", "lease_duration": 600, "renewable": true, "data": {"username": "etl-1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors": []}`))
+		}
+	}
+}
+
+func (f *fakeVault) count(request string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestClient(t *testing.T) {
+	fake := &fakeVault{}
+	server := httptest.NewServer(fake.handler(t))
+	defer server.Close()
+
+	t.Run("reads fields of KV version 1 and 2 secrets with a token", func(t *testing.T) {
+		c, err := NewClient(Config{Address: server.URL + "/", Token: "root", CacheTTL: time.Minute})
+		require.NoError(t, err)
+
+		value, err := c.Read(context.Background(), "secret/data/spark", "password")
+		require.NoError(t, err)
+		require.Equal(t, "hunter2", value)
+		value, err = c.Read(context.Background(), "secret/data/spark", "port")
+		require.NoError(t, err)
+		require.Equal(t, "5432", value)
+		value, err = c.Read(context.Background(), "kv/spark", "password")
+		require.NoError(t, err)
+		require.Equal(t, "v1-secret", value)
+
+		require.Equal(t, 1, fake.count("GET /v1/secret/data/spark"))
+	})
+
+	t.Run("given a missing secret or field, returns NotFoundError", func(t *testing.T) {
+		c, err := NewClient(Config{Address: server.URL, Token: "root"})
+		require.NoError(t, err)
+		_, err = c.Read(context.Background(), "secret/data/nope", "password")
+		require.ErrorIs(t, err, NotFoundError)
+		_, err = c.Read(context.Background(), "secret/data/spark", "nope")
+		require.ErrorIs(t, err, NotFoundError)
+	})
+
+	t.Run("given an invalid token, returns the vault error", func(t *testing.T) {
+		c, err := NewClient(Config{Address: server.URL, Token: "wrong"})
+		require.NoError(t, err)
+		_, err = c.Read(context.Background(), "secret/data/spark", "password")
+		require.ErrorContains(t, err, "permission denied")
+	})
+
+	t.Run("logs in with kubernetes auth and renews the token and leases", func(t *testing.T) {
+		jwtFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(jwtFile, []byte("service-account\n"), 0600))
+		c, err := NewClient(Config{Address: server.URL, KubernetesRole: "spark", JWTFile: jwtFile})
+		require.NoError(t, err)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		value, err := c.Read(context.Background(), "database/creds/etl", "username")
+		require.NoError(t, err)
+		require.Equal(t, "etl-1", value)
+		require.Equal(t, 1, fake.count("POST /v1/auth/kubernetes/login"))
+
+		now = now.Add(6 * time.Minute)
+		_, err = c.Read(context.Background(), "database/creds/etl", "username")
+		require.NoError(t, err)
+		require.Equal(t, 1, fake.count("GET /v1/database/creds/etl"))
+		require.Equal(t, 1, fake.count("PUT /v1/sys/leases/renew"))
+		require.Equal(t, 0, fake.count("POST /v1/auth/token/renew-self"))
+
+		now = now.Add(35 * time.Minute)
+		_, err = c.Read(context.Background(), "database/creds/etl", "username")
+		require.NoError(t, err)
+		require.Equal(t, 2, fake.count("GET /v1/database/creds/etl"))
+		require.Equal(t, 1, fake.count("POST /v1/auth/token/renew-self"))
+		require.Equal(t, 1, fake.count("POST /v1/auth/kubernetes/login"))
+	})
+
+	t.Run("given neither a token nor a role, returns an error", func(t *testing.T) {
+		_, err := NewClient(Config{Address: server.URL})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package yarn is a minimal client for the ResourceManager REST API of YARN,
+// it looks up and kills the applications submitted by spark-submit.
+package yarn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var NotFoundError error = errors.New("application not found")
+
+// Application contains the fields of a YARN application report we need
+type Application struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	User        string `json:"user"`
+	Queue       string `json:"queue"`
+	State       string `json:"state"`
+	FinalStatus string `json:"finalStatus"`
+	Diagnostics string `json:"diagnostics,omitempty"`
+	TrackingURL string `json:"trackingUrl,omitempty"`
+	// times are milliseconds since the epoch, 0 if not reached yet
+	StartedTime  int64 `json:"startedTime"`
+	LaunchTime   int64 `json:"launchTime"`
+	FinishedTime int64 `json:"finishedTime"`
+}
+
+// Final reports whether the application reached a terminal state
+func (a Application) Final() bool {
+	switch a.State {
+	case "FINISHED", "FAILED", "KILLED":
+		return true
+	}
+	return false
+}
+
+type appsResponse struct {
+	// apps is null if no application matches
+	Apps *struct {
+		App []Application `json:"app"`
+	} `json:"apps"`
+}
+
+type remoteException struct {
+	RemoteException struct {
+		Message string `json:"message"`
+	} `json:"RemoteException"`
+}
+
+type Client struct {
+	baseURL string
+	user    string
+	http    *http.Client
+}
+
+// NewClient creates a client for the ResourceManager web address, e.g.
+// http://resourcemanager:8088. The user is sent as user.name for clusters
+// with simple authentication, it may be empty.
+func NewClient(address, user string) (*Client, error) {
+	if address == "" {
+		return nil, fmt.Errorf("no YARN ResourceManager address configured")
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(address, "/"),
+		user:    user,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// ListApps returns the spark applications of the queue, an empty queue
+// returns the applications of all queues
+func (c *Client) ListApps(ctx context.Context, queue string) ([]Application, error) {
+	query := url.Values{}
+	query.Set("applicationTypes", "SPARK")
+	if queue != "" {
+		query.Set("queue", queue)
+	}
+
+	var resp appsResponse
+	if err := c.do(ctx, http.MethodGet, "/ws/v1/cluster/apps", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Apps == nil {
+		return []Application{}, nil
+	}
+	return resp.Apps.App, nil
+}
+
+// KillApp moves the application to the KILLED state
+func (c *Client) KillApp(ctx context.Context, id string) error {
+	body, _ := json.Marshal(map[string]string{"state": "KILLED"})
+	return c.do(ctx, http.MethodPut, "/ws/v1/cluster/apps/"+url.PathEscape(id)+"/state", nil, bytes.NewReader(body), nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, result interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.user != "" {
+		query.Set("user.name", c.user)
+	}
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fmt.Errorf("couldn't create request, %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to YARN ResourceManager failed, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFoundError
+	}
+	// killing an application responds with 202 until it is killed
+	if resp.StatusCode >= 300 {
+		var exception remoteException
+		if err := json.NewDecoder(resp.Body).Decode(&exception); err != nil || exception.RemoteException.Message == "" {
+			return fmt.Errorf("YARN ResourceManager responded with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("YARN ResourceManager responded with status %d: %s", resp.StatusCode, exception.RemoteException.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("couldn't decode YARN ResourceManager response, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yarn
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Run("adds the scheme to addresses without one", func(t *testing.T) {
+		c, err := NewClient("resourcemanager:8088/", "")
+		require.NoError(t, err)
+		require.Equal(t, "http://resourcemanager:8088", c.baseURL)
+
+		_, err = NewClient("", "")
+		require.Error(t, err)
+	})
+
+	t.Run("ListApps returns the spark applications of the queue", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/ws/v1/cluster/apps", r.URL.Path)
+			require.Equal(t, "SPARK", r.URL.Query().Get("applicationTypes"))
+			require.Equal(t, "etl", r.URL.Query().Get("queue"))
+			require.Equal(t, "spark", r.URL.Query().Get("user.name"))
+			_, _ = w.Write([]byte(`{"apps": {"app": [{"id": "application_1_0001", "name": "pi", "queue": "etl", "state": "RUNNING", "finalStatus": "UNDEFINED", "startedTime": 1689000000000}]}}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "spark")
+		require.NoError(t, err)
+		apps, err := c.ListApps(context.Background(), "etl")
+		require.NoError(t, err)
+		require.Equal(t, []Application{{ID: "application_1_0001", Name: "pi", Queue: "etl", State: "RUNNING", FinalStatus: "UNDEFINED", StartedTime: 1689000000000}}, apps)
+		require.False(t, apps[0].Final())
+	})
+
+	t.Run("ListApps returns an empty list if no application matches", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"apps": null}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "")
+		require.NoError(t, err)
+		apps, err := c.ListApps(context.Background(), "")
+		require.NoError(t, err)
+		require.Empty(t, apps)
+	})
+
+	t.Run("KillApp sets the state to KILLED", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPut, r.Method)
+			require.Equal(t, "/ws/v1/cluster/apps/application_1_0001/state", r.URL.Path)
+			body, _ := io.ReadAll(r.Body)
+			require.JSONEq(t, `{"state": "KILLED"}`, string(body))
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"state": "RUNNING"}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "")
+		require.NoError(t, err)
+		require.NoError(t, c.KillApp(context.Background(), "application_1_0001"))
+	})
+
+	t.Run("given an error, returns the message of the remote exception", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"RemoteException": {"exception": "AuthorizationException", "message": "user dr.who is not allowed"}}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "")
+		require.NoError(t, err)
+		err = c.KillApp(context.Background(), "application_1_0001")
+		require.ErrorContains(t, err, "user dr.who is not allowed")
+	})
+
+	t.Run("given a missing application, returns NotFoundError", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "")
+		require.NoError(t, err)
+		require.ErrorIs(t, c.KillApp(context.Background(), "application_1_0002"), NotFoundError)
+	})
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023, Staffbase GmbH and contributors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Staffbase/spark-submit/pkg/jobs"
+	"github.com/Staffbase/spark-submit/pkg/spark"
+	"go.uber.org/zap"
+)
+
+type submitCmd struct {
+	sparkFlags `embed:""`
+
+	Preset         string            `arg:"" help:"name of the preset"`
+	Args           []string          `arg:"" optional:"" help:"application arguments appended to the ones of the preset"`
+	Conf           map[string]string `help:"spark configuration added to the preset, e.g. --conf spark.executor.instances=4"`
+	Param          map[string]string `help:"values of the template placeholders of the preset, e.g. --param date=2023-06-01"`
+	PodTemplate    string            `help:"name of a pod template of the pod template directory for the driver and executors"`
+	Label          map[string]string `help:"labels of the driver and executor pods, e.g. --label ticket=DATA-123"`
+	Annotation     map[string]string `help:"annotations of the driver and executor pods"`
+	ArtifactDigest map[string]string `help:"expected SHA-256 of a referenced artifact, e.g. --artifact-digest etl/etl-1.4.jar=sha256:..."`
+}
+
+// Run submits the preset like the server would and waits until the
+// submission succeeded or failed. An interrupt aborts the submission.
+func (cmd *submitCmd) Run() error {
+	cmd.setupLogger()
+	retries, err := cmd.backoff()
+	if err != nil {
+		return fmt.Errorf("invalid backoff configuration, %w", err)
+	}
+	secrets, err := cmd.secretStore()
+	if err != nil {
+		return fmt.Errorf("couldn't initialize vault, %w", err)
+	}
+	artifacts, err := cmd.artifactStore()
+	if err != nil {
+		return fmt.Errorf("couldn't initialize the artifact store, %w", err)
+	}
+	s, err := spark.New(spark.Config{
+		SparkHome:      cmd.SparkHome,
+		PresetDir:      cmd.SparkPresetDir,
+		DefaultsFile:   cmd.DefaultsFile,
+		Master:         cmd.Master,
+		Debug:          cmd.DebugSubmit,
+		CommandTimeout: cmd.CommandTimeout,
+		Backoff:        retries,
+		Secrets:        secrets,
+		KeytabDir:      cmd.KeytabDir,
+		PodTemplateDir: cmd.PodTemplateDir,
+		Artifacts:      artifacts,
+	}, jobs.NewMemoryStore())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	opts := spark.SubmitOptions{SparkConf: cmd.Conf, Args: cmd.Args, Params: cmd.Param, Labels: cmd.Label, Annotations: cmd.Annotation, ArtifactDigests: cmd.ArtifactDigest}
+	if cmd.PodTemplate != "" {
+		opts.PodTemplate = &spark.PodTemplate{Name: cmd.PodTemplate}
+	}
+	id, err := s.Submit(ctx, cmd.Preset, opts)
+	if err != nil {
+		return err
+	}
+	// there is no concurrency limit, so the submission runs right away and
+	// Shutdown waits for it
+	if err := s.Shutdown(ctx); err != nil {
+		return fmt.Errorf("submission %s aborted", id)
+	}
+
+	job, err := s.Job(id)
+	if err != nil {
+		return err
+	}
+	if job.State != jobs.StateSucceeded {
+		return fmt.Errorf("submission %s %s", id, job.State)
+	}
+	zap.L().Info("submission succeeded", zap.String("jobID", id))
+	return nil
+}